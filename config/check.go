@@ -0,0 +1,72 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/16/20 10:20 AM
+* @Description: 配置校验
+***********************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+// 合法的account.duty
+const (
+	Duty_None = "none"
+	Duty_Peer = "peer"
+	Duty_Seed = "seed"
+)
+
+// 合法的log.level
+const (
+	LogLevel_Debug = "debug"
+	LogLevel_Info  = "info"
+	LogLevel_Warn  = "warn"
+	LogLevel_Error = "error"
+)
+
+// Check 检查必须的配置项是否齐全、彼此是否一致
+// 只做静态检查，id格式、私钥等由使用方检查
+func (c *TomlConfig) Check() error {
+	switch c.Account.Duty {
+	case Duty_None, Duty_Peer, Duty_Seed:
+	default:
+		return fmt.Errorf("%w: account.duty must be one of none/peer/seed, got %q", ErrInvalidConfig, c.Account.Duty)
+	}
+	if c.Account.Key == "" {
+		return fmt.Errorf("%w: empty account.key", ErrInvalidConfig)
+	}
+	if c.Consensus.Type == "" {
+		return fmt.Errorf("%w: empty consensus.type", ErrInvalidConfig)
+	}
+	if c.Bnet.Addr == "" {
+		return fmt.Errorf("%w: empty bnet.addr", ErrInvalidConfig)
+	}
+	if c.Account.Addr != "" && c.Account.Addr != c.Bnet.Addr {
+		return fmt.Errorf("%w: account.addr(%s) mismatches bnet.addr(%s)", ErrInvalidConfig, c.Account.Addr, c.Bnet.Addr)
+	}
+	if c.Pot.TickMs < 0 || c.Pot.CompeteMs < 0 {
+		return fmt.Errorf("%w: negative pot.tick_ms or pot.compete_ms", ErrInvalidConfig)
+	}
+	if c.Bnet.TimeoutMs < 0 {
+		return fmt.Errorf("%w: negative bnet.timeout_ms", ErrInvalidConfig)
+	}
+	if c.Bnet.KeepaliveMaxMiss < 0 {
+		return fmt.Errorf("%w: negative bnet.keepalive_max_miss", ErrInvalidConfig)
+	}
+	if c.Bnet.SendQueueCap < 0 {
+		return fmt.Errorf("%w: negative bnet.send_queue_cap", ErrInvalidConfig)
+	}
+	if g := c.Bnet.Gossip; g.Fanout < 0 || g.TTL < 0 || g.CacheSize < 0 {
+		return fmt.Errorf("%w: negative bnet.gossip.fanout, ttl or cache_size", ErrInvalidConfig)
+	}
+	switch c.Log.Level {
+	case "", LogLevel_Debug, LogLevel_Info, LogLevel_Warn, LogLevel_Error:
+	default:
+		return fmt.Errorf("%w: log.level must be one of debug/info/warn/error, got %q", ErrInvalidConfig, c.Log.Level)
+	}
+	return nil
+}
@@ -4,67 +4,53 @@
 * @Description: 全局配置单例
 ***********************************************************************/
 
-// TODO 暂时不考虑热更新
-
 package config
 
 import (
-	"fmt"
 	"github.com/BurntSushi/toml"
 	"io"
-	"log"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
 )
 
 var (
-	cfg     *TomlConfig
-	once    sync.Once
-	cfgLock sync.RWMutex
+	global     *Reloader
+	globalLock sync.RWMutex
 )
 
-// Init 初始化
-func Init(cfgPath string) {
-	once.Do(func() {
-		// 第一次加载配置
-		reloadConfig(cfgPath)
-
-		// 自定义信号，收到信号则重载配置
-		s := make(chan os.Signal, 1)
-		signal.Notify(s, syscall.SIGUSR1)
-		go func() {
-			for {
-				<-s
-				reloadConfig(cfgPath)
-				log.Println("Reloaded config")
-			}
-		}()
-	})
+// Init 初始化全局配置，并在收到SIGUSR1时重新加载，见Reloader
+// 只有第一次成功的调用生效
+func Init(cfgPath string) error {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+	if global != nil {
+		return nil
+	}
+	r, err := NewReloader(cfgPath)
+	if err != nil {
+		return err
+	}
+	r.WatchSignal()
+	global = r
+	return nil
 }
 
-// Global 全局单例
+// Global 全局单例，未初始化时为nil
 func Global() *TomlConfig {
-	cfgLock.RLock()
-	defer cfgLock.RUnlock()
-	return cfg
+	globalLock.RLock()
+	defer globalLock.RUnlock()
+	if global == nil {
+		return nil
+	}
+	return global.Config()
 }
 
-// reloadConfig 加载/重加载配置
-func reloadConfig(cfgPath string) {
-	//filePath, err := filepath.Abs("./ch3/config.toml")
-	//if err != nil {
-	//	panic(err)
-	//}
-	fmt.Printf("parse toml file once. filePath: %s\n", cfgPath)
-	conf := new(TomlConfig)
-	if _, err := toml.DecodeFile(cfgPath, conf); err != nil {
-		panic(err)
+// Subscribe 订阅全局配置的变化，需要先Init
+func Subscribe(f func(Event)) {
+	globalLock.RLock()
+	defer globalLock.RUnlock()
+	if global != nil {
+		global.Subscribe(f)
 	}
-	cfgLock.Lock()
-	defer cfgLock.Unlock()
-	cfg = conf
 }
 
 ////////////////////// 单纯解析一个配置 ////////////////////////
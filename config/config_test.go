@@ -13,7 +13,9 @@ import (
 
 func TestConfig_Basic(t *testing.T) {
 	// 初始化
-	Init("./config.toml")
+	if err := Init("./config.toml"); err != nil {
+		t.Fatal(err)
+	}
 	// 查看配置
 	fmt.Println(Global())
 }
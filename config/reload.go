@@ -0,0 +1,292 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/18/20 3:20 PM
+* @Description: 配置热更新
+***********************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	配置文件在运行期间被修改后，Reloader.Reload重新解析并校验，与当前配置逐项比较：
+		- [seeds]、[peers]、[log]和bnet.timeout_ms的修改可以在运行期间生效，转换为Event通知订阅者
+		- 其余配置项(账户、共识、存储、监听地址等)的修改需要重启节点，整个新配置被拒绝
+	新配置解析失败、校验失败或被拒绝时，当前配置保持不变。
+	[seeds]/[peers]中的id在加载时即解码，写错的id使整个新配置被拒绝；
+	比较时使用解码后的id，同一节点从hex改写为base58不算变化。
+*/
+
+var ErrNotReloadable = errors.New("config change requires restart")
+
+// Event 配置变化事件，为以下类型之一：
+// *SeedsChanged, *PeersChanged, *LogLevelChanged, *ConnTimeoutChanged
+type Event interface {
+	String() string
+}
+
+// SeedsChanged [seeds]的变化。id为解码后的原始id
+type SeedsChanged struct {
+	Added   map[string]string // 新增或地址变化的 <id, addr>
+	Removed map[string]string // 删除的 <id, addr>
+}
+
+func (e *SeedsChanged) String() string {
+	return fmt.Sprintf("seeds changed: %d added, %d removed", len(e.Added), len(e.Removed))
+}
+
+// PeersChanged [peers]的变化。id为解码后的原始id
+type PeersChanged struct {
+	Added   map[string]string // 新增或地址变化的 <id, addr>
+	Removed map[string]string // 删除的 <id, addr>
+}
+
+func (e *PeersChanged) String() string {
+	return fmt.Sprintf("peers changed: %d added, %d removed", len(e.Added), len(e.Removed))
+}
+
+// LogLevelChanged log.level的变化
+type LogLevelChanged struct {
+	Old, New string
+}
+
+func (e *LogLevelChanged) String() string {
+	return fmt.Sprintf("log level changed: %q -> %q", e.Old, e.New)
+}
+
+// ConnTimeoutChanged bnet.timeout_ms的变化，0表示使用默认值
+type ConnTimeoutChanged struct {
+	Old, New time.Duration
+}
+
+func (e *ConnTimeoutChanged) String() string {
+	return fmt.Sprintf("conn timeout changed: %s -> %s", e.Old, e.New)
+}
+
+// Reloader 持有当前配置，并在配置文件修改后重新加载
+type Reloader struct {
+	path string
+
+	cfg     *TomlConfig
+	cfgLock sync.RWMutex
+
+	subs     []func(Event)
+	subsLock sync.RWMutex
+
+	reloadLock sync.Mutex // 串行化Reload
+}
+
+// NewReloader 加载并校验配置文件
+func NewReloader(path string) (*Reloader, error) {
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Reloader{path: path, cfg: cfg}, nil
+}
+
+// Config 当前配置。返回的配置不得修改
+func (r *Reloader) Config() *TomlConfig {
+	r.cfgLock.RLock()
+	defer r.cfgLock.RUnlock()
+	return r.cfg
+}
+
+// Subscribe 订阅配置变化事件
+// f在Reload所在的goroutine中按订阅顺序被同步调用，不应阻塞
+func (r *Reloader) Subscribe(f func(Event)) {
+	if f == nil {
+		return
+	}
+	r.subsLock.Lock()
+	r.subs = append(r.subs, f)
+	r.subsLock.Unlock()
+}
+
+// Reload 重新加载配置文件
+// 成功时替换当前配置，通知订阅者并返回变化事件；失败时当前配置不变
+func (r *Reloader) Reload() ([]Event, error) {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
+	cfg, err := loadConfigFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	events, err := Diff(r.Config(), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cfgLock.Lock()
+	r.cfg = cfg
+	r.cfgLock.Unlock()
+
+	r.subsLock.RLock()
+	subs := r.subs
+	r.subsLock.RUnlock()
+	for _, ev := range events {
+		for _, f := range subs {
+			f(ev)
+		}
+	}
+	return events, nil
+}
+
+// WatchSignal 收到信号(默认SIGUSR1)时Reload，返回的stop用于停止监听
+// 加载失败只打印日志，节点继续使用当前配置运行
+func (r *Reloader) WatchSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGUSR1}
+	}
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, sigs...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-s:
+				events, err := r.Reload()
+				if err != nil {
+					log.Printf("Reload config(%s) fail, keep the running one: %s\n", r.path, err)
+					continue
+				}
+				log.Printf("Reloaded config(%s): %d changes\n", r.path, len(events))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(s)
+			close(done)
+		})
+	}
+}
+
+// Diff 比较两份配置，返回可以在运行期间生效的变化
+// 存在需要重启才能生效的变化时返回ErrNotReloadable，[seeds]/[peers]中有无法解码的id时返回ErrInvalidConfig
+func Diff(old, new *TomlConfig) ([]Event, error) {
+	fixed := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"account", old.Account, new.Account},
+		{"consensus", old.Consensus, new.Consensus},
+		{"pot", old.Pot, new.Pot},
+		{"pow", old.Pow, new.Pow},
+		{"raft", old.Raft, new.Raft},
+		{"store", old.Store, new.Store},
+		{"bnet.protocol", old.Bnet.Protocol, new.Bnet.Protocol},
+		{"bnet.addr", old.Bnet.Addr, new.Bnet.Addr},
+		{"bnet.keepalive_ms", old.Bnet.KeepaliveMs, new.Bnet.KeepaliveMs},
+		{"bnet.keepalive_max_miss", old.Bnet.KeepaliveMaxMiss, new.Bnet.KeepaliveMaxMiss},
+		{"bnet.send_queue_cap", old.Bnet.SendQueueCap, new.Bnet.SendQueueCap},
+		{"bnet.gossip", old.Bnet.Gossip, new.Bnet.Gossip},
+	}
+	for _, f := range fixed {
+		if !reflect.DeepEqual(f.old, f.new) {
+			return nil, fmt.Errorf("%w: [%s] changed", ErrNotReloadable, f.name)
+		}
+	}
+
+	var nodes [4]map[string]string
+	for i, section := range []struct {
+		name  string
+		nodes map[string]string
+	}{{"seeds", old.Seeds}, {"peers", old.Peers}, {"seeds", new.Seeds}, {"peers", new.Peers}} {
+		ids, err := decodeNodes(section.name, section.nodes)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = ids
+	}
+
+	var events []Event
+	if added, removed := diffNodes(nodes[0], nodes[2]); len(added)+len(removed) > 0 {
+		events = append(events, &SeedsChanged{Added: added, Removed: removed})
+	}
+	if added, removed := diffNodes(nodes[1], nodes[3]); len(added)+len(removed) > 0 {
+		events = append(events, &PeersChanged{Added: added, Removed: removed})
+	}
+	if old.Log.Level != new.Log.Level {
+		events = append(events, &LogLevelChanged{Old: old.Log.Level, New: new.Log.Level})
+	}
+	if old.Bnet.TimeoutMs != new.Bnet.TimeoutMs {
+		events = append(events, &ConnTimeoutChanged{
+			Old: time.Duration(old.Bnet.TimeoutMs) * time.Millisecond,
+			New: time.Duration(new.Bnet.TimeoutMs) * time.Millisecond,
+		})
+	}
+	return events, nil
+}
+
+// diffNodes 比较两个 <id, addr> 表，地址变化的算作新增
+func diffNodes(old, new map[string]string) (added, removed map[string]string) {
+	added, removed = map[string]string{}, map[string]string{}
+	for id, addr := range new {
+		if oaddr, ok := old[id]; !ok || oaddr != addr {
+			added[id] = addr
+		}
+	}
+	for id, addr := range old {
+		if _, ok := new[id]; !ok {
+			removed[id] = addr
+		}
+	}
+	return added, removed
+}
+
+// decodeNodes 解码[seeds]/[peers]中的id，同一节点的不同写法(hex/base58)只能出现一次
+func decodeNodes(section string, nodes map[string]string) (map[string]string, error) {
+	ids := make(map[string]string, len(nodes))
+	for s, addr := range nodes {
+		id, err := identity.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: [%s] id(%s): %s", ErrInvalidConfig, section, s, err)
+		}
+		if _, ok := ids[id]; ok {
+			return nil, fmt.Errorf("%w: [%s] id(%s) listed twice", ErrInvalidConfig, section, s)
+		}
+		ids[id] = addr
+	}
+	return ids, nil
+}
+
+// loadConfigFile 解析并校验配置文件，包括[seeds]/[peers]中的id
+func loadConfigFile(path string) (*TomlConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+	}
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+	if _, err := decodeNodes("seeds", cfg.Seeds); err != nil {
+		return nil, err
+	}
+	if _, err := decodeNodes("peers", cfg.Peers); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,157 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/18/20 4:10 PM
+* @Description: 配置热更新测试
+***********************************************************************/
+
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+// 配置中的节点id
+const (
+	testSeed1 = "2a9714acdbda48298877fe1cfd169730b2097aed"
+	testSeed2 = "912c9ea77f48b4954aa618fd2f2bcf925bb88016"
+	testPeer1 = "7b2cf5999dc3aaf6f87b9631f3375fb823a34a5f"
+	testPeer2 = "393958288d8b7eff06be7294e26075848a3fa8d4"
+)
+
+func rawId(t *testing.T, s string) string {
+	id, err := identity.Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+const testReloadConfig = `
+[account]
+duty = "peer"
+key = "./node.key"
+
+[consensus]
+type = "pot"
+
+[store]
+engine = "memory"
+
+[bnet]
+protocol = "btcp"
+addr = "127.0.0.1:8099"
+timeout_ms = 5000
+
+[log]
+level = "info"
+
+[seeds]
+"2a9714acdbda48298877fe1cfd169730b2097aed" = "127.0.0.1:8001"
+"912c9ea77f48b4954aa618fd2f2bcf925bb88016" = "127.0.0.1:8002"
+
+[peers]
+"7b2cf5999dc3aaf6f87b9631f3375fb823a34a5f" = "127.0.0.1:9001"
+`
+
+func writeTestConfig(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.toml")
+	writeTestConfig(t, path, testReloadConfig)
+
+	r, err := NewReloader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []Event
+	r.Subscribe(func(ev Event) {
+		received = append(received, ev)
+	})
+
+	// 可热更新的修改
+	modified := strings.NewReplacer(
+		`"912c9ea77f48b4954aa618fd2f2bcf925bb88016" = "127.0.0.1:8002"`, `"912c9ea77f48b4954aa618fd2f2bcf925bb88016" = "127.0.0.1:8012"`,
+		`"7b2cf5999dc3aaf6f87b9631f3375fb823a34a5f" = "127.0.0.1:9001"`, `"393958288d8b7eff06be7294e26075848a3fa8d4" = "127.0.0.1:9002"`,
+		`level = "info"`, `level = "debug"`,
+		`timeout_ms = 5000`, `timeout_ms = 1000`,
+	).Replace(testReloadConfig)
+	writeTestConfig(t, path, modified)
+	events, err := r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		&SeedsChanged{Added: map[string]string{rawId(t, testSeed2): "127.0.0.1:8012"}, Removed: map[string]string{}},
+		&PeersChanged{Added: map[string]string{rawId(t, testPeer2): "127.0.0.1:9002"}, Removed: map[string]string{rawId(t, testPeer1): "127.0.0.1:9001"}},
+		&LogLevelChanged{Old: "info", New: "debug"},
+		&ConnTimeoutChanged{Old: 5 * time.Second, New: time.Second},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("subscriber received %v, want %v", received, want)
+	}
+	if r.Config().Log.Level != "debug" {
+		t.Errorf("config not replaced: log.level = %q", r.Config().Log.Level)
+	}
+
+	// 语法错误、校验失败与需要重启的修改都被拒绝，当前配置不变
+	received = nil
+	bad := map[string]string{
+		"syntax":  modified + "\n[bnet\n",
+		"check":   strings.Replace(modified, `level = "debug"`, `level = "verbose"`, 1),
+		"restart": strings.Replace(modified, `addr = "127.0.0.1:8099"`, `addr = "127.0.0.1:8100"`, 1),
+		"bad id":  strings.Replace(modified, testPeer2, "peer2", 1),
+		"twice": strings.Replace(modified, `[peers]`,
+			`[peers]`+"\n"+`"`+identity.EncodeBase58(rawId(t, testPeer2))+`" = "127.0.0.1:9003"`, 1),
+	}
+	for name, content := range bad {
+		writeTestConfig(t, path, content)
+		_, err := r.Reload()
+		if err == nil {
+			t.Errorf("%s: reload should fail", name)
+		}
+		if name == "restart" && !errors.Is(err, ErrNotReloadable) {
+			t.Errorf("%s: err = %v, want ErrNotReloadable", name, err)
+		}
+		if name != "restart" && !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: err = %v, want ErrInvalidConfig", name, err)
+		}
+	}
+	if len(received) != 0 {
+		t.Errorf("rejected reload published %v", received)
+	}
+	if r.Config().Bnet.Addr != "127.0.0.1:8099" || r.Config().Peers[testPeer2] == "" {
+		t.Errorf("config changed by rejected reload: %+v", r.Config())
+	}
+
+	// 同一节点的id从hex改写为base58，不算增删
+	respelled := strings.Replace(modified, testSeed1, identity.EncodeBase58(rawId(t, testSeed1)), 1)
+	writeTestConfig(t, path, respelled)
+	events, err = r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("respelled id reported as %v", events)
+	}
+}
@@ -14,6 +14,7 @@ type TomlConfig struct {
 	Raft      RaftConfig      `toml:"raft"` // 可选
 	Store     StoreConfig     `toml:"store"`
 	Bnet      BnetConfig      `toml:"bnet"`
+	Log       LogConfig       `toml:"log"` // 可选
 
 	Seeds map[string]string `toml:"seeds"`
 	Peers map[string]string `toml:"peers"`
@@ -23,6 +24,7 @@ type AccountConfig struct {
 	Id   string `toml:"id"`
 	Duty string `toml:"duty"`
 	Addr string `toml:"addr"`
+	Key  string `toml:"key"` // 私钥文件路径
 }
 
 type ConsensusConfig struct {
@@ -32,7 +34,8 @@ type ConsensusConfig struct {
 //////////////////
 
 type PotConfig struct {
-	TickMs int `toml:"tick_ms"`
+	TickMs    int `toml:"tick_ms"`
+	CompeteMs int `toml:"compete_ms"` // 每轮中PotStart到PotOver的时长，为0则等于tick_ms
 }
 
 type PowConfig struct {
@@ -46,9 +49,31 @@ type RaftConfig struct {
 type StoreConfig struct {
 	Engine   string `toml:"engine"`
 	Database string `toml:"database"`
+	Sync     bool   `toml:"sync"` // 每次写入后是否fsync
 }
 
 type BnetConfig struct {
-	Protocol string `toml:"protocol"`
-	Addr     string `toml:"addr"`
+	Protocol  string `toml:"protocol"`
+	Addr      string `toml:"addr"`
+	TimeoutMs int    `toml:"timeout_ms"` // 连接写超时，为0则使用默认值
+
+	KeepaliveMs      int `toml:"keepalive_ms"`       // 保活Ping间隔，为0则使用默认值，<0则不保活
+	KeepaliveMaxMiss int `toml:"keepalive_max_miss"` // 连续多少个Ping无回应视为断开，为0则使用默认值
+
+	SendQueueCap int `toml:"send_queue_cap"` // 每个对端的发送队列容量，为0则使用默认值
+
+	Gossip GossipConfig `toml:"gossip"`
+}
+
+// GossipConfig 交易和新区块的gossip扩散，不启用时直接发给所有节点
+type GossipConfig struct {
+	Enable    bool `toml:"enable"`
+	Fanout    int  `toml:"fanout"`     // 每次转发的邻居数，为0则使用默认值
+	TTL       int  `toml:"ttl"`        // 最多转发的跳数，为0则使用默认值
+	CacheSize int  `toml:"cache_size"` // 记住的最近消息数，为0则使用默认值
+	PullMs    int  `toml:"pull_ms"`    // 拉取修复的间隔(毫秒)，为0则使用默认值，<0则不拉取
+}
+
+type LogConfig struct {
+	Level string `toml:"level"` // debug/info/warn/error，为空则为info
 }
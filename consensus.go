@@ -1,19 +1,28 @@
 /**********************************************************************
 * @Author: Eiger (201820114847@mail.scut.edu.cn)
 * @Date: 2020/9/20 19:19
-* @Description: Consensus接口
+* @Description: Consensus接口及共识实现的注册表
 ***********************************************************************/
 
 package bcc
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/azd1997/blockchain-consensus/config"
 	"github.com/azd1997/blockchain-consensus/defines"
 	"github.com/azd1997/blockchain-consensus/implements/pot"
-	"strings"
+	"github.com/azd1997/blockchain-consensus/modules/peerinfo"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
 )
 
 // Consensus 共识接口。事实上一个Consensus实例代表一个基于该共识协议的共识节点
+// 消息处理、状态切换等都是实现内部的事，接口只约定Node组装和驱动共识模块所需的部分
 type Consensus interface {
 
 	// Init 初始化(运行)。 New之后需要Init
@@ -22,34 +31,124 @@ type Consensus interface {
 	// Close 关闭状态机服务，执行一些必要的清理工作
 	Close() error
 
-	// 共识节点必需有处理各类消息的能力
-	handleMsg(msg *defines.Message) error
-
-	// 状态机循环，负责状态的切换
-	stateMachineLoop()
-
-	// 消息处理循环
-	msgHandleLoop()
-
-	// MsgChannel 对于Consensus的上层来说，需要调用该函数，
+	// MsgOutChan 对于Consensus的上层来说，需要调用该函数，
 	// 得到消息channel，根据该channel拿消息去发送到网络中
 	// TODO: 发送的结果是成功还是失败？状态机需不需要考虑？
-	OutMsgChan() chan *defines.MessageWithError
+	MsgOutChan() chan *defines.MessageWithError
 
-	// 接收消息的channel，需要将该chan移交给网络模块去写消息
+	// MsgInChan 接收消息的channel，需要将该chan移交给网络模块去写消息
 	// Consensus模块在内部循环读该chan，处理Message
-	InMsgChan() chan *defines.Message
+	MsgInChan() chan *defines.Message
+}
+
+// ConnEventHandler 需要感知连接建立与断开的共识实现可以实现该接口，Node会将网络模块的连接事件转交给它
+// 事件在网络模块内部的goroutine中同步调用，不应阻塞
+type ConnEventHandler interface {
+	HandleConnEvent(ev *defines.ConnEvent)
+}
+
+// ConsensusOption 构建共识模块所需的选项，所有共识实现共用
+type ConsensusOption struct {
+	Id   string
+	Duty defines.PeerDuty
+	Key  crypto.PrivateKey // 节点私钥
+	Pit  *peerinfo.PeerInfoTable
+	BC   requires.BlockChain
+	Log  requires.ConsensusLog // 共识状态日志，可以为nil
+
+	// Validators 应用层的交易语义校验器
+	Validators []requires.Validator
+
+	// Config 完整的配置，各共识实现从中读取自己的配置段(如[pot])，可以为nil
+	Config *config.TomlConfig
+}
+
+// ConsensusFactory 共识模块的构造函数
+type ConsensusFactory func(opt *ConsensusOption) (Consensus, error)
+
+// 内置的共识类型
+const (
+	ConsensusType_Pot = "pot"
+)
+
+var (
+	ErrUnknownConsensus    = errors.New("unknown consensus type")
+	ErrConsensusRegistered = errors.New("consensus type already registered")
+)
+
+var (
+	consensusFactories     = map[string]ConsensusFactory{}
+	consensusFactoriesLock sync.RWMutex
+)
+
+func init() {
+	if err := RegisterConsensus(ConsensusType_Pot, newPot); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterConsensus 注册共识实现，typ不区分大小写
+// 第三方共识实现在自己包的init中调用，之后即可通过配置中的consensus.type选用
+func RegisterConsensus(typ string, factory ConsensusFactory) error {
+	typ = strings.ToLower(typ)
+	if typ == "" || factory == nil {
+		return errors.New("empty consensus type or nil factory")
+	}
+
+	consensusFactoriesLock.Lock()
+	defer consensusFactoriesLock.Unlock()
+	if _, ok := consensusFactories[typ]; ok {
+		return fmt.Errorf("%w: %s", ErrConsensusRegistered, typ)
+	}
+	consensusFactories[typ] = factory
+	return nil
+}
+
+// ConsensusTypes 已注册的共识类型
+func ConsensusTypes() []string {
+	consensusFactoriesLock.RLock()
+	defer consensusFactoriesLock.RUnlock()
+	types := make([]string, 0, len(consensusFactories))
+	for typ := range consensusFactories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
 }
 
 // NewConsensus 新建一个共识状态机
-func NewConsensus(typ string) (Consensus, error) {
+func NewConsensus(typ string, opt *ConsensusOption) (Consensus, error) {
 	typ = strings.ToLower(typ) // 支持pot, Pot等大小写
-	switch typ {
-	case "pot":
-		return pot.New()
-	default:
-		return nil, errors.New("unknown consensus type")
+	consensusFactoriesLock.RLock()
+	factory, ok := consensusFactories[typ]
+	consensusFactoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownConsensus, typ)
 	}
+	return factory(opt)
+}
 
-	return nil, nil
+// newPot 构建pot共识模块
+func newPot(opt *ConsensusOption) (Consensus, error) {
+	var timing pot.Timing
+	if opt.Config != nil {
+		timing = pot.Timing{
+			TickMs:    int64(opt.Config.Pot.TickMs),
+			CompeteMs: int64(opt.Config.Pot.CompeteMs),
+		}
+	}
+	p, err := pot.New(&pot.Option{
+		Id:         opt.Id,
+		Duty:       opt.Duty,
+		Key:        opt.Key,
+		Pit:        opt.Pit,
+		BC:         opt.BC,
+		Log:        opt.Log,
+		Validators: opt.Validators,
+		Timing:     timing,
+	})
+	if err != nil {
+		return nil, err // 避免返回包着nil指针的非nil接口
+	}
+	return p, nil
 }
@@ -1,33 +1,83 @@
 /**********************************************************************
 * @Author: Eiger (201820114847@mail.scut.edu.cn)
 * @Date: 2020/9/30 17:43
-* @Description: The file is for
+* @Description: 共识状态日志，实现requires.ConsensusLog
 ***********************************************************************/
 
 package bcc
 
-import "github.com/azd1997/blockchain-consensus/requires"
+import (
+	"sync"
+
+	"github.com/azd1997/blockchain-consensus/requires"
+)
+
+// ConsensusLogKeyPrefix 共识状态所在的列族，长度不能超过requires.CFLen
+const ConsensusLogKeyPrefix = "clog"
+
+var consensusStateKey = []byte("state")
 
 // ConsensusLog 共识状态机日志持久化
+// 零值可以直接使用，此时状态只保存在内存中(用于测试中模拟节点重启)
+// 通过NewConsensusLog创建的ConsensusLog会将状态写入Store，进程重启后仍然可以读到
 type ConsensusLog struct {
-	bc requires.BlockChain
-	// 其他字段
+	kv requires.Store
+	cf requires.CF
+
+	state []byte
+	lock  sync.RWMutex
 }
 
-// ReadConsensusState xxx
-func (cl *ConsensusLog) ReadConsensusState() []byte {
-	return []byte{}
+// NewConsensusLog 创建基于Store的共识状态日志，并读取之前保存的状态
+func NewConsensusLog(kv requires.Store) (*ConsensusLog, error) {
+	cl := &ConsensusLog{
+		kv: kv,
+		cf: requires.String2CF(ConsensusLogKeyPrefix),
+	}
+	if err := kv.Open(); err != nil {
+		return nil, err
+	}
+	if err := kv.RegisterCF(cl.cf); err != nil {
+		return nil, err
+	}
+	state, err := kv.Get(cl.cf, consensusStateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(state) > 0 {
+		cl.state = state
+	}
+	return cl, nil
 }
 
-// SaveConsensusState xxx
-func (cl *ConsensusLog) SaveConsensusState(clog []byte) {
+// ReadConsensusState 读取最近一次保存的共识状态
+func (cl *ConsensusLog) ReadConsensusState() []byte {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+	return append([]byte(nil), cl.state...)
+}
 
+// SaveConsensusState 保存共识状态。写Store失败时内存中的状态保持不变
+func (cl *ConsensusLog) SaveConsensusState(clog []byte) error {
+	state := append([]byte(nil), clog...)
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	if cl.kv != nil {
+		if err := cl.kv.Set(cl.cf, consensusStateKey, state); err != nil {
+			return err
+		}
+	}
+	cl.state = state
+	return nil
 }
 
-// Copy xxx
+// Copy 复制当前状态。副本与原日志共用同一个Store
 func (cl *ConsensusLog) Copy() *ConsensusLog {
-	ncl := new(ConsensusLog)
-	// 复制
-
-	return ncl
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+	return &ConsensusLog{
+		kv:    cl.kv,
+		cf:    cl.cf,
+		state: append([]byte(nil), cl.state...),
+	}
 }
@@ -0,0 +1,63 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/15/20 3:20 PM
+* @Description: 共识注册表测试
+***********************************************************************/
+
+package bcc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/implements/pot"
+)
+
+// nopConsensus 第三方共识实现的替身
+type nopConsensus struct {
+	opt *ConsensusOption
+}
+
+func (c *nopConsensus) Init() error                                { return nil }
+func (c *nopConsensus) Close() error                               { return nil }
+func (c *nopConsensus) MsgOutChan() chan *defines.MessageWithError { return nil }
+func (c *nopConsensus) MsgInChan() chan *defines.Message           { return nil }
+
+func TestRegisterConsensus(t *testing.T) {
+	factory := func(opt *ConsensusOption) (Consensus, error) {
+		return &nopConsensus{opt: opt}, nil
+	}
+	if err := RegisterConsensus("Nop", factory); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterConsensus("nop", factory); !errors.Is(err, ErrConsensusRegistered) {
+		t.Errorf("err = %v, want %v", err, ErrConsensusRegistered)
+	}
+	if err := RegisterConsensus(ConsensusType_Pot, factory); !errors.Is(err, ErrConsensusRegistered) {
+		t.Errorf("err = %v, want %v", err, ErrConsensusRegistered)
+	}
+
+	opt := &ConsensusOption{Id: "nop"}
+	css, err := NewConsensus("NOP", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nop, ok := css.(*nopConsensus); !ok || nop.opt != opt {
+		t.Errorf("NewConsensus(NOP) = %v", css)
+	}
+
+	if _, err := NewConsensus("raft", opt); !errors.Is(err, ErrUnknownConsensus) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownConsensus)
+	}
+
+	types := ConsensusTypes()
+	if len(types) != 2 || types[0] != "nop" || types[1] != ConsensusType_Pot {
+		t.Errorf("ConsensusTypes() = %v", types)
+	}
+}
+
+var (
+	_ Consensus        = (*pot.Pot)(nil)
+	_ ConnEventHandler = (*pot.Pot)(nil)
+)
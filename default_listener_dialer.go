@@ -11,19 +11,31 @@ import (
 
 	"github.com/azd1997/blockchain-consensus/requires"
 	_default "github.com/azd1997/blockchain-consensus/requires/default"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
 )
 
 // DefaultListener 默认的Listener
-func DefaultListener(id, addr string) (requires.Listener, error) {
-	return _default.ListenTCP(id, addr)
+// verifier 用于握手时校验对端身份，通常传入 PeerInfoTable.VerifyPeer
+func DefaultListener(id, addr string, key crypto.PrivateKey, verifier _default.PeerVerifier) (requires.Listener, error) {
+	return _default.ListenTCP(id, addr, key, verifier)
 }
 
 // DefaultDialer 默认的Dialer
-func DefaultDialer(id, addr string) (requires.Dialer, error) {
-	return _default.NewDialer(id, addr, 0)
+func DefaultDialer(id, addr string, key crypto.PrivateKey, verifier _default.PeerVerifier) (requires.Dialer, error) {
+	return _default.NewDialer(id, addr, 0, key, verifier)
 }
 
 // DefaultDialerTimeout 默认的Dialer，带超时
-func DefaultDialerTimeout(id, addr string, timeout time.Duration) (requires.Dialer, error) {
-	return _default.NewDialer(id, addr, timeout)
+func DefaultDialerTimeout(id, addr string, timeout time.Duration, key crypto.PrivateKey, verifier _default.PeerVerifier) (requires.Dialer, error) {
+	return _default.NewDialer(id, addr, timeout, key, verifier)
+}
+
+// DefaultTLSListener 基于双向认证TLS的加密Listener
+func DefaultTLSListener(id, addr string, key crypto.PrivateKey, verifier _default.PeerVerifier) (requires.Listener, error) {
+	return _default.ListenTLS(id, addr, key, verifier)
+}
+
+// DefaultTLSDialer 基于双向认证TLS的加密Dialer
+func DefaultTLSDialer(id, addr string, key crypto.PrivateKey, verifier _default.PeerVerifier) (requires.Dialer, error) {
+	return _default.NewTLSDialer(id, addr, 0, key, verifier)
 }
@@ -11,8 +11,19 @@ import (
 	"testing"
 
 	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
+// 生成测试私钥及由其公钥派生的ID
+func genKey(t *testing.T) (crypto.PrivateKey, string) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, identity.FromPublicKey(key.Public())
+}
+
 type peer struct {
 	id   string
 	addr string
@@ -20,12 +31,12 @@ type peer struct {
 	d    requires.Dialer
 }
 
-func genPeer(id, addr string) (*peer, error) {
-	ln, err := DefaultListener(id, addr)
+func genPeer(key crypto.PrivateKey, id, addr string) (*peer, error) {
+	ln, err := DefaultListener(id, addr, key, nil)
 	if err != nil {
 		return nil, err
 	}
-	d, err := DefaultDialer(id, addr)
+	d, err := DefaultDialer(id, addr, key, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -39,17 +50,17 @@ func genPeer(id, addr string) (*peer, error) {
 
 // 测试两个TCP peer间连接的建立和基本的消息发送（echo回显）
 func TestTCP(t *testing.T) {
-	ida := "peerA"
+	keya, ida := genKey(t)
 	addra := "127.0.0.1:8085"
-	peera, err := genPeer(ida, addra)
+	peera, err := genPeer(keya, ida, addra)
 	if err != nil {
 		t.Errorf("%s: %s\n", ida, err)
 	}
 	defer peera.ln.Close()
 
-	idb := "peerB"
+	keyb, idb := genKey(t)
 	addrb := "127.0.0.1:8086"
-	peerb, err := genPeer(idb, addrb)
+	peerb, err := genPeer(keyb, idb, addrb)
 	if err != nil {
 		t.Errorf("%s: %s\n", idb, err)
 	}
@@ -13,6 +13,10 @@ import (
 	"errors"
 	"fmt"
 	"time"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+	"github.com/azd1997/blockchain-consensus/utils/merkle"
 )
 
 //
@@ -30,7 +34,7 @@ type Block struct {
 	Sig       []byte
 }
 
-// Encode 编码
+// Encode gob编码，仅用于存储或调试，哈希与签名使用 EncodeCanonical
 func (b *Block) Encode() ([]byte, error) {
 	buf := new(bytes.Buffer)
 	err := gob.NewEncoder(buf).Encode(b)
@@ -44,13 +48,85 @@ func (b *Block) Decode(data []byte) error {
 	return gob.NewDecoder(r).Decode(b)
 }
 
-// Sign 签名
-func (b *Block) Sign() error {
+// Sign 出块者使用私钥对区块哈希签名
+// 签名前需要先生成SelfHash
+func (b *Block) Sign(key crypto.PrivateKey) error {
+	if key == nil {
+		return errors.New("nil private key")
+	}
+	if b.SelfHash == nil {
+		return errors.New("nil SelfHash when sign")
+	}
+	sig, err := key.Sign(b.SelfHash)
+	if err != nil {
+		return err
+	}
+	b.Sig = sig
+	return nil
+}
+
+// Verify 验证区块头、出块者签名以及交易列表与默克尔根是否一致
+// pub 为出块者(Maker)登记的公钥
+func (b *Block) Verify(pub crypto.PublicKey) error {
+	if err := b.VerifyHeader(pub); err != nil {
+		return err
+	}
+	return b.VerifyMerkle()
+}
+
+// VerifyHeader 只验证区块头哈希与出块者签名，不需要交易列表
+// 轻节点只持有区块头时使用，交易是否包含在区块中则通过 VerifyTxProof 验证
+func (b *Block) VerifyHeader(pub crypto.PublicKey) error {
+	if err := b.VerifyHash(); err != nil {
+		return err
+	}
+	return b.VerifySig(pub)
+}
+
+// VerifyHash 重新计算区块哈希并与SelfHash比对
+func (b *Block) VerifyHash() error {
+	if b == nil {
+		return errors.New("nil block")
+	}
+	h, err := b.computeHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h, b.SelfHash) {
+		return errors.New("mismatched block SelfHash")
+	}
 	return nil
 }
 
-// 验证基础格式与签名
-func (b *Block) Verify() error {
+// VerifySig 验证pub确属Maker，且Sig是其对SelfHash的签名
+func (b *Block) VerifySig(pub crypto.PublicKey) error {
+	if b == nil {
+		return errors.New("nil block")
+	}
+	if pub == nil {
+		return errors.New("nil public key")
+	}
+	if err := identity.MatchPublicKey(b.Maker, pub); err != nil {
+		return fmt.Errorf("invalid Maker: %w", err)
+	}
+	if !pub.Verify(b.SelfHash, b.Sig) {
+		return crypto.ErrVerifySigFail
+	}
+	return nil
+}
+
+// VerifyMerkle 由交易列表重新计算默克尔根并与Merkle比对
+func (b *Block) VerifyMerkle() error {
+	if b == nil {
+		return errors.New("nil block")
+	}
+	root, err := b.merkleRoot()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(root, b.Merkle) {
+		return errors.New("mismatched block Merkle")
+	}
 	return nil
 }
 
@@ -64,21 +140,94 @@ func (b *Block) Hash() error {
 		if b.Sig != nil {
 			return errors.New("non-nil sig when hash")
 		}
-		blockBytes, err := b.Encode()
+		h, err := b.computeHash()
 		if err != nil {
 			return err
 		}
-		h := sha256.Sum256(blockBytes)
-		b.SelfHash = h[:]
+		b.SelfHash = h
 	}
 	return nil
 }
 
+// computeHash 计算区块哈希，不包含SelfHash和Sig
+// 交易列表由Merkle间接覆盖，因此也不直接参与哈希
+func (b *Block) computeHash() ([]byte, error) {
+	nb := *b
+	nb.SelfHash, nb.Sig, nb.Txs = nil, nil, nil
+	blockBytes, err := nb.EncodeCanonical()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(blockBytes)
+	return h[:], nil
+}
+
 // MerkleTxs 为区块内包含的交易列表生成默克尔数根哈希
+// 叶子为各交易的TxHash，需在生成区块哈希前调用
 func (b *Block) MerkleTxs() error {
+	if b == nil {
+		return errors.New("nil block")
+	}
+	root, err := b.merkleRoot()
+	if err != nil {
+		return err
+	}
+	b.Merkle = root
 	return nil
 }
 
+// txHashes 区块内交易哈希列表，即默克尔树的叶子
+func (b *Block) txHashes() ([][]byte, error) {
+	hashes := make([][]byte, len(b.Txs))
+	for i, tx := range b.Txs {
+		if tx == nil || tx.TxHash == nil {
+			return nil, fmt.Errorf("nil tx or TxHash at %d", i)
+		}
+		hashes[i] = tx.TxHash
+	}
+	return hashes, nil
+}
+
+// merkleRoot 由交易列表计算默克尔根
+func (b *Block) merkleRoot() ([]byte, error) {
+	hashes, err := b.txHashes()
+	if err != nil {
+		return nil, err
+	}
+	return merkle.Root(hashes), nil
+}
+
+// TxProof 为区块内哈希为txHash的交易生成包含性证明
+func (b *Block) TxProof(txHash []byte) (*merkle.Proof, error) {
+	if b == nil {
+		return nil, errors.New("nil block")
+	}
+	hashes, err := b.txHashes()
+	if err != nil {
+		return nil, err
+	}
+	return merkle.BuildProofFor(hashes, txHash)
+}
+
+// VerifyTxProof 验证哈希为txHash的交易包含在该区块中
+// 只依赖区块头中的Merkle，调用前应先通过 VerifyHeader 确认区块头可信
+func (b *Block) VerifyTxProof(txHash []byte, proof *merkle.Proof) bool {
+	if b == nil {
+		return false
+	}
+	return merkle.Verify(b.Merkle, txHash, proof)
+}
+
+// Header 区块头，即去掉交易列表的区块副本，供轻节点使用
+func (b *Block) Header() *Block {
+	if b == nil {
+		return nil
+	}
+	h := *b
+	h.Txs = nil
+	return &h
+}
+
 // Key 区块的键
 func (b *Block) Key() string {
 	if b == nil || b.SelfHash == nil {
@@ -99,7 +248,8 @@ func (b *Block) ShortName() string {
 ///////////////////////
 
 // NewBlock 构造新区块
-func NewBlockAndSign(index int64, id string, prevHash []byte, txs []*Transaction, desc string) (*Block, error) {
+// key 为出块者私钥
+func NewBlockAndSign(index int64, id string, prevHash []byte, txs []*Transaction, desc string, key crypto.PrivateKey) (*Block, error) {
 	b := &Block{
 		Index:     index,
 		Maker:     id,
@@ -120,7 +270,7 @@ func NewBlockAndSign(index int64, id string, prevHash []byte, txs []*Transaction
 		return nil, err
 	}
 	// 最后签名
-	if err := b.Sign(); err != nil {
+	if err := b.Sign(key); err != nil {
 		return nil, err
 	}
 
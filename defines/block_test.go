@@ -7,16 +7,40 @@
 package defines
 
 import (
+	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
 func TestBlock(t *testing.T) {
-	tx, err := NewTransactionAndSign("from", "to", 10, nil, "this is a tx")
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
 	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := NewTransactionAndSign(identity.FromPublicKey(key.Public()), "to", 10, nil, "this is a tx", key)
+	if err != nil {
+		t.Error(err)
+	}
+	if err := tx.Verify(key.Public()); err != nil {
 		t.Error(err)
 	}
-	b, err := NewBlockAndSign(1, "id", []byte("prevhash"), []*Transaction{tx})
+	// 签名有效，但签名者不是From
+	signer, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := NewTransactionAndSign(tx.From, "to", 10, nil, "this is a tx", signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := forged.Verify(signer.Public()); !errors.Is(err, identity.ErrMismatchPubKey) {
+		t.Errorf("err = %v, want ErrMismatchPubKey", err)
+	}
+	b, err := NewBlockAndSign(1, identity.FromPublicKey(key.Public()), []byte("prevhash"), []*Transaction{tx}, "this is a block", key)
 	if err != nil {
 		t.Error(err)
 	}
@@ -35,4 +59,79 @@ func TestBlock(t *testing.T) {
 	if !reflect.DeepEqual(b, nb) {
 		t.Error("error")
 	}
+
+	if err := nb.Verify(key.Public()); err != nil {
+		t.Error(err)
+	}
+	other, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nb.Verify(other.Public()); err == nil {
+		t.Error("verify with key other than Maker's should fail")
+	}
+	nb.Description = "tampered"
+	if err := nb.Verify(key.Public()); err == nil {
+		t.Error("verify tampered block should fail")
+	}
+}
+
+func TestBlock_Merkle(t *testing.T) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := identity.FromPublicKey(key.Public())
+
+	txs := make([]*Transaction, 5)
+	for i := range txs {
+		txs[i], err = NewTransactionAndSign(id, "to", int64(i), nil, "tx", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := NewBlockAndSign(1, id, []byte("prevhash"), txs, "block", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Merkle == nil {
+		t.Fatal("nil Merkle")
+	}
+
+	// 轻节点只持有区块头，验证交易包含性
+	header := b.Header()
+	if err := header.VerifyHeader(key.Public()); err != nil {
+		t.Error(err)
+	}
+	for _, tx := range txs {
+		proof, err := b.TxProof(tx.TxHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !header.VerifyTxProof(tx.TxHash, proof) {
+			t.Errorf("verify proof of tx %s fail", tx.ShortName())
+		}
+	}
+	other, err := NewTransactionAndSign(id, "to", 100, nil, "not in block", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.TxProof(other.TxHash); err == nil {
+		t.Error("TxProof of tx not in block should fail")
+	}
+	proof, _ := b.TxProof(txs[0].TxHash)
+	if header.VerifyTxProof(other.TxHash, proof) {
+		t.Error("VerifyTxProof of tx not in block should fail")
+	}
+
+	// 替换交易后Merkle不再匹配
+	b.Txs[2] = other
+	if err := b.Verify(key.Public()); err == nil {
+		t.Error("verify block with replaced tx should fail")
+	}
+	// 篡改Merkle后区块哈希不再匹配
+	header.Merkle = []byte("tampered")
+	if err := header.VerifyHeader(key.Public()); err == nil {
+		t.Error("verify header with tampered Merkle should fail")
+	}
 }
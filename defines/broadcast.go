@@ -0,0 +1,55 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/22/20 9:00 AM
+* @Description: 广播信封
+***********************************************************************/
+
+package defines
+
+import (
+	"strings"
+
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+// BroadcastTo 广播消息的To。签名覆盖To，广播消息只编码、签名一次，所以不能写具体的接收者
+var BroadcastTo = strings.Repeat("\x00", identity.IdLen)
+
+// BroadcastScope 广播的接收者范围，可以组合
+type BroadcastScope uint8
+
+const (
+	BroadcastScope_None  BroadcastScope = 0 // 只发给Recipients
+	BroadcastScope_Peers BroadcastScope = 1 // 所有共识节点
+	BroadcastScope_Seeds BroadcastScope = 2 // 所有种子节点
+	BroadcastScope_All                  = BroadcastScope_Peers | BroadcastScope_Seeds
+)
+
+// Broadcast 广播信封，随MessageWithError交给网络模块
+// 接收者为Scope所选节点与Recipients的并集，不含自己
+type Broadcast struct {
+	Scope      BroadcastScope
+	Recipients []string
+
+	// Gossip 为true且网络模块启用了gossip时，只发给少数邻居，由它们逐跳扩散到全网，
+	// 此时Scope和Recipients只作为挑选邻居的候选范围，Results也只包含直接发送的邻居。
+	// 网络模块没有启用gossip时照常直接广播
+	Gossip bool
+
+	// Results 每个接收者的发送结果 <id, err>
+	// 由网络模块在所有接收者都有结果后填写，之后才写MessageWithError.Err，所以读到Err之后才能读Results
+	Results map[string]error
+}
+
+// NewBroadcast 构造广播消息，msg的To会被置为BroadcastTo，调用方随后签名
+func NewBroadcast(msg *Message, scope BroadcastScope, recipients ...string) *MessageWithError {
+	msg.To = BroadcastTo
+	return &MessageWithError{
+		Msg: msg,
+		Err: make(chan error, 1),
+		Broadcast: &Broadcast{
+			Scope:      scope,
+			Recipients: recipients,
+		},
+	}
+}
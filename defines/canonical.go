@@ -0,0 +1,168 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/28/20 9:40 AM
+* @Description: 区块与交易的确定性编码，用于计算哈希与签名
+***********************************************************************/
+
+package defines
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+	gob编码的结果依赖类型注册顺序和map遍历顺序，同一交易在不同节点上可能得到不同的编码，
+	因此哈希与签名统一使用下面手写的确定性编码，gob仅用于存储或调试。
+
+	规则：
+	1. 首字节为编码版本号 CanonicalVersion，格式变化时递增
+	2. 字段按结构体中定义的顺序依次写入，整数一律大端
+	3. 变长字段写作 长度 | 内容，长度字段宽度随字段而定，超出则报错
+	4. map按key的字节序升序写入
+
+	Transaction:
+		ver(1B) | txhashlen(1B) | TxHash | fromlen(1B) | From | tolen(1B) | To | Amount(8B) |
+		nFields(2B) | { keylen(2B) | key | vallen(4B) | val }... |
+		siglen(2B) | Sig | desclen(4B) | Description
+
+	Block:
+		ver(1B) | Index(8B) | makerlen(1B) | Maker | Timestamp(8B) |
+		selfhashlen(1B) | SelfHash | prevhashlen(1B) | PrevHash | merklelen(1B) | Merkle |
+		nTxs(4B) | { txlen(4B) | tx }... | desclen(4B) | Description | siglen(2B) | Sig
+*/
+
+// CanonicalVersion 确定性编码的版本号
+const CanonicalVersion uint8 = 1
+
+// canonicalWriter 顺序写入定长/变长字段，出错后后续写入均被忽略
+type canonicalWriter struct {
+	buf *bytes.Buffer
+	err error
+}
+
+func newCanonicalWriter() *canonicalWriter {
+	w := &canonicalWriter{buf: new(bytes.Buffer)}
+	w.buf.WriteByte(CanonicalVersion)
+	return w
+}
+
+func (w *canonicalWriter) uint64(v uint64) {
+	if w.err != nil {
+		return
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+// bytes 写入 长度(lenSize字节) | data
+func (w *canonicalWriter) bytes(field string, data []byte, lenSize int) {
+	if w.err != nil {
+		return
+	}
+	var max uint64
+	switch lenSize {
+	case 1:
+		max = math.MaxUint8
+	case 2:
+		max = math.MaxUint16
+	default:
+		max = math.MaxUint32
+	}
+	if uint64(len(data)) > max {
+		w.err = fmt.Errorf("canonical encode: %s too long (%d)", field, len(data))
+		return
+	}
+	w.length(uint64(len(data)), lenSize)
+	w.buf.Write(data)
+}
+
+// length 写入lenSize字节的长度字段
+func (w *canonicalWriter) length(n uint64, lenSize int) {
+	switch lenSize {
+	case 1:
+		w.buf.WriteByte(uint8(n))
+	case 2:
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		w.buf.Write(b[:])
+	default:
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		w.buf.Write(b[:])
+	}
+}
+
+// count 写入元素个数
+func (w *canonicalWriter) count(field string, n int, lenSize int) {
+	if w.err != nil {
+		return
+	}
+	if lenSize == 2 && n > math.MaxUint16 || uint64(n) > math.MaxUint32 {
+		w.err = fmt.Errorf("canonical encode: too many %s (%d)", field, n)
+		return
+	}
+	w.length(uint64(n), lenSize)
+}
+
+func (w *canonicalWriter) result() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// EncodeCanonical 交易的确定性编码
+func (tx *Transaction) EncodeCanonical() ([]byte, error) {
+	w := newCanonicalWriter()
+	w.bytes("TxHash", tx.TxHash, 1)
+	w.bytes("From", []byte(tx.From), 1)
+	w.bytes("To", []byte(tx.To), 1)
+	w.uint64(uint64(tx.Amount))
+
+	keys := make([]string, 0, len(tx.Fields))
+	for k := range tx.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w.count("Fields", len(keys), 2)
+	for _, k := range keys {
+		w.bytes("Fields key", []byte(k), 2)
+		w.bytes("Fields value", tx.Fields[k], 4)
+	}
+
+	w.bytes("Sig", tx.Sig, 2)
+	w.bytes("Description", []byte(tx.Description), 4)
+	return w.result()
+}
+
+// EncodeCanonical 区块的确定性编码
+func (b *Block) EncodeCanonical() ([]byte, error) {
+	w := newCanonicalWriter()
+	w.uint64(uint64(b.Index))
+	w.bytes("Maker", []byte(b.Maker), 1)
+	w.uint64(uint64(b.Timestamp))
+	w.bytes("SelfHash", b.SelfHash, 1)
+	w.bytes("PrevHash", b.PrevHash, 1)
+	w.bytes("Merkle", b.Merkle, 1)
+
+	w.count("Txs", len(b.Txs), 4)
+	for i, tx := range b.Txs {
+		if tx == nil {
+			return nil, fmt.Errorf("canonical encode: nil tx at %d", i)
+		}
+		txBytes, err := tx.EncodeCanonical()
+		if err != nil {
+			return nil, err
+		}
+		w.bytes("Tx", txBytes, 4)
+	}
+
+	w.bytes("Description", []byte(b.Description), 4)
+	w.bytes("Sig", b.Sig, 2)
+	return w.result()
+}
@@ -0,0 +1,98 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/28/20 11:05 AM
+* @Description: 确定性编码测试
+***********************************************************************/
+
+package defines
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 交易编码不依赖Fields的插入顺序
+func TestTransaction_EncodeCanonicalFieldsOrder(t *testing.T) {
+	n := 50
+	fields1 := make(map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		fields1[fmt.Sprintf("key%02d", i)] = []byte{byte(i)}
+	}
+	fields2 := make(map[string][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		fields2[fmt.Sprintf("key%02d", i)] = []byte{byte(i)}
+	}
+
+	tx1 := &Transaction{From: "from", To: "to", Amount: 1, Fields: fields1}
+	tx2 := &Transaction{From: "from", To: "to", Amount: 1, Fields: fields2}
+	if err := tx1.Hash(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		h, err := tx2.computeHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(h, tx1.TxHash) {
+			t.Fatal("same tx hashes differently")
+		}
+	}
+}
+
+// 固定编码格式，格式变化时须同步递增CanonicalVersion
+func TestTransaction_EncodeCanonicalGolden(t *testing.T) {
+	tx := &Transaction{
+		From:        "a",
+		To:          "b",
+		Amount:      258,
+		Fields:      map[string][]byte{"y": {2}, "x": {1}},
+		Description: "d",
+	}
+	b, err := tx.EncodeCanonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"01",           // ver
+		"00",           // TxHash
+		"0161", "0162", // From, To
+		"0000000000000102",     // Amount
+		"0002",                 // nFields
+		"000178", "0000000101", // x
+		"000179", "0000000102", // y
+		"0000",       // Sig
+		"0000000164", // Description
+	}, "")
+	if got := hex.EncodeToString(b); got != want {
+		t.Errorf("EncodeCanonical = %s, want %s", got, want)
+	}
+}
+
+func TestBlock_EncodeCanonical(t *testing.T) {
+	b := &Block{Index: 1, Maker: "m", Txs: []*Transaction{{From: "a"}}}
+	b1, err := b.EncodeCanonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Txs[0].Amount = 1
+	b2, err := b.EncodeCanonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b1, b2) {
+		t.Error("encoding should cover txs")
+	}
+
+	b.Maker = strings.Repeat("m", 256)
+	if _, err := b.EncodeCanonical(); err == nil {
+		t.Error("too long Maker should fail")
+	}
+	b.Maker = "m"
+	b.Txs = append(b.Txs, nil)
+	if _, err := b.EncodeCanonical(); err == nil {
+		t.Error("nil tx should fail")
+	}
+}
@@ -0,0 +1,42 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/19/20 10:10 AM
+* @Description: 连接事件
+***********************************************************************/
+
+package defines
+
+import "fmt"
+
+// ConnEventType 连接事件类型
+type ConnEventType uint8
+
+const (
+	ConnEvent_Connected    ConnEventType = 1 // 与对端的连接建立(主动或被动)
+	ConnEvent_Disconnected ConnEventType = 2 // 与对端的连接断开，网络模块会自动重连
+)
+
+func (t ConnEventType) String() string {
+	switch t {
+	case ConnEvent_Connected:
+		return "Connected"
+	case ConnEvent_Disconnected:
+		return "Disconnected"
+	default:
+		return "Unknown"
+	}
+}
+
+// ConnEvent 网络模块通知上层的连接事件
+type ConnEvent struct {
+	Id   string // 对端id
+	Type ConnEventType
+	Err  error // 断开的原因，Connected时为nil
+}
+
+func (ev *ConnEvent) String() string {
+	if ev.Err != nil {
+		return fmt.Sprintf("ConnEvent{%x %s: %s}", ev.Id, ev.Type, ev.Err)
+	}
+	return fmt.Sprintf("ConnEvent{%x %s}", ev.Id, ev.Type)
+}
@@ -22,6 +22,8 @@ const (
 	EntryType_Transaction EntryType = 3 // 交易	Type Data
 	EntryType_Neighbor    EntryType = 4 // 邻居节点信息	Type Data
 	EntryType_Process     EntryType = 5 // 进度	Type Data
+	EntryType_Evidence    EntryType = 6 // 作恶证据	Type Data
+	EntryType_EmptyRound  EntryType = 7 // 空轮标记	Type Data
 )
 
 func (et EntryType) String() string {
@@ -38,6 +40,10 @@ func (et EntryType) String() string {
 		return "EntryNeighbor"
 	case EntryType_Process:
 		return "EntryProcess"
+	case EntryType_Evidence:
+		return "EntryEvidence"
+	case EntryType_EmptyRound:
+		return "EntryEmptyRound"
 	default:
 		return "EntryUnknown"
 	}
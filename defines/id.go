@@ -6,6 +6,9 @@
 
 package defines
 
+import "github.com/azd1997/blockchain-consensus/utils/identity"
+
 const (
-	IdLen = 20
+	// IdLen ID长度，ID由公钥哈希派生，见identity包
+	IdLen = identity.IdLen
 )
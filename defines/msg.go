@@ -13,11 +13,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
+// MessageWithError 交给网络模块发送的消息
+// 网络模块发送完(或丢弃)后将结果异步写入Err，且不会为此阻塞，所以Err至少要有1的缓冲
 type MessageWithError struct {
 	Msg *Message
 	Err chan error
+
+	// Broadcast 非nil表示广播，Msg.To须为BroadcastTo，见broadcast.go
+	Broadcast *Broadcast
 }
 
 func (msg *MessageWithError) Check() error {
@@ -30,6 +38,9 @@ func (msg *MessageWithError) Check() error {
 	if msg.Err == nil {
 		return errors.New("nil Err channel")
 	}
+	if (msg.Broadcast != nil) != (msg.Msg.To == BroadcastTo) {
+		return errors.New("broadcast envelope mismatches To")
+	}
 	return nil
 }
 
@@ -48,15 +59,43 @@ const (
 	MessageType_Data MessageType = 1 // 一般的数据传输的消息
 
 	MessageType_Req MessageType = 2 // 请求类消息
+
+	// 网络模块之间的保活消息，不传给共识模块
+	// Epoch字段携带发送Ping时Ping方的本地时间(UnixNano)，Pong原样带回，Ping方据此计算往返时延
+	// 连接建立时已经认证过双方身份，保活消息不签名
+	MessageType_Ping MessageType = 3
+	MessageType_Pong MessageType = 4
+
+	// 网络模块之间的gossip消息，同样不签名
+	// Gossip: Epoch为剩余的转发跳数，Entries[0].Data为原始消息(由发起方签名)的完整编码，接收方解出原始消息交给共识模块
+	// GossipPull: Entries[0].Data为已收到消息的哈希(各32B)拼接，对端据此回发缺少的消息
+	MessageType_Gossip     MessageType = 5
+	MessageType_GossipPull MessageType = 6
 )
 
+// IsKeepalive 是否是网络模块之间的保活消息
+func (t MessageType) IsKeepalive() bool {
+	return t == MessageType_Ping || t == MessageType_Pong
+}
+
+// IsGossip 是否是网络模块之间的gossip消息
+func (t MessageType) IsGossip() bool {
+	return t == MessageType_Gossip || t == MessageType_GossipPull
+}
+
 type Message struct {
 	Version Version
 	Type    MessageType
+	Kind    MessageKind // 消息的具体用途，见msg_kind.go
 
 	// 区块索引从1开始，0表示本地还没有区块
 	Epoch int64 // 纪元，指当前是基于哪一个区块的创建时间基准 从数量上等同于最新区块index
 
+	// 可选头部字段，零值不编码，由头部标志位标明是否存在
+
+	// ReqId 响应消息所回应的请求的Request.Id，非响应消息为0
+	ReqId uint64
+
 	From string
 	To   string
 
@@ -64,17 +103,34 @@ type Message struct {
 
 	Reqs []*Request
 
-	Desc string // 描述字段，必须是json序列化后的字符串，按照key:value的形式
-
 	Sig []byte // 消息签名，以保证消息不被恶意篡改
 }
 
+// 头部标志位，置位表示对应的可选头部字段存在
+const (
+	headerFlag_ReqId uint8 = 1 << 0
+
+	headerFlag_All = headerFlag_ReqId
+)
+
+// headerFlags 根据可选头部字段是否为零值计算标志位
+func (msg *Message) headerFlags() uint8 {
+	flags := uint8(0)
+	if msg.ReqId != 0 {
+		flags |= headerFlag_ReqId
+	}
+	return flags
+}
+
 // Len 获取Message序列化后的长度(包含魔数所占用的2B)
 func (msg *Message) Len() int {
 	length := 2 + 4 +
-		1 + 1 + 8 +
+		1 + 1 + 1 + 1 + 8 +
 		1 + (len(msg.From) + len(msg.To)) +
-		1 + 1 + 2 + len(msg.Desc) + 2 + len(msg.Sig) // 还没加上Entries和Requests
+		1 + 1 + 2 + len(msg.Sig) // 还没加上可选头部字段、Entries和Requests
+	if msg.ReqId != 0 {
+		length += 8
+	}
 	for _, ent := range msg.Entries {
 		length += (2 + ent.Len())
 	}
@@ -90,25 +146,39 @@ func (msg *Message) Check() error {
 		return errors.New("nil Message")
 	}
 
-	if len(msg.From) != len(msg.To) {
-		return errors.New("len(From) != len(To)")
+	if err := identity.Validate(msg.From); err != nil {
+		return fmt.Errorf("invalid From: %w", err)
+	}
+	if err := identity.Validate(msg.To); err != nil {
+		return fmt.Errorf("invalid To: %w", err)
 	}
 
-	if len(msg.Sig) == 0 {
+	if len(msg.Sig) == 0 && !msg.Type.IsKeepalive() && !msg.Type.IsGossip() {
 		return errors.New("nil Sig")
 	}
 
+	if msg.Kind > messageKind_Max {
+		return fmt.Errorf("unknown Kind(%d)", msg.Kind)
+	}
+	if msg.Kind != MessageKind_None && msg.Kind.IsReq() != (msg.Type == MessageType_Req) {
+		return fmt.Errorf("Kind(%s) mismatches Type(%d)", msg.Kind, msg.Type)
+	}
+
 	return nil
 }
 
 // Encode 编码
 func (msg *Message) Encode() ([]byte, error) {
-	var err error
-
 	// 检查msg格式是否有效
-	if err = msg.Check(); err != nil {
+	if err := msg.Check(); err != nil {
 		return nil, err
 	}
+	return msg.encode()
+}
+
+// encode 不做格式检查的编码，签名时Sig为空，也需要编码
+func (msg *Message) encode() ([]byte, error) {
+	var err error
 
 	// 获取缓冲
 	buf := new(bytes.Buffer)
@@ -140,12 +210,34 @@ func (msg *Message) Encode() ([]byte, error) {
 		return nil, err
 	}
 
+	// 写入消息种类 1B
+	err = binary.Write(buf, binary.BigEndian, msg.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	// 写入头部标志位 1B
+	flags := msg.headerFlags()
+	err = binary.Write(buf, binary.BigEndian, flags)
+	if err != nil {
+		return nil, err
+	}
+
 	// 写入Epoch 8B
 	err = binary.Write(buf, binary.BigEndian, msg.Epoch)
 	if err != nil {
 		return nil, err
 	}
 
+	// 写入可选头部字段
+	if flags&headerFlag_ReqId != 0 {
+		// ReqId 8B
+		err = binary.Write(buf, binary.BigEndian, msg.ReqId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// 写入id长度 1B
 	idlen := uint8(len(msg.From))
 	err = binary.Write(buf, binary.BigEndian, idlen)
@@ -216,19 +308,6 @@ func (msg *Message) Encode() ([]byte, error) {
 		}
 	}
 
-	// 写入Desc长度 2B
-	desclen := uint16(len(msg.Desc))
-	err = binary.Write(buf, binary.BigEndian, desclen)
-	if err != nil {
-		return nil, err
-	}
-	//fmt.Printf("encode: desclen=%d\n", desclen)
-	// 写入Desc (desclen)B
-	err = binary.Write(buf, binary.BigEndian, []byte(msg.Desc))
-	if err != nil {
-		return nil, err
-	}
-
 	// 写入签名长度 2B
 	siglen := uint16(len(msg.Sig))
 	//fmt.Printf("encode: siglen=%d\n", siglen)
@@ -279,6 +358,10 @@ func (msg *Message) Decode(r io.Reader) error {
 		return err
 	}
 	//fmt.Printf("Decode Version: %d\n", msg.Version)
+	// 其余字段的布局取决于版本，不认识的版本不再往下读
+	if msg.Version != CodeVersion {
+		return fmt.Errorf("%w: %d, want %d", ErrUnknownVersion, msg.Version, CodeVersion)
+	}
 	totallen -= 1 // 减去Version
 	if totallen <= 0 {
 		return errors.New("not enough totallen")
@@ -295,6 +378,30 @@ func (msg *Message) Decode(r io.Reader) error {
 		return errors.New("not enough totallen")
 	}
 
+	// 读取消息种类
+	err = binary.Read(r, binary.BigEndian, &msg.Kind)
+	if err != nil {
+		return err
+	}
+	totallen -= 1 // 减去Kind
+	if totallen <= 0 {
+		return errors.New("not enough totallen")
+	}
+
+	// 读取头部标志位
+	flags := uint8(0)
+	err = binary.Read(r, binary.BigEndian, &flags)
+	if err != nil {
+		return err
+	}
+	if flags&^headerFlag_All != 0 {
+		return fmt.Errorf("unknown header flags(%08b)", flags)
+	}
+	totallen -= 1 // 减去flags
+	if totallen <= 0 {
+		return errors.New("not enough totallen")
+	}
+
 	// 读取Epoch
 	err = binary.Read(r, binary.BigEndian, &msg.Epoch)
 	if err != nil {
@@ -305,6 +412,18 @@ func (msg *Message) Decode(r io.Reader) error {
 		return errors.New("not enough totallen")
 	}
 
+	// 读取可选头部字段
+	if flags&headerFlag_ReqId != 0 {
+		err = binary.Read(r, binary.BigEndian, &msg.ReqId)
+		if err != nil {
+			return err
+		}
+		totallen -= 8 // 减去ReqId
+		if totallen <= 0 {
+			return errors.New("not enough totallen")
+		}
+	}
+
 	// 读取ID长度
 	idlen := uint8(0)
 	err = binary.Read(r, binary.BigEndian, &idlen)
@@ -398,30 +517,6 @@ func (msg *Message) Decode(r io.Reader) error {
 		}
 	}
 
-	// 读取Desc长度
-	desclen := uint16(0)
-	err = binary.Read(r, binary.BigEndian, &desclen)
-	if err != nil {
-		return err
-	}
-	//fmt.Printf("desclen=%d\n", desclen)
-	totallen -= 2
-	if totallen < 0 {
-		return errors.New("not enough totallen")
-	}
-	//fmt.Printf("totallen=%d\n", totallen)
-	// 读取Desc
-	descbytes := make([]byte, desclen)
-	err = binary.Read(r, binary.BigEndian, descbytes)
-	if err != nil {
-		return err
-	}
-	totallen -= uint32(desclen)
-	if totallen < 0 {
-		return errors.New("not enough totallen")
-	}
-	msg.Desc = string(descbytes)
-
 	// 读取签名长度
 	siglen := uint16(0)
 	err = binary.Read(r, binary.BigEndian, &siglen)
@@ -461,7 +556,11 @@ func (msg *Message) Decode(r io.Reader) error {
 	所以还是得用binary编码
 
 	+--------------------------------------+
-	| 版本(1B)  |  消息类型(1B)  | Epoch(8B) |
+	| 版本(1B) | 消息类型(1B) | 消息种类(1B) | 头部标志位(1B) |
+	+--------------------------------------+
+	|               Epoch(8B)              |
+	+--------------------------------------+
+	|     可选头部字段(按标志位依次出现)       |	// ReqId(8B)
 	+--------------------------------------+
 	|  ID长度(1B)  |  发送方ID  |  发送方ID   |
 	+--------------------------------------+
@@ -481,31 +580,59 @@ func (msg *Message) Decode(r io.Reader) error {
 	+--------------------------------------+
 	|  Requesti长度(2B)  |    Requesti      |
 	+--------------------------------------+
-	|   len(Desc)(2B)   |       Desc       |	// 描述字段，用来传递额外信息
-	+--------------------------------------+
 	|    签名长度(2B)    |     发送方签名     |
 	+--------------------------------------+
 
 	记消息长度(除)的预计算公式为 f(T), 则
-	f(Message) = 2 + 4 + 1 + 1 + 8 + 1 + 2*idlen + 1 + 1 + nEntry * (2 + f(Entry)) + nRequest * (2 + f(Request)) + 2 + siglen
+	f(Message) = 2 + 4 + 1 + 1 + 1 + 1 + 8 + f(可选头部字段) + 1 + 2*idlen + 1 + 1 + nEntry * (2 + f(Entry)) + nRequest * (2 + f(Request)) + 2 + siglen
 			   =
 
 */
 
-// TODO
+// signTarget 签名所覆盖的内容，即Sig置空后的完整编码
+func (msg *Message) signTarget() ([]byte, error) {
+	m := *msg
+	m.Sig = nil
+	return m.encode()
+}
 
-// Sign 生成签名
-func (msg *Message) Sign() error {
-	msg.Sig = []byte("signature")
+// Sign 使用发送方私钥生成签名
+func (msg *Message) Sign(key crypto.PrivateKey) error {
+	if key == nil {
+		return errors.New("nil private key")
+	}
+	target, err := msg.signTarget()
+	if err != nil {
+		return err
+	}
+	sig, err := key.Sign(target)
+	if err != nil {
+		return err
+	}
+	msg.Sig = sig
 	return nil
 }
 
 // Verify 验证基础格式与签名
-func (msg *Message) Verify() error {
-	if bytes.Equal(msg.Sig, []byte("signature")) {
-		return nil
+// pub 为发送方(From)登记的公钥
+func (msg *Message) Verify(pub crypto.PublicKey) error {
+	if err := msg.Check(); err != nil {
+		return err
+	}
+	if pub == nil {
+		return errors.New("nil public key")
 	}
-	return errors.New("verify sig fail")
+	if err := identity.MatchPublicKey(msg.From, pub); err != nil {
+		return err
+	}
+	target, err := msg.signTarget()
+	if err != nil {
+		return err
+	}
+	if !pub.Verify(target, msg.Sig) {
+		return crypto.ErrVerifySigFail
+	}
+	return nil
 }
 
 // String 字符串表示
@@ -516,20 +643,3 @@ func (msg *Message) String() string {
 	}
 	return string(b)
 }
-
-func (msg *Message) WriteDesc(keyValues ...string) error {
-	descmap := map[string]string{}
-	length := len(keyValues)
-	if length % 2 != 0 {
-		return errors.New("wrong arguments")
-	}
-	for i:=0; i<length/2; i++ {
-		descmap[keyValues[2*i]] = keyValues[2*i+1]
-	}
-	res, err := json.Marshal(descmap)
-	if err != nil {
-		return err
-	}
-	msg.Desc = string(res)
-	return nil
-}
\ No newline at end of file
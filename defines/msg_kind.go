@@ -0,0 +1,114 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/25/20 9:30 AM
+* @Description: 消息种类
+***********************************************************************/
+
+package defines
+
+// MessageKind 消息种类，说明一条消息的用途，共识模块据此分派处理
+// MessageType只区分数据/请求，Kind则进一步区分是哪一种数据或请求
+type MessageKind uint8
+
+const (
+	MessageKind_None MessageKind = 0 // 未指明，网络模块内部的保活、gossip消息
+
+	// 请求，Type为MessageType_Req
+	MessageKind_ReqBlocks    MessageKind = 1
+	MessageKind_ReqNeighbors MessageKind = 2
+	MessageKind_ReqProcesses MessageKind = 3
+
+	// 对请求的回应，Type为MessageType_Data，ReqId为所回应的请求的Id
+	MessageKind_RspBlocks    MessageKind = 4
+	MessageKind_RspNeighbors MessageKind = 5
+	MessageKind_RspProcesses MessageKind = 6
+
+	// 主动广播的数据，Type为MessageType_Data
+	MessageKind_Transaction  MessageKind = 7
+	MessageKind_Proof        MessageKind = 8 // 证明者广播自己的证明
+	MessageKind_RelayedProof MessageKind = 9 // 种子转发的胜者证明，From不是证明者
+	MessageKind_NewBlock     MessageKind = 10
+	MessageKind_Neighbor     MessageKind = 11 // 种子转发的新节点信息
+	MessageKind_Evidence     MessageKind = 12
+	MessageKind_EmptyRound   MessageKind = 13 // 种子宣布某一轮为空轮
+
+	messageKind_Max = MessageKind_EmptyRound
+)
+
+func (k MessageKind) String() string {
+	switch k {
+	case MessageKind_None:
+		return "none"
+	case MessageKind_ReqBlocks:
+		return "req-blocks"
+	case MessageKind_ReqNeighbors:
+		return "req-neighbors"
+	case MessageKind_ReqProcesses:
+		return "req-processes"
+	case MessageKind_RspBlocks:
+		return "rsp-blocks"
+	case MessageKind_RspNeighbors:
+		return "rsp-neighbors"
+	case MessageKind_RspProcesses:
+		return "rsp-processes"
+	case MessageKind_Transaction:
+		return "transaction"
+	case MessageKind_Proof:
+		return "proof"
+	case MessageKind_RelayedProof:
+		return "relayed-proof"
+	case MessageKind_NewBlock:
+		return "newblock"
+	case MessageKind_Neighbor:
+		return "neighbor"
+	case MessageKind_Evidence:
+		return "evidence"
+	case MessageKind_EmptyRound:
+		return "empty-round"
+	default:
+		return "unknown"
+	}
+}
+
+// IsReq 是否是请求
+func (k MessageKind) IsReq() bool {
+	return k == MessageKind_ReqBlocks || k == MessageKind_ReqNeighbors || k == MessageKind_ReqProcesses
+}
+
+// RequestType 请求类消息所携带的请求的类型
+func (k MessageKind) RequestType() (RequestType, bool) {
+	switch k {
+	case MessageKind_ReqBlocks:
+		return RequestType_Blocks, true
+	case MessageKind_ReqNeighbors:
+		return RequestType_Neighbors, true
+	case MessageKind_ReqProcesses:
+		return RequestType_Processes, true
+	default:
+		return 0, false
+	}
+}
+
+// EntryType 数据类消息所携带的条目的类型，一条消息只携带一种条目
+func (k MessageKind) EntryType() (EntryType, bool) {
+	switch k {
+	case MessageKind_RspBlocks:
+		return EntryType_Block, true
+	case MessageKind_RspNeighbors, MessageKind_Neighbor:
+		return EntryType_Neighbor, true
+	case MessageKind_RspProcesses:
+		return EntryType_Process, true
+	case MessageKind_Transaction:
+		return EntryType_Transaction, true
+	case MessageKind_Proof, MessageKind_RelayedProof:
+		return EntryType_Proof, true
+	case MessageKind_NewBlock:
+		return EntryType_NewBlock, true
+	case MessageKind_Evidence:
+		return EntryType_Evidence, true
+	case MessageKind_EmptyRound:
+		return EntryType_EmptyRound, true
+	default:
+		return 0, false
+	}
+}
@@ -8,19 +8,43 @@ package defines
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+// 测试用的密钥对，testFrom/testTo为由公钥派生的ID
+var (
+	testKey, _   = crypto.GenerateKey(crypto.DefaultSigScheme)
+	testToKey, _ = crypto.GenerateKey(crypto.DefaultSigScheme)
+	testFrom     = identity.FromPublicKey(testKey.Public())
+	testTo       = identity.FromPublicKey(testToKey.Public())
 )
 
 var testMessage = &Message{
 	Version: CodeVersion,
 	Type:    MessageType_Data,
+	Kind:    MessageKind_RspBlocks,
 	Epoch:   8,
-	From:    "id_from",
-	To:      "id_toto", // From和To长度要一致
+	ReqId:   7,
+	From:    testFrom,
+	To:      testTo,
 	Entries: []*Entry{testEntry},
 	Reqs:    []*Request{testRequest1, testRequest2},
-	Desc:    "description",
+}
+
+// 没有可选头部字段
+var testReqMessage = &Message{
+	Version: CodeVersion,
+	Type:    MessageType_Req,
+	Kind:    MessageKind_ReqBlocks,
+	Epoch:   8,
+	From:    testFrom,
+	To:      testTo,
+	Reqs:    []*Request{testRequest1},
 }
 
 func TestMessage(t *testing.T) {
@@ -29,19 +53,17 @@ func TestMessage(t *testing.T) {
 		msg  *Message
 	}{
 		{"normal_case", testMessage},
+		{"without_optional_header", testReqMessage},
 	}
 
+	key := testKey
+
 	// 测试逻辑
 	for _, test := range tests {
 		test := test
 
-		err := test.msg.WriteDesc("type", "testmsg")
-		if err != nil {
-			t.Error(err)
-		}
-
 		// 调用Sign()
-		err = test.msg.Sign()
+		err := test.msg.Sign(key)
 		if err != nil {
 			t.Error(err)
 		}
@@ -77,7 +99,7 @@ func TestMessage(t *testing.T) {
 		// 加上前面加载的desclen和siglen，合计编码过程中多了2+2+4=8个0
 
 		// 调用Verify()
-		err = amsg.Verify()
+		err = amsg.Verify(key.Public())
 		if err != nil {
 			t.Error(err)
 		}
@@ -86,3 +108,117 @@ func TestMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestMessage_VerifyTampered(t *testing.T) {
+	key, other := testKey, testToKey
+
+	msg := &Message{
+		Version: CodeVersion,
+		Type:    MessageType_Data,
+		Epoch:   3,
+		From:    testFrom,
+		To:      testTo,
+	}
+	if err := msg.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Verify(key.Public()); err != nil {
+		t.Error(err)
+	}
+
+	// 其他节点的公钥不能通过验证
+	if err := msg.Verify(other.Public()); err == nil {
+		t.Error("verify with other's public key should fail")
+	}
+
+	// 篡改内容后不能通过验证
+	msg.Epoch = 4
+	if err := msg.Verify(key.Public()); err == nil {
+		t.Error("verify tampered msg should fail")
+	}
+}
+
+func TestMessage_CheckId(t *testing.T) {
+	msg := &Message{
+		Version: CodeVersion,
+		Type:    MessageType_Data,
+		From:    "id_from",
+		To:      testTo,
+		Sig:     []byte("sig"),
+	}
+	if err := msg.Check(); err == nil {
+		t.Error("msg with invalid From should not pass Check")
+	}
+	msg.From = testFrom
+	if err := msg.Check(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMessage_CheckKind(t *testing.T) {
+	msg := &Message{
+		Version: CodeVersion,
+		Type:    MessageType_Data,
+		Kind:    MessageKind_ReqNeighbors,
+		From:    testFrom,
+		To:      testTo,
+		Sig:     []byte("sig"),
+	}
+	if err := msg.Check(); err == nil {
+		t.Error("req kind in data msg should fail")
+	}
+	msg.Type = MessageType_Req
+	if err := msg.Check(); err != nil {
+		t.Error(err)
+	}
+	msg.Kind = messageKind_Max + 1
+	if err := msg.Check(); err == nil {
+		t.Error("unknown kind should fail")
+	}
+}
+
+func TestMessage_DecodeUnknownFlags(t *testing.T) {
+	msg := &Message{
+		Version: CodeVersion,
+		Type:    MessageType_Data,
+		Kind:    MessageKind_Proof,
+		From:    testFrom,
+		To:      testTo,
+	}
+	if err := msg.Sign(testKey); err != nil {
+		t.Fatal(err)
+	}
+	b, err := msg.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 魔数2B+总长4B+版本1B+类型1B+种类1B之后是头部标志位
+	b[9] |= 0x80
+	if err := new(Message).Decode(bytes.NewReader(b)); err == nil {
+		t.Error("unknown header flags should fail")
+	}
+}
+
+func TestMessage_DecodeUnknownVersion(t *testing.T) {
+	msg := &Message{
+		Version: CodeVersion,
+		Type:    MessageType_Data,
+		Kind:    MessageKind_Proof,
+		From:    testFrom,
+		To:      testTo,
+	}
+	if err := msg.Sign(testKey); err != nil {
+		t.Fatal(err)
+	}
+	b, err := msg.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 版本号位于魔数2B+总长4B之后
+	for _, v := range []Version{0x0, CodeVersion + 1} {
+		b[6] = byte(v)
+		if err := new(Message).Decode(bytes.NewReader(b)); !errors.Is(err, ErrUnknownVersion) {
+			t.Errorf("version %d: err = %v, want ErrUnknownVersion", v, err)
+		}
+	}
+}
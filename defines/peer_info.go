@@ -10,6 +10,9 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
+
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
 //// Peer 节点信息
@@ -65,16 +68,37 @@ func (duty PeerDuty) String() string {
 
 // PeerInfo 节点信息
 type PeerInfo struct {
-	Id   string
-	Addr string
-	Duty PeerDuty
-	Attr PeerAttr
-	Data []byte
+	Id     string
+	Addr   string
+	Duty   PeerDuty
+	Attr   PeerAttr
+	PubKey []byte // 序列化后的公钥(带算法前缀)，用于验证该节点的签名
+	Data   []byte
+}
+
+// Check 检查节点信息是否有效
+// Id必须定长，如果携带了公钥，Id必须由该公钥派生
+func (pi *PeerInfo) Check() error {
+	if pi == nil {
+		return errors.New("nil PeerInfo")
+	}
+	if err := identity.Validate(pi.Id); err != nil {
+		return err
+	}
+	if len(pi.PubKey) > 0 {
+		if err := identity.MatchPubKey(pi.Id, pi.PubKey); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // String
 func (pi *PeerInfo) String() string {
-	b, err := json.Marshal(pi)
+	// Id是原始字节，日志中以短名显示
+	shown := *pi
+	shown.Id = identity.Short(pi.Id)
+	b, err := json.Marshal(&shown)
 	if err != nil {
 		return err.Error()
 	}
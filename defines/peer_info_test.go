@@ -9,6 +9,8 @@ package defines
 import (
 	"reflect"
 	"testing"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
 )
 
 func TestPeerInfo(t *testing.T) {
@@ -32,3 +34,27 @@ func TestPeerInfo(t *testing.T) {
 		t.Error("error")
 	}
 }
+
+func TestPeerInfo_Check(t *testing.T) {
+	pi := &PeerInfo{
+		Id:     testFrom,
+		Addr:   "addr",
+		Duty:   PeerDuty_Peer,
+		PubKey: crypto.MarshalPublicKey(testKey.Public()),
+	}
+	if err := pi.Check(); err != nil {
+		t.Error(err)
+	}
+
+	// 公钥与Id不匹配
+	pi.PubKey = crypto.MarshalPublicKey(testToKey.Public())
+	if err := pi.Check(); err == nil {
+		t.Error("PeerInfo with mismatched PubKey should not pass Check")
+	}
+
+	// Id长度不对
+	pi.Id, pi.PubKey = "id", nil
+	if err := pi.Check(); err == nil {
+		t.Error("PeerInfo with short Id should not pass Check")
+	}
+}
@@ -39,6 +39,9 @@ func (rt RequestType) String() string {
 type Request struct {
 	Type RequestType
 
+	// Id 请求方生成的请求编号，响应消息的Message.ReqId回填该值，请求方据此把响应对应到请求。0表示不需要对应
+	Id uint64
+
 	// 根据index区间请求
 	IndexStart int64
 	IndexCount int64 // 正数代表正向获取，负数代表反方向获取
@@ -57,7 +60,7 @@ func (req *Request) Check() error {
 
 // Len 获取序列化后长度
 func (req *Request) Len() int {
-	length := 1 + 8 + 8 +
+	length := 1 + 8 + 8 + 8 +
 		4 + 4 +
 		4 + len(req.Data)
 	hashnum := len(req.Hashes)
@@ -89,6 +92,12 @@ func (req *Request) Encode() ([]byte, error) {
 		return nil, err
 	}
 
+	// Id 8B
+	err = binary.Write(buf, binary.BigEndian, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
 	// IndexStart 8B
 	err = binary.Write(buf, binary.BigEndian, req.IndexStart)
 	if err != nil {
@@ -147,6 +156,12 @@ func (req *Request) Decode(r io.Reader) error {
 		return err
 	}
 
+	// Id 8B
+	err = binary.Read(r, binary.BigEndian, &req.Id)
+	if err != nil {
+		return err
+	}
+
 	// IndexStart 8B
 	err = binary.Read(r, binary.BigEndian, &req.IndexStart)
 	if err != nil {
@@ -206,7 +221,7 @@ func (req *Request) Decode(r io.Reader) error {
 	(暂时不考虑字节对齐问题)
 
 	+--------------------------------------+
-	| Type(1B) |  Start(8B)  |   Count(8B)   |
+	| Type(1B) |  Id(8B)  |  Start(8B)  |  Count(8B)  |
 	+--------------------------------------+
 	|   hashnum(4B)   |    hashlen(4B)     |
 	+--------------------------------------+
@@ -216,5 +231,5 @@ func (req *Request) Decode(r io.Reader) error {
 	+--------------------------------------+
 
 	长度计算公式：
-	f(Request) = 1 + 8 + 8 + 4 + 4 + hashlen * hashnum + 4 + datalen
+	f(Request) = 1 + 8 + 8 + 8 + 4 + 4 + hashlen * hashnum + 4 + datalen
 */
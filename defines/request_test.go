@@ -14,6 +14,7 @@ import (
 
 var testRequest1 = &Request{
 	Type:       RequestType_Blocks,
+	Id:         0x1234567890,
 	IndexStart: 3,
 	IndexCount: 15,
 	Hashes: [][]byte{
@@ -12,6 +12,9 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
 // Transaction 交易
@@ -41,7 +44,7 @@ func (tx *Transaction) ShortName() string {
 	}
 }
 
-// Encode 编码
+// Encode gob编码，仅用于存储或调试，哈希与签名使用 EncodeCanonical
 func (tx *Transaction) Encode() ([]byte, error) {
 	buf := new(bytes.Buffer)
 	err := gob.NewEncoder(buf).Encode(tx)
@@ -55,40 +58,84 @@ func (tx *Transaction) Decode(data []byte) error {
 	return gob.NewDecoder(r).Decode(tx)
 }
 
-// Sign 签名
-func (tx *Transaction) Sign() error {
+// Sign 交易发起者使用私钥对交易哈希签名
+// 签名前需要先生成TxHash
+func (tx *Transaction) Sign(key crypto.PrivateKey) error {
+	if key == nil {
+		return errors.New("nil private key")
+	}
+	if tx.TxHash == nil {
+		return errors.New("nil TxHash when sign")
+	}
+	sig, err := key.Sign(tx.TxHash)
+	if err != nil {
+		return err
+	}
+	tx.Sig = sig
 	return nil
 }
 
-// 验证基础格式与签名
-func (tx *Transaction) Verify() error {
+// Verify 验证交易哈希与签名
+// pub 为交易发起者(From)的公钥，须能派生出From
+func (tx *Transaction) Verify(pub crypto.PublicKey) error {
+	if tx == nil {
+		return errors.New("nil tx")
+	}
+	if pub == nil {
+		return errors.New("nil public key")
+	}
+	if err := identity.MatchPublicKey(tx.From, pub); err != nil {
+		return fmt.Errorf("invalid From: %w", err)
+	}
+	h, err := tx.computeHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h, tx.TxHash) {
+		return errors.New("mismatched TxHash")
+	}
+	if !pub.Verify(tx.TxHash, tx.Sig) {
+		return crypto.ErrVerifySigFail
+	}
 	return nil
 }
 
-// Hash 为区块生成哈希或者查询其哈希
+// Hash 为交易生成哈希或者查询其哈希
 func (tx *Transaction) Hash() error {
 	if tx == nil {
-		return errors.New("nil block")
+		return errors.New("nil tx")
 	}
 
 	if tx.TxHash == nil {
 		if tx.Sig != nil {
 			return errors.New("non-nil sig when hash")
 		}
-		txBytes, err := tx.Encode()
+		h, err := tx.computeHash()
 		if err != nil {
 			return err
 		}
-		h := sha256.Sum256(txBytes)
-		tx.TxHash = h[:]
+		tx.TxHash = h
 	}
 	return nil
 }
 
+// computeHash 计算交易哈希，不包含TxHash和Sig
+func (tx *Transaction) computeHash() ([]byte, error) {
+	ntx := *tx
+	ntx.TxHash, ntx.Sig = nil, nil
+	txBytes, err := ntx.EncodeCanonical()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(txBytes)
+	return h[:], nil
+}
+
 ///////////////////////
 
 // NewTransaction 构造新区块
-func NewTransactionAndSign(from, to string, amount int64, fields map[string][]byte, description string) (*Transaction, error) {
+// key 为交易发起者私钥
+func NewTransactionAndSign(from, to string, amount int64, fields map[string][]byte, description string, key crypto.PrivateKey) (*Transaction, error) {
 	tx := &Transaction{
 		TxHash:      nil,
 		From:        from,
@@ -103,7 +150,7 @@ func NewTransactionAndSign(from, to string, amount int64, fields map[string][]by
 		return nil, err
 	}
 	// 最后签名
-	if err := tx.Sign(); err != nil {
+	if err := tx.Sign(key); err != nil {
 		return nil, err
 	}
 
@@ -6,10 +6,18 @@
 
 package defines
 
+import "errors"
+
 // Version 共识节点版本
 // 不同共识协议的节点版本号不能放在一起比较
 type Version uint8
 
+// CodeVersion 当前消息编码的版本，编码格式变化时递增
+// 0x1: Request加入Id，Message加入ReqId
+// 0x2: Desc换成Kind，ReqId改为由头部标志位标明的可选字段
 const (
-	CodeVersion Version = 0x0
+	CodeVersion Version = 0x2
 )
+
+// ErrUnknownVersion 消息的编码版本不是CodeVersion
+var ErrUnknownVersion = errors.New("unknown message version")
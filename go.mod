@@ -4,6 +4,7 @@ go 1.15
 
 require (
 	github.com/BurntSushi/toml v0.3.1
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1
 	github.com/natefinch/lumberjack v2.0.0+incompatible
 	go.uber.org/zap v1.16.0
 	golang.org/x/tools v0.0.0-20191029190741-b9c20aec41a5
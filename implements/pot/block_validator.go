@@ -0,0 +1,205 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/29/20 10:20 AM
+* @Description: 区块校验流水线，区块在添加到区块链之前必须通过
+***********************************************************************/
+
+package pot
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+)
+
+/*
+	区块依次经过以下阶段，任一阶段失败则整个区块被拒绝：
+	1. index		与本地前一区块序号连续
+	2. prevhash		PrevHash与本地前一区块哈希一致
+	3. timestamp	时间戳晚于前一区块，不超前于时钟，新区块还不能早于最近几轮
+	4. selfhash		重新计算区块哈希
+	5. merkle		重新计算默克尔根
+	6. maker		出块者签名
+	7. duptx		区块内无重复交易
+	8. txsig		逐笔验证交易哈希与签名
+	9. app			应用层注册的交易语义校验(requires.Validator)
+
+	本地没有前一区块时(例如正在同步、链不连续)，1、2两步以及时间戳的下界检查跳过，
+	其连续性由区块链在补齐空缺时保证。
+*/
+
+// blockContext 校验一个区块所需的上下文
+type blockContext struct {
+	prev  *defines.Block // 本地已有的前一区块，未知时为nil
+	now   time.Time      // 参照的时钟时刻
+	fresh bool           // 是否是本轮刚出的新区块，是则检查时间戳的下界
+}
+
+// blockStage 校验流水线的一个阶段
+type blockStage struct {
+	name  string
+	check func(p *Pot, b *defines.Block, ctx *blockContext) error
+}
+
+// blockPipeline 区块校验流水线，按顺序执行，开销小的检查放在前面
+var blockPipeline = []blockStage{
+	{"index", checkBlockIndex},
+	{"prevhash", checkBlockPrevHash},
+	{"timestamp", checkBlockTimestamp},
+	{"selfhash", checkBlockSelfHash},
+	{"merkle", checkBlockMerkle},
+	{"maker", checkBlockMaker},
+	{"duptx", checkBlockDupTx},
+	{"txsig", checkBlockTxSig},
+	{"app", checkBlockApp},
+}
+
+// RegisterValidator 注册应用层的交易语义校验器
+// 区块中的每笔交易都要通过所有已注册的校验器，区块才会被接受
+func (p *Pot) RegisterValidator(v requires.Validator) {
+	if v == nil {
+		return
+	}
+	p.validatorsLock.Lock()
+	p.validators = append(p.validators, v)
+	p.validatorsLock.Unlock()
+}
+
+// validateNewBlock 校验本轮刚出的新区块，now为本轮的时钟时刻
+func (p *Pot) validateNewBlock(b *defines.Block, now time.Time) error {
+	return p.validateBlock(b, &blockContext{
+		prev:  p.localBlock(b.Index - 1),
+		now:   now,
+		fresh: true,
+	})
+}
+
+// validateSyncBlock 校验同步得到的历史区块或启动时请求的区块
+func (p *Pot) validateSyncBlock(b *defines.Block) error {
+	return p.validateBlock(b, &blockContext{
+		prev: p.localBlock(b.Index - 1),
+		now:  time.Now(),
+	})
+}
+
+// validateBlock 让区块依次经过校验流水线
+func (p *Pot) validateBlock(b *defines.Block, ctx *blockContext) error {
+	if b == nil {
+		return &BlockValidationError{Stage: "nil", Err: fmt.Errorf("nil block")}
+	}
+	for _, stage := range blockPipeline {
+		if err := stage.check(p, b, ctx); err != nil {
+			return &BlockValidationError{Stage: stage.name, Block: b.ShortName(), Err: err}
+		}
+	}
+	return nil
+}
+
+// localBlock 查询本地序号为index的区块，没有则返回nil
+func (p *Pot) localBlock(index int64) *defines.Block {
+	if index < 1 || index > p.bc.GetMaxIndex() {
+		return nil
+	}
+	blocks, err := p.bc.GetBlocksByRange(index, 1)
+	if err != nil || len(blocks) == 0 {
+		return nil
+	}
+	return blocks[0]
+}
+
+func checkBlockIndex(p *Pot, b *defines.Block, ctx *blockContext) error {
+	if b.Index < 1 {
+		return fmt.Errorf("%w: index=%d", ErrBlockIndex, b.Index)
+	}
+	if ctx.prev != nil && ctx.prev.Index+1 != b.Index {
+		return fmt.Errorf("%w: prev=%d, index=%d", ErrBlockIndex, ctx.prev.Index, b.Index)
+	}
+	return nil
+}
+
+func checkBlockPrevHash(p *Pot, b *defines.Block, ctx *blockContext) error {
+	if ctx.prev != nil && !bytes.Equal(ctx.prev.SelfHash, b.PrevHash) {
+		return ErrBlockPrevHash
+	}
+	return nil
+}
+
+func checkBlockTimestamp(p *Pot, b *defines.Block, ctx *blockContext) error {
+	now := ctx.now.UnixNano()
+	timing := p.getTiming()
+	if b.Timestamp > now+int64(timing.maxBlockClockDrift()) {
+		return fmt.Errorf("%w: ahead of clock by %s", ErrBlockTimestamp, time.Duration(b.Timestamp-now))
+	}
+	if ctx.fresh && b.Timestamp < now-int64(timing.maxNewBlockAge()) {
+		return fmt.Errorf("%w: stale new block, made %s ago", ErrBlockTimestamp, time.Duration(now-b.Timestamp))
+	}
+	if ctx.prev != nil && b.Timestamp <= ctx.prev.Timestamp {
+		return fmt.Errorf("%w: not after prev block", ErrBlockTimestamp)
+	}
+	return nil
+}
+
+func checkBlockSelfHash(p *Pot, b *defines.Block, ctx *blockContext) error {
+	return b.VerifyHash()
+}
+
+func checkBlockMerkle(p *Pot, b *defines.Block, ctx *blockContext) error {
+	return b.VerifyMerkle()
+}
+
+func checkBlockMaker(p *Pot, b *defines.Block, ctx *blockContext) error {
+	pub, err := p.pubKeyOf(b.Maker)
+	if err != nil {
+		return err
+	}
+	return b.VerifySig(pub)
+}
+
+func checkBlockDupTx(p *Pot, b *defines.Block, ctx *blockContext) error {
+	seen := make(map[string]struct{}, len(b.Txs))
+	for _, tx := range b.Txs {
+		k := string(tx.TxHash)
+		if _, ok := seen[k]; ok {
+			return fmt.Errorf("%w: %s", ErrBlockDupTx, tx.Key())
+		}
+		seen[k] = struct{}{}
+	}
+	return nil
+}
+
+func checkBlockTxSig(p *Pot, b *defines.Block, ctx *blockContext) error {
+	// 同一区块中同一发起者的交易通常很多，缓存其公钥
+	pubs := make(map[string]crypto.PublicKey)
+	for _, tx := range b.Txs {
+		pub, ok := pubs[tx.From]
+		if !ok {
+			var err error
+			pub, err = p.pubKeyOf(tx.From)
+			if err != nil {
+				return fmt.Errorf("tx(%s): %w", tx.Key(), err)
+			}
+			pubs[tx.From] = pub
+		}
+		if err := tx.Verify(pub); err != nil {
+			return fmt.Errorf("tx(%s): %w", tx.Key(), err)
+		}
+	}
+	return nil
+}
+
+func checkBlockApp(p *Pot, b *defines.Block, ctx *blockContext) error {
+	p.validatorsLock.RLock()
+	defer p.validatorsLock.RUnlock()
+	for _, v := range p.validators {
+		for _, tx := range b.Txs {
+			if err := v.ValidateTx(b, tx); err != nil {
+				return fmt.Errorf("tx(%s): %w", tx.Key(), err)
+			}
+		}
+	}
+	return nil
+}
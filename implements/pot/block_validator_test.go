@@ -0,0 +1,119 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/29/20 2:35 PM
+* @Description: 区块校验流水线测试
+***********************************************************************/
+
+package pot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+)
+
+func TestPot_ValidateBlock(t *testing.T) {
+	makerKey, maker := newTestKey(t)
+	senderKey, sender := newTestKey(t)
+	strangerKey, stranger := newTestKey(t)
+	fakeKey, _ := newTestKey(t)
+	selfKey, self := newTestKey(t)
+
+	p := newTestPot(t, selfKey,
+		&defines.PeerInfo{Id: maker, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(makerKey.Public())},
+		&defines.PeerInfo{Id: sender, Addr: "127.0.0.1:8002", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(senderKey.Public())})
+	genesis, err := p.bc.CreateTheWorld()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTx := func(from string, amount int64, key crypto.PrivateKey) *defines.Transaction {
+		tx, err := defines.NewTransactionAndSign(from, self, amount, nil, "", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	newBlock := func(index int64, prev []byte, txs []*defines.Transaction, key crypto.PrivateKey) *defines.Block {
+		b, err := defines.NewBlockAndSign(index, maker, prev, txs, "", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tx := newTx(sender, 1, senderKey)
+	valid := newBlock(2, genesis.SelfHash, []*defines.Transaction{tx}, makerKey)
+	if err := p.validateNewBlock(valid, time.Now()); err != nil {
+		t.Fatalf("valid block rejected: %s", err)
+	}
+
+	noTxs := newBlock(2, genesis.SelfHash, []*defines.Transaction{tx}, makerKey)
+	noTxs.Txs = nil
+
+	p.RegisterValidator(requires.ValidatorFunc(func(b *defines.Block, tx *defines.Transaction) error {
+		if tx.Amount > 100 {
+			return errors.New("amount too large")
+		}
+		return nil
+	}))
+
+	tests := []struct {
+		name    string
+		b       *defines.Block
+		ctx     *blockContext
+		stage   string
+		wantErr error
+	}{
+		{"index gap", newBlock(3, genesis.SelfHash, nil, makerKey),
+			&blockContext{prev: genesis, now: time.Now()}, "index", ErrBlockIndex},
+		{"wrong prev hash", newBlock(2, []byte("not the genesis"), nil, makerKey),
+			nil, "prevhash", ErrBlockPrevHash},
+		{"ahead of clock", valid,
+			&blockContext{now: time.Now().Add(-2 * p.getTiming().maxBlockClockDrift())}, "timestamp", ErrBlockTimestamp},
+		{"stale new block", valid,
+			&blockContext{now: time.Now().Add(p.getTiming().maxNewBlockAge() + time.Second), fresh: true}, "timestamp", ErrBlockTimestamp},
+		{"txs stripped", noTxs, nil, "merkle", nil},
+		{"forged maker", newBlock(2, genesis.SelfHash, nil, fakeKey), nil, "maker", nil},
+		{"duplicate tx", newBlock(2, genesis.SelfHash, []*defines.Transaction{tx, tx}, makerKey),
+			nil, "duptx", ErrBlockDupTx},
+		{"forged tx", newBlock(2, genesis.SelfHash, []*defines.Transaction{newTx(sender, 1, fakeKey)}, makerKey),
+			nil, "txsig", crypto.ErrVerifySigFail},
+		{"unknown tx sender", newBlock(2, genesis.SelfHash, []*defines.Transaction{newTx(stranger, 1, strangerKey)}, makerKey),
+			nil, "txsig", nil},
+		{"app rejected", newBlock(2, genesis.SelfHash, []*defines.Transaction{newTx(sender, 1000, senderKey)}, makerKey),
+			nil, "app", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.ctx == nil {
+				err = p.validateNewBlock(tt.b, time.Now())
+			} else {
+				err = p.validateBlock(tt.b, tt.ctx)
+			}
+			var verr *BlockValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("err = %v, want BlockValidationError", err)
+			}
+			if verr.Stage != tt.stage {
+				t.Errorf("stage = %s, want %s (err: %s)", verr.Stage, tt.stage, err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// 已注册的校验器不影响正常交易
+	if err := p.validateNewBlock(valid, time.Now()); err != nil {
+		t.Errorf("valid block rejected after RegisterValidator: %s", err)
+	}
+}
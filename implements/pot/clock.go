@@ -48,7 +48,7 @@ func (mt MomentType) String() string {
 // bn -> [recv bn] -> decide bn / trigger clock / broadcast proof
 // -> decide bn1 maker / make / wait -> bn1 -> ...
 
-// 两个区块之间的时间被均匀分成两段，每段TickMs
+// 两个区块之间的时间被分成两段，划分方式见Timing
 
 // Clock 时钟
 // 使用：
@@ -68,6 +68,8 @@ type Clock struct {
 	// 启用的话，程序会利用每一次的新区块进行时间纠偏
 	enableTimeCorrect bool
 
+	timing Timing // Start之后不能再修改
+
 	t       *time.Timer
 	done    chan struct{}
 	trigger chan int64  // unixnano timestamp
@@ -75,11 +77,12 @@ type Clock struct {
 	epoch   int64
 }
 
-func NewClock(enableTimeCorrect bool) *Clock {
+func NewClock(enableTimeCorrect bool, timing Timing) *Clock {
 	timer := time.NewTimer(0)
 	<-timer.C
 	return &Clock{
 		enableTimeCorrect:enableTimeCorrect,
+		timing:  timing,
 		t:       timer,
 		done:    make(chan struct{}),
 		trigger: make(chan int64),
@@ -151,21 +154,22 @@ func (c *Clock) Trigger(b *defines.Block) error {
 // 每确定一个新区块，需要驱动出两个时刻信号(PotOver(n) PotStart(n+1))
 func (c *Clock) loop() {
 
-	unit := int64(TickMs * time.Millisecond)
-	divisor := 2 * unit
+	compete, decide := c.timing.compete(), c.timing.decide()
+	divisor := int64(c.timing.round())
 
 	for {
 		select {
 		case <-c.done:
 			return
 		case bt := <-c.trigger:
+			// 距下一个PotOver的时长，PotStart在PotOver之前compete处
 			delta := divisor - (time.Now().UnixNano() - bt)%divisor
 			//fmt.Printf("delta: %dms\n", delta/1e6)
 			//fmt.Println("now: ", time.Now())
 			var phase1, phase2 time.Duration
-			if delta < unit {
+			if delta < int64(compete) {
 				phase1 = time.Duration(delta)
-				phase2 = time.Duration(unit)
+				phase2 = decide
 				//fmt.Println(phase1.Milliseconds(), phase2.Milliseconds())
 				//fmt.Println("1111", time.Now())
 				c.t.Reset(phase1)
@@ -184,7 +188,7 @@ func (c *Clock) loop() {
 
 
 			} else {
-				phase1 = time.Duration(delta - unit)
+				phase1 = time.Duration(delta) - compete
 				c.t.Reset(phase1)
 				c.Tick <- Moment{
 					Type: MomentType_PotStart,
@@ -201,20 +205,20 @@ func (c *Clock) loop2(base int64) {
 
 	//potOverTick, potStartTick := new(time.Ticker), new(time.Ticker)
 
-	unit := int64(TickMs * time.Millisecond)
-	divisor := 2 * unit
+	compete, decide := c.timing.compete(), c.timing.decide()
+	divisor := int64(c.timing.round())
 	delta := divisor - (time.Now().UnixNano() - base) % divisor
 	//fmt.Println(delta / 1e6)
 	var startBegin, overBegin *time.Timer
-	if delta < unit {
+	if delta < int64(compete) {
 		overBegin = time.NewTimer(time.Duration(delta))
-		startBegin = time.NewTimer(time.Duration(delta + unit))
+		startBegin = time.NewTimer(time.Duration(delta) + decide)
 		//time.Sleep()
 		//potOverTick = time.NewTicker(time.Duration(divisor) * time.Nanosecond)
 		//time.Sleep(time.Duration(unit))
 		//potStartTick = time.NewTicker(time.Duration(divisor) * time.Nanosecond)
 	} else {
-		startBegin = time.NewTimer(time.Duration(delta - unit))
+		startBegin = time.NewTimer(time.Duration(delta) - compete)
 		overBegin = time.NewTimer(time.Duration(delta))
 
 		//time.Sleep(time.Duration(delta - unit))
@@ -271,4 +275,4 @@ func (c *Clock) Close() {
 // 接下来是1次tick还是两次tick时，出现问题，因为处理时延是不确定的，虽然都在10us级
 
 // makeblock -> trigger / decide block / trigger
-// 这个过程比TickMs会稍微多一些
+// 这个过程比一个滴答会稍微多一些
@@ -26,7 +26,7 @@ func testClockUser1(t *testing.T, c *Clock, zero time.Time) {
 				now := time.Now()
 				t.Logf("now....: %v\n", now.Sub(zero).Milliseconds())
 				err := c.Trigger(&defines.Block{
-					Timestamp: now.UnixNano() - TickMs*int64(time.Millisecond),
+					Timestamp: now.UnixNano() - DefaultTickMs*int64(time.Millisecond),
 				})
 				if err != nil {
 					t.Error(err)
@@ -53,13 +53,13 @@ func testClockUser2(t *testing.T, c *Clock, zero time.Time) {
 }
 
 // 正常情况指的是Clock已经启动，新区块是在Clock控制之下来触发的
-// zero.UnixNano() - TickMs * int64(time.Millisecond)
+// zero.UnixNano() - DefaultTickMs * int64(time.Millisecond)
 func TestClock_NormalCase(t *testing.T) {
-	c := NewClock(true)
+	c := NewClock(true, DefaultTiming)
 
 	zero := time.Now()
 	baseBlock := &defines.Block{
-		Timestamp: zero.UnixNano() - TickMs*int64(time.Millisecond),
+		Timestamp: zero.UnixNano() - DefaultTickMs*int64(time.Millisecond),
 	}
 	fmt.Println("recv a decided block and pot start now")
 	t.Logf("start1111: %d\n", time.Now().Sub(zero).Milliseconds())
@@ -76,11 +76,11 @@ func TestClock_NormalCase(t *testing.T) {
 // StartCase指接收到最新区块，然后以此触发clock，启动clock
 // 存在两种情况，一种是启动clock时接下里就要“decide block”，一种是接下来就要“pot over / make block” 再"decide block"
 func TestClock_StartCase1(t *testing.T) {
-	c := NewClock(true)
+	c := NewClock(true, DefaultTiming)
 
 	zero := time.Now()
 	baseBlock := &defines.Block{
-		Timestamp: zero.UnixNano() - 1.5*TickMs*int64(time.Millisecond),
+		Timestamp: zero.UnixNano() - 1.5*DefaultTickMs*int64(time.Millisecond),
 	}
 	fmt.Println("recv a decided block")
 	t.Logf("start1111: %d\n", time.Now().Sub(zero).Milliseconds())
@@ -97,11 +97,11 @@ func TestClock_StartCase1(t *testing.T) {
 // StartCase指接收到最新区块，然后以此触发clock，启动clock
 // 存在两种情况，一种是启动clock时接下里就要“decide block”，一种是接下来就要“pot over / make block” 再"decide block"
 func TestClock_StartCase2(t *testing.T) {
-	c := NewClock(true)
+	c := NewClock(true, DefaultTiming)
 
 	zero := time.Now()
 	baseBlock := &defines.Block{
-		Timestamp: zero.UnixNano() - 2.5*TickMs*int64(time.Millisecond),
+		Timestamp: zero.UnixNano() - 2.5*DefaultTickMs*int64(time.Millisecond),
 	}
 	fmt.Println("recv a decided block")
 	t.Logf("start1111: %d\n", time.Now().Sub(zero).Milliseconds())
@@ -118,11 +118,11 @@ func TestClock_StartCase2(t *testing.T) {
 //////////////////////////// 测试基于loop2的时钟 ///////////////////////////////
 
 func TestClock_disableTimeCorrect(t *testing.T) {
-	c := NewClock(false)
+	c := NewClock(false, DefaultTiming)
 
 	zero := time.Now()
 	baseBlock := &defines.Block{
-		Timestamp: zero.UnixNano() - 2.5*TickMs*int64(time.Millisecond),
+		Timestamp: zero.UnixNano() - 2.5*DefaultTickMs*int64(time.Millisecond),
 	}
 	fmt.Println("recv a decided block")
 	t.Logf("start1111: %d\n", time.Now().Sub(zero).Milliseconds())
@@ -0,0 +1,27 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/19/20 2:30 PM
+* @Description: 网络模块的连接事件
+***********************************************************************/
+
+package pot
+
+import (
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+// HandleConnEvent 网络模块的连接事件
+// 断线重连由网络模块负责，共识这边只关心种子是否可达：所有种子都断开时，本节点无法同步节点表与进度
+func (p *Pot) HandleConnEvent(ev *defines.ConnEvent) {
+	switch ev.Type {
+	case defines.ConnEvent_Connected:
+		p.Infof("connected to %s", identity.Short(ev.Id))
+	case defines.ConnEvent_Disconnected:
+		if p.pit.IsSeed(ev.Id) {
+			p.Warnf("disconnected from seed %s: %v", identity.Short(ev.Id), ev.Err)
+		} else {
+			p.Infof("disconnected from %s: %v", identity.Short(ev.Id), ev.Err)
+		}
+	}
+}
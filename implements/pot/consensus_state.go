@@ -0,0 +1,119 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 11/4/20 10:30 AM
+* @Description: 共识状态的保存与恢复
+***********************************************************************/
+
+package pot
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+// consensusState 区块链之外、重启后需要恢复的共识状态
+// 保存在Option.Log中，在每轮决定新区块、收到种子转发的投票以及惩罚作恶者之后更新
+type consensusState struct {
+	Epoch int64
+
+	// 最近一次决定的胜者
+	Decided *Proof
+
+	// 种子转发的投票(<证明者id, 转发的seed>)及被投票的证明，只在重启后仍处于同一轮时恢复
+	BaseIndex    int64
+	Relayers     map[string][]string
+	RelayedProof []*Proof
+
+	// 进度表
+	MaxIndex  int64
+	Processes map[string]*defines.Process
+
+	// 作恶名单
+	Evidences []*Evidence
+}
+
+// Encode 编码
+func (cs *consensusState) Encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(cs)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode 解码
+func (cs *consensusState) Decode(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(cs)
+}
+
+// saveState 将当前共识状态写入Option.Log，没有配置Log时什么也不做
+func (p *Pot) saveState() {
+	if p.clog == nil {
+		return
+	}
+	p.clogLock.Lock()
+	defer p.clogLock.Unlock()
+
+	// saveState也会在消息处理循环中调用，证明表须整体在锁内取快照
+	cs := &consensusState{
+		Epoch:     p.Epoch(),
+		Evidences: p.evidences.all(),
+	}
+	cs.Decided, cs.BaseIndex, cs.Relayers, cs.RelayedProof = p.proofs.snapshot()
+	cs.MaxIndex, cs.Processes = p.processes.snapshot()
+
+	data, err := cs.Encode()
+	if err != nil {
+		p.Errorf("encode consensus state fail: %s", err)
+		return
+	}
+	if err := p.clog.SaveConsensusState(data); err != nil {
+		p.Errorf("save consensus state fail: %s", err)
+	}
+}
+
+// loadState 从Option.Log恢复重启前的共识状态
+// 必须在Init中、证明表设置好基准区块之后、启动各个循环之前调用
+func (p *Pot) loadState() error {
+	if p.clog == nil {
+		return nil
+	}
+	data := p.clog.ReadConsensusState()
+	if len(data) == 0 {
+		return nil
+	}
+	cs := new(consensusState)
+	if err := cs.Decode(data); err != nil {
+		return err
+	}
+
+	p.setEpoch(cs.Epoch)
+	p.proofs.Decided = cs.Decided
+	p.stateRestored = true
+	p.processes.restore(cs.MaxIndex, cs.Processes)
+
+	// 证据在保存前已经验证过，直接恢复惩罚
+	for _, ev := range cs.Evidences {
+		if !p.evidences.add(ev) {
+			continue
+		}
+		offender := ev.Offender()
+		p.proofs.Ban(offender)
+		if err := p.pit.SetAttr(offender, defines.PeerAttr_Malicious); err != nil {
+			p.Warnf("mark %s malicious fail: %s", identity.Short(offender), err)
+		}
+	}
+
+	// 本地区块链没有前进，说明重启前后处于同一轮，之前收到的投票仍然有效
+	if cs.BaseIndex == p.proofs.baseIndex {
+		p.proofs.RestoreRelayed(cs.Relayers, cs.RelayedProof)
+	}
+
+	p.Infof("consensus state restored: epoch %d, %d processes, %d evidences",
+		cs.Epoch, len(cs.Processes), len(cs.Evidences))
+	return nil
+}
@@ -0,0 +1,246 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 11/4/20 11:10 AM
+* @Description: 共识状态保存与恢复测试
+***********************************************************************/
+
+package pot
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/modules/blockchain"
+	"github.com/azd1997/blockchain-consensus/modules/store"
+	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+// memLog 内存中的requires.ConsensusLog
+type memLog struct {
+	state []byte
+}
+
+func (l *memLog) ReadConsensusState() []byte {
+	return l.state
+}
+
+func (l *memLog) SaveConsensusState(state []byte) error {
+	l.state = append([]byte(nil), state...)
+	return nil
+}
+
+func TestPot_ConsensusStateReload(t *testing.T) {
+	cheaterKey, cheater := newTestKey(t)
+	honestKey, honest := newTestKey(t)
+	selfKey, self := newTestKey(t)
+	peers := []*defines.PeerInfo{
+		{Id: cheater, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(cheaterKey.Public())},
+		{Id: honest, Addr: "127.0.0.1:8002", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(honestKey.Public())},
+	}
+	clog := new(memLog)
+
+	p := newTestPotWithOption(t, selfKey, &Option{Log: clog}, peers...)
+	block, err := defines.NewBlockAndSign(1, cheater, nil, nil, "", cheaterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProof := func(id string, txsNum int64, blockHash []byte, key crypto.PrivateKey) *Proof {
+		proof := &Proof{Id: id, TxsNum: txsNum, BlockHash: blockHash, Base: block.PrevHash, BaseIndex: 0}
+		if err := proof.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		return proof
+	}
+
+	// 本轮收到两个种子转发的投票
+	vote := newProof(honest, 1, []byte("honest block"), honestKey)
+	p.proofs.AddProofRelayedBySeed("seed01", vote)
+	p.proofs.AddProofRelayedBySeed("seed02", vote)
+	p.setEpoch(5)
+	p.processes.restore(5, map[string]*defines.Process{honest: {Index: 5, Id: honest}})
+	if err := p.punish(&Evidence{Proof: newProof(cheater, 100, block.SelfHash, cheaterKey), Block: block}); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	// 同一轮内重启，Init时恢复
+	r := newTestPotWithOption(t, selfKey, &Option{Log: clog}, peers...)
+	if err := r.loadLocal(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Epoch() != 5 {
+		t.Errorf("epoch = %d, want 5", r.Epoch())
+	}
+	if r.processes.maxIndex != 5 || r.processes.get(honest).Index != 5 {
+		t.Errorf("processes not restored: maxIndex = %d, %s = %v", r.processes.maxIndex, honest, r.processes.get(honest))
+	}
+	if r.evidences.get(cheater) == nil || !r.proofs.IsBanned(cheater) {
+		t.Error("cheater should still be punished after restart")
+	}
+	info, err := r.pit.Get(cheater)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Attr != defines.PeerAttr_Malicious {
+		t.Errorf("cheater attr = %v, want PeerAttr_Malicious", info.Attr)
+	}
+	if r.proofs.votes(honest) != 2 || !r.proofs.table[honest].Equal(vote) {
+		t.Errorf("relayed votes not restored: %v", r.proofs.relayers)
+	}
+	r.Close()
+
+	// 本地区块链已经进入下一轮，之前的投票作废
+	bc := test.NewBlockChain(self, selfKey)
+	if _, err := bc.CreateTheWorld(); err != nil {
+		t.Fatal(err)
+	}
+	r = newTestPotWithOption(t, selfKey, &Option{Log: clog, BC: bc}, peers...)
+	if err := r.loadLocal(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.proofs.relayers) != 0 || len(r.proofs.table) != 0 {
+		t.Errorf("stale votes restored: %v", r.proofs.relayers)
+	}
+	if !r.proofs.IsBanned(cheater) {
+		t.Error("cheater should still be banned in the next round")
+	}
+}
+
+// saveState在消息处理循环中调用，与状态切换循环并发
+func TestPot_SaveStateConcurrent(t *testing.T) {
+	peerKey, peer := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+	p := newTestPotWithOption(t, selfKey, &Option{Log: new(memLog)})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			p.saveState()
+		}
+	}()
+	for i := int64(0); i < 50; i++ {
+		proof := &Proof{Id: peer, TxsNum: 1, BlockHash: []byte("b"), Base: []byte("base"), BaseIndex: i}
+		if err := proof.Sign(peerKey); err != nil {
+			t.Fatal(err)
+		}
+		p.proofs.AddProofRelayedBySeed("seed01", proof)
+		p.proofs.DecideWinner(Moment{Type: MomentType_PotStart, Time: time.Now()})
+		p.proofs.Reset(Moment{Type: MomentType_PotStart, Time: time.Now()}, nil)
+		p.setEpoch(i)
+	}
+	<-done
+}
+
+func TestPot_RestartSkipsSync(t *testing.T) {
+	selfKey, self := newTestKey(t)
+	log.InitGlobalLogger(self, false, false)
+	clog := new(memLog)
+	bc := test.NewBlockChain(self, selfKey)
+	genesis, err := bc.CreateTheWorld()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestPotWithOption(t, selfKey, &Option{Log: clog, BC: bc})
+	p.setEpoch(genesis.Index)
+	p.Close()
+
+	// 保存的状态就是本地最新区块，且还在同一轮内：不向任何节点同步即可启动
+	r := newTestPotWithOption(t, selfKey, &Option{Log: clog, BC: bc})
+	if err := r.loadLocal(); err != nil {
+		t.Fatal(err)
+	}
+	if !r.restoredStateCurrent(genesis) {
+		t.Fatal("restored state should be current")
+	}
+	if err := r.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if r.getState() != StateType_NotReady {
+		t.Errorf("state = %s, want %s", r.getState(), StateType_NotReady)
+	}
+	r.Close()
+
+	// 区块已过去一轮以上，或状态与本地区块链对不上，都需要重新同步
+	old := *genesis
+	old.Timestamp = time.Now().Add(-2 * r.getTiming().round()).UnixNano()
+	if r.restoredStateCurrent(&old) {
+		t.Error("state older than a round should not be current")
+	}
+	r.setEpoch(genesis.Index - 1)
+	if r.restoredStateCurrent(genesis) {
+		t.Error("state behind the local chain should not be current")
+	}
+	fresh := newTestPot(t, selfKey)
+	if fresh.restoredStateCurrent(genesis) {
+		t.Error("nothing restored, state should not be current")
+	}
+}
+
+// 与Node相同的顺序重启：先构造Pot，再Init持久化的区块链，最后Init共识
+// 证明表的基准区块和投票须在区块链加载之后才能恢复
+func TestPot_RestartWithStoreBlockChain(t *testing.T) {
+	honestKey, honest := newTestKey(t)
+	selfKey, self := newTestKey(t)
+	log.InitGlobalLogger(self, false, false)
+	peers := []*defines.PeerInfo{{Id: honest, Addr: "127.0.0.1:8002", Duty: defines.PeerDuty_Peer,
+		PubKey: crypto.MarshalPublicKey(honestKey.Public())}}
+	dir := t.TempDir()
+	clog := new(memLog)
+
+	openChain := func() (*store.FileStore, *blockchain.BlockChain) {
+		kv := store.NewFileStore(dir, false)
+		bc, err := blockchain.NewBlockChain(self, selfKey, kv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kv, bc
+	}
+
+	kv, bc := openChain()
+	if err := bc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := bc.CreateTheWorld()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPotWithOption(t, selfKey, &Option{Log: clog, BC: bc}, peers...)
+	if err := p.loadLocal(); err != nil {
+		t.Fatal(err)
+	}
+	vote := &Proof{Id: honest, TxsNum: 1, BlockHash: []byte("honest block"), Base: genesis.SelfHash, BaseIndex: genesis.Index}
+	if err := vote.Sign(honestKey); err != nil {
+		t.Fatal(err)
+	}
+	p.proofs.AddProofRelayedBySeed("seed01", vote)
+	p.proofs.AddProofRelayedBySeed("seed02", vote)
+	p.Close()
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	kv, bc = openChain()
+	defer kv.Close()
+	r := newTestPotWithOption(t, selfKey, &Option{Log: clog, BC: bc}, peers...)
+	defer r.Close()
+	if err := bc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.loadLocal(); err != nil {
+		t.Fatal(err)
+	}
+	if r.proofs.baseIndex != genesis.Index || !bytes.Equal(r.proofs.base, genesis.SelfHash) {
+		t.Errorf("proofs base = %d/%x, want %d/%x", r.proofs.baseIndex, r.proofs.base, genesis.Index, genesis.SelfHash)
+	}
+	if r.proofs.votes(honest) != 2 || !r.proofs.table[honest].Equal(vote) {
+		t.Errorf("relayed votes not restored: %v", r.proofs.relayers)
+	}
+}
@@ -0,0 +1,239 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/26/20 2:30 PM
+* @Description: 空轮标记，由种子宣布，各节点据此一致地跳过取不到胜者区块的一轮
+***********************************************************************/
+
+package pot
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/binary"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	胜者区块取不到时，各节点各自判空轮是不安全的：取到区块的节点前进了一个区块，没取到的节点停在原地，链从此分叉。
+
+	因此空轮由种子宣布：种子补取失败后，对 (Index, Base, BlockHash) 签名，广播空轮标记。
+	普通节点补取胜者区块时同时等待这一标记，等到区块则照常确定，等到标记才记为空轮；
+	两者都没等到则本轮不确定，之后迟到的区块回应仍会经handleEntryBlock追加到链上。
+	种子的补取时限(Timing.emptyRoundTimeout)短于普通节点，保证标记能在普通节点放弃等待前送达。
+*/
+
+// EmptyRound 空轮标记
+type EmptyRound struct {
+	Seed      string // 宣布空轮的种子
+	Index     int64  // 空轮的序号，即胜者证明的BaseIndex+1
+	Base      []byte // 该轮基于的区块的哈希
+	BlockHash []byte // 取不到的胜者区块的哈希
+	Sig       []byte // Seed对以上字段的签名
+}
+
+func (er *EmptyRound) Short() string {
+	h := fmt.Sprintf("%x", er.BlockHash)
+	if len(h) > 6 {
+		h = h[:6]
+	}
+	return fmt.Sprintf("empty{%d, by %s, missing %s}", er.Index, identity.Short(er.Seed), h)
+}
+
+// Encode 编码
+func (er *EmptyRound) Encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(er); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode 解码
+func (er *EmptyRound) Decode(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(er)
+}
+
+// emptyRoundSigPrefix 空轮标记签名的域分隔前缀
+const emptyRoundSigPrefix = "bcc-pot-empty-round:"
+
+func (er *EmptyRound) signingBytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, binary.BigEndian,
+		[]byte(emptyRoundSigPrefix),
+		uint8(len(er.Seed)), []byte(er.Seed),
+		er.Index,
+		uint16(len(er.Base)), er.Base,
+		uint16(len(er.BlockHash)), er.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign 种子对标记签名
+func (er *EmptyRound) Sign(key crypto.PrivateKey) error {
+	data, err := er.signingBytes()
+	if err != nil {
+		return err
+	}
+	er.Sig, err = key.Sign(data)
+	return err
+}
+
+// Verify 使用种子(Seed)的公钥验证标记的签名
+func (er *EmptyRound) Verify(pub crypto.PublicKey) error {
+	if pub == nil {
+		return errors.New("nil public key")
+	}
+	if err := identity.MatchPublicKey(er.Seed, pub); err != nil {
+		return fmt.Errorf("invalid empty round Seed: %w", err)
+	}
+	data, err := er.signingBytes()
+	if err != nil {
+		return err
+	}
+	if !pub.Verify(data, er.Sig) {
+		return crypto.ErrVerifySigFail
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////
+
+// emptyRoundTable 收到的空轮标记，按序号索引
+type emptyRoundTable struct {
+	lock    sync.Mutex
+	rounds  map[int64]*EmptyRound
+	waiters map[int64]chan struct{} // 标记到达时关闭
+}
+
+func newEmptyRoundTable() *emptyRoundTable {
+	return &emptyRoundTable{
+		rounds:  make(map[int64]*EmptyRound),
+		waiters: make(map[int64]chan struct{}),
+	}
+}
+
+// add 记录标记，同一序号只记第一个
+func (t *emptyRoundTable) add(er *EmptyRound) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.rounds[er.Index]; ok {
+		return false
+	}
+	t.rounds[er.Index] = er
+	if ch, ok := t.waiters[er.Index]; ok {
+		close(ch)
+		delete(t.waiters, er.Index)
+	}
+	return true
+}
+
+// get 查询index轮的标记
+func (t *emptyRoundTable) get(index int64) *EmptyRound {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.rounds[index]
+}
+
+// wait 返回的chan在index轮的标记到达后关闭
+func (t *emptyRoundTable) wait(index int64) <-chan struct{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ch := make(chan struct{})
+	if _, ok := t.rounds[index]; ok {
+		close(ch)
+		return ch
+	}
+	if w, ok := t.waiters[index]; ok {
+		return w
+	}
+	t.waiters[index] = ch
+	return ch
+}
+
+// prune 丢弃index之前各轮的标记与等待
+func (t *emptyRoundTable) prune(index int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for i := range t.rounds {
+		if i < index {
+			delete(t.rounds, i)
+		}
+	}
+	for i := range t.waiters {
+		if i < index {
+			delete(t.waiters, i)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////
+
+// declareEmptyRound 种子宣布proof所在的一轮为空轮
+func (p *Pot) declareEmptyRound(proof *Proof) error {
+	er := &EmptyRound{
+		Seed:      p.id,
+		Index:     proof.BaseIndex + 1,
+		Base:      proof.Base,
+		BlockHash: proof.BlockHash,
+	}
+	if err := er.Sign(p.key); err != nil {
+		return err
+	}
+	p.emptyRounds.add(er)
+
+	data, err := er.Encode()
+	if err != nil {
+		return err
+	}
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Data,
+		Kind:    defines.MessageKind_EmptyRound,
+		From:    p.id,
+		Entries: []*defines.Entry{{
+			BaseIndex: proof.BaseIndex,
+			Base:      proof.Base,
+			Type:      defines.EntryType_EmptyRound,
+			Data:      data,
+		}},
+	}
+	results, err := p.broadcast(msg, defines.BroadcastScope_All)
+	p.logBroadcast("declareEmptyRound", results)
+	return err
+}
+
+// handleEntryEmptyRound 处理种子宣布的空轮标记
+// 只接受种子本人签名、且基于本地最新区块的标记
+func (p *Pot) handleEntryEmptyRound(from string, ent *defines.Entry) error {
+	er := new(EmptyRound)
+	if err := er.Decode(ent.Data); err != nil {
+		return err
+	}
+	if er.Seed != from || !p.pit.IsSeed(from) {
+		return fmt.Errorf("empty round not declared by seed: %s", identity.Short(from))
+	}
+	pub, err := p.pubKeyOf(from)
+	if err != nil {
+		return err
+	}
+	if err := er.Verify(pub); err != nil {
+		return err
+	}
+
+	latest := p.localBlock(p.bc.GetMaxIndex())
+	if latest == nil || er.Index != latest.Index+1 || !bytes.Equal(er.Base, latest.SelfHash) {
+		return fmt.Errorf("%s does not follow local latest block", er.Short())
+	}
+	if p.emptyRounds.add(er) {
+		p.Infof("seed declared %s", er.Short())
+	}
+	return nil
+}
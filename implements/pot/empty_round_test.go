@@ -0,0 +1,77 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/26/20 4:10 PM
+* @Description: 空轮标记测试
+***********************************************************************/
+
+package pot
+
+import (
+	"testing"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+)
+
+func TestPot_HandleEntryEmptyRound(t *testing.T) {
+	seedKey, seed := newTestKey(t)
+	peerKey, peer := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+
+	p := newTestPot(t, selfKey,
+		&defines.PeerInfo{Id: seed, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Seed,
+			PubKey: crypto.MarshalPublicKey(seedKey.Public())},
+		&defines.PeerInfo{Id: peer, Addr: "127.0.0.1:8002", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(peerKey.Public())})
+	defer p.Close()
+	genesis, err := p.bc.CreateTheWorld()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := func(er *EmptyRound, key crypto.PrivateKey) *defines.Entry {
+		if err := er.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		data, err := er.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &defines.Entry{Type: defines.EntryType_EmptyRound, Data: data}
+	}
+	waiting := p.emptyRounds.wait(2)
+
+	// 普通节点不能宣布空轮
+	if err := p.handleEntryEmptyRound(peer, entry(&EmptyRound{Seed: peer, Index: 2, Base: genesis.SelfHash}, peerKey)); err == nil {
+		t.Error("empty round from peer accepted")
+	}
+	// 冒用种子的名义
+	if err := p.handleEntryEmptyRound(seed, entry(&EmptyRound{Seed: seed, Index: 2, Base: genesis.SelfHash}, peerKey)); err == nil {
+		t.Error("forged empty round accepted")
+	}
+	// 不接在本地最新区块之后
+	if err := p.handleEntryEmptyRound(seed, entry(&EmptyRound{Seed: seed, Index: 3, Base: genesis.SelfHash}, seedKey)); err == nil {
+		t.Error("empty round of a later index accepted")
+	}
+	select {
+	case <-waiting:
+		t.Fatal("waiter released by invalid empty round")
+	default:
+	}
+
+	if err := p.handleEntryEmptyRound(seed, entry(&EmptyRound{Seed: seed, Index: 2, Base: genesis.SelfHash}, seedKey)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-waiting:
+	default:
+		t.Error("waiter not released")
+	}
+	if p.emptyRounds.get(2) == nil {
+		t.Error("empty round not recorded")
+	}
+	p.emptyRounds.prune(3)
+	if p.emptyRounds.get(2) != nil {
+		t.Error("prune should drop earlier rounds")
+	}
+}
@@ -8,11 +8,57 @@ package pot
 
 import (
 	"errors"
+	"fmt"
 )
 
 // ErrCannotConnectToSeedsWhenInit 无法联通种子节点
 var ErrCannotConnectToSeedsWhenInit = errors.New("cannot connect to seeds when init")
 
+// ErrNeighborFromNonSeed 节点信息只采信种子节点发来的
+var ErrNeighborFromNonSeed = errors.New("neighbor info not from seed")
+
+// 证明消息的来源不对
+var (
+	ErrProofNotFromProver = errors.New("proof not sent by its prover")
+	ErrRelayFromNonSeed   = errors.New("proof relayed by non-seed")
+)
+
+// 区块校验流水线(见block_validator.go)各阶段的错误
+var (
+	ErrBlockIndex     = errors.New("block index not continuous with prev block")
+	ErrBlockPrevHash  = errors.New("block PrevHash mismatches prev block")
+	ErrBlockTimestamp = errors.New("block timestamp out of bounds")
+	ErrBlockDupTx     = errors.New("duplicate tx in block")
+)
+
+// 作恶证据(见evidence.go)无效的原因
+var (
+	ErrEvidenceIncomplete = errors.New("incomplete evidence")
+	ErrEvidenceUnrelated  = errors.New("evidence parts are unrelated")
+	ErrEvidenceNoConflict = errors.New("evidence parts do not conflict")
+)
+
+// 请求跟踪(见request_tracker.go)的错误
+var (
+	ErrRequestTimeout = errors.New("request timeout")
+	ErrRequestClosed  = errors.New("request tracker closed")
+)
+
+// BlockValidationError 区块未通过校验流水线的某一阶段
+type BlockValidationError struct {
+	Stage string // 未通过的阶段
+	Block string // 区块短名
+	Err   error
+}
+
+func (e *BlockValidationError) Error() string {
+	return fmt.Sprintf("validate block(%s) fail at stage [%s]: %s", e.Block, e.Stage, e.Err)
+}
+
+func (e *BlockValidationError) Unwrap() error {
+	return e.Err
+}
+
 // MsgHandleError 消息处理错误
 //type MsgHandleError struct {
 //	Duty defines.PeerDuty
@@ -0,0 +1,268 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/30/20 9:45 AM
+* @Description: 作恶证据的记录、验证与传播
+***********************************************************************/
+
+package pot
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	目前可以确认两类作恶：
+	1. EvidenceType_Mismatch 胜者的证明与其区块不一致(Proof.Match失败)，说明证明者谎报了区块内容以赢得竞争。
+	   证明由证明者签名，区块由出块者签名，二者一起构成证据
+	2. EvidenceType_Equivocation 同一节点在同一BaseIndex上签发了两份内容不同的证明，
+	   例如向不同节点报告不同的TxsNum/BlockHash。两份签名的证明本身就是证据
+	证据不可抵赖，任何节点拿到证据后都可以独立验证，不需要信任转发者。
+
+	节点验证证据通过后：
+	1. 将证明者从proofTable中拉黑，此后不再计入其证明
+	2. 在PeerInfoTable中将其标记为PeerAttr_Malicious
+	3. 将证据转发给其他节点。每个作恶者只处理第一份证据，转发因此会终止
+*/
+
+// EvidenceType 作恶证据类型
+type EvidenceType uint8
+
+const (
+	EvidenceType_Mismatch     EvidenceType = 0 // 证明与区块不一致
+	EvidenceType_Equivocation EvidenceType = 1 // 同一轮的两份矛盾证明
+)
+
+func (et EvidenceType) String() string {
+	switch et {
+	case EvidenceType_Mismatch:
+		return "Mismatch"
+	case EvidenceType_Equivocation:
+		return "Equivocation"
+	default:
+		return "Unknown"
+	}
+}
+
+// Evidence 作恶证据
+type Evidence struct {
+	Type     EvidenceType
+	Proof    *Proof         // 作恶者签名的证明
+	Block    *defines.Block // Mismatch: Proof.BlockHash 指向的区块
+	Conflict *Proof         // Equivocation: 与Proof矛盾的另一份证明
+}
+
+// Offender 作恶者
+func (ev *Evidence) Offender() string {
+	return ev.Proof.Id
+}
+
+// Short 简短描述
+func (ev *Evidence) Short() string {
+	if ev.Type == EvidenceType_Equivocation {
+		return fmt.Sprintf("evidence{%s, offender: %s, proofs: %s <> %s}",
+			ev.Type, identity.Short(ev.Proof.Id), ev.Proof.Short(), ev.Conflict.Short())
+	}
+	return fmt.Sprintf("evidence{%s, offender: %s, proof: %s, block: %s}",
+		ev.Type, identity.Short(ev.Proof.Id), ev.Proof.Short(), ev.Block.ShortName())
+}
+
+// Encode 编码
+func (ev *Evidence) Encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(ev)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode 解码
+// ev := new(Evidence)
+func (ev *Evidence) Decode(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(ev)
+}
+
+// Verify 独立验证证据
+// pubKeyOf 用于查询证明者与出块者的公钥
+func (ev *Evidence) Verify(pubKeyOf func(id string) (crypto.PublicKey, error)) error {
+	if ev == nil || ev.Proof == nil {
+		return ErrEvidenceIncomplete
+	}
+
+	// 证明确实出自作恶者
+	pub, err := pubKeyOf(ev.Proof.Id)
+	if err != nil {
+		return err
+	}
+	if err := ev.Proof.Verify(pub); err != nil {
+		return fmt.Errorf("verify proof: %w", err)
+	}
+
+	switch ev.Type {
+	case EvidenceType_Mismatch:
+		return ev.verifyMismatch(pubKeyOf)
+	case EvidenceType_Equivocation:
+		return ev.verifyEquivocation(pub)
+	default:
+		return fmt.Errorf("unknown evidence type(%d)", ev.Type)
+	}
+}
+
+func (ev *Evidence) verifyMismatch(pubKeyOf func(id string) (crypto.PublicKey, error)) error {
+	if ev.Block == nil {
+		return ErrEvidenceIncomplete
+	}
+
+	// 区块本身有效，且正是证明所指的区块
+	makerPub, err := pubKeyOf(ev.Block.Maker)
+	if err != nil {
+		return err
+	}
+	if err := ev.Block.Verify(makerPub); err != nil {
+		return fmt.Errorf("verify block: %w", err)
+	}
+	if !bytes.Equal(ev.Proof.BlockHash, ev.Block.SelfHash) {
+		return ErrEvidenceUnrelated
+	}
+
+	// 二者确实矛盾
+	if ev.Proof.Match(ev.Block) {
+		return ErrEvidenceNoConflict
+	}
+	return nil
+}
+
+// pub 为作恶者公钥，已用于验证ev.Proof
+func (ev *Evidence) verifyEquivocation(pub crypto.PublicKey) error {
+	if ev.Conflict == nil {
+		return ErrEvidenceIncomplete
+	}
+
+	// 两份证明出自同一节点，针对同一轮
+	if ev.Conflict.Id != ev.Proof.Id || ev.Conflict.BaseIndex != ev.Proof.BaseIndex {
+		return ErrEvidenceUnrelated
+	}
+	if err := ev.Conflict.Verify(pub); err != nil {
+		return fmt.Errorf("verify conflict proof: %w", err)
+	}
+
+	// 二者确实矛盾
+	if ev.Proof.Equal(ev.Conflict) {
+		return ErrEvidenceNoConflict
+	}
+	return nil
+}
+
+// evidenceTable 已确认的作恶证据，每个作恶者只保留第一份
+type evidenceTable struct {
+	table map[string]*Evidence // <offender, *Evidence>
+	sync.RWMutex
+}
+
+func newEvidenceTable() *evidenceTable {
+	return &evidenceTable{table: map[string]*Evidence{}}
+}
+
+// add 添加证据，作恶者已有证据时返回false
+func (et *evidenceTable) add(ev *Evidence) bool {
+	et.Lock()
+	defer et.Unlock()
+	if _, ok := et.table[ev.Offender()]; ok {
+		return false
+	}
+	et.table[ev.Offender()] = ev
+	return true
+}
+
+// get 查询作恶者的证据
+func (et *evidenceTable) get(offender string) *Evidence {
+	et.RLock()
+	defer et.RUnlock()
+	return et.table[offender]
+}
+
+// all 所有证据
+func (et *evidenceTable) all() []*Evidence {
+	et.RLock()
+	defer et.RUnlock()
+	evs := make([]*Evidence, 0, len(et.table))
+	for _, ev := range et.table {
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+// punish 验证证据并惩罚作恶者，新的证据会转发给其他节点
+func (p *Pot) punish(ev *Evidence) error {
+	if err := ev.Verify(p.pubKeyOf); err != nil {
+		return err
+	}
+	if !p.evidences.add(ev) {
+		return nil // 已经处理过
+	}
+
+	offender := ev.Offender()
+	p.Warnf("punish malicious peer: %s", ev.Short())
+	p.proofs.Ban(offender)
+	if err := p.pit.SetAttr(offender, defines.PeerAttr_Malicious); err != nil {
+		p.Errorf("mark %s malicious fail: %s", identity.Short(offender), err)
+	}
+	p.saveState()
+
+	go func() {
+		if err := p.broadcastEvidence(ev); err != nil {
+			p.Errorf("broadcast %s fail: %s", ev.Short(), err)
+		}
+	}()
+	return nil
+}
+
+// 处理Evidence
+func (p *Pot) handleEntryEvidence(from string, ent *defines.Entry) error {
+	ev := new(Evidence)
+	if err := ev.Decode(ent.Data); err != nil {
+		return err
+	}
+	return p.punish(ev)
+}
+
+// 将作恶证据广播给所有种子节点和共识节点(作恶者除外)
+func (p *Pot) broadcastEvidence(ev *Evidence) error {
+	evBytes, err := ev.Encode()
+	if err != nil {
+		return err
+	}
+	entry := &defines.Entry{
+		Type: defines.EntryType_Evidence,
+		Data: evBytes,
+	}
+
+	// 广播，作恶者除外，所以逐个列出接收者
+	var tos []string
+	f := func(peer *defines.PeerInfo) error {
+		if peer.Id != ev.Offender() {
+			tos = append(tos, peer.Id)
+		}
+		return nil
+	}
+	p.pit.RangeSeeds(f)
+	p.pit.RangePeers(f)
+
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Data,
+		Kind:    defines.MessageKind_Evidence,
+		From:    p.id,
+		Entries: []*defines.Entry{entry},
+	}
+	results, err := p.broadcast(msg, defines.BroadcastScope_None, tos...)
+	p.logBroadcast("broadcastEvidence", results)
+	return err
+}
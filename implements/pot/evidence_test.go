@@ -0,0 +1,192 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/30/20 11:20 AM
+* @Description: 作恶证据测试
+***********************************************************************/
+
+package pot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+)
+
+func TestEvidence_Verify(t *testing.T) {
+	cheaterKey, cheater := newTestKey(t)
+	honestKey, honest := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+
+	p := newTestPot(t, selfKey,
+		&defines.PeerInfo{Id: cheater, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(cheaterKey.Public())},
+		&defines.PeerInfo{Id: honest, Addr: "127.0.0.1:8002", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(honestKey.Public())})
+
+	block, err := defines.NewBlockAndSign(2, cheater, []byte("base"), nil, "", cheaterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProof := func(id string, txsNum int64, key crypto.PrivateKey) *Proof {
+		proof := &Proof{
+			Id:        id,
+			TxsNum:    txsNum,
+			BlockHash: block.SelfHash,
+			Base:      block.PrevHash,
+			BaseIndex: block.Index - 1,
+		}
+		if err := proof.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		return proof
+	}
+
+	// 谎报交易数量
+	lie := &Evidence{Proof: newProof(cheater, 100, cheaterKey), Block: block}
+	if err := lie.Verify(p.pubKeyOf); err != nil {
+		t.Errorf("valid evidence rejected: %s", err)
+	}
+
+	// 经过编解码后仍可验证
+	data, err := lie.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(Evidence)
+	if err := decoded.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Verify(p.pubKeyOf); err != nil {
+		t.Errorf("decoded evidence rejected: %s", err)
+	}
+
+	// 冒领他人的区块
+	if err := (&Evidence{Proof: newProof(honest, 0, honestKey), Block: block}).Verify(p.pubKeyOf); err != nil {
+		t.Errorf("evidence of claiming others' block rejected: %s", err)
+	}
+
+	// 诚实的证明不构成证据
+	ev := &Evidence{Proof: newProof(cheater, 0, cheaterKey), Block: block}
+	if err := ev.Verify(p.pubKeyOf); !errors.Is(err, ErrEvidenceNoConflict) {
+		t.Errorf("err = %v, want %v", err, ErrEvidenceNoConflict)
+	}
+
+	// 伪造他人签名的证明不能诬陷他人
+	ev = &Evidence{Proof: newProof(honest, 100, cheaterKey), Block: block}
+	if err := ev.Verify(p.pubKeyOf); !errors.Is(err, crypto.ErrVerifySigFail) {
+		t.Errorf("err = %v, want %v", err, crypto.ErrVerifySigFail)
+	}
+
+	// 证明与区块无关
+	other, err := defines.NewBlockAndSign(2, honest, []byte("base"), nil, "other", honestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev = &Evidence{Proof: newProof(cheater, 100, cheaterKey), Block: other}
+	if err := ev.Verify(p.pubKeyOf); !errors.Is(err, ErrEvidenceUnrelated) {
+		t.Errorf("err = %v, want %v", err, ErrEvidenceUnrelated)
+	}
+
+	if err := (&Evidence{Block: block}).Verify(p.pubKeyOf); !errors.Is(err, ErrEvidenceIncomplete) {
+		t.Errorf("err = %v, want %v", err, ErrEvidenceIncomplete)
+	}
+}
+
+func TestPot_Punish(t *testing.T) {
+	cheaterKey, cheater := newTestKey(t)
+	honestKey, honest := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+
+	p := newTestPot(t, selfKey,
+		&defines.PeerInfo{Id: cheater, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(cheaterKey.Public())},
+		&defines.PeerInfo{Id: honest, Addr: "127.0.0.1:8002", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(honestKey.Public())})
+
+	block, err := defines.NewBlockAndSign(2, cheater, []byte("base"), nil, "", cheaterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProof := func(id string, txsNum int64, blockHash []byte, key crypto.PrivateKey) *Proof {
+		proof := &Proof{Id: id, TxsNum: txsNum, BlockHash: blockHash, Base: block.PrevHash, BaseIndex: 1}
+		if err := proof.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		return proof
+	}
+	lie := newProof(cheater, 100, block.SelfHash, cheaterKey)
+	honestProof := newProof(honest, 1, []byte("honest block"), honestKey)
+
+	p.proofs.Add(lie)
+	p.proofs.Add(honestProof)
+	if p.proofs.winner != lie {
+		t.Fatal("the lying proof should be winning before punishment")
+	}
+
+	// 收到其他节点转发的证据
+	ev := &Evidence{Proof: lie, Block: block}
+	data, err := ev.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.handleEntryEvidence(honest, &defines.Entry{Type: defines.EntryType_Evidence, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.evidences.get(cheater) == nil {
+		t.Error("evidence not recorded")
+	}
+	if !p.proofs.IsBanned(cheater) {
+		t.Error("cheater not banned in proofTable")
+	}
+	if p.proofs.winner != honestProof {
+		t.Error("winner should fall back to the honest proof")
+	}
+	info, err := p.pit.Get(cheater)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Attr != defines.PeerAttr_Malicious {
+		t.Errorf("cheater attr = %v, want PeerAttr_Malicious", info.Attr)
+	}
+
+	// 此后作恶者的证明不再计入，转发的也不行
+	p.proofs.Add(lie)
+	p.proofs.AddProofRelayedBySeed("seed01", lie)
+	if p.proofs.winner != honestProof || p.proofs.votes(cheater) != 0 {
+		t.Error("proof of banned peer should be ignored")
+	}
+
+	// 邻居信息不能洗白作恶者
+	fresh := *info
+	fresh.Attr = defines.PeerAttr_Normal
+	freshBytes, err := fresh.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	neighbor := &defines.Entry{Type: defines.EntryType_Neighbor, Data: freshBytes}
+	if err := p.handleEntryNeighbor(honest, neighbor); !errors.Is(err, ErrNeighborFromNonSeed) {
+		t.Errorf("err = %v, want ErrNeighborFromNonSeed", err)
+	}
+	_, seed := newTestKey(t)
+	if err := p.pit.AddSeeds(map[string]string{seed: "127.0.0.1:8003"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.handleEntryNeighbor(seed, neighbor); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := p.pit.Get(cheater); info.Attr != defines.PeerAttr_Malicious {
+		t.Error("neighbor info should not clear PeerAttr_Malicious")
+	}
+
+	// 伪造的证据不会导致惩罚
+	framed := &Evidence{Proof: newProof(honest, 100, block.SelfHash, cheaterKey), Block: block}
+	if err := p.punish(framed); err == nil {
+		t.Error("forged evidence should be rejected")
+	}
+	if p.proofs.IsBanned(honest) {
+		t.Error("honest peer should not be banned")
+	}
+}
@@ -8,16 +8,19 @@ package pot
 
 import (
 	"errors"
+	"sync"
+	"sync/atomic"
 
 	"github.com/azd1997/blockchain-consensus/defines"
 	"github.com/azd1997/blockchain-consensus/modules/peerinfo"
 	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
 	"github.com/azd1997/blockchain-consensus/utils/log"
 )
 
 const (
-	// TickMs 逻辑时钟每个滴答就是500ms
-	TickMs = 500
+	// DefaultTickMs 默认每个滴答500ms，实际使用的见Timing
+	DefaultTickMs = 500
 
 	// DefaultMsgChanLen 默认channel长度
 	DefaultMsgChanLen = 100
@@ -30,19 +33,32 @@ const (
 type Option struct {
 	Id   string
 	Duty defines.PeerDuty
+	Key  crypto.PrivateKey // 节点私钥，用于消息签名
 	Pit  *peerinfo.PeerInfoTable
 	BC   requires.BlockChain
+
+	// Log 共识状态日志，用于重启后恢复共识状态，为nil则不保存
+	Log requires.ConsensusLog
+
+	// Timing 每轮的时间划分，零值使用DefaultTiming
+	// 仅在本节点创建创世区块时生效，否则以创世区块中记录的为准
+	Timing Timing
+
+	// Validators 应用层的交易语义校验器，也可以在New之后通过RegisterValidator添加
+	Validators []requires.Validator
 }
 
 // Pot pot节点
 type Pot struct {
-	id   string           // 账户、节点、客户端共用一个ID
-	duty defines.PeerDuty // 普通结点/种子节点/工人节点
+	id   string            // 账户、节点、客户端共用一个ID
+	duty defines.PeerDuty  // 普通结点/种子节点/工人节点
+	key  crypto.PrivateKey // 节点私钥
 
 	//latest bool // 本节点是否追上系统最新进度
 
 	// epoch等效于网络中最新区块索引
 	// 节点启动时必须Ready后判断当前处于哪一epoch
+	// 消息处理循环也会读取，须经Epoch/setEpoch原子访问
 	epoch int64
 
 	state StateType // 状态状态
@@ -51,10 +67,8 @@ type Pot struct {
 	//processesLock *sync.RWMutex
 	processes *processTable
 
-	// 用于p.loopBeforeReady
-	nWait          int
-	nWaitChan      chan int
-	nWaitBlockChan chan *defines.Block
+	// 启动阶段发出的请求，见request_tracker.go
+	requests *requestTracker
 
 	// 对外提供的消息通道
 	// 本机节点生成新交易时，也是构造成交易消息从msgin传入
@@ -83,6 +97,27 @@ type Pot struct {
 
 	udbt *undecidedBlockTable
 
+	evidences *evidenceTable // 已确认的作恶证据
+
+	// 正在按哈希补取的区块，见pot_fetch.go
+	fetching     map[string]chan *defines.Block
+	fetchingLock sync.Mutex
+
+	emptyRounds *emptyRoundTable // 种子宣布的空轮，见empty_round.go
+
+	// 应用层注册的交易语义校验器，见block_validator.go
+	validators     []requires.Validator
+	validatorsLock sync.RWMutex
+
+	// 时间划分(Timing)，启动时可能被创世区块中的替换，见timing.go
+	timing atomic.Value
+
+	// 共识状态日志，见consensus_state.go
+	clog     requires.ConsensusLog
+	clogLock sync.Mutex
+	// 启动时从clog恢复了共识状态，重启时据此判断能否跳过同步
+	stateRestored bool
+
 	// 区块缓存的事交给Blockchain去做，这里不管
 	//blocksCache map[string]*defines.Block // 同步到本机节点的区块，但尚未排好序的。也就是序列化没有接着本地最高区块后边的
 	//blocksLock *sync.RWMutex
@@ -120,36 +155,43 @@ func New(opt *Option) (*Pot, error) {
 	if logger == nil {
 		return nil, errors.New("nil logger, please init logger first")
 	}
+	if opt.Key == nil {
+		return nil, errors.New("nil private key")
+	}
 
-	var latestBlockHash []byte
-	latestIndex := opt.BC.GetMaxIndex()
-	if latestIndex > 0 {
-		latestBlock, err := opt.BC.GetBlocksByRange(-1, 1)
-		if err != nil {
-			return nil, err
-		}
-		if len(latestBlock) > 0 {
-			latestBlockHash = latestBlock[0].SelfHash
-		}
+	timing, err := opt.Timing.normalize()
+	if err != nil {
+		return nil, err
 	}
-	proofs := newProofTable(latestIndex, latestBlockHash)
 
 	p := &Pot{
 		id:                  opt.Id,
 		duty:                opt.Duty,
-		clock:               NewClock(false),
+		key:                 opt.Key,
+		clock:               NewClock(false, timing),
 		processes:           newProcessTable(),
 		msgin:               make(chan *defines.Message, DefaultMsgChanLen),
 		msgout:              make(chan *defines.MessageWithError, DefaultMsgChanLen),
 		localTxIn:           make(chan *defines.Transaction, DefaultMsgChanLen),
 		potStartBeforeReady: make(chan Moment), //阻塞式
-		proofs:              proofs,
+		proofs:              newProofTable(0, nil), // 基准区块在Init中设置，见loadLocal
 		udbt:                newUndecidedBlockTable(),
+		evidences:           newEvidenceTable(),
+		fetching:            map[string]chan *defines.Block{},
+		emptyRounds:         newEmptyRoundTable(),
 		bc:                  opt.BC,
+		clog:                opt.Log,
 		done:                make(chan struct{}),
 		Logger:              logger,
 	}
 
+	p.timing.Store(timing)
+	p.requests = newRequestTracker(p.done)
+
+	for _, v := range opt.Validators {
+		p.RegisterValidator(v)
+	}
+
 	if opt.Pit == nil {
 		p.pit = peerinfo.Global()
 	} else {
@@ -160,6 +202,8 @@ func New(opt *Option) (*Pot, error) {
 		}
 	}
 
+	p.processes.alive = p.isAlive
+
 	return p, nil
 }
 
@@ -167,6 +211,7 @@ func New(opt *Option) (*Pot, error) {
 
 // Close 关闭
 func (p *Pot) Close() error {
+	p.saveState()
 	close(p.done)
 	return nil
 }
@@ -8,10 +8,10 @@ package pot
 
 import (
 	"errors"
-	"io"
 	"time"
 
 	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
 // send指向网络中（或者说外部依赖的网络模块）发送消息。 注意本地消息不要通过该方法使用
@@ -20,7 +20,7 @@ import (
 func (p *Pot) send(msg *defines.Message) error {
 	merr := &defines.MessageWithError{
 		Msg: msg,
-		Err: make(chan error),
+		Err: make(chan error, 1),
 	}
 	p.msgout <- merr
 	return <-merr.Err
@@ -31,7 +31,7 @@ func (p *Pot) signAndSendMsg(msg *defines.Message) error {
 	if msg == nil {
 		return errors.New("nil msg")
 	}
-	err := msg.Sign()
+	err := msg.Sign(p.key)
 	if err != nil {
 		return err
 	}
@@ -39,10 +39,43 @@ func (p *Pot) signAndSendMsg(msg *defines.Message) error {
 	return p.send(msg)
 }
 
-//// broadcast
-//func (n *Node) broadcast(msg *defines.Message) {
-//
-//}
+// broadcast 签名一次，由网络模块发给scope所选节点及recipients(不含自己)
+// 返回每个接收者的发送结果 <id, err>。会阻塞到所有接收者都有结果
+func (p *Pot) broadcast(msg *defines.Message, scope defines.BroadcastScope, recipients ...string) (map[string]error, error) {
+	return p.sendBroadcast(defines.NewBroadcast(msg, scope, recipients...))
+}
+
+// gossip 同broadcast，但允许网络模块经gossip层扩散，此时返回的只是直接发送的邻居的结果
+// 用于交易、新区块这类需要到达全网、但不要求发送方直连所有节点的消息
+func (p *Pot) gossip(msg *defines.Message, scope defines.BroadcastScope) (map[string]error, error) {
+	merr := defines.NewBroadcast(msg, scope)
+	merr.Broadcast.Gossip = true
+	return p.sendBroadcast(merr)
+}
+
+func (p *Pot) sendBroadcast(merr *defines.MessageWithError) (map[string]error, error) {
+	msg := merr.Msg
+	if err := msg.Sign(p.key); err != nil {
+		return nil, err
+	}
+	p.msgout <- merr
+	err := <-merr.Err
+	return merr.Broadcast.Results, err
+}
+
+// logBroadcast 按接收者记录广播结果，返回成功送达的数量
+func (p *Pot) logBroadcast(name string, results map[string]error) int {
+	succ := 0
+	for id, err := range results {
+		if err != nil {
+			p.Errorf("%s: to %s fail: %v", name, identity.Short(id), err)
+		} else {
+			p.Debugf("%s: to %s", name, identity.Short(id))
+			succ++
+		}
+	}
+	return succ
+}
 
 // 将tx广播给所有种子节点和共识节点
 func (p *Pot) broadcastTx(tx *defines.Transaction) error {
@@ -56,32 +89,16 @@ func (p *Pot) broadcastTx(tx *defines.Transaction) error {
 	}
 
 	// 广播
-	f := func(peer *defines.PeerInfo) error {
-		if peer.Id != p.id {
-			msg := &defines.Message{
-				Version: defines.CodeVersion,
-				Type:    defines.MessageType_Data,
-				From:    p.id,
-				To:      peer.Id,
-				Entries: []*defines.Entry{entry},
-			}
-			if err := msg.WriteDesc("type", "transaction"); err != nil {
-				return err
-			}
-			if err := p.signAndSendMsg(msg); err != nil {
-				p.Errorf("broadcastTx: to %s fail: %v", peer.Id, err)
-				return err
-			} else {
-				p.Debugf("broadcastTx: to %s", peer.Id)
-			}
-		}
-		return nil
-	}
-
-	//p.pit.RangeSeeds(f)
-	p.pit.RangePeers(f)
-
-	return nil
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Data,
+		Kind:    defines.MessageKind_Transaction,
+		From:    p.id,
+		Entries: []*defines.Entry{entry},
+	}
+	results, err := p.gossip(msg, defines.BroadcastScope_Peers)
+	p.logBroadcast("broadcastTx", results)
+	return err
 }
 
 // 广播自己的证明
@@ -107,6 +124,9 @@ func (p *Pot) broadcastSelfProof() error {
 		Base:      nb.PrevHash,
 		BaseIndex: nb.Index - 1,
 	}
+	if err := proof.Sign(p.key); err != nil {
+		return err
+	}
 
 	// 添加到自己的proofs
 	p.proofs.Add(proof)
@@ -128,34 +148,23 @@ func (p *Pot) broadcastProof(proof *Proof, onlypeers bool) error {
 	}
 
 	// 广播
-	f := func(peer *defines.PeerInfo) error {
-		if peer.Id != p.id {
-			msg := &defines.Message{
-				Version: defines.CodeVersion,
-				Type:    defines.MessageType_Data,
-				From:    p.id,
-				To:      peer.Id,
-				Entries: []*defines.Entry{entry},
-			}
-			if err := msg.WriteDesc("type", "proof"); err != nil {
-				return err
-			}
-			if err := p.signAndSendMsg(msg); err != nil {
-				p.Errorf("broadcastProof: to %s fail: %v", peer.Id, err)
-				return err
-			} else {
-				p.Debugf("broadcastProof: to %s", peer.Id)
-			}
-		}
-		return nil
-	}
-
-	if !onlypeers {
-		p.pit.RangeSeeds(f)
-	}
-	p.pit.RangePeers(f)
-
-	return nil
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Data,
+		Kind:    defines.MessageKind_Proof,
+		From:    p.id,
+		Entries: []*defines.Entry{entry},
+	}
+	if proof.Id != p.id { // 转发别人的证明
+		msg.Kind = defines.MessageKind_RelayedProof
+	}
+	scope := defines.BroadcastScope_All
+	if onlypeers {
+		scope = defines.BroadcastScope_Peers
+	}
+	results, err := p.broadcast(msg, scope)
+	p.logBroadcast("broadcastProof", results)
+	return err
 }
 
 // 广播新区块
@@ -175,37 +184,21 @@ func (p *Pot) broadcastNewBlock(nb *defines.Block) error {
 	}
 
 	// 广播
-	f := func(peer *defines.PeerInfo) error {
-		if peer.Id != p.id {
-			msg := &defines.Message{
-				Version: defines.CodeVersion,
-				Type:    defines.MessageType_Data,
-				From:    p.id,
-				To:      peer.Id,
-				Entries: []*defines.Entry{entry},
-			}
-			if err := msg.WriteDesc("type", "newblock"); err != nil {
-				return err
-			}
-			if err := p.signAndSendMsg(msg); err != nil {
-				p.Errorf("broadcastNewBlock: to %s fail: %v", peer.Id, err)
-				return err
-			} else {
-				p.Debugf("broadcastNewBlock: to %s", peer.Id)
-			}
-		}
-		return nil
-	}
-	p.pit.RangePeers(f)
-	p.pit.RangeSeeds(f)
-	return nil
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Data,
+		Kind:    defines.MessageKind_NewBlock,
+		From:    p.id,
+		Entries: []*defines.Entry{entry},
+	}
+	results, err := p.gossip(msg, defines.BroadcastScope_All)
+	p.logBroadcast("broadcastNewBlock", results)
+	return err
 }
 
 // 广播getNeighbors请求
 // toseeds true则向种子节点广播；否则向所有节点广播
 func (p *Pot) broadcastRequestNeighbors(toseeds bool) error {
-	p.nWait = 0
-
 	// 查询自身节点信息
 	self, err := p.pit.Get(p.id)
 	if err != nil {
@@ -221,78 +214,43 @@ func (p *Pot) broadcastRequestNeighbors(toseeds bool) error {
 		Type: defines.RequestType_Neighbors,
 		Data: selfb,
 	}
-	f := func(peer *defines.PeerInfo) error {
-		if peer.Id == p.id {
-			return nil
-		}
-
-		msg := &defines.Message{
-			Version: defines.CodeVersion,
-			Type:    defines.MessageType_Req,
-			From:    p.id,
-			To:      peer.Id,
-			Reqs:    []*defines.Request{req},
-		}
-		if err := msg.WriteDesc("type", "req-neighbor"); err != nil {
-			return err
-		}
-		if err := p.signAndSendMsg(msg); err != nil {
-			p.Errorf("broadcastRequestNeighbors: to %s fail: %v", peer.Id, err)
-			return err
-		} else {
-			p.Debugf("broadcastRequestNeighbors: to %s", peer.Id)
-			p.nWait++
-		}
-
-		return nil
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Req,
+		Kind:    defines.MessageKind_ReqNeighbors,
+		From:    p.id,
+		Reqs:    []*defines.Request{req},
 	}
-
-	p.pit.RangeSeeds(f)
-	if !toseeds {
-		p.pit.RangePeers(f)
+	scope := defines.BroadcastScope_All
+	if toseeds {
+		scope = defines.BroadcastScope_Seeds
 	}
-	return nil
+	results, err := p.broadcast(msg, scope)
+	p.logBroadcast("broadcastRequestNeighbors", results)
+	return err
 }
 
 // 广播请求所有节点最新进度
 // toseeds true表示向seeds请求所有共识节点进度
 // false 表示seeds/peers都问
 func (p *Pot) broadcastRequestProcesses(toseeds bool) error {
-	p.nWait = 0 // 重置
 	req := &defines.Request{
 		Type: defines.RequestType_Processes,
 	}
-	f := func(peer *defines.PeerInfo) error {
-		if peer.Id == p.id {
-			return nil
-		}
-
-		msg := &defines.Message{
-			Version: defines.CodeVersion,
-			Type:    defines.MessageType_Req,
-			From:    p.id,
-			To:      peer.Id,
-			Reqs:    []*defines.Request{req},
-		}
-		if err := msg.WriteDesc("type", "process"); err != nil {
-			return err
-		}
-		if err := p.signAndSendMsg(msg); err != nil {
-			p.Errorf("broadcastRequestProcesses: to %s fail: %v", peer.Id, err)
-			return err
-		} else {
-			p.Debugf("broadcastRequestProcesses: to %s", peer.Id)
-			p.nWait++
-		}
-
-		return nil
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Req,
+		Kind:    defines.MessageKind_ReqProcesses,
+		From:    p.id,
+		Reqs:    []*defines.Request{req},
 	}
-	p.pit.RangeSeeds(f)
-	if !toseeds {
-		p.pit.RangePeers(f)
+	scope := defines.BroadcastScope_All
+	if toseeds {
+		scope = defines.BroadcastScope_Seeds
 	}
-
-	return nil
+	results, err := p.broadcast(msg, scope)
+	p.logBroadcast("broadcastRequestProcesses", results)
+	return err
 }
 
 // 广播请求区块，追上最新进度
@@ -300,8 +258,6 @@ func (p *Pot) broadcastRequestProcesses(toseeds bool) error {
 // false 则向全部最新进度节点发送请求区块消息
 func (p *Pot) broadcastRequestBlocks(random3 bool) error {
 
-	p.nWait = 0 // 重置
-
 	process := p.processes.get(p.id)
 	req := &defines.Request{
 		Type:       defines.RequestType_Blocks,
@@ -329,19 +285,16 @@ func (p *Pot) broadcastRequestBlocks(random3 bool) error {
 		msg := &defines.Message{
 			Version: defines.CodeVersion,
 			Type:    defines.MessageType_Req,
+			Kind:    defines.MessageKind_ReqBlocks,
 			From:    p.id,
 			To:      peer,
 			Reqs:    []*defines.Request{req},
 		}
-		if err := msg.WriteDesc("type", "req-blocks"); err != nil {
-			return err
-		}
 		if err := p.signAndSendMsg(msg); err != nil {
 			p.Errorf("broadcastRequestBlocks: to %s fail: %s", peer, err)
 			return err
 		} else {
 			p.Debugf("broadcastRequestBlocks: to %s", peer)
-			p.nWait++
 		}
 	}
 
@@ -353,20 +306,18 @@ func (p *Pot) broadcastRequestBlocks(random3 bool) error {
 // hashes 请求的区块的哈希。 hashes为nil或长度为0，则不使用哈希查找
 // nPeers, nSeeds 对请求的peer和seed数量做限制. <0则不生效
 // ids 手动指定向哪些节点请求
-func (p *Pot) requestBlocks(start, end int64, hashes [][]byte, nPeers, nSeeds int, ids ...string) error {
-
-	process := p.processes.get(p.id)
-	req := &defines.Request{
-		Type:       defines.RequestType_Blocks,
-		IndexStart: process.Index + 1, // 自己的进度+1
-		IndexCount: 0,                 // 0表示响应端要回复所有
-	}
+// 发往每个节点的请求都登记到p.requests，timeout后仍未回应则超时；返回已发出的请求，出错时也包括出错前发出的
+func (p *Pot) requestBlocks(start, end int64, hashes [][]byte, nPeers, nSeeds int, timeout time.Duration, ids ...string) ([]*pendingRequest, error) {
 
 	// 构造请求
 	req := &defines.Request{
 		Type:       defines.RequestType_Blocks,
-		IndexStart: process.Index + 1, // 自己的进度+1
-		IndexCount: 0,                 // 0表示响应端要回复所有
+		IndexStart: start,
+		IndexCount: end - start + 1,
+		Hashes:     hashes,
+	}
+	if start <= 0 {
+		req.IndexStart, req.IndexCount = 0, 0
 	}
 
 	// 收集要广播的节点：seeds + 若干peer
@@ -383,58 +334,59 @@ func (p *Pot) requestBlocks(start, end int64, hashes [][]byte, nPeers, nSeeds in
 			break
 		}
 	}
+	// 名额有限，只选可达的peer
 	peers := p.pit.Peers()
 	if nPeers < 0 || nPeers > len(peers) {
 		nPeers = len(peers)
 	}
 	for id := range peers {
+		if !p.isAlive(id) {
+			continue
+		}
 		if nPeers > 0 {
 			tos[id] = struct{}{}
-			nSeeds--
+			nPeers--
 		} else {
 			break
 		}
 	}
-	//
-	if n := len(ids); n > 0 {
-		for i:=0; i<n; i++ {
-			tos[ids[i]] = struct{}{}
-		}
+	// 手动指定的节点
+	for _, id := range ids {
+		tos[id] = struct{}{}
 	}
 
-
-
-	for _, peer := range peers {
-		if peer == p.id {
+	var prs []*pendingRequest
+	for to := range tos {
+		if to == p.id {
 			continue
 		}
+		pr := p.requests.register(to, defines.RequestType_Blocks, timeout)
+		r := *req
+		r.Id = pr.id
 		msg := &defines.Message{
 			Version: defines.CodeVersion,
 			Type:    defines.MessageType_Req,
+			Kind:    defines.MessageKind_ReqBlocks,
 			From:    p.id,
-			To:      peer,
-			Reqs:    []*defines.Request{req},
-		}
-		if err := msg.WriteDesc("type", "req-blocks"); err != nil {
-			return err
+			To:      to,
+			Reqs:    []*defines.Request{&r},
 		}
 		if err := p.signAndSendMsg(msg); err != nil {
-			p.Errorf("broadcastRequestBlocks: to %s fail: %s", peer, err)
-			return err
+			p.requests.cancel(pr, err)
+			p.Errorf("requestBlocks: to %s fail: %s", to, err)
+			return prs, err
 		} else {
-			p.Debugf("broadcastRequestBlocks: to %s", peer)
-			p.nWait++
+			p.Debugf("requestBlocks: to %s", to)
 		}
+		prs = append(prs, pr)
 	}
 
-	return nil
+	return prs, nil
 }
 
 // 向种子节点广播获取最新区块
 func (p *Pot) broadcastRequestLatestBlock() error {
 
-	p.nWait = 0 // 重置
-
 	req := &defines.Request{
 		Type:       defines.RequestType_Blocks,
 		IndexStart: -1, // 请求对方的最新区块
@@ -448,19 +400,16 @@ func (p *Pot) broadcastRequestLatestBlock() error {
 		msg := &defines.Message{
 			Version: defines.CodeVersion,
 			Type:    defines.MessageType_Req,
+			Kind:    defines.MessageKind_ReqBlocks,
 			From:    p.id,
 			To:      peer.Id,
 			Reqs:    []*defines.Request{req},
 		}
-		if err := msg.WriteDesc("type", "req-latestblock"); err != nil {
-			return err
-		}
 		if err := p.signAndSendMsg(msg); err != nil {
 			p.Errorf("broadcastRequestBlocks: to %s fail: %s", peer.Id, err)
 			return err
 		} else {
 			p.Debugf("broadcastRequestBlocks: to %s", peer.Id)
-			p.nWait++
 		}
 		return nil
 	})
@@ -470,76 +419,54 @@ func (p *Pot) broadcastRequestLatestBlock() error {
 
 ///////////////////////////////////////////////////////////////////
 
-// wait 函数用于等待邻居们的某一类消息回应
-func (p *Pot) wait(nWait int) error {
-	timeoutD := time.Duration(2 * TickMs) * time.Millisecond
-	timeout := time.NewTimer(timeoutD)
-	if p.nWaitChan == nil {
-		p.nWaitChan = make(chan int)
-	}
-
+// waitNeighbors 等待各个邻居表请求的回应
+// 各请求有自己的截止时间，一个回应都没等到才返回错误
+func (p *Pot) waitNeighbors(futures []neighborsFuture) error {
 	cnt := 0
-	for {
-		select {
-		case <-p.done:
-			p.Debugf("wait: done and return")
-			return nil
-		case <-p.nWaitChan:
-			nWait--
-			cnt++
-			p.Debugf("wait: nWait--")
-			// 等待结束
-			if nWait == 0 {
-				p.Debugf("wait: wait finish and return")
-				return nil
-			}
-		case <-timeout.C:
-			// 超时需要判断两种情况：
-			if cnt == 0 { // 一个回复都没收到
-				p.Errorf("wait: timeout, no response received")
-				return errors.New("wait timeout and no response received")
-			}
-			p.Debugf("wait: timeout, %d responses received, return", cnt)
+	for _, f := range futures {
+		pis, err := f.Wait()
+		if errors.Is(err, ErrRequestClosed) {
+			p.Debugf("waitNeighbors: done and return")
 			return nil
 		}
+		if err != nil {
+			p.Debugf("waitNeighbors: wait %s fail: %s", identity.Short(f.To()), err)
+			continue
+		}
+		cnt++
+		p.Debugf("waitNeighbors: %d neighbors from %s", len(pis), identity.Short(f.To()))
 	}
+	if cnt == 0 { // 一个回复都没收到
+		p.Errorf("waitNeighbors: timeout, no response received")
+		return errors.New("wait timeout and no response received")
+	}
+	p.Debugf("waitNeighbors: %d/%d responses received, return", cnt, len(futures))
+	return nil
 }
 
 // 等待某个区块，需要在wait阶段决定哪个才是正确的
-// blockIndex=-1时表示等最新区块; nWait表示等待的数量
-func (p *Pot) waitAndDecideOneBlock(blockIndex int64, nWait int) (*defines.Block, error) {
-	timeoutD := time.Duration(2 * TickMs) * time.Millisecond
-	timeout := time.NewTimer(timeoutD)
-	if p.nWaitBlockChan == nil {
-		p.nWaitBlockChan = make(chan *defines.Block)
-	}
-
+// blockIndex=-1时表示等最新区块; futures为发出的各个请求
+func (p *Pot) waitAndDecideOneBlock(blockIndex int64, futures []blockFuture) (*defines.Block, error) {
 	p.udbt.Reset(blockIndex) // 重置未决区块表
 
 	cnt := 0
-	for {
-		select {
-		case <-p.done: // 程序被关闭
-			p.Debugf("wait: done and return")
+	for _, f := range futures {
+		b, err := f.Wait()
+		if errors.Is(err, ErrRequestClosed) { // 程序被关闭
+			p.Debugf("waitAndDecideOneBlock: done and return")
 			return nil, nil
-		case b := <-p.nWaitBlockChan:
-			nWait--
-			cnt++
-			p.Debugf("wait: nWait--")
-			p.udbt.Add(b) // 添加到未决区块表
-			// 等待结束
-			if nWait == 0 {
-				p.Debugf("wait: wait finish and return")
-				return p.udbt.Major(), nil
-			}
-		case <-timeout.C:
-			// 超时需要判断两种情况：
-			if cnt == 0 { // 一个回复都没收到
-				p.Errorf("wait: timeout, no response received")
-				return nil, errors.New("wait timeout and no response received")
-			}
-			p.Debugf("wait: timeout, %d responses received, return", cnt)
-			return p.udbt.Major(), nil
 		}
+		if err != nil {
+			p.Debugf("waitAndDecideOneBlock: wait %s fail: %s", identity.Short(f.To()), err)
+			continue
+		}
+		cnt++
+		p.udbt.Add(b) // 添加到未决区块表
+	}
+	if cnt == 0 { // 一个回复都没收到
+		p.Errorf("waitAndDecideOneBlock: timeout, no response received")
+		return nil, errors.New("wait timeout and no response received")
 	}
+	p.Debugf("waitAndDecideOneBlock: %d/%d responses received, return", cnt, len(futures))
+	return p.udbt.Major(), nil
 }
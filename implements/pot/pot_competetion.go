@@ -69,6 +69,7 @@ func (p *Pot) endPot(moment Moment) {
 // decide 决定新区块
 func (p *Pot) decide(moment Moment) {
 	p.Info("decide new block now")
+	defer p.saveState()
 	// 决定谁是胜者
 	decidedWinnerProof := p.proofs.DecideWinner(moment)
 	if decidedWinnerProof != nil {
@@ -76,21 +77,32 @@ func (p *Pot) decide(moment Moment) {
 		decidedWinnerBlock := p.udbt.Get(decidedWinnerProof.BlockHash)
 		p.Info(p.udbt.Display())
 
-		// 这说明没收到胜者的区块。
+		// 这说明没收到胜者的区块，向其他节点补取，取不到则由种子宣布空轮(见pot_fetch.go, empty_round.go)
 		if decidedWinnerBlock == nil {
-			p.Errorf("proof decided, but decided block not found")
-			return
+			p.Warnf("proof decided, but decided block not found, fetch it now")
+			decidedWinnerBlock = p.fetchDecidedBlock(decidedWinnerProof)
+			if decidedWinnerBlock == nil {
+				return
+			}
+			p.udbt.Add(decidedWinnerBlock)
 		}
 
 		// 拿到decided proof 和 decided block 后，需要校验
 		if !decidedWinnerProof.Match(decidedWinnerBlock) {
 			p.Errorf("proof decided, but decided block doesn't match the decided proof")
-			// TODO: decided block的构造者作假，惩罚
+			// 证明者谎报了区块内容，惩罚并广播证据
+			ev := &Evidence{Type: EvidenceType_Mismatch, Proof: decidedWinnerProof, Block: decidedWinnerBlock}
+			if err := p.punish(ev); err != nil {
+				p.Errorf("punish decided winner(%s) fail: %s", decidedWinnerProof.Short(), err)
+			}
 			return
 		}
 
-		// TODO: 对decidedWinnerBlock内容的校验
-
+		// 校验decidedWinnerBlock内容，上一轮收到时本地可能还没有其前一区块，这里需要再次校验
+		if err := p.validateNewBlock(decidedWinnerBlock, moment.Time); err != nil {
+			p.Errorf("proof decided, but decided block is invalid: %s", err)
+			return
+		}
 
 		// 更新时钟(如果允许时间纠偏的话)
 		if err := p.clock.Trigger(decidedWinnerBlock); err != nil {
@@ -102,9 +114,40 @@ func (p *Pot) decide(moment Moment) {
 		}
 		// 刷新进度表并更新自己进度 （暂时没使用）
 		p.processes.refresh(decidedWinnerBlock)
+		p.setEpoch(decidedWinnerBlock.Index)
 	} else {	// decided为nil说明，此时proofs表一个证明都没收到，正常情况下只有seed启动时会遇到。 异常情况下则是自己掉线了
 		// 啥也不用干
 		p.Debug("decide winner proof but no winner found")
 	}
 
 }
+
+// fetchDecidedBlock 补取胜者区块，取不到时返回nil
+// 种子取不到则宣布空轮；其他节点同时等待种子的空轮标记，不自行判定空轮
+func (p *Pot) fetchDecidedBlock(proof *Proof) *defines.Block {
+	index := proof.BaseIndex + 1
+	ids := p.aliveIds(p.proofs.Ids())
+	p.emptyRounds.prune(index)
+
+	if p.duty == defines.PeerDuty_Seed {
+		block := p.fetchBlock(proof.BlockHash, p.getTiming().emptyRoundTimeout(), nil, ids...)
+		if block == nil {
+			p.Errorf("decided block(%s) unavailable, declare round %d empty", proof.Short(), index)
+			if err := p.declareEmptyRound(proof); err != nil {
+				p.Errorf("declare empty round %d fail: %s", index, err)
+			}
+		}
+		return block
+	}
+
+	block := p.fetchBlock(proof.BlockHash, p.getTiming().fetchBlockTimeout(), p.emptyRounds.wait(index), ids...)
+	if block != nil {
+		return block
+	}
+	if er := p.emptyRounds.get(index); er != nil {
+		p.Warnf("decided block(%s) unavailable, round %d is empty: %s", proof.Short(), index, er.Short())
+	} else {
+		p.Errorf("decided block(%s) unavailable and no seed declared round %d empty, wait for it", proof.Short(), index)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/31/20 10:05 AM
+* @Description: 按哈希补取缺失的胜者区块
+***********************************************************************/
+
+package pot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+)
+
+/*
+	decide时可能已确定了胜者证明，却没有收到胜者区块(胜者广播时丢包、或者只发给了部分节点)。
+	此时向种子以及本轮发出过证明的节点按哈希请求该区块，这些节点若已确定该区块或者仍在未决区块表中持有它，就会回发。
+
+	等待的时长从PotStart开始计，必须留出足够时间给本轮竞争(startPot)，因此只等竞争阶段的一半(Timing.fetchBlockTimeout)。
+	仍然取不到的话，由种子宣布本轮为空轮：不添加任何区块，下一轮所有节点继续基于同一个最新区块竞争，见empty_round.go。
+	之所以不退而选择次优证明，是因为只有胜者会广播区块，次优证明的区块除了其出块者没有节点持有，各节点无法就它达成一致。
+*/
+
+// fetchBlock 向种子及ids按哈希请求区块，timeout内等到则返回，否则返回nil
+// stop关闭时(例如等到了空轮标记)提前返回nil，不需要时传nil
+// 各请求登记在p.requests中，回应不论处于哪个状态都经handleMsg交给deliverFetchedMsg
+func (p *Pot) fetchBlock(hash []byte, timeout time.Duration, stop <-chan struct{}, ids ...string) *defines.Block {
+	k := fmt.Sprintf("%x", hash)
+	ch := make(chan *defines.Block, 1)
+	p.fetchingLock.Lock()
+	p.fetching[k] = ch
+	p.fetchingLock.Unlock()
+	defer func() {
+		p.fetchingLock.Lock()
+		delete(p.fetching, k)
+		p.fetchingLock.Unlock()
+	}()
+
+	prs, err := p.requestBlocks(0, 0, [][]byte{hash}, 0, -1, timeout, ids...)
+	if err != nil {
+		p.Errorf("fetchBlock: request block(%s) fail: %s", k, err)
+	}
+	// 取到区块或放弃等待后，其余节点的回应都不再需要
+	defer func() {
+		for _, pr := range prs {
+			p.requests.cancel(pr, ErrRequestTimeout)
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case b := <-ch:
+		return b
+	case <-timer.C:
+		return nil
+	case <-stop:
+		return nil
+	case <-p.done:
+		return nil
+	}
+}
+
+// deliverFetchedMsg 若msg是对补取请求的回应，且带有正在补取的区块，则交给fetchBlock并返回true
+// 请求已超时或已取到区块后迟到的回应返回false，照常处理(例如经handleEntryBlock追加到链上)
+func (p *Pot) deliverFetchedMsg(msg *defines.Message) bool {
+	for _, ent := range msg.Entries {
+		if ent.Type != defines.EntryType_Block {
+			continue
+		}
+		b := new(defines.Block)
+		if err := b.Decode(ent.Data); err != nil {
+			continue
+		}
+		ch, ok := p.fetchingChan(b)
+		if !ok || !p.requests.resolve(msg.From, msg.ReqId, defines.RequestType_Blocks, b) {
+			continue
+		}
+		select {
+		case ch <- b:
+		default: // 已经收到过
+		}
+		return true
+	}
+	return false
+}
+
+// fetchingChan 若b是正在补取的区块，返回等待它的fetchBlock的chan
+func (p *Pot) fetchingChan(b *defines.Block) (chan *defines.Block, bool) {
+	p.fetchingLock.Lock()
+	ch, ok := p.fetching[b.Key()]
+	p.fetchingLock.Unlock()
+	if !ok {
+		return nil, false
+	}
+	// 哈希可以自证，防止伪造的区块占用等待的位置。其余校验由decide完成
+	if err := b.VerifyHash(); err != nil {
+		return nil, false
+	}
+	return ch, true
+}
+
+// blocksByHashes 按哈希查询区块，区块链中没有的再从未决区块表中找
+// 刚确定的胜者区块可能还没有加入区块链
+func (p *Pot) blocksByHashes(hashes [][]byte) ([]*defines.Block, error) {
+	blocks := make([]*defines.Block, 0, len(hashes))
+	for _, h := range hashes {
+		b, err := p.bc.GetBlockByHash(h)
+		if err != nil || b == nil {
+			b = p.udbt.Get(h)
+		}
+		if b != nil {
+			blocks = append(blocks, b)
+		}
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("none of the %d requested blocks found", len(hashes))
+	}
+	return blocks, nil
+}
@@ -0,0 +1,303 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/31/20 2:30 PM
+* @Description: 补取胜者区块测试
+***********************************************************************/
+
+package pot
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+)
+
+// serveMsgOut 模拟网络模块消费p发出的消息，对区块请求调用respond
+func serveMsgOut(p *Pot, respond func(to string, req *defines.Request)) {
+	for {
+		select {
+		case <-p.done:
+			return
+		case merr := <-p.msgout:
+			merr.Err <- nil
+			for _, req := range merr.Msg.Reqs {
+				if req.Type == defines.RequestType_Blocks && respond != nil {
+					respond(merr.Msg.To, req)
+				}
+			}
+		}
+	}
+}
+
+func TestPot_DecideFetchMissingBlock(t *testing.T) {
+	makerKey, maker := newTestKey(t)
+	seedKey, seed := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+
+	p := newTestPot(t, selfKey,
+		&defines.PeerInfo{Id: maker, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(makerKey.Public())},
+		&defines.PeerInfo{Id: seed, Addr: "127.0.0.1:8002", Duty: defines.PeerDuty_Seed,
+			PubKey: crypto.MarshalPublicKey(seedKey.Public())})
+	defer p.Close()
+
+	genesis, err := p.bc.CreateTheWorld()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := defines.NewBlockAndSign(2, maker, genesis.SelfHash, nil, "", makerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := block.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := *block
+	forged.Description = "forged"
+	forgedEncoded, err := forged.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 种子先回一个伪造的同哈希区块，再回真正的区块
+	requested := make(chan string, 10)
+	go serveMsgOut(p, func(to string, req *defines.Request) {
+		requested <- to
+		if to != seed || len(req.Hashes) != 1 || !bytes.Equal(req.Hashes[0], block.SelfHash) {
+			return
+		}
+		for _, data := range [][]byte{forgedEncoded, encoded} {
+			ent := &defines.Entry{BaseIndex: 1, Base: genesis.SelfHash, Type: defines.EntryType_Block, Data: data}
+			msg := &defines.Message{
+				Version: defines.CodeVersion,
+				Type:    defines.MessageType_Data,
+				Kind:    defines.MessageKind_RspBlocks,
+				ReqId:   req.Id,
+				From:    seed,
+				To:      p.id,
+				Entries: []*defines.Entry{ent},
+			}
+			if err := msg.Sign(seedKey); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := p.handleMsg(msg); err != nil {
+				t.Logf("handleMsg: %s", err)
+			}
+		}
+	})
+
+	proof := &Proof{Id: maker, BlockHash: block.SelfHash, Base: genesis.SelfHash, BaseIndex: 1}
+	if err := proof.Sign(makerKey); err != nil {
+		t.Fatal(err)
+	}
+	p.proofs.Add(proof)
+	p.proofs.Judged = proof
+	p.decide(Moment{Type: MomentType_PotStart, Time: time.Now()})
+
+	if got := p.bc.GetMaxIndex(); got != 2 {
+		t.Fatalf("bc max index = %d, want 2", got)
+	}
+	latest := p.bc.GetLatestBlock()
+	if latest == nil || !bytes.Equal(latest.SelfHash, block.SelfHash) || latest.Description != "" {
+		t.Errorf("latest block = %v, want the fetched block", latest)
+	}
+
+	// 种子和胜者都应该收到请求
+	got := map[string]bool{}
+	for len(requested) > 0 {
+		got[<-requested] = true
+	}
+	if !got[seed] || !got[maker] {
+		t.Errorf("requested = %v, want both seed and maker", got)
+	}
+}
+
+func TestPot_DecideEmptyRound(t *testing.T) {
+	makerKey, maker := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+
+	p := newTestPotWithOption(t, selfKey, &Option{Timing: Timing{TickMs: 50}},
+		&defines.PeerInfo{Id: maker, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(makerKey.Public())})
+	defer p.Close()
+	go serveMsgOut(p, nil) // 没有节点回应
+
+	genesis, err := p.bc.CreateTheWorld()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.proofs.Judged = &Proof{Id: maker, BlockHash: []byte("missing"), Base: genesis.SelfHash, BaseIndex: 1}
+	start := time.Now()
+	p.decide(Moment{Type: MomentType_PotStart, Time: start})
+
+	timeout := p.getTiming().fetchBlockTimeout()
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > p.getTiming().round() {
+		t.Errorf("decide took %s, should wait about %s for the missing block", elapsed, timeout)
+	}
+	// 没有种子宣布空轮，不前进也不记为空轮
+	if got := p.bc.GetMaxIndex(); got != 1 {
+		t.Errorf("bc max index = %d, want 1", got)
+	}
+	if p.emptyRounds.get(2) != nil {
+		t.Error("peer should not declare empty round by itself")
+	}
+	if len(p.fetching) != 0 {
+		t.Error("fetching should be cleaned up")
+	}
+}
+
+func TestPot_HandleRequestBlocksFromUdbt(t *testing.T) {
+	makerKey, maker := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+
+	p := newTestPot(t, selfKey, &defines.PeerInfo{Id: maker, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+		PubKey: crypto.MarshalPublicKey(makerKey.Public())})
+	defer p.Close()
+
+	block, err := defines.NewBlockAndSign(2, maker, []byte("base"), nil, "", makerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.udbt.Add(block)
+
+	done := make(chan *defines.Message, 1)
+	go func() {
+		merr := <-p.msgout
+		merr.Err <- nil
+		done <- merr.Msg
+	}()
+
+	req := &defines.Request{Type: defines.RequestType_Blocks, Hashes: [][]byte{[]byte("unknown"), block.SelfHash}}
+	if err := p.handleRequestBlocks(maker, req); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-done
+	if len(msg.Entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(msg.Entries))
+	}
+	b := new(defines.Block)
+	if err := b.Decode(msg.Entries[0].Data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.SelfHash, block.SelfHash) {
+		t.Error("response should carry the undecided block")
+	}
+}
+
+// wire 把a发出的消息交给b处理，模拟二者之间的网络，发往其他节点的消息丢弃
+func wire(a, b *Pot) {
+	for {
+		select {
+		case <-a.done:
+			return
+		case merr := <-a.msgout:
+			msg := merr.Msg
+			if merr.Broadcast != nil {
+				merr.Broadcast.Results = map[string]error{b.id: nil}
+			}
+			merr.Err <- nil
+			if merr.Broadcast != nil || msg.To == b.id {
+				go b.handleMsg(msg)
+			}
+		}
+	}
+}
+
+// 胜者区块只有部分节点持有或无人持有时，种子与普通节点最终停在同一个区块上
+func TestPot_DecideAgreeOnMissingBlock(t *testing.T) {
+	makerKey, maker := newTestKey(t)
+	seedKey, seed := newTestKey(t)
+	peerKey, peer := newTestKey(t)
+	info := func(id string, key crypto.PrivateKey, duty defines.PeerDuty) *defines.PeerInfo {
+		return &defines.PeerInfo{Id: id, Addr: "127.0.0.1:8001", Duty: duty,
+			PubKey: crypto.MarshalPublicKey(key.Public())}
+	}
+
+	// 胜者maker不在线，seedHolds表示种子是否收到了胜者区块
+	run := func(t *testing.T, seedHolds bool) (s, q *Pot, block *defines.Block) {
+		timing := Timing{TickMs: 50}
+		s = newTestPotWithOption(t, seedKey, &Option{Duty: defines.PeerDuty_Seed, Timing: timing},
+			info(maker, makerKey, defines.PeerDuty_Peer), info(peer, peerKey, defines.PeerDuty_Peer))
+		q = newTestPotWithOption(t, peerKey, &Option{Timing: timing},
+			info(maker, makerKey, defines.PeerDuty_Peer), info(seed, seedKey, defines.PeerDuty_Seed))
+		go wire(s, q)
+		go wire(q, s)
+
+		genesis, err := s.bc.CreateTheWorld()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.bc.AddNewBlock(genesis); err != nil {
+			t.Fatal(err)
+		}
+		block, err = defines.NewBlockAndSign(2, maker, genesis.SelfHash, nil, "", makerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seedHolds {
+			s.udbt.Add(block)
+		}
+		proof := &Proof{Id: maker, BlockHash: block.SelfHash, Base: genesis.SelfHash, BaseIndex: 1}
+		if err := proof.Sign(makerKey); err != nil {
+			t.Fatal(err)
+		}
+
+		moment := Moment{Type: MomentType_PotStart, Time: time.Now()}
+		for _, p := range []*Pot{s, q} {
+			p.setState(StateType_PostPot)
+			p.proofs.Add(proof)
+			p.proofs.Judged = proof
+		}
+		if seedHolds {
+			// 测试用的区块链不支持并发读写，种子先确定，普通节点再向它补取
+			s.decide(moment)
+			q.decide(moment)
+			return s, q, block
+		}
+		// 二者同时补取失败，普通节点等待种子的空轮标记
+		var wg sync.WaitGroup
+		for _, p := range []*Pot{s, q} {
+			wg.Add(1)
+			go func(p *Pot) {
+				defer wg.Done()
+				p.decide(moment)
+			}(p)
+		}
+		wg.Wait()
+		return s, q, block
+	}
+
+	t.Run("nobody holds", func(t *testing.T) {
+		s, q, _ := run(t, false)
+		defer s.Close()
+		defer q.Close()
+		if s.bc.GetMaxIndex() != 1 || q.bc.GetMaxIndex() != 1 {
+			t.Errorf("max index: seed %d, peer %d, want both 1", s.bc.GetMaxIndex(), q.bc.GetMaxIndex())
+		}
+		if s.emptyRounds.get(2) == nil || q.emptyRounds.get(2) == nil {
+			t.Error("round 2 should be declared empty by seed and followed by peer")
+		}
+	})
+
+	t.Run("seed holds", func(t *testing.T) {
+		s, q, block := run(t, true)
+		defer s.Close()
+		defer q.Close()
+		for name, p := range map[string]*Pot{"seed": s, "peer": q} {
+			latest := p.bc.GetLatestBlock()
+			if latest == nil || !bytes.Equal(latest.SelfHash, block.SelfHash) {
+				t.Errorf("%s latest = %v, want the winner block", name, latest)
+			}
+		}
+		if q.emptyRounds.get(2) != nil {
+			t.Error("round 2 should not be empty")
+		}
+	})
+}
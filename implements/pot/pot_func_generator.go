@@ -14,7 +14,8 @@ import (
 type rangePitFunc func(peer *defines.PeerInfo) error
 
 // requestNeighborsFuncGenerator 根据当前pot状态机本地状态，生成用来请求邻居节点的函数
-func (p *Pot) requestNeighborsFuncGenerator() (rangePitFunc, error) {
+// 每个发出的请求都登记到p.requests，其future追加到futures
+func (p *Pot) requestNeighborsFuncGenerator(futures *[]neighborsFuture) (rangePitFunc, error) {
 	selfPeerInfo, err := p.pit.Get(p.id)
 	if err != nil {
 		return nil, err
@@ -28,64 +29,69 @@ func (p *Pot) requestNeighborsFuncGenerator() (rangePitFunc, error) {
 		if peer.Id == p.id {
 			return nil
 		}
+		pr := p.requests.register(peer.Id, defines.RequestType_Neighbors, p.getTiming().round())
 		msg := &defines.Message{
 			Version: defines.CodeVersion,
 			Type:    defines.MessageType_Req,
-			Epoch:   p.epoch, // 会变化
+			Kind:    defines.MessageKind_ReqNeighbors,
+			Epoch:   p.Epoch(), // 会变化
 			From:    p.id,
 			To:      peer.Id,
 			Reqs: []*defines.Request{&defines.Request{
 				Type: defines.RequestType_Neighbors,
+				Id:   pr.id,
 				Data: spib,
 			}},
 		}
-		if err := msg.WriteDesc("type", "req-neighbors"); err != nil {
-			return err
-		}
 		if err := p.signAndSendMsg(msg); err != nil {
+			p.requests.cancel(pr, err)
 			p.Errorf("requestNeighbors: to %s fail: %v", peer.Id, err)
 			return err
 		} else {
 			p.Debugf("requestNeighbors: to %s", peer.Id)
 		}
+		*futures = append(*futures, neighborsFuture{pr})
 		return nil
 	}
 	return rnf, nil
 }
 
 // requestLatestBlockFuncGenerator 根据当前pot状态机本地状态，生成用来请求最新区块的函数
-func (p *Pot) requestLatestBlockFuncGenerator() func(peer *defines.PeerInfo) error {
-	return p.requestOneBlockFuncGenerator(-1)
+func (p *Pot) requestLatestBlockFuncGenerator(futures *[]blockFuture) rangePitFunc {
+	return p.requestOneBlockFuncGenerator(-1, futures)
 }
 
 // requestOneBlockFuncGenerator 根据当前pot状态机本地状态，生成用来请求某个区块的函数
 // index为负数时反向索引; index为0不存在
-func (p *Pot) requestOneBlockFuncGenerator(index int64) func(peer *defines.PeerInfo) error {
+// 每个发出的请求都登记到p.requests，其future追加到futures
+func (p *Pot) requestOneBlockFuncGenerator(index int64, futures *[]blockFuture) rangePitFunc {
 	rnf := func(peer *defines.PeerInfo) error {
 		if peer.Id == p.id {
 			return nil
 		}
+		pr := p.requests.register(peer.Id, defines.RequestType_Blocks, p.getTiming().round())
 		msg := &defines.Message{
 			Version: defines.CodeVersion,
 			Type:    defines.MessageType_Req,
-			Epoch:   p.epoch, // 会变化
+			Kind:    defines.MessageKind_ReqBlocks,
+			Epoch:   p.Epoch(), // 会变化
 			From:    p.id,
 			To:      peer.Id,
 			Reqs: []*defines.Request{&defines.Request{
 				Type:       defines.RequestType_Blocks,
+				Id:         pr.id,
 				IndexStart: index,
 				IndexCount: 1,
 			}},
 		}
-		if err := msg.WriteDesc("type", "req-block"); err != nil {
-			return err
-		}
 		if err := p.signAndSendMsg(msg); err != nil {
+			p.requests.cancel(pr, err)
 			p.Errorf("requestBlock: to %s fail: %v", peer.Id, err)
 			return err
 		} else {
 			p.Debugf("requestBlock: to %s", peer.Id)
 		}
+		*futures = append(*futures, blockFuture{pr})
 		return nil
 	}
 	return rnf
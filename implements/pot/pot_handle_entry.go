@@ -9,8 +9,12 @@ package pot
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"time"
+
 	"github.com/azd1997/blockchain-consensus/defines"
 	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
 // 处理EntryBlocks
@@ -31,8 +35,8 @@ func (p *Pot) handleEntryBlock(from string, ent *defines.Entry) error {
 	if err != nil {
 		return err
 	}
-	// 检查区块本身格式/签名的正确性
-	err = block.Verify()
+	// 区块需通过校验流水线
+	err = p.validateSyncBlock(block)
 	if err != nil {
 		return err
 	}
@@ -64,30 +68,24 @@ func (p *Pot) handleEntryBlock(from string, ent *defines.Entry) error {
 }
 
 // 处理Proof
-// 有两种情况: 1. 自己为自己竞争的proof; 2. seed转发的winner proof
+// 有两种情况: 1. 自己为自己竞争的proof(MessageKind_Proof); 2. seed转发的winner proof(MessageKind_RelayedProof)
 // 情况1，发生在Competing阶段，则是收集起来，决出胜者；
-// 情况2，发生在Winner或者Loser阶段，是seed节点转发出来的胜者proof，此时需要保存下来与自身得到的结果进行比对
-// 情况2通过 proof.Id != msg.From 判定
+// 情况2，发生在Winner或者Loser阶段，是seed节点转发出来的胜者proof，此时需要保存下来与自身得到的结果进行比对，见handleEntryRelayedProof
 func (p *Pot) handleEntryProof(from string, ent *defines.Entry) error {
-	// 解码
-	proof := new(Proof)
-	if err := proof.Decode(ent.Data); err != nil {
+	proof, err := p.decodeProof(ent)
+	if err != nil {
 		return err
 	}
-	// 检查ent的Base信息是否合理
-	process := p.processes.get(p.id)
-	if ent.BaseIndex != process.Index || !bytes.Equal(ent.Base, process.Hash) {
-		return errors.New("mismatched ent.Base or ent.BaseIndex")
+	if proof.Id != from {
+		return fmt.Errorf("%w: %s sent proof of %s", ErrProofNotFromProver, identity.Short(from), identity.Short(proof.Id))
 	}
 
-
 	// 如果证明信息有效，用以更新本地winner
-	if proof.Id == from { // 情况1
-		p.Debugf("AddProof: %s(%v)", from, proof)
-		p.proofs.Add(proof)
-	} else { // 情况2
-		p.Debugf("AddProofRelayedBySeed: %s(%v)", proof.Id, proof)
-		p.proofs.AddProofRelayedBySeed(proof)
+	p.Debugf("AddProof: %s(%v)", from, proof)
+	if ev := p.proofs.Add(proof); ev != nil {
+		// 证明者在本轮发出了矛盾的证明
+		p.Errorf("%s sent conflicting proofs: %s", identity.Short(proof.Id), ev.Short())
+		return p.punish(ev)
 	}
 
 	p.Debugf("current proofs: %v", p.proofs)
@@ -95,6 +93,51 @@ func (p *Pot) handleEntryProof(from string, ent *defines.Entry) error {
 	return nil
 }
 
+// handleEntryRelayedProof 处理seed转发的winner proof
+// 只有种子的转发才计票，否则任何节点重放别人的证明都能冒充种子投票
+func (p *Pot) handleEntryRelayedProof(from string, ent *defines.Entry) error {
+	if !p.pit.IsSeed(from) {
+		return fmt.Errorf("%w: %s", ErrRelayFromNonSeed, identity.Short(from))
+	}
+	proof, err := p.decodeProof(ent)
+	if err != nil {
+		return err
+	}
+
+	p.Debugf("AddProofRelayedBySeed: %s(%v) by %s", proof.Id, proof, identity.Short(from))
+	if ev := p.proofs.AddProofRelayedBySeed(from, proof); ev != nil {
+		p.Errorf("%s sent conflicting proofs: %s", identity.Short(proof.Id), ev.Short())
+		return p.punish(ev)
+	}
+	p.saveState()
+
+	p.Debugf("current proofs: %v", p.proofs)
+
+	return nil
+}
+
+// decodeProof 解码ent中的证明，并检查证明者签名及其所基于的区块
+func (p *Pot) decodeProof(ent *defines.Entry) (*Proof, error) {
+	proof := new(Proof)
+	if err := proof.Decode(ent.Data); err != nil {
+		return nil, err
+	}
+	// 不论是否经过转发，证明都必须带有证明者本人的签名
+	pub, err := p.pubKeyOf(proof.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := proof.Verify(pub); err != nil {
+		return nil, err
+	}
+	// 检查ent的Base信息是否合理
+	process := p.processes.get(p.id)
+	if ent.BaseIndex != process.Index || !bytes.Equal(ent.Base, process.Hash) {
+		return nil, errors.New("mismatched ent.Base or ent.BaseIndex")
+	}
+	return proof, nil
+}
+
 // 处理新区块
 // 新区块的话，得检查是否与之前的证明信息匹配
 func (p *Pot) handleEntryNewBlock(from string, ent *defines.Entry) error {
@@ -105,8 +148,8 @@ func (p *Pot) handleEntryNewBlock(from string, ent *defines.Entry) error {
 		return err
 	}
 
-	// 检查区块本身格式/签名的正确性
-	if err := block.Verify(); err != nil {
+	// 提前过滤无效区块，未决区块表中只保留通过校验的区块
+	if err := p.validateNewBlock(block, time.Now()); err != nil {
 		return err
 	}
 
@@ -133,6 +176,14 @@ func (p *Pot) handleEntryTransaction(from string, ent *defines.Entry) error {
 		if err := tx.Decode(ent.Data); err != nil {
 			return err
 		}
+		// 交易经gossip转发，from不一定是发起者，须用发起者的公钥验证
+		pub, err := p.pubKeyOf(tx.From)
+		if err != nil {
+			return fmt.Errorf("tx(%s): %w", tx.Key(), err)
+		}
+		if err := tx.Verify(pub); err != nil {
+			return fmt.Errorf("tx(%s): %w", tx.Key(), err)
+		}
 
 		// 尝试添加到本地交易池
 		p.bc.TxInChan() <- tx
@@ -144,14 +195,23 @@ func (p *Pot) handleEntryTransaction(from string, ent *defines.Entry) error {
 // handleEntryNeighbor 处理邻居节点信息
 // TODO: 考虑节点恶意
 // 目前直接相信这个节点信息，添加到本地节点信息表
-// 暂定：节点信息只会从seed(可信)到peer
+// 节点信息只会从seed(可信)到peer，其他节点发来的一律拒绝，
+// 否则任何能连上来的节点都能往节点表里写入节点
 func (p *Pot) handleEntryNeighbor(from string, ent *defines.Entry) error {
-	//fmt.Printf("%v\n", ent)
+	if !p.pit.IsSeed(from) {
+		return fmt.Errorf("%w: %s", ErrNeighborFromNonSeed, identity.Short(from))
+	}
 	pi := new(defines.PeerInfo)
 	err := pi.Decode(ent.Data)
 	if err != nil {
 		return err
 	}
+	// 节点属性(是否作恶、是否可达)是本地的判断，不采信邻居传来的
+	if old, _ := p.pit.Get(pi.Id); old != nil {
+		pi.Attr = old.Attr
+	} else {
+		pi.Attr = defines.PeerAttr_Normal
+	}
 	return p.pit.Set(pi)
 }
 
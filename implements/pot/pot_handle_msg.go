@@ -14,10 +14,22 @@ import (
 
 // 处理外界消息输入和内部消息
 func (p *Pot) handleMsg(msg *defines.Message) error {
-	// 检查消息格式与签名
-	if err := msg.Verify(); err != nil {
+	// 检查消息格式与签名，签名必须能通过发送方登记的公钥验证
+	pub, err := p.pubKeyOf(msg.From)
+	if err != nil {
 		return err
 	}
+	if err := msg.Verify(pub); err != nil {
+		return fmt.Errorf("verify msg from %s fail: %w", msg.From, err)
+	}
+
+	// decide补取胜者区块的回应及种子的空轮标记，不论当前处于哪个状态都交给decide(RLB等阶段同样会decide)
+	if msg.Kind == defines.MessageKind_RspBlocks && p.deliverFetchedMsg(msg) {
+		return nil
+	}
+	if msg.Kind == defines.MessageKind_EmptyRound {
+		return p.handleEntries(msg, p.handleEntryEmptyRound)
+	}
 
 	// 根据当前状态不同，执行不同的消息处理
 	state := p.getState()
@@ -131,7 +143,7 @@ func (p *Pot) handleMsgWhenPostPot(msg *defines.Message) error {
 
 //////////////////////////////////////////////////
 
-// Message分Data和Req两大类
+// Message分Data和Req两大类，Kind进一步说明是哪一种数据或请求，各阶段按Kind分派
 
 // PreInited_RN阶段
 // 仅处理邻居消息
@@ -150,31 +162,33 @@ func (p *Pot) handleMsgWhenPreInitedRNForDutySeed(msg *defines.Message) error {
 
 // 再PreInited阶段，所有类型的节点能处理的消息是相同的
 func (p *Pot) handleMsgWhenPreInitedRNForAllDuty(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Neighbor]", p.DutyState())
-	case defines.MessageType_Data:
-		count := 0
+	switch msg.Kind {
+	case defines.MessageKind_RspNeighbors, defines.MessageKind_Neighbor:
+		pis := make([]*defines.PeerInfo, 0, len(msg.Entries))
 		for _, ent := range msg.Entries {
-			ent := ent
-			if ent.Type == defines.EntryType_Neighbor {
-				count++
-				// 通用的handleEntryNeighbor，添加就完事
-				if err := p.handleEntryNeighbor(msg.From, ent); err != nil {
-					p.Errorf("%s handle EntryType_Neighbor from (%s) fail: %s", p.DutyState(), msg.From, err)
-				} else {
-					p.Debugf("%s handle EntryType_Neighbor from (%s) succ", p.DutyState(), msg.From)
-				}
+			if ent.Type != defines.EntryType_Neighbor {
+				continue
+			}
+			// 通用的handleEntryNeighbor，添加就完事
+			if err := p.handleEntryNeighbor(msg.From, ent); err != nil {
+				p.Errorf("%s handle EntryType_Neighbor from (%s) fail: %s", p.DutyState(), msg.From, err)
+				continue
+			}
+			p.Debugf("%s handle EntryType_Neighbor from (%s) succ", p.DutyState(), msg.From)
+			pi := new(defines.PeerInfo)
+			if err := pi.Decode(ent.Data); err == nil {
+				pis = append(pis, pi)
 			}
 		}
-		if count > 0 && p.nWaitChan != nil { // 说明包含Neighbors
-			p.nWaitChan <- 1 // 通知收到一个节点回传了节点信息表
+		// 种子转发的新节点信息照单全收，但只有对得上请求的回应才计入等待
+		if msg.Kind == defines.MessageKind_RspNeighbors &&
+			!p.requests.resolve(msg.From, msg.ReqId, defines.RequestType_Neighbors, pis) {
+			p.Debugf("%s received unsolicited neighbors from %s", p.DutyState(), msg.From)
 		}
 		return nil
-	case defines.MessageType_Req:
-		return fmt.Errorf("%s can only handle [EntryType_Neighbor]", p.DutyState())
 	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
+		return fmt.Errorf("%s can only handle [%s %s], got %s", p.DutyState(),
+			defines.MessageKind_RspNeighbors, defines.MessageKind_Neighbor, msg.Kind)
 	}
 }
 
@@ -195,31 +209,29 @@ func (p *Pot) handleMsgWhenPreInitedRFBForDutySeed(msg *defines.Message) error {
 
 // 再PreInited阶段，所有类型的节点能处理的消息是相同的
 func (p *Pot) handleMsgWhenPreInitedRFBForAllDuty(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-		count := len(msg.Entries)
-		// 等待的是1号区块，其baseindex=0
-		if count != 1 || msg.Entries[0].Type != defines.EntryType_Block || msg.Entries[0].BaseIndex > 0 {
-			return fmt.Errorf("%s received a unexpected msg from %s", p.DutyState(), msg.From)
-		}
-		firstBlock := new(defines.Block)
-		err := firstBlock.Decode(msg.Entries[0].Data)
-		if err != nil {
-			return err
-		}
-		if p.nWaitBlockChan != nil {
-			p.nWaitBlockChan <- firstBlock // 通知收到一个节点回传了1号区块
-			p.Debugf("%s handle EntryType_Block from (%s) succ", p.DutyState(), msg.From)
-		}
-		// 这个firstBlock由启动逻辑确定之后再写到本地
-		return nil
-	case defines.MessageType_Req:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
+	if msg.Kind != defines.MessageKind_RspBlocks {
+		return fmt.Errorf("%s can only handle [%s], got %s", p.DutyState(), defines.MessageKind_RspBlocks, msg.Kind)
 	}
+	// 等待的是1号区块，其baseindex=0
+	if len(msg.Entries) != 1 || msg.Entries[0].Type != defines.EntryType_Block || msg.Entries[0].BaseIndex > 0 {
+		return fmt.Errorf("%s received a unexpected msg from %s", p.DutyState(), msg.From)
+	}
+	firstBlock := new(defines.Block)
+	err := firstBlock.Decode(msg.Entries[0].Data)
+	if err != nil {
+		return err
+	}
+	// 无效的回应不计入等待的数量
+	if err := p.validateSyncBlock(firstBlock); err != nil {
+		return err
+	}
+	// 迟到的、重复的回应不计入
+	if !p.requests.resolve(msg.From, msg.ReqId, defines.RequestType_Blocks, firstBlock) {
+		return fmt.Errorf("%s received a stale or unsolicited block from %s", p.DutyState(), msg.From)
+	}
+	p.Debugf("%s handle EntryType_Block from (%s) succ", p.DutyState(), msg.From)
+	// 这个firstBlock由启动逻辑确定之后再写到本地
+	return nil
 }
 
 // PreInited_RLB阶段
@@ -239,31 +251,29 @@ func (p *Pot) handleMsgWhenPreInitedRLBForDutySeed(msg *defines.Message) error {
 
 // 再PreInited阶段，所有类型的节点能处理的消息是相同的
 func (p *Pot) handleMsgWhenPreInitedRLBForAllDuty(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-		count := len(msg.Entries)
-		// 等待的是最新区块，其序号未知
-		if count != 1 || msg.Entries[0].Type != defines.EntryType_Block {
-			return fmt.Errorf("%s received a unexpected msg from %s", p.DutyState(), msg.From)
-		}
-		latestBlock := new(defines.Block)
-		err := latestBlock.Decode(msg.Entries[0].Data)
-		if err != nil {
-			return err
-		}
-		if p.nWaitBlockChan != nil {
-			p.nWaitBlockChan <- latestBlock // 通知收到一个节点回传了最新区块
-			p.Debugf("%s handle EntryType_Block from (%s) succ", p.DutyState(), msg.From)
-		}
-		// 这个latestBlock由启动逻辑确定之后再写到本地
-		return nil
-	case defines.MessageType_Req:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
+	if msg.Kind != defines.MessageKind_RspBlocks {
+		return fmt.Errorf("%s can only handle [%s], got %s", p.DutyState(), defines.MessageKind_RspBlocks, msg.Kind)
 	}
+	// 等待的是最新区块，其序号未知
+	if len(msg.Entries) != 1 || msg.Entries[0].Type != defines.EntryType_Block {
+		return fmt.Errorf("%s received a unexpected msg from %s", p.DutyState(), msg.From)
+	}
+	latestBlock := new(defines.Block)
+	err := latestBlock.Decode(msg.Entries[0].Data)
+	if err != nil {
+		return err
+	}
+	// 无效的回应不计入等待的数量
+	if err := p.validateSyncBlock(latestBlock); err != nil {
+		return err
+	}
+	// 例如1号区块请求迟到的回应，不能当作最新区块
+	if !p.requests.resolve(msg.From, msg.ReqId, defines.RequestType_Blocks, latestBlock) {
+		return fmt.Errorf("%s received a stale or unsolicited block from %s", p.DutyState(), msg.From)
+	}
+	p.Debugf("%s handle EntryType_Block from (%s) succ", p.DutyState(), msg.From)
+	// 这个latestBlock由启动逻辑确定之后再写到本地
+	return nil
 }
 
 // NotReady阶段
@@ -275,639 +285,113 @@ func (p *Pot) handleMsgWhenPreInitedRLBForAllDuty(msg *defines.Message) error {
 // 或者不管是否Ready，如果本机有，就返回给请求方
 
 func (p *Pot) handleMsgWhenNotReadyForDutyNone(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-
-		// 种子在NotReady阶段能够处理的Data类消息
-
-		for _, ent := range msg.Entries {
-			ent := ent
-			var err error
-
-			switch ent.Type {
-			case defines.EntryType_Block:
-				// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
-				// 且按LatestBlock倒序补漏
-				err = p.handleEntryBlock(msg.From, ent)
-			case defines.EntryType_Proof:
-				// 收集Proof. NotReady只是不竞选不校验，不代表不见证
-				err = p.handleEntryProof(msg.From, ent)
-			case defines.EntryType_NewBlock:
-				// 收集新区块
-				err = p.handleEntryNewBlock(msg.From, ent)
-			case defines.EntryType_Transaction:
-				err = p.handleEntryTransaction(msg.From, ent)
-			case defines.EntryType_Neighbor:
-				err = p.handleEntryNeighbor(msg.From, ent)
-			case defines.EntryType_Process:
-				err = p.handleEntryProcess(msg.From, ent)
-			default:
-				p.Errorf("%s met unknown entry type(%v)", p.DutyState(), ent.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), ent.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), ent.Type, msg.From)
-			}
-		}
-
-		return nil
-	case defines.MessageType_Req: // 对于请求，正常响应，逻辑基本是一致的
-		for _, req := range msg.Reqs {
-			req := req
-			var err error
-
-			switch req.Type {
-			case defines.RequestType_Neighbors:
-				err = p.handleRequestNeighbors(msg.From, req)
-			case defines.RequestType_Blocks:
-				err = p.handleRequestBlocks(msg.From, req)
-			case defines.RequestType_Processes:
-				err = p.handleRequestProcesses(msg.From, req)
-			default:
-				p.Errorf("%s met unknown req type(%v)", p.DutyState(), req.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), req.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), req.Type, msg.From)
-			}
-		}
-
-		return nil
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
-	}
+	return p.handleMsgByKind(msg)
 }
 
 func (p *Pot) handleMsgWhenNotReadyForDutyPeer(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-
-		// 种子在NotReady阶段能够处理的Data类消息
-
-		for _, ent := range msg.Entries {
-			ent := ent
-			var err error
-
-			switch ent.Type {
-			case defines.EntryType_Block:
-				// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
-				// 且按LatestBlock倒序补漏
-				err = p.handleEntryBlock(msg.From, ent)
-			case defines.EntryType_Proof:
-				// 收集Proof. NotReady只是不竞选不校验，不代表不见证
-				err = p.handleEntryProof(msg.From, ent)
-			case defines.EntryType_NewBlock:
-				// 收集新区块
-				err = p.handleEntryNewBlock(msg.From, ent)
-			case defines.EntryType_Transaction:
-				err = p.handleEntryTransaction(msg.From, ent)
-			case defines.EntryType_Neighbor:
-				err = p.handleEntryNeighbor(msg.From, ent)
-			case defines.EntryType_Process:
-				err = p.handleEntryProcess(msg.From, ent)
-			default:
-				p.Errorf("%s met unknown entry type(%v)", p.DutyState(), ent.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), ent.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), ent.Type, msg.From)
-			}
-		}
-
-		return nil
-	case defines.MessageType_Req: // 对于请求，正常响应，逻辑基本是一致的
-		for _, req := range msg.Reqs {
-			req := req
-			var err error
-
-			switch req.Type {
-			case defines.RequestType_Neighbors:
-				err = p.handleRequestNeighbors(msg.From, req)
-			case defines.RequestType_Blocks:
-				err = p.handleRequestBlocks(msg.From, req)
-			case defines.RequestType_Processes:
-				err = p.handleRequestProcesses(msg.From, req)
-			default:
-				p.Errorf("%s met unknown req type(%v)", p.DutyState(), req.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), req.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), req.Type, msg.From)
-			}
-		}
-
-		return nil
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
-	}
+	return p.handleMsgByKind(msg)
 }
 
 func (p *Pot) handleMsgWhenNotReadyForDutySeed(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-
-		// 种子在NotReady阶段能够处理的Data类消息
-
-		for _, ent := range msg.Entries {
-			ent := ent
-			var err error
-
-			switch ent.Type {
-			case defines.EntryType_Block:
-				// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
-				// 且按LatestBlock倒序补漏
-				err = p.handleEntryBlock(msg.From, ent)
-			case defines.EntryType_Proof:
-				// 收集Proof. NotReady只是不竞选不校验，不代表不见证
-				err = p.handleEntryProof(msg.From, ent)
-			case defines.EntryType_NewBlock:
-				// 收集新区块
-				err = p.handleEntryNewBlock(msg.From, ent)
-			case defines.EntryType_Transaction:
-				err = p.handleEntryTransaction(msg.From, ent)
-			case defines.EntryType_Neighbor:
-				err = p.handleEntryNeighbor(msg.From, ent)
-			case defines.EntryType_Process:
-				err = p.handleEntryProcess(msg.From, ent)
-			default:
-				p.Errorf("%s met unknown entry type(%v)", p.DutyState(), ent.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), ent.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), ent.Type, msg.From)
-			}
-		}
-
-		return nil
-	case defines.MessageType_Req: // 对于请求，正常响应，逻辑基本是一致的
-		for _, req := range msg.Reqs {
-			req := req
-			var err error
-
-			switch req.Type {
-			case defines.RequestType_Neighbors:
-				err = p.handleRequestNeighbors(msg.From, req)
-			case defines.RequestType_Blocks:
-				err = p.handleRequestBlocks(msg.From, req)
-			case defines.RequestType_Processes:
-				err = p.handleRequestProcesses(msg.From, req)
-			default:
-				p.Errorf("%s met unknown req type(%v)", p.DutyState(), req.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), req.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), req.Type, msg.From)
-			}
-		}
-
-		return nil
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
-	}
+	return p.handleMsgByKind(msg)
 }
 
 // InPot阶段
 // 处理Req消息和Data消息
 
 func (p *Pot) handleMsgWhenInPotForDutyNone(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-
-		// 种子在NotReady阶段能够处理的Data类消息
-
-		for _, ent := range msg.Entries {
-			ent := ent
-			var err error
-
-			switch ent.Type {
-			case defines.EntryType_Block:
-				// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
-				// 且按LatestBlock倒序补漏
-				err = p.handleEntryBlock(msg.From, ent)
-			case defines.EntryType_Proof:
-				// 收集Proof. NotReady只是不竞选不校验，不代表不见证
-				err = p.handleEntryProof(msg.From, ent)
-			case defines.EntryType_NewBlock:
-				// 收集新区块
-				err = p.handleEntryNewBlock(msg.From, ent)
-			case defines.EntryType_Transaction:
-				err = p.handleEntryTransaction(msg.From, ent)
-			case defines.EntryType_Neighbor:
-				err = p.handleEntryNeighbor(msg.From, ent)
-			case defines.EntryType_Process:
-				err = p.handleEntryProcess(msg.From, ent)
-			default:
-				p.Errorf("%s met unknown entry type(%v)", p.DutyState(), ent.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), ent.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), ent.Type, msg.From)
-			}
-		}
-
-		return nil
-	case defines.MessageType_Req: // 对于请求，正常响应，逻辑基本是一致的
-		for _, req := range msg.Reqs {
-			req := req
-			var err error
-
-			switch req.Type {
-			case defines.RequestType_Neighbors:
-				err = p.handleRequestNeighbors(msg.From, req)
-			case defines.RequestType_Blocks:
-				err = p.handleRequestBlocks(msg.From, req)
-			case defines.RequestType_Processes:
-				err = p.handleRequestProcesses(msg.From, req)
-			default:
-				p.Errorf("%s met unknown req type(%v)", p.DutyState(), req.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), req.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), req.Type, msg.From)
-			}
-		}
-
-		return nil
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
-	}
+	return p.handleMsgByKind(msg)
 }
 
 func (p *Pot) handleMsgWhenInPotForDutyPeer(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-
-		// 种子在NotReady阶段能够处理的Data类消息
-
-		for _, ent := range msg.Entries {
-			ent := ent
-			var err error
-
-			switch ent.Type {
-			case defines.EntryType_Block:
-				// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
-				// 且按LatestBlock倒序补漏
-				err = p.handleEntryBlock(msg.From, ent)
-			case defines.EntryType_Proof:
-				// 收集Proof. NotReady只是不竞选不校验，不代表不见证
-				err = p.handleEntryProof(msg.From, ent)
-			case defines.EntryType_NewBlock:
-				// 收集新区块
-				err = p.handleEntryNewBlock(msg.From, ent)
-			case defines.EntryType_Transaction:
-				err = p.handleEntryTransaction(msg.From, ent)
-			case defines.EntryType_Neighbor:
-				err = p.handleEntryNeighbor(msg.From, ent)
-			case defines.EntryType_Process:
-				err = p.handleEntryProcess(msg.From, ent)
-			default:
-				p.Errorf("%s met unknown entry type(%v)", p.DutyState(), ent.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), ent.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), ent.Type, msg.From)
-			}
-		}
-
-		return nil
-	case defines.MessageType_Req: // 对于请求，正常响应，逻辑基本是一致的
-		for _, req := range msg.Reqs {
-			req := req
-			var err error
-
-			switch req.Type {
-			case defines.RequestType_Neighbors:
-				err = p.handleRequestNeighbors(msg.From, req)
-			case defines.RequestType_Blocks:
-				err = p.handleRequestBlocks(msg.From, req)
-			case defines.RequestType_Processes:
-				err = p.handleRequestProcesses(msg.From, req)
-			default:
-				p.Errorf("%s met unknown req type(%v)", p.DutyState(), req.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), req.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), req.Type, msg.From)
-			}
-		}
-
-		return nil
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
-	}
+	return p.handleMsgByKind(msg)
 }
 
 func (p *Pot) handleMsgWhenInPotForDutySeed(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-
-		// 种子在NotReady阶段能够处理的Data类消息
-
-		for _, ent := range msg.Entries {
-			ent := ent
-			var err error
-
-			switch ent.Type {
-			case defines.EntryType_Block:
-				// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
-				// 且按LatestBlock倒序补漏
-				err = p.handleEntryBlock(msg.From, ent)
-			case defines.EntryType_Proof:
-				// 收集Proof. NotReady只是不竞选不校验，不代表不见证
-				err = p.handleEntryProof(msg.From, ent)
-			case defines.EntryType_NewBlock:
-				// 收集新区块
-				err = p.handleEntryNewBlock(msg.From, ent)
-			case defines.EntryType_Transaction:
-				err = p.handleEntryTransaction(msg.From, ent)
-			case defines.EntryType_Neighbor:
-				err = p.handleEntryNeighbor(msg.From, ent)
-			case defines.EntryType_Process:
-				err = p.handleEntryProcess(msg.From, ent)
-			default:
-				p.Errorf("%s met unknown entry type(%v)", p.DutyState(), ent.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), ent.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), ent.Type, msg.From)
-			}
-		}
-
-		return nil
-	case defines.MessageType_Req: // 对于请求，正常响应，逻辑基本是一致的
-		for _, req := range msg.Reqs {
-			req := req
-			var err error
-
-			switch req.Type {
-			case defines.RequestType_Neighbors:
-				err = p.handleRequestNeighbors(msg.From, req)
-			case defines.RequestType_Blocks:
-				err = p.handleRequestBlocks(msg.From, req)
-			case defines.RequestType_Processes:
-				err = p.handleRequestProcesses(msg.From, req)
-			default:
-				p.Errorf("%s met unknown req type(%v)", p.DutyState(), req.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), req.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), req.Type, msg.From)
-			}
-		}
-
-		return nil
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
-	}
+	return p.handleMsgByKind(msg)
 }
 
 // PostPot阶段
 // 处理Req消息和Data消息
 
 func (p *Pot) handleMsgWhenPostPotForDutyNone(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-
-		// 种子在NotReady阶段能够处理的Data类消息
-
-		for _, ent := range msg.Entries {
-			ent := ent
-			var err error
-
-			switch ent.Type {
-			case defines.EntryType_Block:
-				// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
-				// 且按LatestBlock倒序补漏
-				err = p.handleEntryBlock(msg.From, ent)
-			case defines.EntryType_Proof:
-				// 收集Proof. NotReady只是不竞选不校验，不代表不见证
-				err = p.handleEntryProof(msg.From, ent)
-			case defines.EntryType_NewBlock:
-				// 收集新区块
-				err = p.handleEntryNewBlock(msg.From, ent)
-			case defines.EntryType_Transaction:
-				err = p.handleEntryTransaction(msg.From, ent)
-			case defines.EntryType_Neighbor:
-				err = p.handleEntryNeighbor(msg.From, ent)
-			case defines.EntryType_Process:
-				err = p.handleEntryProcess(msg.From, ent)
-			default:
-				p.Errorf("%s met unknown entry type(%v)", p.DutyState(), ent.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), ent.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), ent.Type, msg.From)
-			}
-		}
+	return p.handleMsgByKind(msg)
+}
 
-		return nil
-	case defines.MessageType_Req: // 对于请求，正常响应，逻辑基本是一致的
-		for _, req := range msg.Reqs {
-			req := req
-			var err error
-
-			switch req.Type {
-			case defines.RequestType_Neighbors:
-				err = p.handleRequestNeighbors(msg.From, req)
-			case defines.RequestType_Blocks:
-				err = p.handleRequestBlocks(msg.From, req)
-			case defines.RequestType_Processes:
-				err = p.handleRequestProcesses(msg.From, req)
-			default:
-				p.Errorf("%s met unknown req type(%v)", p.DutyState(), req.Type)
-			}
+func (p *Pot) handleMsgWhenPostPotForDutyPeer(msg *defines.Message) error {
+	return p.handleMsgByKind(msg)
+}
 
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), req.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), req.Type, msg.From)
-			}
-		}
+func (p *Pot) handleMsgWhenPostPotForDutySeed(msg *defines.Message) error {
+	return p.handleMsgByKind(msg)
+}
 
-		return nil
+// handleMsgByKind NotReady/InPot/PostPot阶段各类节点的处理逻辑相同，都按Kind分派
+func (p *Pot) handleMsgByKind(msg *defines.Message) error {
+	switch msg.Kind {
+	case defines.MessageKind_ReqBlocks:
+		return p.handleReqs(msg, p.handleRequestBlocks)
+	case defines.MessageKind_ReqNeighbors:
+		return p.handleReqs(msg, p.handleRequestNeighbors)
+	case defines.MessageKind_ReqProcesses:
+		return p.handleReqs(msg, p.handleRequestProcesses)
+	case defines.MessageKind_RspBlocks:
+		// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
+		// 且按LatestBlock倒序补漏
+		return p.handleEntries(msg, p.handleEntryBlock)
+	case defines.MessageKind_Proof:
+		// 收集Proof. NotReady只是不竞选不校验，不代表不见证
+		return p.handleEntries(msg, p.handleEntryProof)
+	case defines.MessageKind_RelayedProof:
+		return p.handleEntries(msg, p.handleEntryRelayedProof)
+	case defines.MessageKind_NewBlock:
+		return p.handleEntries(msg, p.handleEntryNewBlock)
+	case defines.MessageKind_Transaction:
+		return p.handleEntries(msg, p.handleEntryTransaction)
+	case defines.MessageKind_RspNeighbors, defines.MessageKind_Neighbor:
+		return p.handleEntries(msg, p.handleEntryNeighbor)
+	case defines.MessageKind_RspProcesses:
+		return p.handleEntries(msg, p.handleEntryProcess)
+	case defines.MessageKind_Evidence:
+		return p.handleEntries(msg, p.handleEntryEvidence)
 	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
+		return fmt.Errorf("%s met unknown msg kind(%s)", p.DutyState(), msg.Kind)
 	}
 }
 
-func (p *Pot) handleMsgWhenPostPotForDutyPeer(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-
-		// 种子在NotReady阶段能够处理的Data类消息
-
-		for _, ent := range msg.Entries {
-			ent := ent
-			var err error
-
-			switch ent.Type {
-			case defines.EntryType_Block:
-				// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
-				// 且按LatestBlock倒序补漏
-				err = p.handleEntryBlock(msg.From, ent)
-			case defines.EntryType_Proof:
-				// 收集Proof. NotReady只是不竞选不校验，不代表不见证
-				err = p.handleEntryProof(msg.From, ent)
-			case defines.EntryType_NewBlock:
-				// 收集新区块
-				err = p.handleEntryNewBlock(msg.From, ent)
-			case defines.EntryType_Transaction:
-				err = p.handleEntryTransaction(msg.From, ent)
-			case defines.EntryType_Neighbor:
-				err = p.handleEntryNeighbor(msg.From, ent)
-			case defines.EntryType_Process:
-				err = p.handleEntryProcess(msg.From, ent)
-			default:
-				p.Errorf("%s met unknown entry type(%v)", p.DutyState(), ent.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), ent.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), ent.Type, msg.From)
-			}
+// handleEntries 逐个处理消息中的条目，条目类型须与Kind相符
+// 单个条目处理失败只记录日志，不影响其他条目
+func (p *Pot) handleEntries(msg *defines.Message, handle func(from string, ent *defines.Entry) error) error {
+	typ, _ := msg.Kind.EntryType()
+	for _, ent := range msg.Entries {
+		if ent.Type != typ {
+			p.Errorf("%s met %s in %s msg from (%s)", p.DutyState(), ent.Type, msg.Kind, msg.From)
+			continue
 		}
-
-		return nil
-	case defines.MessageType_Req: // 对于请求，正常响应，逻辑基本是一致的
-		for _, req := range msg.Reqs {
-			req := req
-			var err error
-
-			switch req.Type {
-			case defines.RequestType_Neighbors:
-				err = p.handleRequestNeighbors(msg.From, req)
-			case defines.RequestType_Blocks:
-				err = p.handleRequestBlocks(msg.From, req)
-			case defines.RequestType_Processes:
-				err = p.handleRequestProcesses(msg.From, req)
-			default:
-				p.Errorf("%s met unknown req type(%v)", p.DutyState(), req.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), req.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), req.Type, msg.From)
-			}
+		if err := handle(msg.From, ent); err != nil {
+			p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), msg.Kind, msg.From, err)
+		} else {
+			p.Debugf("%s handle %s from (%s) succ", p.DutyState(), msg.Kind, msg.From)
 		}
-
-		return nil
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
 	}
+	return nil
 }
 
-func (p *Pot) handleMsgWhenPostPotForDutySeed(msg *defines.Message) error {
-	switch msg.Type {
-	case defines.MessageType_None:
-		return fmt.Errorf("%s can only handle [EntryType_Block]", p.DutyState())
-	case defines.MessageType_Data:
-
-		// 种子在NotReady阶段能够处理的Data类消息
-
-		for _, ent := range msg.Entries {
-			ent := ent
-			var err error
-
-			switch ent.Type {
-			case defines.EntryType_Block:
-				// 在NotReady状态下接收过往区块需要注意，所有接收到的区块临时存到一个哈希表
-				// 且按LatestBlock倒序补漏
-				err = p.handleEntryBlock(msg.From, ent)
-			case defines.EntryType_Proof:
-				// 收集Proof. NotReady只是不竞选不校验，不代表不见证
-				err = p.handleEntryProof(msg.From, ent)
-			case defines.EntryType_NewBlock:
-				// 收集新区块
-				err = p.handleEntryNewBlock(msg.From, ent)
-			case defines.EntryType_Transaction:
-				err = p.handleEntryTransaction(msg.From, ent)
-			case defines.EntryType_Neighbor:
-				err = p.handleEntryNeighbor(msg.From, ent)
-			case defines.EntryType_Process:
-				err = p.handleEntryProcess(msg.From, ent)
-			default:
-				p.Errorf("%s met unknown entry type(%v)", p.DutyState(), ent.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), ent.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), ent.Type, msg.From)
-			}
+// handleReqs 逐个响应消息中的请求，请求类型须与Kind相符
+func (p *Pot) handleReqs(msg *defines.Message, handle func(from string, req *defines.Request) error) error {
+	typ, _ := msg.Kind.RequestType()
+	for _, req := range msg.Reqs {
+		if req.Type != typ {
+			p.Errorf("%s met %s in %s msg from (%s)", p.DutyState(), req.Type, msg.Kind, msg.From)
+			continue
 		}
-
-		return nil
-	case defines.MessageType_Req: // 对于请求，正常响应，逻辑基本是一致的
-		for _, req := range msg.Reqs {
-			req := req
-			var err error
-
-			switch req.Type {
-			case defines.RequestType_Neighbors:
-				err = p.handleRequestNeighbors(msg.From, req)
-			case defines.RequestType_Blocks:
-				err = p.handleRequestBlocks(msg.From, req)
-			case defines.RequestType_Processes:
-				err = p.handleRequestProcesses(msg.From, req)
-			default:
-				p.Errorf("%s met unknown req type(%v)", p.DutyState(), req.Type)
-			}
-
-			if err != nil {
-				p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), req.Type, msg.From, err)
-			} else {
-				p.Debugf("%s handle %s from (%s) succ", p.DutyState(), req.Type, msg.From)
-			}
+		if err := handle(msg.From, req); err != nil {
+			p.Errorf("%s handle %s from (%s) fail: %s", p.DutyState(), msg.Kind, msg.From, err)
+		} else {
+			p.Debugf("%s handle %s from (%s) succ", p.DutyState(), msg.Kind, msg.From)
 		}
-
-		return nil
-	default:
-		return fmt.Errorf("%s met unknown msg type(%v)", p.DutyState(), msg.Type)
 	}
+	return nil
 }
 
 // /////////////////////////// 处理消息 /////////////////////////
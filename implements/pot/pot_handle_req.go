@@ -34,7 +34,7 @@ func (p *Pot) handleRequestBlocks(from string, req *defines.Request) error {
 	} else if req.IndexCount > 0 { // 按Index请求
 		blocks, err = p.bc.GetBlocksByRange(req.IndexStart, req.IndexCount) //TODO
 	} else { // 按Hash请求
-		blocks, err = p.bc.GetBlocksByHashes(req.Hashes)
+		blocks, err = p.blocksByHashes(req.Hashes)
 	}
 
 	if err != nil {
@@ -43,7 +43,7 @@ func (p *Pot) handleRequestBlocks(from string, req *defines.Request) error {
 	}
 	p.Debugf("handleRequestBlocks: get blocks from blockchain(p.bc) for %s, blocks=%v", from, blocks)
 	p.Debugf("current BC: %s", p.bc.Display())
-	return p.responseBlocks(from, blocks...)
+	return p.responseBlocks(from, req.Id, blocks...)
 }
 
 // 将自身的邻居表整理回发
@@ -72,13 +72,12 @@ func (p *Pot) handleRequestNeighbors(from string, req *defines.Request) error {
 	msg := &defines.Message{
 		Version: defines.CodeVersion,
 		Type:    defines.MessageType_Data,
+		Kind:    defines.MessageKind_RspNeighbors,
+		ReqId:   req.Id,
 		From:    p.id,
 		To:      from,
 		Entries: entries,
 	}
-	if err := msg.WriteDesc("type", "rsp-neighbors"); err != nil {
-		return err
-	}
 	err := p.signAndSendMsg(msg)
 	if err != nil {
 		return err
@@ -92,58 +91,53 @@ func (p *Pot) handleRequestNeighbors(from string, req *defines.Request) error {
 		Type: defines.EntryType_Neighbor,
 		Data: req.Data, // 请求方的节点信息
 	}
-	p.pit.RangePeers(func(peer *defines.PeerInfo) error {
-		msg := &defines.Message{
-			Version: defines.CodeVersion,
-			Type:    defines.MessageType_Data,
-			From:    p.id,
-			To:      peer.Id,
-			Entries: []*defines.Entry{bEntry},
-		}
-		if err := msg.WriteDesc("type", "neighbor"); err != nil {
-			return err
-		}
-		return p.signAndSendMsg(msg)
-	})
+	bmsg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Data,
+		Kind:    defines.MessageKind_Neighbor,
+		From:    p.id,
+		Entries: []*defines.Entry{bEntry},
+	}
+	results, _ := p.broadcast(bmsg, defines.BroadcastScope_Peers)
+	p.logBroadcast("handleRequestNeighbors", results)
 
 	return nil
 }
 
-// 将自身的
+// 将自身的进度表整理回发
 func (p *Pot) handleRequestProcesses(from string, req *defines.Request) error {
 
-	// 回发节点表
-	entries := make([]*defines.Entry, 0)
-	p.pit.RangePeers(func(peer *defines.PeerInfo) error {
-		b, err := peer.Encode()
+	// 回发进度表
+	_, processes := p.processes.snapshot()
+	entries := make([]*defines.Entry, 0, len(processes))
+	for _, process := range processes {
+		b, err := process.Encode()
 		if err != nil {
-			p.Errorf("handleRequestNeighbors: encode peerinfo(%v) fail: %s", *peer, err)
+			p.Errorf("handleRequestProcesses: encode process(%v) fail: %s", *process, err)
 			return err
 		}
 		entries = append(entries, &defines.Entry{
-			Type: defines.EntryType_Neighbor,
+			Type: defines.EntryType_Process,
 			Data: b,
 		})
-		return nil
-	})
+	}
 
 	msg := &defines.Message{
 		Version: defines.CodeVersion,
 		Type:    defines.MessageType_Data,
+		Kind:    defines.MessageKind_RspProcesses,
+		ReqId:   req.Id,
 		From:    p.id,
 		To:      from,
 		Entries: entries,
 	}
-	if err := msg.WriteDesc("type", "rsp-processes"); err != nil {
-		return err
-	}
 	return p.signAndSendMsg(msg)
 }
 
 /////////////////////////////////////////////////
 
-// 向节点回应其请求的区块
-func (p *Pot) responseBlocks(to string, blocks ...*defines.Block) error {
+// 向节点回应其请求的区块，reqId为所回应请求的Id
+func (p *Pot) responseBlocks(to string, reqId uint64, blocks ...*defines.Block) error {
 	l := len(blocks)
 	if l == 0 {
 		return nil
@@ -172,12 +166,11 @@ func (p *Pot) responseBlocks(to string, blocks ...*defines.Block) error {
 	msg := &defines.Message{
 		Version: defines.CodeVersion,
 		Type:    defines.MessageType_Data,
+		Kind:    defines.MessageKind_RspBlocks,
+		ReqId:   reqId,
 		From:    p.id,
 		To:      to,
 		Entries: entries,
 	}
-	if err := msg.WriteDesc("type", "rsp-blocks"); err != nil {
-		return err
-	}
 	return p.signAndSendMsg(msg)
 }
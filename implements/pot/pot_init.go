@@ -8,6 +8,9 @@ package pot
 
 import (
 	"errors"
+	"fmt"
+	"time"
+
 	"github.com/azd1997/blockchain-consensus/defines"
 )
 
@@ -28,6 +31,10 @@ func (p *Pot) Init() error {
 
 	p.Infof("Init start")
 
+	if err := p.loadLocal(); err != nil {
+		return err
+	}
+
 	// 启动消息处理循环
 	go p.msgHandleLoop()
 	// 启动状态切换循环(没有clock触发)
@@ -58,6 +65,28 @@ func (p *Pot) Init() error {
 	return nil
 }
 
+// loadLocal 以本地最新区块作为证明表的基准，并恢复重启前的共识状态
+// 持久化的区块链(modules/blockchain)在其Init之后才能读出最新区块，而Node先构造共识、后Init区块链，所以不能放在New中
+func (p *Pot) loadLocal() error {
+	var latestHash []byte
+	latestIndex := p.bc.GetMaxIndex()
+	if latestIndex > 0 {
+		latest, err := p.bc.GetBlocksByRange(-1, 1)
+		if err != nil {
+			return err
+		}
+		if len(latest) > 0 {
+			latestHash = latest[0].SelfHash
+		}
+	}
+	p.proofs.setBase(latestIndex, latestHash)
+
+	if err := p.loadState(); err != nil {
+		return fmt.Errorf("load consensus state fail: %w", err)
+	}
+	return nil
+}
+
 // 作为Seed初始化
 // 包括初始化启动(还没有区块链)与重启动两种
 // 	1. 初始化启动： 启动消息处理循环/创造0号区块/启动时钟/进入
@@ -88,7 +117,8 @@ func (p *Pot) initForSeedFirstStart() error {
 
 	// 尝试与节点表其他seed联系，请求邻居信息
 	p.setState(StateType_PreInited_RequestNeighbors)
-	rnf, err := p.requestNeighborsFuncGenerator()
+	var futures []neighborsFuture
+	rnf, err := p.requestNeighborsFuncGenerator(&futures)
 	if err != nil {
 		return err
 	}
@@ -104,7 +134,7 @@ func (p *Pot) initForSeedFirstStart() error {
 		// (a)情况下，当前seed需要创建区块链了：
 
 		// 创建创世区块(1号区块)
-		genesis, err := p.bc.CreateTheWorld()
+		genesis, err := p.createGenesis()
 		if err != nil {
 			p.Fatalf("initForSeedFirstStart: create genesis block fail: %s\n", err)
 		}
@@ -121,9 +151,8 @@ func (p *Pot) initForSeedFirstStart() error {
 	}
 
 	// total - 1 > len(errs) 部分成功或全部成功.   <的情况不用讨论，不可能出现
-	nWait := total - 1 - len(errs) // 设置等待数量
-	// 等待
-	if err := p.wait(nWait); err != nil { // 一个都没等到
+	// 等待发出的各个请求
+	if err := p.waitNeighbors(futures); err != nil { // 一个都没等到
 		return err // 退出启动
 	}
 
@@ -155,7 +184,8 @@ func (p *Pot) initForSeedFirstStart() error {
 	if err != nil {
 		return err
 	}
-	// 初始化时钟
+	// 初始化时钟，时间划分以1号区块中的为准
+	p.adoptGenesisTiming(firstBlock)
 	p.clock.Start(firstBlock)
 	// 将第一个区块加入到本地。这里对于1号区块的添加是使用AddNewBlock，特殊处理
 	if err := p.bc.AddNewBlock(firstBlock); err != nil {
@@ -210,6 +240,15 @@ func (p *Pot) initForSeedFirstStart() error {
 func (p *Pot) initForSeedReStart() error {
 	p.Info("initForSeedReStart")
 
+	localMaxBlock, err := p.bc.GetBlocksByRange(-1, 1)
+	if err != nil {
+		return err
+	}
+	p.adoptLocalGenesisTiming()
+	if p.restoredStateCurrent(localMaxBlock[0]) {
+		return p.resumeRestoredState(localMaxBlock[0])
+	}
+
 	// 1. 广播seeds(以及peers)，看有无在线的
 	// 尝试与节点表其他seed联系，请求邻居信息
 	p.setState(StateType_PreInited_RequestNeighbors)
@@ -219,17 +258,13 @@ func (p *Pot) initForSeedReStart() error {
 	}
 
 	// 2. 根据本地已有区块，初始化时钟
-	localMaxBlock, err := p.bc.GetBlocksByRange(-1, 1)
-	if err != nil {
-		return err
-	}
 	p.clock.Start(localMaxBlock[0])
 
 	// 3. 等待一段时间，到达PotStart时刻
 	<-p.potStartBeforeReady
 
 	// 4. 发起请求最新区块。
-	// 之所以要在PotStart时刻请求，是为了降低复杂性，2*TickMs能保证回应能在新区块诞生前收到
+	// 之所以要在PotStart时刻请求，是为了降低复杂性，回应能在新区块诞生(PotOver)前收到
 	p.setState(StateType_PreInited_RequestLatestBlock)
 	latestBlock, err := p.requestLatestBlockAndWait(seedsAllFail)
 	if err != nil {
@@ -266,7 +301,8 @@ func (p *Pot) initForPeerFirstStart() error {
 	if err != nil {
 		return err
 	}
-	// 初始化时钟
+	// 初始化时钟，时间划分以1号区块中的为准
+	p.adoptGenesisTiming(firstBlock)
 	p.clock.Start(firstBlock)
 	if err := p.bc.AddNewBlock(firstBlock); err != nil {
 		p.Errorf("add first block fail: %s", err)
@@ -300,6 +336,15 @@ func (p *Pot) initForPeerFirstStart() error {
 func (p *Pot) initForPeerReStart() error {
 	p.Info("initForPeerReStart")
 
+	localMaxBlock, err := p.bc.GetBlocksByRange(-1, 1)
+	if err != nil {
+		return err
+	}
+	p.adoptLocalGenesisTiming()
+	if p.restoredStateCurrent(localMaxBlock[0]) {
+		return p.resumeRestoredState(localMaxBlock[0])
+	}
+
 	// 1. 向seeds和预配置的peers请求节点表
 	p.setState(StateType_PreInited_RequestNeighbors)
 	seedsAllFail, err := p.requestNeighborsAndWait()
@@ -308,10 +353,6 @@ func (p *Pot) initForPeerReStart() error {
 	}
 
 	// 2. 根据本地已有区块，初始化时钟
-	localMaxBlock, err := p.bc.GetBlocksByRange(-1, 1)
-	if err != nil {
-		return err
-	}
 	p.clock.Start(localMaxBlock[0])
 
 	// 3. 等待一段时间，到达PotStart时刻
@@ -343,12 +384,32 @@ func (p *Pot) initForPeerReStart() error {
 	return nil
 }
 
+// restoredStateCurrent 重启前保存的共识状态是否仍是最新的
+// 状态记录的纪元就是本地最新区块，且该区块出块至今不到一轮，网络中还不可能有更新的区块
+func (p *Pot) restoredStateCurrent(latest *defines.Block) bool {
+	if !p.stateRestored || latest == nil || p.Epoch() != latest.Index {
+		return false
+	}
+	age := time.Duration(time.Now().UnixNano() - latest.Timestamp)
+	return age >= 0 && age < p.getTiming().round()
+}
+
+// resumeRestoredState 以本地最新区块启动时钟，跳过邻居与最新区块的同步，直接进入NotReady
+// 节点表与投票等已由loadState及节点表自身的存储恢复
+func (p *Pot) resumeRestoredState(latest *defines.Block) error {
+	p.Infof("restored consensus state is current(epoch %d), skip sync", latest.Index)
+	p.clock.Start(latest)
+	p.setState(StateType_NotReady)
+	return nil
+}
+
 ////////////////////////////////////////////////////
 
 // 请求邻居节点
 func (p *Pot) requestNeighborsAndWait() (seedsAllFail bool, err error) {
 	all := 0
-	rnf, err := p.requestNeighborsFuncGenerator()
+	var futures []neighborsFuture
+	rnf, err := p.requestNeighborsFuncGenerator(&futures)
 	if err != nil {
 		return false, err
 	}
@@ -366,9 +427,8 @@ func (p *Pot) requestNeighborsAndWait() (seedsAllFail bool, err error) {
 		return seedsAllFail, errors.New("fatal error: impossible")
 	}
 	// 部分成功或全部成功
-	nWait := all - len(errs) // 设置等待数量
 	// 等待 (此时的handle函数会将邻居节点进行存储，若重复以先存为准)
-	if err := p.wait(nWait); err != nil { // 一个都没等到
+	if err := p.waitNeighbors(futures); err != nil { // 一个都没等到
 		return seedsAllFail, err // 退出启动
 	}
 	return seedsAllFail, nil
@@ -383,23 +443,20 @@ func (p *Pot) requestLatestBlockAndWait(seedsAllFail bool) (*defines.Block, erro
 func (p *Pot) requestOneBlockAndWait(seedsAllFail bool, index int64) (*defines.Block, error) {
 	var total, all int
 	var errs map[string]error
-	var nWait int
+	var futures []blockFuture
 	if seedsAllFail {
-		total, errs = p.pit.RangePeers(p.requestOneBlockFuncGenerator(index))
+		total, errs = p.pit.RangePeers(p.requestOneBlockFuncGenerator(index, &futures))
 	} else {
-		total, errs = p.pit.RangeSeeds(p.requestOneBlockFuncGenerator(index))
+		total, errs = p.pit.RangeSeeds(p.requestOneBlockFuncGenerator(index, &futures))
 	}
 	all = total - bool2int(p.duty == defines.PeerDuty_Seed)
 	if all == len(errs) { // 全部发信失败，则退出
 		return nil, errors.New("request latest block to seeds and peers all fail")
 	} else if all < len(errs) {
 		return nil, errors.New("fatal error: impossible")
-	} else {
-		// 部分成功或全部成功
-		nWait = all - len(errs) // 设置等待数量
 	}
-	// 等待该区块，并从众多回复中确定接收哪一个
-	if wb, err := p.waitAndDecideOneBlock(index, nWait); err != nil { // 一个都没等到
+	// 部分成功或全部成功，等待该区块，并从众多回复中确定接收哪一个
+	if wb, err := p.waitAndDecideOneBlock(index, futures); err != nil { // 一个都没等到
 		return nil, err // 退出启动
 	} else {
 		return wb, nil
@@ -6,7 +6,10 @@
 
 package pot
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 // 状态切换循环
 // 这里要注意这个循环启动的前提是同步到了最新进度，拿到了世界时钟之后才进入状态切换循环
@@ -21,6 +24,9 @@ func (p *Pot) stateMachineLoop() {
 			return
 		case moment := <-p.clock.Tick:
 			p.Infof("stateMachineLoop: clock tick: %s", moment.String())
+			if n := p.requests.expire(time.Now()); n > 0 {
+				p.Debugf("stateMachineLoop: %d requests expired", n)
+			}
 
 			// 根据当前状态来处理此滴答消息
 			state := p.getState()
@@ -162,7 +168,7 @@ func (p *Pot) msgHandleLoop() {
 		case msg := <-p.msgin:
 			err = p.handleMsg(msg)
 			if err != nil {
-				p.Errorf("msgHandleLoop: handle msg(%s) fail: msg=%s,err=%s", msg.Desc, msg, err)
+				p.Errorf("msgHandleLoop: handle msg(%s) fail: msg=%s,err=%s", msg.Kind, msg, err)
 			}
 		case tx := <-p.localTxIn:
 			// 存到本地
@@ -0,0 +1,153 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/18/20 5:20 PM
+* @Description: The file is for
+***********************************************************************/
+
+package pot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/modules/peerinfo"
+	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+// newTestKey 生成测试用密钥对及其派生的ID
+func newTestKey(t *testing.T) (crypto.PrivateKey, string) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, identity.FromPublicKey(key.Public())
+}
+
+// newTestPot 构造一个仅用于测试消息处理的Pot，peers为预登记的节点(含公钥)
+func newTestPot(t *testing.T, key crypto.PrivateKey, peers ...*defines.PeerInfo) *Pot {
+	return newTestPotWithOption(t, key, &Option{}, peers...)
+}
+
+// newTestPotWithOption 同newTestPot，opt中未设置的Id/Duty/Key/Pit/BC由测试填充
+func newTestPotWithOption(t *testing.T, key crypto.PrivateKey, opt *Option, peers ...*defines.PeerInfo) *Pot {
+	id := identity.FromPublicKey(key.Public())
+	log.InitGlobalLogger(id, false, false)
+
+	pit, err := peerinfo.NewPeerInfoTable(id, test.NewStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range peers {
+		if err := pit.Set(info); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opt.Id, opt.Key, opt.Pit = id, key, pit
+	if opt.Duty == defines.PeerDuty_None {
+		opt.Duty = defines.PeerDuty_Peer
+	}
+	if opt.BC == nil {
+		opt.BC = test.NewBlockChain(id, key)
+	}
+	p, err := New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPot_HandleMsgVerifySig(t *testing.T) {
+	seedKey, seed01 := newTestKey(t)
+	seed02Key, seed02 := newTestKey(t)
+	fakeKey, _ := newTestKey(t)
+	peerKey, peer01 := newTestKey(t)
+
+	p := newTestPot(t, peerKey, &defines.PeerInfo{
+		Id:     seed01,
+		Addr:   "127.0.0.1:8001",
+		Duty:   defines.PeerDuty_Seed,
+		PubKey: crypto.MarshalPublicKey(seedKey.Public()),
+	})
+
+	newMsg := func() *defines.Message {
+		return &defines.Message{
+			Version: defines.CodeVersion,
+			Type:    defines.MessageType_Data,
+			From:    seed01,
+			To:      peer01,
+		}
+	}
+
+	// 冒充seed01的消息应被拒绝
+	forged := newMsg()
+	if err := forged.Sign(fakeKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.handleMsg(forged); err == nil {
+		t.Error("forged msg should be rejected")
+	}
+
+	// 未登记公钥的发送方应被拒绝
+	unknown := newMsg()
+	unknown.From = seed02
+	if err := unknown.Sign(seed02Key); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.handleMsg(unknown); err == nil {
+		t.Error("msg from unknown sender should be rejected")
+	}
+
+	// seed01的真实签名可以通过验证
+	genuine := newMsg()
+	if err := genuine.Sign(seedKey); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := p.pubKeyOf(genuine.From)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := genuine.Verify(pub); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPot_HandleEntryTransactionVerify(t *testing.T) {
+	senderKey, sender := newTestKey(t)
+	fakeKey, _ := newTestKey(t)
+	relayKey, relay := newTestKey(t)
+	selfKey, self := newTestKey(t)
+
+	p := newTestPot(t, selfKey,
+		&defines.PeerInfo{Id: sender, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(senderKey.Public())},
+		&defines.PeerInfo{Id: relay, Addr: "127.0.0.1:8002", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(relayKey.Public())})
+
+	entry := func(key crypto.PrivateKey) *defines.Entry {
+		tx, err := defines.NewTransactionAndSign(sender, self, 1, nil, "", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := tx.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &defines.Entry{Type: defines.EntryType_Transaction, Data: data}
+	}
+
+	// 由relay转发、但不是sender签名的交易应被拒绝
+	if err := p.handleEntryTransaction(relay, entry(fakeKey)); !errors.Is(err, crypto.ErrVerifySigFail) {
+		t.Errorf("err = %v, want ErrVerifySigFail", err)
+	}
+	if err := p.handleEntryTransaction(relay, entry(senderKey)); err != nil {
+		t.Errorf("genuine tx rejected: %s", err)
+	}
+}
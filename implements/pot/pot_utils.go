@@ -9,6 +9,9 @@ package pot
 import (
 	"fmt"
 	"sync/atomic"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
 )
 
 // 查看当前状态
@@ -21,6 +24,22 @@ func (p *Pot) setState(newState StateType) {
 	atomic.StoreUint32((*uint32)(&p.state), uint32(newState))
 }
 
+// 查询某个节点在节点表中登记的公钥
+// 自身的公钥直接取自私钥，不要求节点表中登记了自己
+func (p *Pot) pubKeyOf(id string) (crypto.PublicKey, error) {
+	if id == p.id {
+		return p.key.Public(), nil
+	}
+	info, err := p.pit.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.PubKey) == 0 {
+		return nil, fmt.Errorf("no public key registered for %s", id)
+	}
+	return crypto.UnmarshalPublicKey(info.PubKey)
+}
+
 // 查看当前状态和duty
 func (p *Pot) DutyState() string {
 	return fmt.Sprintf("%s-%s", p.duty.String(), p.getState().String())
@@ -90,14 +109,42 @@ func (p *Pot) isSelfReady() bool {
 	return !p.bc.Discontinuous()
 }
 
+// isAlive 节点是否可达且正常。可达性由网络模块的保活维护在节点信息表中，见bnet/keepalive.go
+// 自己总是可达的；不在节点信息表中的节点视为不可达
+func (p *Pot) isAlive(id string) bool {
+	if id == p.id {
+		return true
+	}
+	info, err := p.pit.Get(id)
+	return err == nil && info.Attr == defines.PeerAttr_Normal
+}
+
+// aliveIds 过滤出ids中可达的节点，全都不可达时原样返回，交给网络模块去尝试
+func (p *Pot) aliveIds(ids []string) []string {
+	alive := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if p.isAlive(id) {
+			alive = append(alive, id)
+		}
+	}
+	if len(alive) == 0 {
+		return ids
+	}
+	return alive
+}
+
 // Epoch 查看当前处于哪一个纪元
 func (p *Pot) Epoch() int64 {
-	return p.epoch
+	return atomic.LoadInt64(&p.epoch)
+}
+
+func (p *Pot) setEpoch(epoch int64) {
+	atomic.StoreInt64(&p.epoch, epoch)
 }
 
 // NextEpoch 新纪元开启
 func (p *Pot) NextEpoch() {
-	p.epoch++
+	atomic.AddInt64(&p.epoch, 1)
 }
 
 func bool2int(b bool) int {
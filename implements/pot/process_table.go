@@ -56,7 +56,9 @@ type processTable struct {
 	lock *sync.RWMutex
 
 	//total      int // 统计的总节点数
-	//totalAlive int // 进度在n或n-1的节点认为是在不断同步，其他可能是挂掉或刚起来，不算在alive内
+
+	// alive 节点是否可达，由Pot根据节点信息表中的连接状态提供。为nil则认为都可达
+	alive func(id string) bool
 
 	// 自己的进度是否存在空洞？空洞情况
 	// “不重叠区间” 按left升序
@@ -97,6 +99,28 @@ func (pt *processTable) refresh(latestBlock *defines.Block) {
 	}
 }
 
+// snapshot 复制当前进度表
+func (pt *processTable) snapshot() (int64, map[string]*defines.Process) {
+	pt.lock.RLock()
+	defer pt.lock.RUnlock()
+	processes := make(map[string]*defines.Process, len(pt.processes))
+	for id, process := range pt.processes {
+		processes[id] = process
+	}
+	return pt.maxIndex, processes
+}
+
+// restore 恢复重启前保存的进度表
+func (pt *processTable) restore(maxIndex int64, processes map[string]*defines.Process) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	pt.maxIndex = maxIndex
+	pt.processes = make(map[string]*defines.Process, len(processes))
+	for id, process := range processes {
+		pt.processes[id] = process
+	}
+}
+
 // set 更新某个节点的进度
 func (pt *processTable) set(id string, process *defines.Process) {
 	pt.lock.Lock()
@@ -134,7 +158,12 @@ func (pt *processTable) nLatestPeers(n int) []string {
 	}
 	all := make([]string, 0, c)
 	for id := range pt.processes {
-		all = append(all, id)
+		if pt.isAlive(id) {
+			all = append(all, id)
+		}
+	}
+	if l > len(all) {
+		l = len(all)
 	}
 	return all[:l]
 }
@@ -161,11 +190,21 @@ func (pt *processTable) latest() {
 
 }
 
-// totalAlive 所有状况正常的节点数
+// totalAlive 所有状况正常(可达)的节点数
 func (pt *processTable) totalAlive() int {
 	pt.lock.RLock()
 	defer pt.lock.RUnlock()
-	return len(pt.processes)
+	n := 0
+	for id := range pt.processes {
+		if pt.isAlive(id) {
+			n++
+		}
+	}
+	return n
+}
+
+func (pt *processTable) isAlive(id string) bool {
+	return pt.alive == nil || pt.alive(id)
 }
 
 // fill 本机节点获得中间的区块，用以填补空缺 （fill hole）
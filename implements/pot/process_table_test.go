@@ -70,3 +70,32 @@ func Test_processTable_fill(t *testing.T) {
 		})
 	}
 }
+
+func Test_processTable_alive(t *testing.T) {
+	pt := newProcessTable()
+	pt.restore(3, map[string]*defines.Process{
+		"a": {Index: 3},
+		"b": {Index: 3},
+		"c": {Index: 2},
+	})
+	if n := pt.totalAlive(); n != 3 {
+		t.Errorf("totalAlive without liveness = %d, want 3", n)
+	}
+
+	pt.alive = func(id string) bool { return id != "b" }
+	if n := pt.totalAlive(); n != 2 {
+		t.Errorf("totalAlive = %d, want 2", n)
+	}
+	peers := pt.nLatestPeers(0)
+	if len(peers) != 2 {
+		t.Errorf("nLatestPeers(0) = %v, want 2 peers", peers)
+	}
+	for _, id := range peers {
+		if id == "b" {
+			t.Errorf("nLatestPeers returns unreachable b")
+		}
+	}
+	if peers := pt.nLatestPeers(5); len(peers) != 2 {
+		t.Errorf("nLatestPeers(5) = %v, want 2 peers", peers)
+	}
+}
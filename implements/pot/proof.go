@@ -8,12 +8,15 @@ package pot
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"bytes"
 	"encoding/gob"
 	"github.com/azd1997/blockchain-consensus/utils/binary"
 
 	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
 // 这里将证明的含义作下说明：本质上节点构造区块是在PotOver时，这时将区块哈希和区块包含的有效交易数量作为证明
@@ -25,10 +28,16 @@ type Proof struct {
 	BlockHash []byte // 自己构造的区块的哈希
 	Base      []byte // 基于的区块的哈希
 	BaseIndex int64  // 基于的区块的序号
+	Sig       []byte // Id对以上字段的签名，经种子转发后仍可验证，也是作恶时的证据
 }
 
 func (p *Proof) Short() string {
-	str := fmt.Sprintf("%d-%s:%s(%d)", p.BaseIndex+1, p.Id, fmt.Sprintf("%x", p.BlockHash)[:6], p.TxsNum)
+	// 证明可能来自网络，BlockHash长度不可信
+	h := fmt.Sprintf("%x", p.BlockHash)
+	if len(h) > 6 {
+		h = h[:6]
+	}
+	str := fmt.Sprintf("%d-%s:%s(%d)", p.BaseIndex+1, identity.Short(p.Id), h, p.TxsNum)
 	return str
 }
 
@@ -48,6 +57,53 @@ func (p *Proof) Decode(data []byte) error {
 	return gob.NewDecoder(bytes.NewReader(data)).Decode(p)
 }
 
+// proofSigPrefix 证明签名的域分隔前缀
+const proofSigPrefix = "bcc-pot-proof:"
+
+// signingBytes 证明中被签名的内容，不含Sig
+func (p *Proof) signingBytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, binary.BigEndian,
+		[]byte(proofSigPrefix),
+		uint8(len(p.Id)), []byte(p.Id),
+		p.TxsNum,
+		uint16(len(p.BlockHash)), p.BlockHash,
+		uint16(len(p.Base)), p.Base,
+		p.BaseIndex)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign 证明者对证明签名
+func (p *Proof) Sign(key crypto.PrivateKey) error {
+	data, err := p.signingBytes()
+	if err != nil {
+		return err
+	}
+	p.Sig, err = key.Sign(data)
+	return err
+}
+
+// Verify 使用证明者(Id)的公钥验证证明的签名
+func (p *Proof) Verify(pub crypto.PublicKey) error {
+	if pub == nil {
+		return errors.New("nil public key")
+	}
+	if err := identity.MatchPublicKey(p.Id, pub); err != nil {
+		return fmt.Errorf("invalid proof Id: %w", err)
+	}
+	data, err := p.signingBytes()
+	if err != nil {
+		return err
+	}
+	if !pub.Verify(data, p.Sig) {
+		return crypto.ErrVerifySigFail
+	}
+	return nil
+}
+
 // GreaterThan 两个证明间的比较
 // 调用前确保p与ap的base一致
 func (p *Proof) GreaterThan(ap *Proof) bool {
@@ -78,6 +134,18 @@ func (p *Proof) GreaterThan(ap *Proof) bool {
 	}
 }
 
+// Equal 比较两个证明的内容，不比较签名
+func (p *Proof) Equal(ap *Proof) bool {
+	if p == nil || ap == nil {
+		return p == ap
+	}
+	return p.Id == ap.Id &&
+		p.TxsNum == ap.TxsNum &&
+		bytes.Equal(p.BlockHash, ap.BlockHash) &&
+		p.BaseIndex == ap.BaseIndex &&
+		bytes.Equal(p.Base, ap.Base)
+}
+
 // Match 检查block和proof是否匹配
 func (p *Proof) Match(block *defines.Block) bool {
 	return p.Id == block.Maker &&
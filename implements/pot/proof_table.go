@@ -26,7 +26,9 @@ type proofTable struct {
 	sync.RWMutex                   // 保护table
 	winner       *Proof            // 胜者
 
-	relayed map[string]int // seed转发的winnerproof的投票数
+	relayers map[string]map[string]struct{} // <证明者id, 转发其证明的seed集合>，每个seed每轮只计一票，Reset时清空
+
+	banned map[string]struct{} // 已被证实作恶的节点，其证明不再计入，Reset时不清空
 	// relayedWinner *Proof		 	// seed公认的winner，众数比较
 
 	// 当PotStart时决定新区块时，需要依赖relayedWinner, 如果没有relayed，则相信自己的winner
@@ -39,10 +41,12 @@ type proofTable struct {
 }
 
 // Add 添加
-func (proofs *proofTable) Add(p *Proof) {
+// 每轮每个节点只保留第一份证明。
+// 同一节点在同一BaseIndex上又发来内容不同的证明时，不予采纳，并返回其一证多投(equivocation)的证据
+func (proofs *proofTable) Add(p *Proof) *Evidence {
 
-	if p == nil {
-		return
+	if p == nil || proofs.IsBanned(p.Id) {
+		return nil
 	}
 
 	//if proofs.HasLatestBlockNow {
@@ -53,34 +57,155 @@ func (proofs *proofTable) Add(p *Proof) {
 
 
 	proofs.Lock()
+	defer proofs.Unlock()
+
+	old := proofs.table[p.Id]
+	if old != nil {
+		switch {
+		case old.BaseIndex > p.BaseIndex: // 过时的证明
+			return nil
+		case old.BaseIndex == p.BaseIndex:
+			if old.Equal(p) { // 重复收到(例如种子转发)
+				return nil
+			}
+			return &Evidence{Type: EvidenceType_Equivocation, Proof: old, Conflict: p}
+		}
+	}
 	proofs.table[p.Id] = p
-	proofs.Unlock()
 
-	if p.GreaterThan(proofs.winner) {
+	if old != nil && old == proofs.winner { // 旧证明被更新一轮的证明替换
+		proofs.refreshWinner()
+	} else if p.GreaterThan(proofs.winner) {
 		proofs.winner = p
 	}
+	return nil
+}
+
+// AddProofRelayedBySeed 添加seed转发的proof，调用方须确认seed确是种子节点
+// 只有与本地所存一致的证明才计票，同一seed重复转发只计一票
+func (proofs *proofTable) AddProofRelayedBySeed(seed string, relayed *Proof) *Evidence {
+	if relayed == nil || proofs.IsBanned(relayed.Id) {
+		return nil
+	}
+	if ev := proofs.Add(relayed); ev != nil {
+		return ev
+	}
+	proofs.Lock()
+	if proofs.table[relayed.Id].Equal(relayed) {
+		seeds := proofs.relayers[relayed.Id]
+		if seeds == nil {
+			seeds = make(map[string]struct{})
+			proofs.relayers[relayed.Id] = seeds
+		}
+		seeds[seed] = struct{}{}
+	}
+	proofs.Unlock()
+	return nil
+}
+
+// votes 转发了id的证明的seed数
+func (proofs *proofTable) votes(id string) int {
+	proofs.RLock()
+	defer proofs.RUnlock()
+	return len(proofs.relayers[id])
+}
+
+// refreshWinner 遍历证明表重新确定winner，调用方需持有锁
+func (proofs *proofTable) refreshWinner() {
+	proofs.winner = nil
+	for _, p := range proofs.table {
+		if p.GreaterThan(proofs.winner) {
+			proofs.winner = p
+		}
+	}
+}
+
+// Ban 将作恶节点id加入黑名单
+// 此后其证明不再计入，本轮已收到的证明也一并移除
+func (proofs *proofTable) Ban(id string) {
+	proofs.Lock()
+	defer proofs.Unlock()
+
+	proofs.banned[id] = struct{}{}
+	delete(proofs.table, id)
+	delete(proofs.relayers, id)
+
+	if proofs.winner != nil && proofs.winner.Id == id {
+		proofs.refreshWinner()
+	}
+	if proofs.Judged != nil && proofs.Judged.Id == id {
+		proofs.Judged = proofs.winner
+	}
+	if proofs.Decided != nil && proofs.Decided.Id == id {
+		proofs.Decided = nil
+	}
+}
+
+// IsBanned 查询id是否已被列入黑名单
+func (proofs *proofTable) IsBanned(id string) bool {
+	proofs.RLock()
+	_, ok := proofs.banned[id]
+	proofs.RUnlock()
+	return ok
 }
 
-// AddProofRelayedBySeed 添加seed转发的proof
-func (proofs *proofTable) AddProofRelayedBySeed(relayed *Proof) {
-	proofs.Add(relayed)
-	proofs.relayed[relayed.Id]++
+// snapshot 保存共识状态用的快照：本轮决定的胜者、竞争基于的区块序号、各证明的转发者及被投票的证明
+func (proofs *proofTable) snapshot() (decided *Proof, baseIndex int64, relayers map[string][]string, voted []*Proof) {
+	proofs.RLock()
+	defer proofs.RUnlock()
+	relayers = make(map[string][]string, len(proofs.relayers))
+	voted = make([]*Proof, 0, len(proofs.relayers))
+	for id, seeds := range proofs.relayers {
+		for seed := range seeds {
+			relayers[id] = append(relayers[id], seed)
+		}
+		if proof := proofs.table[id]; proof != nil {
+			voted = append(voted, proof)
+		}
+	}
+	return proofs.Decided, proofs.baseIndex, relayers, voted
+}
+
+// RestoreRelayed 恢复重启前保存的投票，被投票的证明一并放回证明表
+// 只能在本轮竞争开始前调用，已被禁止的节点的投票会被忽略
+func (proofs *proofTable) RestoreRelayed(relayers map[string][]string, voted []*Proof) {
+	proofs.Lock()
+	defer proofs.Unlock()
+	for _, proof := range voted {
+		if _, ok := proofs.banned[proof.Id]; ok || proof.BaseIndex != proofs.baseIndex {
+			continue
+		}
+		proofs.table[proof.Id] = proof
+		seeds := make(map[string]struct{}, len(relayers[proof.Id]))
+		for _, seed := range relayers[proof.Id] {
+			seeds[seed] = struct{}{}
+		}
+		proofs.relayers[proof.Id] = seeds
+	}
+}
+
+// Ids 本轮发出过证明的节点
+func (proofs *proofTable) Ids() []string {
+	proofs.RLock()
+	defer proofs.RUnlock()
+	ids := make([]string, 0, len(proofs.table))
+	for id := range proofs.table {
+		ids = append(ids, id)
+	}
+	return ids
 }
 
 // JudgeWinner 获胜者的proof
 // 必须在PotOver时调用
 // Judge是自己判定的
 func (proofs *proofTable) JudgeWinner(moment Moment) *Proof {
+	proofs.Lock()
+	defer proofs.Unlock()
 	if moment.Type == MomentType_PotOver && moment.Time.After(proofs.start.Time) {
 
 		proofs.end = moment
 
 		proofs.Judged = proofs.winner
-
-		if proofs.Judged != nil {
-			fmt.Printf("JudgeWinner: winner(%s)(%d, %x)\n", proofs.Judged.Id, proofs.Judged.TxsNum, proofs.Judged.BlockHash)
-		}
-
 		return proofs.Judged
 	}
 	return nil
@@ -91,21 +216,21 @@ func (proofs *proofTable) JudgeWinner(moment Moment) *Proof {
 // Decide 是在收到种子们的决定之后再综合决定新区块是哪个
 // 调用完Decide之后必须检查decided block
 func (proofs *proofTable) DecideWinner(moment Moment) *Proof {
+	proofs.Lock()
+	defer proofs.Unlock()
 	// 是PotStart时刻并且比本轮开始时的PotStart大
 	if moment.Type == MomentType_PotStart && moment.Time.After(proofs.start.Time) {
 		// 确定出seed承认的winner
 		var seedRelayWinnerProof *Proof
 		var seedRelayWinner string
 		max := 0
-		for id, count := range proofs.relayed {
-			if count > max {
+		for id, seeds := range proofs.relayers {
+			if count := len(seeds); count > max {
 				max = count
 				seedRelayWinner = id
 			}
 		}
-		proofs.RLock()
 		seedRelayWinnerProof = proofs.table[seedRelayWinner]
-		proofs.RUnlock()
 
 		// 确定自己承认的winner
 		selfJudgeWinnerProof := proofs.Judged
@@ -124,6 +249,8 @@ func (proofs *proofTable) DecideWinner(moment Moment) *Proof {
 // Reset 重置
 // 传入的latestBlock应该是bc的最新区块
 func (proofs *proofTable) Reset(moment Moment, latestBlock *defines.Block) {
+	proofs.Lock()
+	defer proofs.Unlock()
 
 	if !moment.Time.After(proofs.end.Time) {
 		return
@@ -153,9 +280,9 @@ func (proofs *proofTable) Reset(moment Moment, latestBlock *defines.Block) {
 	proofs.winner = nil
 	proofs.Decided = nil
 	proofs.Judged = nil
-	proofs.Lock()
 	proofs.table = map[string]*Proof{}
-	proofs.Unlock()
+	// 投票只对本轮的证明有效
+	proofs.relayers = map[string]map[string]struct{}{}
 }
 
 func (proofs *proofTable) Display() string {
@@ -176,12 +303,21 @@ func (proofs *proofTable) Display() string {
 	return str
 }
 
+// setBase 设置本轮竞争基于的区块，只能在竞争开始前调用
+func (proofs *proofTable) setBase(latestBlockIndex int64, latestBlockHash []byte) {
+	proofs.Lock()
+	defer proofs.Unlock()
+	proofs.baseIndex = latestBlockIndex
+	proofs.base = latestBlockHash
+}
+
 // newProofTable 使用最新的区块去创建证明表
 func newProofTable(latestBlockIndex int64, latestBlockHash []byte) *proofTable {
 	return &proofTable{
 		baseIndex: latestBlockIndex,
 		base:      latestBlockHash,
 		table:     map[string]*Proof{},
-		relayed:   map[string]int{},
+		relayers:  map[string]map[string]struct{}{},
+		banned:    map[string]struct{}{},
 	}
 }
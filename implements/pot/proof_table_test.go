@@ -0,0 +1,194 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/30/20 4:10 PM
+* @Description: 证明表测试
+***********************************************************************/
+
+package pot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+)
+
+func TestProofTable_Equivocation(t *testing.T) {
+	peerKey, peer := newTestKey(t)
+	fakeKey, _ := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+
+	p := newTestPot(t, selfKey, &defines.PeerInfo{Id: peer, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+		PubKey: crypto.MarshalPublicKey(peerKey.Public())})
+
+	newProof := func(txsNum int64, baseIndex int64, key crypto.PrivateKey) *Proof {
+		proof := &Proof{
+			Id:        peer,
+			TxsNum:    txsNum,
+			BlockHash: []byte{byte(txsNum)},
+			Base:      []byte("base"),
+			BaseIndex: baseIndex,
+		}
+		if err := proof.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		return proof
+	}
+
+	first := newProof(1, 1, peerKey)
+	if ev := p.proofs.Add(first); ev != nil {
+		t.Fatalf("unexpected evidence: %s", ev.Short())
+	}
+
+	// 重复收到同一证明(包括种子转发)不算作恶
+	dup := *first
+	if ev := p.proofs.Add(&dup); ev != nil {
+		t.Errorf("duplicate proof reported as equivocation: %s", ev.Short())
+	}
+	if ev := p.proofs.AddProofRelayedBySeed("seed01", &dup); ev != nil {
+		t.Errorf("relayed proof reported as equivocation: %s", ev.Short())
+	}
+	if p.proofs.votes(peer) != 1 {
+		t.Errorf("votes(peer) = %d, want 1", p.proofs.votes(peer))
+	}
+
+	// 过时的证明被忽略
+	if ev := p.proofs.Add(newProof(9, 0, peerKey)); ev != nil {
+		t.Errorf("stale proof reported as equivocation: %s", ev.Short())
+	}
+	if p.proofs.table[peer] != first {
+		t.Error("stale proof should not replace the first one")
+	}
+
+	// 同一轮的矛盾证明
+	conflict := newProof(5, 1, peerKey)
+	ev := p.proofs.Add(conflict)
+	if ev == nil {
+		t.Fatal("equivocation not detected")
+	}
+	if p.proofs.table[peer] != first || p.proofs.winner != first {
+		t.Error("the first proof should be kept")
+	}
+	if ev.Type != EvidenceType_Equivocation || ev.Offender() != peer {
+		t.Errorf("evidence = %s", ev.Short())
+	}
+	if ev := p.proofs.AddProofRelayedBySeed("seed01", conflict); ev == nil {
+		t.Error("equivocation relayed by seed not detected")
+	}
+
+	// 证据可以在其他节点上独立验证
+	data, err := ev.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(Evidence)
+	if err := decoded.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Verify(p.pubKeyOf); err != nil {
+		t.Errorf("equivocation evidence rejected: %s", err)
+	}
+
+	// 无效的证据
+	invalid := []struct {
+		name     string
+		conflict *Proof
+		wantErr  error
+	}{
+		{"forged conflict", newProof(5, 1, fakeKey), crypto.ErrVerifySigFail},
+		{"different round", newProof(5, 2, peerKey), ErrEvidenceUnrelated},
+		{"same content", newProof(1, 1, peerKey), ErrEvidenceNoConflict},
+		{"missing conflict", nil, ErrEvidenceIncomplete},
+	}
+	for _, tt := range invalid {
+		ev := &Evidence{Type: EvidenceType_Equivocation, Proof: first, Conflict: tt.conflict}
+		if err := ev.Verify(p.pubKeyOf); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// 新一轮的证明替换上一轮的
+	next := newProof(2, 2, peerKey)
+	if ev := p.proofs.Add(next); ev != nil {
+		t.Errorf("proof of next round reported as equivocation: %s", ev.Short())
+	}
+	if p.proofs.table[peer] != next || p.proofs.winner != next {
+		t.Error("proof of next round should replace the old one")
+	}
+
+	if err := p.punish(ev); err != nil {
+		t.Fatal(err)
+	}
+	if !p.proofs.IsBanned(peer) {
+		t.Error("equivocating peer not banned")
+	}
+}
+
+func TestProofTable_ResetClearsRelayed(t *testing.T) {
+	peerKey, peer := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+	p := newTestPot(t, selfKey)
+
+	proof := &Proof{Id: peer, TxsNum: 1, BlockHash: []byte("b"), Base: []byte("base"), BaseIndex: 1}
+	if err := proof.Sign(peerKey); err != nil {
+		t.Fatal(err)
+	}
+	p.proofs.AddProofRelayedBySeed("seed01", proof)
+	if p.proofs.votes(peer) != 1 {
+		t.Fatalf("votes(peer) = %d, want 1", p.proofs.votes(peer))
+	}
+	p.proofs.Reset(Moment{Type: MomentType_PotStart, Time: time.Now()}, nil)
+	if len(p.proofs.relayers) != 0 {
+		t.Errorf("relayed votes survive Reset: %v", p.proofs.relayers)
+	}
+}
+
+// 只有种子的转发计票，同一种子重复转发只计一票
+func TestPot_HandleEntryRelayedProof(t *testing.T) {
+	proverKey, prover := newTestKey(t)
+	seedKey, seed := newTestKey(t)
+	relayKey, relay := newTestKey(t)
+	selfKey, _ := newTestKey(t)
+	p := newTestPot(t, selfKey,
+		&defines.PeerInfo{Id: prover, Addr: "127.0.0.1:8001", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(proverKey.Public())},
+		&defines.PeerInfo{Id: seed, Addr: "127.0.0.1:8002", Duty: defines.PeerDuty_Seed,
+			PubKey: crypto.MarshalPublicKey(seedKey.Public())},
+		&defines.PeerInfo{Id: relay, Addr: "127.0.0.1:8003", Duty: defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(relayKey.Public())})
+
+	process := p.processes.get(p.id)
+	proof := &Proof{Id: prover, TxsNum: 1, BlockHash: []byte("b"), Base: process.Hash, BaseIndex: process.Index}
+	if err := proof.Sign(proverKey); err != nil {
+		t.Fatal(err)
+	}
+	data, err := proof.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ent := &defines.Entry{BaseIndex: process.Index, Base: process.Hash, Type: defines.EntryType_Proof, Data: data}
+
+	// 普通节点重放别人的证明不算投票，也不能当作证明者本人的证明
+	for i := 0; i < 3; i++ {
+		if err := p.handleEntryRelayedProof(relay, ent); !errors.Is(err, ErrRelayFromNonSeed) {
+			t.Fatalf("err = %v, want ErrRelayFromNonSeed", err)
+		}
+	}
+	if err := p.handleEntryProof(relay, ent); !errors.Is(err, ErrProofNotFromProver) {
+		t.Errorf("err = %v, want ErrProofNotFromProver", err)
+	}
+	if p.proofs.votes(prover) != 0 {
+		t.Errorf("votes = %d after non-seed relays, want 0", p.proofs.votes(prover))
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.handleEntryRelayedProof(seed, ent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.proofs.votes(prover) != 1 {
+		t.Errorf("votes = %d after the same seed relayed twice, want 1", p.proofs.votes(prover))
+	}
+}
@@ -0,0 +1,180 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/24/20 9:30 AM
+* @Description: 请求跟踪，将响应对应到发出的请求
+***********************************************************************/
+
+package pot
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+)
+
+/*
+	启动阶段过去按收到的消息条数计数，响应不带请求编号，上一轮请求迟到的回复(例如请求1号区块时慢了一步的rsp-blocks)
+	会被当作当前请求的回复计入。
+
+	现在每个请求在发出前登记，分配一个Request.Id，响应方在Message.ReqId中原样带回。
+	只有编号、响应方、请求类型都对得上的第一条响应会被接受，重复的、迟到的(已超时)、不请自来的响应都会被拒绝。
+	每个请求有自己的截止时间，调用方通过对应类型的future等待某个节点对某个请求的回复。
+*/
+
+// pendingRequest 一个等待响应的请求
+type pendingRequest struct {
+	id       uint64
+	to       string
+	typ      defines.RequestType
+	deadline time.Time
+
+	t    *requestTracker
+	done chan struct{} // 得到结果(响应、超时、取消)后关闭
+	rsp  interface{}
+	err  error
+}
+
+// To 请求发往的节点
+func (r *pendingRequest) To() string {
+	return r.to
+}
+
+// wait 等到响应或截止时间
+func (r *pendingRequest) wait() (interface{}, error) {
+	timer := time.NewTimer(time.Until(r.deadline))
+	defer timer.Stop()
+	select {
+	case <-r.done:
+	case <-timer.C:
+		r.t.finish(r, nil, ErrRequestTimeout)
+	case <-r.t.done:
+		return nil, ErrRequestClosed
+	}
+	<-r.done
+	return r.rsp, r.err
+}
+
+// blockFuture 单个区块的请求
+type blockFuture struct {
+	*pendingRequest
+}
+
+func (f blockFuture) Wait() (*defines.Block, error) {
+	v, err := f.wait()
+	if err != nil {
+		return nil, err
+	}
+	return v.(*defines.Block), nil
+}
+
+// neighborsFuture 邻居表的请求
+type neighborsFuture struct {
+	*pendingRequest
+}
+
+func (f neighborsFuture) Wait() ([]*defines.PeerInfo, error) {
+	v, err := f.wait()
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*defines.PeerInfo), nil
+}
+
+// requestTracker 登记发出的请求，等待对应的响应
+type requestTracker struct {
+	next    uint64 // 上一个分配的请求编号，原子操作
+	lock    sync.Mutex
+	pending map[uint64]*pendingRequest
+	done    <-chan struct{}
+}
+
+// newRequestTracker done关闭后所有等待立即返回ErrRequestClosed
+func newRequestTracker(done <-chan struct{}) *requestTracker {
+	return &requestTracker{
+		// 以启动时间作为编号起点，避免重启前发出的请求的迟到响应撞上新请求的编号
+		next:    uint64(time.Now().UnixNano()),
+		pending: make(map[uint64]*pendingRequest),
+		done:    done,
+	}
+}
+
+// register 登记一个发往to的请求，timeout后仍未收到响应则视为超时
+// 返回的请求的id需要写入Request.Id后再发送
+func (t *requestTracker) register(to string, typ defines.RequestType, timeout time.Duration) *pendingRequest {
+	id := atomic.AddUint64(&t.next, 1)
+	if id == 0 { // 0表示不需要对应
+		id = atomic.AddUint64(&t.next, 1)
+	}
+	r := &pendingRequest{
+		id:       id,
+		to:       to,
+		typ:      typ,
+		deadline: time.Now().Add(timeout),
+		t:        t,
+		done:     make(chan struct{}),
+	}
+	t.lock.Lock()
+	t.pending[id] = r
+	t.lock.Unlock()
+	return r
+}
+
+// cancel 请求没能发出时撤销登记
+func (t *requestTracker) cancel(r *pendingRequest, err error) {
+	t.finish(r, nil, err)
+}
+
+// resolve 把from对id的typ类响应交给等待方
+// 只有第一条对得上的响应会被接受，返回false说明是重复、迟到或不请自来的响应
+func (t *requestTracker) resolve(from string, id uint64, typ defines.RequestType, rsp interface{}) bool {
+	if id == 0 {
+		return false
+	}
+	t.lock.Lock()
+	r, ok := t.pending[id]
+	t.lock.Unlock()
+	if !ok || r.to != from || r.typ != typ {
+		return false
+	}
+	return t.finish(r, rsp, nil)
+}
+
+// finish 结束请求，已经结束的返回false
+func (t *requestTracker) finish(r *pendingRequest, rsp interface{}, err error) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.pending[r.id]; !ok {
+		return false
+	}
+	delete(t.pending, r.id)
+	r.rsp, r.err = rsp, err
+	close(r.done)
+	return true
+}
+
+// expire 以ErrRequestTimeout结束截止时间早于now的请求，返回结束的个数
+// 没有调用方wait的请求(例如只等到部分回应就不再等待的)靠它清理
+func (t *requestTracker) expire(now time.Time) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	n := 0
+	for id, r := range t.pending {
+		if now.Before(r.deadline) {
+			continue
+		}
+		delete(t.pending, id)
+		r.err = ErrRequestTimeout
+		close(r.done)
+		n++
+	}
+	return n
+}
+
+// len 等待中的请求数
+func (t *requestTracker) len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.pending)
+}
@@ -0,0 +1,95 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/24/20 11:00 AM
+* @Description: 请求跟踪测试
+***********************************************************************/
+
+package pot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+)
+
+func Test_requestTracker(t *testing.T) {
+	done := make(chan struct{})
+	tr := newRequestTracker(done)
+
+	seed1, seed2 := "seed01", "seed02"
+	first := blockFuture{tr.register(seed2, defines.RequestType_Blocks, time.Second)}
+	latest := blockFuture{tr.register(seed2, defines.RequestType_Blocks, time.Second)}
+	if first.id == latest.id || first.id == 0 {
+		t.Fatalf("ids = %d, %d", first.id, latest.id)
+	}
+
+	b1 := &defines.Block{Index: 1}
+	b9 := &defines.Block{Index: 9}
+	// 不是发给seed01的请求；类型不对；没有编号
+	if tr.resolve(seed1, latest.id, defines.RequestType_Blocks, b9) {
+		t.Error("resolved by wrong peer")
+	}
+	if tr.resolve(seed2, latest.id, defines.RequestType_Neighbors, []*defines.PeerInfo{}) {
+		t.Error("resolved by wrong type")
+	}
+	if tr.resolve(seed2, 0, defines.RequestType_Blocks, b9) {
+		t.Error("resolved without id")
+	}
+
+	if !tr.resolve(seed2, first.id, defines.RequestType_Blocks, b1) {
+		t.Fatal("resolve first fail")
+	}
+	// 重复的回应
+	if tr.resolve(seed2, first.id, defines.RequestType_Blocks, b9) {
+		t.Error("duplicate response accepted")
+	}
+	if !tr.resolve(seed2, latest.id, defines.RequestType_Blocks, b9) {
+		t.Fatal("resolve latest fail")
+	}
+	if b, err := first.Wait(); err != nil || b != b1 {
+		t.Errorf("first = %v, %v", b, err)
+	}
+	if b, err := latest.Wait(); err != nil || b != b9 {
+		t.Errorf("latest = %v, %v", b, err)
+	}
+
+	// 超时后迟到的回应被拒绝
+	slow := neighborsFuture{tr.register(seed1, defines.RequestType_Neighbors, 20*time.Millisecond)}
+	if _, err := slow.Wait(); !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("err = %v, want ErrRequestTimeout", err)
+	}
+	if tr.resolve(seed1, slow.id, defines.RequestType_Neighbors, []*defines.PeerInfo{}) {
+		t.Error("late response accepted")
+	}
+
+	// 没发出去的请求
+	failed := tr.register(seed1, defines.RequestType_Blocks, time.Second)
+	sendErr := errors.New("send fail")
+	tr.cancel(failed, sendErr)
+	if _, err := (blockFuture{failed}).Wait(); err != sendErr {
+		t.Errorf("err = %v, want %v", err, sendErr)
+	}
+	if tr.len() != 0 {
+		t.Errorf("len = %d, want 0", tr.len())
+	}
+
+	// 无人等待的请求在截止时间后由expire清理
+	stale := tr.register(seed1, defines.RequestType_Blocks, 10*time.Millisecond)
+	fresh := tr.register(seed2, defines.RequestType_Blocks, time.Minute)
+	if n := tr.expire(time.Now().Add(time.Second)); n != 1 || tr.len() != 1 {
+		t.Errorf("expired %d, len = %d, want 1, 1", n, tr.len())
+	}
+	if _, err := (blockFuture{stale}).Wait(); !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("err = %v, want ErrRequestTimeout", err)
+	}
+	tr.cancel(fresh, sendErr)
+
+	// 关闭后等待立即返回
+	pending := blockFuture{tr.register(seed1, defines.RequestType_Blocks, time.Minute)}
+	close(done)
+	if _, err := pending.Wait(); !errors.Is(err, ErrRequestClosed) {
+		t.Errorf("err = %v, want ErrRequestClosed", err)
+	}
+}
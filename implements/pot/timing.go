@@ -0,0 +1,155 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/17/20 2:10 PM
+* @Description: 每轮竞争的时间划分
+***********************************************************************/
+
+package pot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+)
+
+/*
+	一轮(两个区块之间)长2*TickMs，被PotStart/PotOver两个时刻分成两段：
+		PotStart --CompeteMs--> PotOver --(2*TickMs-CompeteMs)--> PotStart
+	前一段用于收集交易和证明(竞争)，后一段用于出块、广播和等待胜者区块。
+
+	整个集群必须使用相同的时间划分，否则各节点的时钟对不上。
+	因此时间划分由创建创世区块的种子写入创世区块的Description，其他节点启动时以创世区块中的为准，
+	本地配置只在创建创世区块时生效。
+*/
+
+// Timing 时间划分
+type Timing struct {
+	TickMs    int64 `json:"tick_ms"`    // 滴答长度，一轮为两个滴答
+	CompeteMs int64 `json:"compete_ms"` // PotStart到PotOver的时长，为0则等于TickMs
+}
+
+// DefaultTiming 默认时间划分：每轮1s，竞争与出块各占一半
+var DefaultTiming = Timing{TickMs: DefaultTickMs, CompeteMs: DefaultTickMs}
+
+var ErrInvalidTiming = errors.New("invalid timing")
+
+// normalize 补全缺省值并检查
+// 全零视为未设置，使用DefaultTiming
+func (t Timing) normalize() (Timing, error) {
+	if t == (Timing{}) {
+		return DefaultTiming, nil
+	}
+	if t.CompeteMs == 0 {
+		t.CompeteMs = t.TickMs
+	}
+	if t.TickMs <= 0 || t.CompeteMs <= 0 || t.CompeteMs >= 2*t.TickMs {
+		return t, fmt.Errorf("%w: tick_ms=%d, compete_ms=%d", ErrInvalidTiming, t.TickMs, t.CompeteMs)
+	}
+	return t, nil
+}
+
+func (t Timing) tick() time.Duration {
+	return time.Duration(t.TickMs) * time.Millisecond
+}
+
+// round 一轮的时长
+func (t Timing) round() time.Duration {
+	return 2 * t.tick()
+}
+
+// compete PotStart到PotOver
+func (t Timing) compete() time.Duration {
+	return time.Duration(t.CompeteMs) * time.Millisecond
+}
+
+// decide PotOver到下一个PotStart
+func (t Timing) decide() time.Duration {
+	return t.round() - t.compete()
+}
+
+// maxBlockClockDrift 允许区块时间戳超前于本地时钟的最大值，容忍节点间的时钟偏差
+func (t Timing) maxBlockClockDrift() time.Duration {
+	return t.tick()
+}
+
+// maxNewBlockAge 新区块在本轮被确定时距其出块时间的最大值
+// 区块在PotOver出块，下一个PotStart被确定，这里放宽到两轮
+func (t Timing) maxNewBlockAge() time.Duration {
+	return 2 * t.round()
+}
+
+// fetchBlockTimeout 补取胜者区块的等待时长，见pot_fetch.go
+func (t Timing) fetchBlockTimeout() time.Duration {
+	return t.compete() / 2
+}
+
+// emptyRoundTimeout 种子补取胜者区块的等待时长，超时则宣布空轮
+// 只有fetchBlockTimeout的一半，留出空轮标记送达普通节点的时间，见empty_round.go
+func (t Timing) emptyRoundTimeout() time.Duration {
+	return t.fetchBlockTimeout() / 2
+}
+
+func (t Timing) String() string {
+	return fmt.Sprintf("timing{tick: %dms, compete: %dms}", t.TickMs, t.CompeteMs)
+}
+
+// genesisTiming 解析创世区块中记录的时间划分
+func genesisTiming(genesis *defines.Block) (Timing, error) {
+	var t Timing
+	if err := json.Unmarshal([]byte(genesis.Description), &t); err != nil {
+		return t, fmt.Errorf("%w: genesis description: %s", ErrInvalidTiming, err)
+	}
+	if t == (Timing{}) {
+		return t, fmt.Errorf("%w: no timing in genesis", ErrInvalidTiming)
+	}
+	return t.normalize()
+}
+
+// createGenesis 创建记录了本地时间划分的创世区块并加入区块链
+func (p *Pot) createGenesis() (*defines.Block, error) {
+	desc, err := json.Marshal(p.getTiming())
+	if err != nil {
+		return nil, err
+	}
+	genesis, err := defines.NewBlockAndSign(1, p.id, nil, nil, string(desc), p.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.bc.AddNewBlock(genesis); err != nil {
+		return nil, err
+	}
+	return genesis, nil
+}
+
+// adoptGenesisTiming 采用创世区块中的时间划分，必须在时钟启动前调用
+// 创世区块没有记录时间划分时沿用本地配置
+func (p *Pot) adoptGenesisTiming(genesis *defines.Block) {
+	t, err := genesisTiming(genesis)
+	if err != nil {
+		p.Warnf("keep local %s: %s", p.getTiming(), err)
+		return
+	}
+	if t != p.getTiming() {
+		p.Warnf("local %s differs from genesis, use %s", p.getTiming(), t)
+	}
+	p.timing.Store(t)
+	p.clock.timing = t
+}
+
+// getTiming 当前使用的时间划分
+func (p *Pot) getTiming() Timing {
+	return p.timing.Load().(Timing)
+}
+
+// adoptLocalGenesisTiming 重启时采用本地1号区块中的时间划分
+func (p *Pot) adoptLocalGenesisTiming() {
+	blocks, err := p.bc.GetBlocksByRange(1, 1)
+	if err != nil || len(blocks) == 0 || blocks[0] == nil {
+		p.Warnf("keep local %s: local genesis unavailable(%v)", p.getTiming(), err)
+		return
+	}
+	p.adoptGenesisTiming(blocks[0])
+}
@@ -0,0 +1,98 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/17/20 4:30 PM
+* @Description: 时间划分测试
+***********************************************************************/
+
+package pot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+)
+
+func TestTiming_normalize(t *testing.T) {
+	tests := []struct {
+		in, want Timing
+		wantErr  error
+	}{
+		{Timing{}, DefaultTiming, nil},
+		{Timing{TickMs: 50}, Timing{TickMs: 50, CompeteMs: 50}, nil},
+		{Timing{TickMs: 1500, CompeteMs: 2000}, Timing{TickMs: 1500, CompeteMs: 2000}, nil},
+		{Timing{TickMs: 50, CompeteMs: 100}, Timing{}, ErrInvalidTiming},
+		{Timing{CompeteMs: 100}, Timing{}, ErrInvalidTiming},
+		{Timing{TickMs: -1}, Timing{}, ErrInvalidTiming},
+	}
+	for _, tt := range tests {
+		got, err := tt.in.normalize()
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v.normalize() err = %v, want %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%v.normalize() = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPot_GenesisTiming(t *testing.T) {
+	seedKey, _ := newTestKey(t)
+	peerKey, _ := newTestKey(t)
+	timing := Timing{TickMs: 50, CompeteMs: 30}
+
+	seed := newTestPotWithOption(t, seedKey, &Option{Timing: timing})
+	genesis, err := seed.createGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seed.bc.GetMaxIndex() != 1 {
+		t.Errorf("genesis not added, max index = %d", seed.bc.GetMaxIndex())
+	}
+
+	// 其他节点以创世区块为准
+	peer := newTestPot(t, peerKey)
+	peer.adoptGenesisTiming(genesis)
+	if peer.getTiming() != timing || peer.clock.timing != timing {
+		t.Errorf("peer timing = %v, clock timing = %v, want %v", peer.getTiming(), peer.clock.timing, timing)
+	}
+
+	// 没有记录时间划分的创世区块不改变本地配置
+	legacy, err := defines.NewBlockAndSign(1, seed.id, nil, nil, "block from seed", seedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer = newTestPot(t, peerKey)
+	peer.adoptGenesisTiming(legacy)
+	if peer.getTiming() != DefaultTiming {
+		t.Errorf("peer timing = %v, want %v", peer.getTiming(), DefaultTiming)
+	}
+}
+
+func TestClock_Timing(t *testing.T) {
+	timing := Timing{TickMs: 50, CompeteMs: 30}
+	c := NewClock(false, timing)
+	defer c.Close()
+	c.Start(&defines.Block{Index: 1, Timestamp: time.Now().UnixNano()})
+
+	// PotOver与区块时间戳对齐，PotStart在其前30ms
+	var last Moment
+	for i := 0; i < 6; i++ {
+		m := <-c.Tick
+		if i > 0 {
+			want := timing.decide()
+			if m.Type == MomentType_PotOver {
+				want = timing.compete()
+			}
+			if m.Type == last.Type {
+				t.Fatalf("got %s twice", m.Type)
+			}
+			if d := m.Time.Sub(last.Time) - want; d < -10*time.Millisecond || d > 10*time.Millisecond {
+				t.Errorf("%s -> %s took %s, want %s", last.Type, m.Type, m.Time.Sub(last.Time), want)
+			}
+		}
+		last = m
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"sort"
 
 	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
 // newUndecidedBlockTable 新建未决区块表
@@ -116,7 +117,7 @@ func (udbt *undecidedBlockTable) Display() string {
 		return ubs[i].count > ubs[j].count
 	})
 	for i:=0; i<len(ubs); i++ {
-		substr := fmt.Sprintf("%s(%s,%d) ", ubs[i].b.ShortName(), ubs[i].b.Maker, ubs[i].count)
+		substr := fmt.Sprintf("%s(%s,%d) ", ubs[i].b.ShortName(), identity.Short(ubs[i].b.Maker), ubs[i].count)
 		str += substr
 	}
 	str += "}"
@@ -7,6 +7,8 @@
 package pot
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"strconv"
@@ -15,6 +17,8 @@ import (
 	"github.com/azd1997/blockchain-consensus/defines"
 	_default "github.com/azd1997/blockchain-consensus/requires/default"
 	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 	"github.com/azd1997/blockchain-consensus/utils/log"
 )
 
@@ -43,38 +47,38 @@ func StartCluster(nSeed int, nPeer int, debug bool, addCaller bool, enableClient
 	}
 
 
-	for _, idaddr := range seeds {
-		id, addr := idaddr[0], idaddr[1]
-		node, err := StartNode(id, addr, seedsm, peersm, debug, addCaller)
+	for _, nameaddr := range seeds {
+		name, addr := nameaddr[0], nameaddr[1]
+		node, err := StartNode(name, addr, seedsm, peersm, debug, addCaller)
 		if err != nil {
 			return nil, err
 		}
-		c.seeds[id] = node
+		c.seeds[name] = node
 	}
 
 	time.Sleep(2 * time.Second)
 
-	for _, idaddr := range peers {
-		id, addr := idaddr[0], idaddr[1]
-		node, err := StartNode(id, addr, seedsm, peersm, debug, addCaller)
+	for _, nameaddr := range peers {
+		name, addr := nameaddr[0], nameaddr[1]
+		node, err := StartNode(name, addr, seedsm, peersm, debug, addCaller)
 		if err != nil {
 			return nil, err
 		}
-		c.peers[id] = node
+		c.peers[name] = node
 	}
 
 	if enableClients {
 		// 创建txmaker
-		for id := range peersm {
-			id := id
+		for name := range peersm {
+			node := c.peers[name]
 			tos := make([]string, 0, len(peers))
 			for to := range peersm {
-				if id != to {
-					tos = append(tos, to)
+				if name != to {
+					tos = append(tos, c.peers[to].id)
 				}
 			}
-			tm := test.NewTxMaker(id, tos, c.peers[id].pot.LocalTxInChan())
-			c.clients[id] = tm
+			tm := test.NewTxMaker(node.id, node.key, tos, node.pot.LocalTxInChan())
+			c.clients[name] = tm
 			go tm.Start()
 		}
 	}
@@ -83,36 +87,54 @@ func StartCluster(nSeed int, nPeer int, debug bool, addCaller bool, enableClient
 	return c, nil
 }
 
-func StartNode(id, addr string, seeds, peers map[string]string, debug bool, addCaller bool) (*Node, error) {
+// StartNode 启动节点
+// name 为节点在实验集群中的名字，如"seed01"，节点ID由名字对应的密钥派生
+// seeds, peers 为 name-addr 信息对
+func StartNode(name, addr string, seeds, peers map[string]string, debug bool, addCaller bool) (*Node, error) {
 
-	logdest := fmt.Sprintf(logDestFormat, id)
+	logdest := fmt.Sprintf(logDestFormat, name)
+
+	key, err := labKey(name)
+	if err != nil {
+		return nil, err
+	}
+	id := identity.FromPublicKey(key.Public())
 
 	// 初始化日志单例
 	log.InitGlobalLogger(id, debug, addCaller, logdest)
 
-	ln, err := _default.ListenTCP(id, addr)
+	pubkeys := labPubKeys(seeds, peers)
+	ln, err := _default.ListenTCP(id, addr, key, labVerifier(pubkeys))
 	if err != nil {
 		return nil, err
 	}
-	d, err := _default.NewDialer(id, addr, 0)
+	d, err := _default.NewDialer(id, addr, 0, key, labVerifier(pubkeys))
 	if err != nil {
 		return nil, err
 	}
 	kv := test.NewStore()
-	bc := test.NewBlockChain(id)
+	bc := test.NewBlockChain(id, key)
 
 	var duty defines.PeerDuty
-	if id[:4] == "seed" {
+	if name[:4] == "seed" {
 		duty = defines.PeerDuty_Seed
-	} else if id[:4] == "peer" {
+	} else if name[:4] == "peer" {
 		duty = defines.PeerDuty_Peer
 	} else {
 		return nil, errors.New("unknown duty")
 	}
 
-	node, err := NewNode(id, duty,
+	seedIds, err := labIds(seeds)
+	if err != nil {
+		return nil, err
+	}
+	peerIds, err := labIds(peers)
+	if err != nil {
+		return nil, err
+	}
+	node, err := NewNode(id, duty, key,
 		ln, d, kv, bc, logdest,
-		seeds, peers)
+		seedIds, peerIds, pubkeys)
 	if err != nil {
 		return nil, err
 	}
@@ -163,3 +185,58 @@ func genIdsAndAddrs(nSeed, nPeer int) ([][2]string, [][2]string, map[string]stri
 
 	return seeds, peers, seedsm, peersm
 }
+
+// labKey 实验集群中由节点名确定性地派生节点私钥，使得各节点无需交换即可得知彼此公钥和ID
+func labKey(name string) (crypto.PrivateKey, error) {
+	signer, err := crypto.GetSigner(crypto.SigScheme_Ed25519)
+	if err != nil {
+		return nil, err
+	}
+	seed := sha256.Sum256([]byte(name))
+	return signer.PrivateKeyFromBytes(seed[:])
+}
+
+// labId 节点名对应的ID
+func labId(name string) (string, error) {
+	key, err := labKey(name)
+	if err != nil {
+		return "", err
+	}
+	return identity.FromPublicKey(key.Public()), nil
+}
+
+// labIds 将 name-addr 信息对转换为 id-addr 信息对
+func labIds(nameaddrs map[string]string) (map[string]string, error) {
+	idaddrs := make(map[string]string, len(nameaddrs))
+	for name, addr := range nameaddrs {
+		id, err := labId(name)
+		if err != nil {
+			return nil, err
+		}
+		idaddrs[id] = addr
+	}
+	return idaddrs, nil
+}
+
+// labPubKeys 预配置节点的公钥表，以id为键
+func labPubKeys(seeds, peers map[string]string) map[string][]byte {
+	pubkeys := make(map[string][]byte)
+	for _, m := range []map[string]string{seeds, peers} {
+		for name := range m {
+			if key, err := labKey(name); err == nil {
+				pubkeys[identity.FromPublicKey(key.Public())] = crypto.MarshalPublicKey(key.Public())
+			}
+		}
+	}
+	return pubkeys
+}
+
+// labVerifier 握手时以预配置的公钥表校验对端，表外的节点ID自证即可接受
+func labVerifier(pubkeys map[string][]byte) _default.PeerVerifier {
+	return func(id string, pubkey []byte) error {
+		if pk, ok := pubkeys[id]; ok && !bytes.Equal(pk, pubkey) {
+			return fmt.Errorf("pubkey of %s mismatches", identity.Short(id))
+		}
+		return nil
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/azd1997/blockchain-consensus/modules/bnet"
 	"github.com/azd1997/blockchain-consensus/modules/peerinfo"
 	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
 )
 
 // 配置内容
@@ -32,7 +33,7 @@ tick_ms = 500
 [raft]
 
 [store]
-engine = "badger"
+engine = "file"
 database = "./data"
 
 [bnet]
@@ -58,6 +59,7 @@ type Node struct {
 	id   string
 	duty defines.PeerDuty
 	addr string
+	key  crypto.PrivateKey
 
 	// kv 存储
 	kv requires.Store
@@ -79,18 +81,20 @@ type Node struct {
 
 // NewNode 构建Node
 func NewNode(
-	id string, duty defines.PeerDuty, // 账户配置
+	id string, duty defines.PeerDuty, key crypto.PrivateKey, // 账户配置
 	ln requires.Listener, dialer requires.Dialer, // 网络配置
 	kv requires.Store, bc requires.BlockChain, // 外部依赖
 	logdest string, // 日志输出路径
 	seeds map[string]string, //预配置的种子节点
 	peers map[string]string, // 预配置的共识节点
+	pubkeys map[string][]byte, // 预配置节点的公钥
 ) (*Node, error) {
 
 	node := &Node{
 		id:   id,
 		duty: duty,
 		addr: ln.LocalListenAddr().String(),
+		key:  key,
 		kv:   kv, // kv是外部准备好的
 		bc:   bc,
 	}
@@ -107,21 +111,35 @@ func NewNode(
 	node.pit = pit
 	// 预配置节点表
 	if err := node.pit.Set(&defines.PeerInfo{
-		Id:   node.id,
-		Addr: node.addr,
-		Attr: 0,
-		Duty: node.duty,
-		Data: nil,
+		Id:     node.id,
+		Addr:   node.addr,
+		Attr:   0,
+		Duty:   node.duty,
+		PubKey: crypto.MarshalPublicKey(key.Public()),
+		Data:   nil,
 	}); err != nil {
 		return nil, err
 	}
 	node.pit.AddPeers(peers)
 	node.pit.AddSeeds(seeds)
+	// 登记预配置节点的公钥
+	for id, pub := range pubkeys {
+		info, err := node.pit.Get(id)
+		if err != nil {
+			continue
+		}
+		ninfo := *info
+		ninfo.PubKey = pub
+		if err := node.pit.Set(&ninfo); err != nil {
+			return nil, err
+		}
+	}
 
 	// 构建共识状态机
 	pm, err := pot.New(&pot.Option{
 		Id:   id,
 		Duty: duty,
+		Key:  key,
 		Pit:  pit,
 		BC:   bc,
 	})
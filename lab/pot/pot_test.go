@@ -10,38 +10,50 @@ import (
 	"github.com/azd1997/blockchain-consensus/defines"
 	_default "github.com/azd1997/blockchain-consensus/requires/default"
 	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 	"github.com/azd1997/blockchain-consensus/utils/log"
 	"testing"
 )
 
 func TestNode_PeerInit(t *testing.T) {
-	id := "peer1"
+	name := "peer1"
 	duty := defines.PeerDuty_Peer
 	addr := "127.0.0.1:7991"
 	logdest := "./pot.log"
 
+	key, err := labKey(name)
+	tError(t, err)
+	id := identity.FromPublicKey(key.Public())
+
 	// 初始化日志单例
 	log.InitGlobalLogger(id, true, true, logdest)
 	defer log.Sync()
 
-	ln, err := _default.ListenTCP(id, addr)
+	seeds := map[string]string{
+		"seed1": "127.0.0.1:8991",
+	}
+	peers := map[string]string{
+		"peer1": "127.0.0.1:7991",
+		"peer2": "127.0.0.1:7992",
+		"peer3": "127.0.0.1:7993",
+	}
+	pubkeys := labPubKeys(seeds, peers)
+
+	ln, err := _default.ListenTCP(id, addr, key, labVerifier(pubkeys))
 	tError(t, err)
-	d, err := _default.NewDialer(id, addr, 0)
+	d, err := _default.NewDialer(id, addr, 0, key, labVerifier(pubkeys))
 	tError(t, err)
 
 	kv := test.NewStore()
-	bc := test.NewBlockChain(id)
+	bc := test.NewBlockChain(id, key)
 
+	seedIds, err := labIds(seeds)
+	tError(t, err)
+	peerIds, err := labIds(peers)
+	tError(t, err)
 	node, err := NewNode(
-		id, duty, ln, d, kv, bc, logdest,
-		map[string]string{
-			"seed1": "127.0.0.1:8991",
-		},
-		map[string]string{
-			"peer1": "127.0.0.1:7991",
-			"peer2": "127.0.0.1:7992",
-			"peer3": "127.0.0.1:7993",
-		})
+		id, duty, key, ln, d, kv, bc, logdest,
+		seedIds, peerIds, pubkeys)
 	tError(t, err)
 
 	err = node.Init()
@@ -49,33 +61,44 @@ func TestNode_PeerInit(t *testing.T) {
 }
 
 func TestNode_SeedInit(t *testing.T) {
-	id := "seed1"
+	name := "seed1"
 	duty := defines.PeerDuty_Seed
 	addr := "127.0.0.1:8991"
 	logdest := "./pot-seed1.log"
 
+	key, err := labKey(name)
+	tError(t, err)
+	id := identity.FromPublicKey(key.Public())
+
 	// 初始化日志单例
 	log.InitGlobalLogger(id, true, true, logdest)
 	defer log.Sync()
 
-	ln, err := _default.ListenTCP(id, addr)
+	seeds := map[string]string{
+		"seed1": "127.0.0.1:8991",
+	}
+	peers := map[string]string{
+		"peer1": "127.0.0.1:7991",
+		"peer2": "127.0.0.1:7992",
+		"peer3": "127.0.0.1:7993",
+	}
+	pubkeys := labPubKeys(seeds, peers)
+
+	ln, err := _default.ListenTCP(id, addr, key, labVerifier(pubkeys))
 	tError(t, err)
-	d, err := _default.NewDialer(id, addr, 0)
+	d, err := _default.NewDialer(id, addr, 0, key, labVerifier(pubkeys))
 	tError(t, err)
 
 	kv := test.NewStore()
-	bc := test.NewBlockChain(id)
+	bc := test.NewBlockChain(id, key)
 
+	seedIds, err := labIds(seeds)
+	tError(t, err)
+	peerIds, err := labIds(peers)
+	tError(t, err)
 	node, err := NewNode(
-		id, duty, ln, d, kv, bc, logdest,
-		map[string]string{
-			"seed1": "127.0.0.1:8991",
-		},
-		map[string]string{
-			"peer1": "127.0.0.1:7991",
-			"peer2": "127.0.0.1:7992",
-			"peer3": "127.0.0.1:7993",
-		})
+		id, duty, key, ln, d, kv, bc, logdest,
+		seedIds, peerIds, pubkeys)
 	tError(t, err)
 
 	err = node.Init()
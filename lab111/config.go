@@ -83,7 +83,11 @@ func make_config(t *testing.T, n int, unreliable bool, consensusType string) *co
 	for nodeid, nodeCfg := range cfg.nodes {
 		ni, nc := nodeid, nodeCfg
 		nc.nodeLog = "./id_" + ni
-		nc.css = bcc.NewConsensus(consensusType)
+		css, err := bcc.NewConsensus(consensusType, &bcc.ConsensusOption{Id: ni})
+		if err != nil {
+			log.Fatalf("new consensus(%s) for %s fail: %s", consensusType, ni, err)
+		}
+		nc.css = css
 	}
 
 	// 连接所有节点
@@ -0,0 +1,567 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 11/2/20 9:30 AM
+* @Description: 基于requires.Store持久化的区块链
+***********************************************************************/
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+const (
+	Module_Bcl = "BCL"
+)
+
+var (
+	// 这个错误用于提示上层，本地区块链与网络不一致，需要重新同步
+	ErrWrongChain = errors.New("wrong blocks in chain")
+)
+
+// NewBlockChain 新建区块链
+// key 为本节点私钥，用于对生成的区块签名
+// kv 为持久化使用的存储引擎，Init时从中恢复区块链
+func NewBlockChain(id string, key crypto.PrivateKey, kv requires.Store) (*BlockChain, error) {
+	logger := log.NewLogger(Module_Bcl, id)
+	if logger == nil {
+		return nil, errors.New("nil logger, please init logger first")
+	}
+	if kv == nil {
+		return nil, errors.New("nil store")
+	}
+
+	return &BlockChain{
+		id:     id,
+		key:    key,
+		store:  newBlockStore(kv),
+		discon: map[int64]*defines.Block{},
+		txpool: map[string]*defines.Transaction{},
+		txin:   make(chan *defines.Transaction, 100),
+		Logger: logger,
+	}, nil
+}
+
+// BlockChain 持久化的区块链
+//
+// 与test.BlockChain一样，区块链由若干连续分段构成：
+// [1,2,3]
+// [10,11]	// 10是启动时收到的最新的区块
+// [20,21]
+// 每个分段只信任其第1个区块(通过多数法选出的)，空缺只能从可信任的区块开始倒序填充。
+// 区块本身只存在于Store中，内存里只保留分段信息、最新区块和游离区块
+type BlockChain struct {
+	id  string
+	key crypto.PrivateKey
+
+	store *blockStore
+
+	// maxIndex记录的是本地曾经达到的最大高度
+	// 当本地区块链遇到错误需要删除一部分区块分段时，maxIndex并不回缩
+	maxIndex int64
+	segs     []segment
+	latest   *defines.Block // 最后一个分段的末尾区块
+
+	// 暂时接不上任何分段的区块，同样持久化
+	discon map[int64]*defines.Block
+
+	txpool map[string]*defines.Transaction
+	txin   chan *defines.Transaction
+
+	inited bool
+	sync.RWMutex
+
+	*log.Logger
+}
+
+// ID 节点ID
+func (bc *BlockChain) ID() string {
+	return bc.id
+}
+
+// Init 打开存储并恢复区块链，之后启动交易收集循环
+func (bc *BlockChain) Init() error {
+	bc.Lock()
+	defer bc.Unlock()
+
+	if bc.inited {
+		return nil
+	}
+
+	bc.Info("Init start")
+
+	if err := bc.store.open(); err != nil {
+		return err
+	}
+	if err := bc.load(); err != nil {
+		return err
+	}
+
+	go bc.collectTxLoop()
+
+	bc.inited = true
+	bc.Infof("Init succ. maxIndex=%d, segments=%v", bc.maxIndex, bc.segs)
+	return nil
+}
+
+// Inited 是否已初始化
+func (bc *BlockChain) Inited() bool {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.inited
+}
+
+// load 从存储中恢复分段信息、最新区块和游离区块
+func (bc *BlockChain) load() error {
+	m, err := bc.store.getMeta()
+	if err != nil {
+		return err
+	}
+	if m == nil { // 初次启动
+		return nil
+	}
+
+	if n := len(m.Segments); n > 0 {
+		end := m.Segments[n-1].End
+		latest, err := bc.store.getBlock(end)
+		if err != nil {
+			return err
+		}
+		if latest == nil {
+			return fmt.Errorf("latest block(%d) missing in store", end)
+		}
+		bc.latest = latest
+	}
+	bc.maxIndex, bc.segs = m.MaxIndex, m.Segments
+
+	var stale []int64
+	err = bc.store.rangeDiscon(func(b *defines.Block) error {
+		if bc.contains(b.Index) { // 已接入分段，只是崩溃前没来得及删除
+			stale = append(stale, b.Index)
+		} else {
+			bc.discon[b.Index] = b
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, index := range stale {
+		if err := bc.store.delDiscon(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Display 展示进度，每个分段只展示首尾区块
+func (bc *BlockChain) Display() string {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	display := fmt.Sprintf("\nBlockChain(%s)(maxIndex=%d):\n", bc.id, bc.maxIndex)
+	for i, seg := range bc.segs {
+		segStr := fmt.Sprintf("Segment%d<%d-%d>:\t", i+1, seg.Start, seg.End)
+		first, _ := bc.store.getBlock(seg.Start)
+		last, _ := bc.store.getBlock(seg.End)
+		if first != nil && last != nil {
+			segStr += fmt.Sprintf("(%d,%s)%s", first.Index, identity.Short(first.Maker), first.ShortName())
+			if seg.End > seg.Start {
+				segStr += fmt.Sprintf(" ——> ... ——> (%d,%s)%s", last.Index, identity.Short(last.Maker), last.ShortName())
+			}
+		}
+		display += segStr + "\n"
+	}
+	if len(bc.discon) > 0 {
+		display += fmt.Sprintf("Discontinuous: %d blocks\n", len(bc.discon))
+	}
+	display += "\n"
+	return display
+}
+
+// GetMaxIndex 本地最新分段的末尾索引，不一定等于maxIndex
+func (bc *BlockChain) GetMaxIndex() int64 {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.localMaxIndex()
+}
+
+func (bc *BlockChain) localMaxIndex() int64 {
+	if len(bc.segs) == 0 {
+		return 0
+	}
+	return bc.segs[len(bc.segs)-1].End
+}
+
+// contains index是否在某个分段中
+func (bc *BlockChain) contains(index int64) bool {
+	for _, seg := range bc.segs {
+		if index >= seg.Start && index <= seg.End {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBlockByIndex 按索引查询区块
+func (bc *BlockChain) GetBlockByIndex(index int64) (*defines.Block, error) {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.getBlockByIndex(index)
+}
+
+func (bc *BlockChain) getBlockByIndex(index int64) (*defines.Block, error) {
+	if !bc.contains(index) {
+		return nil, fmt.Errorf("bc is discontinuous now and block(%d) is missing, try again later", index)
+	}
+	b, err := bc.store.getBlock(index)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, fmt.Errorf("block(%d) missing in store", index)
+	}
+	return b, nil
+}
+
+// GetBlocksByRange 按索引范围查询区块
+// start为负数时表示倒数，例如(-1, 1)表示最新的1个区块
+// count为0表示查询到末尾(或开头)
+func (bc *BlockChain) GetBlocksByRange(start, count int64) ([]*defines.Block, error) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	if count < 0 {
+		return nil, errors.New("negative count")
+	}
+
+	var resL, resR int64
+	right := bc.localMaxIndex()
+	if start >= 1 && start <= right { // 正常情况
+		resL, resR = start, right
+		if count > 0 && start+count-1 < right {
+			resR = start + count - 1
+		}
+	} else if start < 0 && (-start) <= right { // start反向有效
+		resL, resR = 1, right+1+start
+		if count > 0 && resR-count+1 > 1 {
+			resL = resR - count + 1
+		}
+	} else {
+		return nil, errors.New("invalid start")
+	}
+
+	res := make([]*defines.Block, resR-resL+1)
+	existErr := false
+	for i := resL; i <= resR; i++ {
+		b, err := bc.getBlockByIndex(i)
+		if err != nil {
+			existErr = true
+		}
+		res[i-resL] = b
+	}
+	if existErr {
+		return res, errors.New("bc is discontinuous now and some blocks are missing, try again later")
+	}
+	return res, nil
+}
+
+// GetBlocksByHashes 按哈希批量查询区块，任一缺失则返回错误
+func (bc *BlockChain) GetBlocksByHashes(hashes [][]byte) ([]*defines.Block, error) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	res := make([]*defines.Block, len(hashes))
+	for i := 0; i < len(hashes); i++ {
+		b, err := bc.getBlockByHash(hashes[i])
+		if err != nil {
+			return nil, err
+		}
+		res[i] = b
+	}
+	return res, nil
+}
+
+// GetBlockByHash 按哈希查询区块
+func (bc *BlockChain) GetBlockByHash(hash []byte) (*defines.Block, error) {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.getBlockByHash(hash)
+}
+
+func (bc *BlockChain) getBlockByHash(hash []byte) (*defines.Block, error) {
+	index, err := bc.store.getIndex(hash)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || !bc.contains(index) {
+		return nil, fmt.Errorf("block(%x) not found", hash)
+	}
+	b, err := bc.getBlockByIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	// 崩溃残留的哈希索引可能指向已被覆盖的位置
+	if !bytes.Equal(b.SelfHash, hash) {
+		return nil, fmt.Errorf("block(%x) not found", hash)
+	}
+	return b, nil
+}
+
+// GetLatestBlock 获取最新的区块(这里的最新的指的是网络最新)
+// 本地曾经见过更高的区块但没有接上时返回nil
+func (bc *BlockChain) GetLatestBlock() *defines.Block {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	if len(bc.segs) == 0 || bc.maxIndex > bc.localMaxIndex() {
+		return nil
+	}
+	return bc.latest
+}
+
+// Discontinuous 区块链是否存在空洞
+func (bc *BlockChain) Discontinuous() bool {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	return bc.discontinuous()
+}
+
+// 空的区块链不缺任何区块，视为连续
+func (bc *BlockChain) discontinuous() bool {
+	if len(bc.segs) == 0 {
+		return false
+	}
+	return len(bc.segs) > 1 || bc.segs[0].Start != 1 || bc.maxIndex != bc.segs[0].End
+}
+
+// AddNewBlock 将最新的区块的添加到区块链中
+// 调用方如果Add返回错误ErrWrongChain，那么重新请求最新区块
+func (bc *BlockChain) AddNewBlock(nb *defines.Block) error {
+	bc.Lock()
+	defer bc.Unlock()
+	return bc.addNewBlock(nb)
+}
+
+func (bc *BlockChain) addNewBlock(nb *defines.Block) error {
+	bc.Debugf("BlockChain: AddNewBlock: block=%s", nb.ShortName())
+
+	if nb.Index <= bc.maxIndex {
+		return nil
+	}
+
+	segs := append([]segment{}, bc.segs...)
+	if n := len(segs); n > 0 && nb.Index == segs[n-1].End+1 { // 刚好是下一个区块
+		if !bytes.Equal(nb.PrevHash, bc.latest.SelfHash) {
+			return fmt.Errorf("%w: block(%s) does not link to local latest block(%s)",
+				ErrWrongChain, nb.ShortName(), bc.latest.ShortName())
+		}
+		segs[n-1].End = nb.Index
+	} else { // 创建新的分段
+		segs = append(segs, segment{Start: nb.Index, End: nb.Index})
+	}
+
+	if err := bc.commit(nb.Index, segs, nb); err != nil {
+		return err
+	}
+	bc.latest = nb
+	bc.cleanTxPool(nb)
+
+	// 每次添加新区块之后，都检查下是否可以填补空白
+	return bc.checkDiscontinuous()
+}
+
+// AddBlock 添加历史区块，接不上的先作为游离区块保存
+func (bc *BlockChain) AddBlock(b *defines.Block) error {
+	bc.Lock()
+	defer bc.Unlock()
+
+	bc.Debugf("BlockChain: AddBlock: block=%s", b.ShortName())
+
+	if b.Index == bc.maxIndex+1 {
+		return bc.addNewBlock(b)
+	}
+	if bc.contains(b.Index) || bc.discon[b.Index] != nil {
+		return nil
+	}
+
+	if err := bc.store.putDiscon(b); err != nil {
+		return err
+	}
+	bc.discon[b.Index] = b
+	return bc.checkDiscontinuous()
+}
+
+// commit 写入meta以及新接入分段的区块b(可为nil)，成功后才更新内存中的状态
+func (bc *BlockChain) commit(maxIndex int64, segs []segment, b *defines.Block) error {
+	m := &meta{MaxIndex: maxIndex, Segments: segs}
+	if b == nil {
+		if err := bc.store.setMeta(m); err != nil {
+			return err
+		}
+	} else {
+		if err := bc.store.commitBlock(b, m, bc.discon[b.Index] != nil); err != nil {
+			return err
+		}
+		delete(bc.discon, b.Index)
+	}
+	bc.maxIndex, bc.segs = maxIndex, segs
+	return nil
+}
+
+// checkDiscontinuous 检查discon，将能接上的区块倒序插到各分段前面，
+// 分段之间接上时合并，接不上说明后一分段有问题，将其丢弃
+func (bc *BlockChain) checkDiscontinuous() error {
+	for i := len(bc.segs) - 1; i >= 0; i-- {
+		for {
+			seg := bc.segs[i]
+			prevIndex := seg.Start - 1
+			if prevIndex < 1 {
+				break
+			}
+			first, err := bc.store.getBlock(seg.Start) // 该分段上绝对可信的区块
+			if err != nil {
+				return err
+			}
+
+			// 已经与前一分段相接
+			if i > 0 && prevIndex == bc.segs[i-1].End {
+				prevLast, err := bc.store.getBlock(prevIndex)
+				if err != nil {
+					return err
+				}
+				segs := append([]segment{}, bc.segs[:i]...)
+				if bytes.Equal(first.PrevHash, prevLast.SelfHash) {
+					segs[i-1].End = seg.End
+					segs = append(segs, bc.segs[i+1:]...)
+					if err := bc.commit(bc.maxIndex, segs, nil); err != nil {
+						return err
+					}
+					break
+				}
+				// 与前一个不连贯，说明这一段出现了问题，丢弃
+				bc.Warnf("segment<%d-%d> does not link to segment<%d-%d>, drop it",
+					seg.Start, seg.End, bc.segs[i-1].Start, bc.segs[i-1].End)
+				if err := bc.commit(bc.maxIndex, segs, nil); err != nil {
+					return err
+				}
+				if bc.latest, err = bc.store.getBlock(segs[len(segs)-1].End); err != nil {
+					return err
+				}
+				return ErrWrongChain
+			}
+
+			pb := bc.discon[prevIndex]
+			if pb == nil {
+				break
+			}
+			if !bytes.Equal(pb.SelfHash, first.PrevHash) { // 与可信区块矛盾，丢弃
+				bc.Warnf("discontinuous block(%s) does not link to block(%s), drop it", pb.ShortName(), first.ShortName())
+				if err := bc.dropDiscon(prevIndex); err != nil {
+					return err
+				}
+				break
+			}
+
+			// 将该区块加到本分段
+			segs := append([]segment{}, bc.segs...)
+			segs[i].Start = prevIndex
+			if err := bc.commit(bc.maxIndex, segs, pb); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (bc *BlockChain) dropDiscon(index int64) error {
+	if err := bc.store.delDiscon(index); err != nil {
+		return err
+	}
+	delete(bc.discon, index)
+	return nil
+}
+
+// CreateTheWorld 创世界(创建区块链，构建1号区块)
+func (bc *BlockChain) CreateTheWorld() (genesis *defines.Block, err error) {
+	bc.Lock()
+	defer bc.Unlock()
+
+	bc.Debug("BlockChain: CreateTheWorld")
+
+	if len(bc.segs) > 0 { // 说明已经有区块
+		return nil, errors.New("non-empty blockchain")
+	}
+
+	genesis, err = defines.NewBlockAndSign(1, bc.id, nil, nil, fmt.Sprintf("block from %s at %s", bc.id, time.Now().String()), bc.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.addNewBlock(genesis); err != nil {
+		return nil, err
+	}
+	return genesis, nil
+}
+
+// GenNextBlock 聚集交易池中的交易，生成下一个区块
+func (bc *BlockChain) GenNextBlock() (*defines.Block, error) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	bc.Debugf("BlockChain: GenNextBlock: next=%d", bc.localMaxIndex()+1)
+
+	if len(bc.segs) == 0 {
+		return nil, errors.New("empty blockchain")
+	}
+	if bc.discontinuous() {
+		return nil, errors.New("discontinuous blockchain, fill first")
+	}
+
+	txs := make([]*defines.Transaction, 0, len(bc.txpool))
+	for _, tx := range bc.txpool {
+		txs = append(txs, tx)
+	}
+
+	return defines.NewBlockAndSign(bc.latest.Index+1, bc.id, bc.latest.SelfHash, txs,
+		fmt.Sprintf("block from %s at %s", bc.id, time.Now().String()), bc.key)
+}
+
+// TxInChan 交易传入通道
+func (bc *BlockChain) TxInChan() chan *defines.Transaction {
+	return bc.txin
+}
+
+func (bc *BlockChain) collectTxLoop() {
+	for tx := range bc.txin {
+		bc.Debugf("collectTxLoop: recv a tx: %s", tx.Key())
+		bc.addTx(tx)
+	}
+}
+
+func (bc *BlockChain) addTx(tx *defines.Transaction) {
+	bc.Lock()
+	defer bc.Unlock()
+
+	k := tx.Key()
+	if bc.txpool[k] == nil {
+		bc.txpool[k] = tx
+	}
+}
+
+// cleanTxPool 收到最新区块，将已被打包的交易从交易池删除
+func (bc *BlockChain) cleanTxPool(nb *defines.Block) {
+	for _, usedtx := range nb.Txs {
+		delete(bc.txpool, usedtx.Key())
+	}
+}
@@ -0,0 +1,214 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 11/2/20 3:40 PM
+* @Description: 持久化区块链测试
+***********************************************************************/
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+func newTestBlockChain(t *testing.T, key crypto.PrivateKey, kv requires.Store) *BlockChain {
+	id := identity.FromPublicKey(key.Public())
+	log.InitGlobalLogger(id, false, false)
+	bc, err := NewBlockChain(id, key, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+// 生成以genesis开头的n个连续区块，返回值下标即区块索引
+func genTestBlocks(t *testing.T, key crypto.PrivateKey, n int) []*defines.Block {
+	id := identity.FromPublicKey(key.Public())
+	blocks := make([]*defines.Block, n+1)
+	var prev []byte
+	for i := 1; i <= n; i++ {
+		b, err := defines.NewBlockAndSign(int64(i), id, prev, nil, "", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks[i], prev = b, b.SelfHash
+	}
+	return blocks
+}
+
+func TestBlockChain_Reopen(t *testing.T) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := test.NewStore()
+
+	bc := newTestBlockChain(t, key, kv)
+	if bc.GetMaxIndex() != 0 {
+		t.Fatalf("new bc max index = %d, want 0", bc.GetMaxIndex())
+	}
+	genesis, err := bc.CreateTheWorld()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := []*defines.Block{nil, genesis}
+	for i := 2; i <= 5; i++ {
+		nb, err := bc.GenNextBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AddNewBlock(nb); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, nb)
+	}
+
+	// 重启后从存储中恢复
+	bc = newTestBlockChain(t, key, kv)
+	if bc.GetMaxIndex() != 5 || bc.Discontinuous() {
+		t.Fatalf("reopened bc: max index = %d, discontinuous = %v", bc.GetMaxIndex(), bc.Discontinuous())
+	}
+	if latest := bc.GetLatestBlock(); latest == nil || !bytes.Equal(latest.SelfHash, blocks[5].SelfHash) {
+		t.Errorf("latest block = %v, want %s", latest, blocks[5].ShortName())
+	}
+	if b, err := bc.GetBlockByHash(blocks[3].SelfHash); err != nil || b.Index != 3 {
+		t.Errorf("GetBlockByHash(3) = %v, %v", b, err)
+	}
+	got, err := bc.GetBlocksByRange(-1, 1)
+	if err != nil || len(got) != 1 || got[0].Index != 5 {
+		t.Errorf("GetBlocksByRange(-1, 1) = %v, %v", got, err)
+	}
+	got, err = bc.GetBlocksByRange(2, 0)
+	if err != nil || len(got) != 4 || got[0].Index != 2 {
+		t.Errorf("GetBlocksByRange(2, 0) = %v, %v", got, err)
+	}
+	if _, err := bc.CreateTheWorld(); err == nil {
+		t.Error("CreateTheWorld on a non-empty blockchain should fail")
+	}
+
+	// 丢弃分段后残留的区块与哈希索引，重启后不可见
+	lost, err := bc.GenNextBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.store.put(bc.store.blocksCF, lost); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Set(bc.store.hashesCF, lost.SelfHash, indexKey(lost.Index)); err != nil {
+		t.Fatal(err)
+	}
+	bc = newTestBlockChain(t, key, kv)
+	if bc.GetMaxIndex() != 5 {
+		t.Errorf("max index = %d, want 5", bc.GetMaxIndex())
+	}
+	if _, err := bc.GetBlockByHash(lost.SelfHash); err == nil {
+		t.Error("block out of segments should not be visible")
+	}
+	nb, err := bc.GenNextBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nb.Description = "another"
+	if err := nb.Hash(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddNewBlock(nb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.GetBlockByHash(lost.SelfHash); err == nil {
+		t.Error("overwritten block should not be found by its stale hash index")
+	}
+
+	// 接不上本地最新区块
+	bad := genTestBlocks(t, key, 7)[7]
+	if err := bc.AddNewBlock(bad); !errors.Is(err, ErrWrongChain) {
+		t.Errorf("err = %v, want %v", err, ErrWrongChain)
+	}
+}
+
+func TestBlockChain_Segments(t *testing.T) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := test.NewStore()
+	blocks := genTestBlocks(t, key, 6)
+
+	// peer启动：先拿到1号区块，再拿到网络最新区块
+	bc := newTestBlockChain(t, key, kv)
+	if err := bc.AddNewBlock(blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddNewBlock(blocks[5]); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Discontinuous() || bc.GetMaxIndex() != 5 {
+		t.Fatalf("discontinuous = %v, max index = %d", bc.Discontinuous(), bc.GetMaxIndex())
+	}
+	if _, err := bc.GenNextBlock(); err == nil {
+		t.Error("GenNextBlock on a discontinuous blockchain should fail")
+	}
+
+	// 与分段首区块矛盾的游离区块被丢弃
+	fake, err := defines.NewBlockAndSign(4, bc.ID(), []byte("fake"), nil, "", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlock(fake); err != nil {
+		t.Fatal(err)
+	}
+	if len(bc.discon) != 0 {
+		t.Error("fake block should be dropped")
+	}
+
+	// 游离区块同样会持久化
+	if err := bc.AddBlock(blocks[3]); err != nil {
+		t.Fatal(err)
+	}
+	bc = newTestBlockChain(t, key, kv)
+	if bc.discon[3] == nil || len(bc.segs) != 2 {
+		t.Fatalf("reopened bc: discon = %v, segments = %v", bc.discon, bc.segs)
+	}
+
+	// 倒序填充，最终合并为一个分段
+	if err := bc.AddBlock(blocks[4]); err != nil {
+		t.Fatal(err)
+	}
+	if bc.segs[1].Start != 3 {
+		t.Errorf("segments = %v, want the second one to start at 3", bc.segs)
+	}
+	if err := bc.AddBlock(blocks[2]); err != nil {
+		t.Fatal(err)
+	}
+	if bc.Discontinuous() || len(bc.discon) != 0 {
+		t.Errorf("segments = %v, discon = %v, want continuous", bc.segs, bc.discon)
+	}
+	if err := bc.AddNewBlock(blocks[6]); err != nil {
+		t.Fatal(err)
+	}
+
+	bc = newTestBlockChain(t, key, kv)
+	if bc.Discontinuous() || bc.GetMaxIndex() != 6 {
+		t.Errorf("reopened bc: segments = %v, max index = %d", bc.segs, bc.GetMaxIndex())
+	}
+	all, err := bc.GetBlocksByRange(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range all {
+		if !bytes.Equal(b.SelfHash, blocks[i+1].SelfHash) {
+			t.Errorf("block %d mismatched", i+1)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 11/2/20 10:20 AM
+* @Description: 区块链在Store中的存储布局
+***********************************************************************/
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/requires"
+)
+
+/*
+	存储布局(每类数据一个列族):
+	1. BlockKeyPrefix   <index(8B大端), 区块>	已接入某个分段的区块
+	2. HashKeyPrefix    <hash, index(8B大端)>	哈希索引
+	3. DisconKeyPrefix  <index(8B大端), 区块>	暂时接不上任何分段的游离区块
+	4. MetaKeyPrefix    <metaKey, meta>		分段信息与maxIndex
+
+	区块、哈希索引与meta在同一个requires.Batch中提交，进程在中途崩溃的话，要么全部生效，要么全部未生效。
+	丢弃分段时只改写meta，被丢弃分段的区块和哈希索引留在存储中，之后会被同索引的区块覆盖；
+	残留的哈希索引查询时会校验其指向的区块，因此也是无害的。
+	重启时只需读取meta和最新区块，不需要扫描全部区块。
+*/
+
+const (
+	// 长度不能超过requires.CFLen (6)
+	BlockKeyPrefix  = "blocks"
+	HashKeyPrefix   = "bhash-"
+	DisconKeyPrefix = "bdisc-"
+	MetaKeyPrefix   = "bmeta-"
+)
+
+var metaKey = []byte("meta")
+
+// segment 连续的区块分段[start, end]
+type segment struct {
+	Start, End int64
+}
+
+// meta 区块链元数据
+type meta struct {
+	MaxIndex int64
+	Segments []segment
+}
+
+// Encode 编码
+func (m *meta) Encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(m)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode 解码
+func (m *meta) Decode(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(m)
+}
+
+func indexKey(index int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(index))
+	return k
+}
+
+func keyIndex(k []byte) (int64, error) {
+	if len(k) != 8 {
+		return 0, fmt.Errorf("invalid index key(%x)", k)
+	}
+	return int64(binary.BigEndian.Uint64(k)), nil
+}
+
+// blockStore 对requires.Store的薄封装
+type blockStore struct {
+	kv                                   requires.Store
+	blocksCF, hashesCF, disconCF, metaCF requires.CF
+}
+
+func newBlockStore(kv requires.Store) *blockStore {
+	return &blockStore{
+		kv:       kv,
+		blocksCF: requires.String2CF(BlockKeyPrefix),
+		hashesCF: requires.String2CF(HashKeyPrefix),
+		disconCF: requires.String2CF(DisconKeyPrefix),
+		metaCF:   requires.String2CF(MetaKeyPrefix),
+	}
+}
+
+func (s *blockStore) open() error {
+	if err := s.kv.Open(); err != nil {
+		return err
+	}
+	for _, cf := range []requires.CF{s.blocksCF, s.hashesCF, s.disconCF, s.metaCF} {
+		if err := s.kv.RegisterCF(cf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMeta 读取元数据，从未写入过时返回nil
+func (s *blockStore) getMeta() (*meta, error) {
+	v, err := s.kv.Get(s.metaCF, metaKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	m := new(meta)
+	if err := m.Decode(v); err != nil {
+		return nil, fmt.Errorf("decode meta: %w", err)
+	}
+	return m, nil
+}
+
+func (s *blockStore) setMeta(m *meta) error {
+	batch := s.kv.NewBatch()
+	if err := s.batchMeta(batch, m); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+func (s *blockStore) batchMeta(batch requires.Batch, m *meta) error {
+	v, err := m.Encode()
+	if err != nil {
+		return err
+	}
+	batch.Set(s.metaCF, metaKey, v)
+	return nil
+}
+
+// getBlock 读取index处的区块，不存在时返回nil
+func (s *blockStore) getBlock(index int64) (*defines.Block, error) {
+	return s.get(s.blocksCF, index)
+}
+
+// commitBlock 原子地写入区块、哈希索引与新的meta，并删除同索引的游离区块(如果有的话)
+func (s *blockStore) commitBlock(b *defines.Block, m *meta, delDiscon bool) error {
+	v, err := b.Encode()
+	if err != nil {
+		return err
+	}
+	batch := s.kv.NewBatch()
+	batch.Set(s.blocksCF, indexKey(b.Index), v)
+	batch.Set(s.hashesCF, b.SelfHash, indexKey(b.Index))
+	if err := s.batchMeta(batch, m); err != nil {
+		return err
+	}
+	if delDiscon {
+		batch.Del(s.disconCF, indexKey(b.Index))
+	}
+	return batch.Commit()
+}
+
+// getIndex 按哈希查询索引，不存在时返回-1
+func (s *blockStore) getIndex(hash []byte) (int64, error) {
+	v, err := s.kv.Get(s.hashesCF, hash)
+	if err != nil {
+		return -1, err
+	}
+	if len(v) == 0 {
+		return -1, nil
+	}
+	return keyIndex(v)
+}
+
+func (s *blockStore) putDiscon(b *defines.Block) error {
+	return s.put(s.disconCF, b)
+}
+
+func (s *blockStore) delDiscon(index int64) error {
+	return s.kv.Del(s.disconCF, indexKey(index))
+}
+
+// rangeDiscon 遍历所有游离区块
+func (s *blockStore) rangeDiscon(f func(b *defines.Block) error) error {
+	return s.kv.RangeCF(s.disconCF, func(key, value []byte) error {
+		b := new(defines.Block)
+		if err := b.Decode(value); err != nil {
+			return err
+		}
+		return f(b)
+	})
+}
+
+func (s *blockStore) get(cf requires.CF, index int64) (*defines.Block, error) {
+	v, err := s.kv.Get(cf, indexKey(index))
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	b := new(defines.Block)
+	if err := b.Decode(v); err != nil {
+		return nil, fmt.Errorf("decode block(%d): %w", index, err)
+	}
+	return b, nil
+}
+
+func (s *blockStore) put(cf requires.CF, b *defines.Block) error {
+	v, err := b.Encode()
+	if err != nil {
+		return err
+	}
+	return s.kv.Set(cf, indexKey(b.Index), v)
+}
@@ -0,0 +1,113 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/22/20 10:00 AM
+* @Description: 广播: 一次编码、签名，分发到多个连接
+***********************************************************************/
+
+package bnet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+)
+
+var ErrBroadcastFailed = errors.New("broadcast failed")
+
+// broadcast 把同一份编码放入每个接收者的发送队列
+// 所有接收者都有结果后填写Broadcast.Results，再回传汇总结果: 全部成功(包括没有接收者)为nil，否则为ErrBroadcastFailed
+func (n *Net) broadcast(msg *defines.MessageWithError) {
+	if msg.Broadcast.Gossip && n.gossip != nil {
+		n.gossipBroadcast(msg)
+		return
+	}
+	raw, err := msg.Msg.Encode()
+	if err != nil {
+		n.deliver(msg, err)
+		return
+	}
+	n.fanout(msg, n.recipients(msg.Broadcast), raw)
+}
+
+// fanout 把raw放入tos中每个节点的发送队列，并跟踪各自的结果
+func (n *Net) fanout(msg *defines.MessageWithError, tos []string, raw []byte) {
+	if len(tos) == 0 {
+		msg.Broadcast.Results = map[string]error{}
+		n.deliver(msg, nil)
+		return
+	}
+	t := &broadcastTracker{
+		n:       n,
+		msg:     msg,
+		pending: len(tos),
+		results: make(map[string]error, len(tos)),
+	}
+	for _, to := range tos {
+		to := to
+		n.enqueueTo(to, &outMsg{
+			msg:  msg.Msg,
+			raw:  raw,
+			done: func(err error) { t.done(to, err) },
+		})
+	}
+}
+
+// recipients 广播的接收者，去重且不含自己
+func (n *Net) recipients(b *defines.Broadcast) []string {
+	set := make(map[string]struct{})
+	if b.Scope&defines.BroadcastScope_Peers != 0 {
+		for id := range n.pit.Peers() {
+			set[id] = struct{}{}
+		}
+	}
+	if b.Scope&defines.BroadcastScope_Seeds != 0 {
+		for id := range n.pit.Seeds() {
+			set[id] = struct{}{}
+		}
+	}
+	for _, id := range b.Recipients {
+		set[id] = struct{}{}
+	}
+	delete(set, n.id)
+
+	tos := make([]string, 0, len(set))
+	for id := range set {
+		tos = append(tos, id)
+	}
+	return tos
+}
+
+// broadcastTracker 收集一次广播在各接收者上的发送结果
+type broadcastTracker struct {
+	n       *Net
+	msg     *defines.MessageWithError
+	lock    sync.Mutex
+	pending int
+	results map[string]error
+}
+
+func (t *broadcastTracker) done(to string, err error) {
+	t.lock.Lock()
+	t.results[to] = err
+	t.pending--
+	finished := t.pending == 0
+	t.lock.Unlock()
+	if !finished {
+		return
+	}
+
+	failed := 0
+	for _, err := range t.results {
+		if err != nil {
+			failed++
+		}
+	}
+	t.msg.Broadcast.Results = t.results
+	if failed > 0 {
+		t.n.deliver(t.msg, fmt.Errorf("%w: %d/%d recipients", ErrBroadcastFailed, failed, len(t.results)))
+		return
+	}
+	t.n.deliver(t.msg, nil)
+}
@@ -0,0 +1,100 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/22/20 11:00 AM
+* @Description: 广播测试
+***********************************************************************/
+
+package bnet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+// 一次签名的广播送达所有peer和seed，不可达的接收者单独报告
+func TestNet_Broadcast(t *testing.T) {
+	keyA, idA := genKey(t)
+	keyB, idB := genKey(t)
+	keyC, idC := genKey(t)
+	keyD, idD := genKey(t)
+	addrA, addrB, addrC, addrD := "127.0.0.1:8112", "127.0.0.1:8113", "127.0.0.1:8114", "127.0.0.1:8115"
+	log.InitGlobalLogger(idA, false, false)
+	log.InitGlobalLogger(idB, false, false)
+	log.InitGlobalLogger(idC, false, false)
+
+	newNet := func(key crypto.PrivateKey, id, addr string, msgin chan *defines.MessageWithError, infos ...*defines.PeerInfo) *Net {
+		n, err := NewNet(&Option{
+			Id:       id,
+			Addr:     addr,
+			Key:      key,
+			Protocol: Protocol_BTCP,
+			Pit:      newTestPit(t, id, infos...),
+			MsgIn:    msgin,
+			MsgOut:   make(chan *defines.Message, 10),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	peerB := newNet(keyB, idB, addrB, make(chan *defines.MessageWithError, 10))
+	defer peerB.Close()
+	peerC := newNet(keyC, idC, addrC, make(chan *defines.MessageWithError, 10))
+	defer peerC.Close()
+
+	// D不在线
+	msgin := make(chan *defines.MessageWithError, 10)
+	peerA := newNet(keyA, idA, addrA, msgin,
+		&defines.PeerInfo{Id: idB, Addr: addrB, Duty: defines.PeerDuty_Peer, PubKey: crypto.MarshalPublicKey(keyB.Public())},
+		&defines.PeerInfo{Id: idC, Addr: addrC, Duty: defines.PeerDuty_Seed, PubKey: crypto.MarshalPublicKey(keyC.Public())},
+		&defines.PeerInfo{Id: idD, Addr: addrD, Duty: defines.PeerDuty_Peer, PubKey: crypto.MarshalPublicKey(keyD.Public())},
+	)
+	defer peerA.Close()
+
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Data,
+		From:    idA,
+		Kind:    defines.MessageKind_Proof,
+	}
+	merr := defines.NewBroadcast(msg, defines.BroadcastScope_All)
+	if err := msg.Sign(keyA); err != nil {
+		t.Fatal(err)
+	}
+	msgin <- merr
+
+	select {
+	case err := <-merr.Err:
+		if !errors.Is(err, ErrBroadcastFailed) {
+			t.Errorf("err = %v, want ErrBroadcastFailed", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for broadcast result")
+	}
+	results := merr.Broadcast.Results
+	if len(results) != 3 || results[idB] != nil || results[idC] != nil || results[idD] == nil {
+		t.Errorf("results = %v", results)
+	}
+
+	for _, n := range []*Net{peerB, peerC} {
+		select {
+		case got := <-n.msgout:
+			if got.From != idA || got.To != defines.BroadcastTo || got.Kind != defines.MessageKind_Proof {
+				t.Errorf("unexpected msg: %v", got)
+			}
+			if err := got.Verify(keyA.Public()); err != nil {
+				t.Error(err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Errorf("timeout waiting for broadcast at %s", n.id)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/18/20 5:40 PM
+* @Description: 应用配置热更新中的网络相关变化
+***********************************************************************/
+
+package bnet
+
+import (
+	"github.com/azd1997/blockchain-consensus/config"
+)
+
+// HandleConfigEvent 订阅config.Reloader，应用连接超时的变化，
+// 并断开与被移除或地址变化的节点的连接
+// 需要在节点信息表之后订阅，这样重新建连时使用的是新地址
+func (n *Net) HandleConfigEvent(ev config.Event) {
+	var changed []map[string]string
+	switch e := ev.(type) {
+	case *config.ConnTimeoutChanged:
+		n.SetConnTimeout(e.New)
+		n.Infof("conn timeout set to %s", n.ConnTimeout())
+		return
+	case *config.SeedsChanged:
+		changed = []map[string]string{e.Added, e.Removed}
+	case *config.PeersChanged:
+		changed = []map[string]string{e.Added, e.Removed}
+	default:
+		return
+	}
+
+	for _, nodes := range changed {
+		for id := range nodes {
+			n.dropConn(id)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/18/20 6:00 PM
+* @Description: 配置热更新事件测试
+***********************************************************************/
+
+package bnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/config"
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+func TestNet_HandleConfigEvent(t *testing.T) {
+	keyA, idA := genKey(t)
+	keyB, idB := genKey(t)
+	addrA, addrB := "127.0.0.1:8095", "127.0.0.1:8096"
+	log.InitGlobalLogger(idA, false, false)
+	log.InitGlobalLogger(idB, false, false)
+
+	pitA := newTestPit(t, idA)
+	defer pitA.Close()
+	pitB := newTestPit(t, idB, &defines.PeerInfo{
+		Id:     idA,
+		Addr:   addrA,
+		PubKey: crypto.MarshalPublicKey(keyA.Public()),
+	})
+	defer pitB.Close()
+	peerA := runPeer(t, keyA, idA, addrA, Protocol_BTCP, pitA, nil, nil)
+	defer peerA.Close()
+	peerB := runPeer(t, keyB, idB, addrB, Protocol_BTCP, pitB, nil, nil)
+	defer peerB.Close()
+
+	if err := peerB.send(idA, genTestMsg(t, keyB, idB, idA)); err != nil {
+		t.Fatal(err)
+	}
+	conn := peerB.conns[idA]
+	if conn == nil || conn.Timeout() != DefaultConnTimeout {
+		t.Fatalf("conn to A = %v, want timeout %s", conn, DefaultConnTimeout)
+	}
+
+	// 超时修改对现有连接生效
+	peerB.HandleConfigEvent(&config.ConnTimeoutChanged{Old: 0, New: time.Second})
+	if conn.Timeout() != time.Second || peerB.ConnTimeout() != time.Second {
+		t.Errorf("timeout = %s, want 1s", conn.Timeout())
+	}
+
+	// 从配置中移除A后断开连接
+	peerB.HandleConfigEvent(&config.PeersChanged{
+		Added:   map[string]string{},
+		Removed: map[string]string{idA: addrA},
+	})
+	peerB.connsLock.RLock()
+	_, ok := peerB.conns[idA]
+	peerB.connsLock.RUnlock()
+	if ok {
+		t.Error("conn to removed peer should be dropped")
+	}
+}
@@ -9,14 +9,17 @@ package bnet
 import (
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/azd1997/blockchain-consensus/defines"
 	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
 const (
-	// 默认连接中的读写操作超时
+	// 默认连接中的写操作超时
 	DefaultConnTimeout time.Duration = 5 * time.Second
 )
 
@@ -55,8 +58,25 @@ type Conn struct {
 	// Conn只负责写msgChan，Net会另起goroutine循环读msgChan
 	msgChan chan<- *defines.Message // Message channel
 
-	status  ConnStatus    // Conn当前状态
-	timeout time.Duration // 超时
+	status  uint32 // Conn当前状态(ConnStatus)，必须使用atomic包的方法加载和变更
+	timeout int64  // 写超时(time.Duration)，可在运行期间修改，必须使用atomic包的方法加载和变更
+
+	outbound bool // 是否是自己连出的
+
+	// onClose RecvLoop因错误(包括被Close)退出时调用，Net借此清理连接表并重连
+	onClose func(c *Conn, err error)
+	// onGossip 收到gossip消息时调用，为nil则丢弃gossip消息
+	onGossip func(c *Conn, msg *defines.Message)
+
+	writeLock   sync.Mutex    // 消息发送与保活回复可能并发写
+	done        chan struct{} // Close时关闭，通知keepaliveLoop退出
+	closeOnce   sync.Once
+	closeReason atomic.Value // 主动关闭的原因(error)，见closeWith
+
+	// 保活，见keepalive.go。均为UnixNano或time.Duration，必须使用atomic包的方法加载和变更
+	lastPing int64 // 最近一次发出Ping的时间
+	lastPong int64 // 最近一次收到的Pong所对应的Ping的时间
+	rtt      int64 // 平滑后的往返时延，0表示还没有样本
 }
 
 // ToConn 将requires.Conn封装成bcc.Conn
@@ -67,34 +87,66 @@ func ToConn(conn requires.Conn, recvmsg chan<- *defines.Message) *Conn {
 	c := &Conn{
 		conn:    conn,
 		msgChan: recvmsg,
-		status:  ConnStatus_Ready,
-		timeout: DefaultConnTimeout,
+		status:  uint32(ConnStatus_Ready),
+		timeout: int64(DefaultConnTimeout),
+		done:    make(chan struct{}),
 	}
 	return c
 }
 
+// Timeout 写超时
+func (c *Conn) Timeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.timeout))
+}
+
+// SetTimeout 修改写超时，对之后的Send生效。非正数表示不限时
+func (c *Conn) SetTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.timeout, int64(timeout))
+}
+
 // Status 报告Conn状态
 func (c *Conn) Status() string {
-	return c.status.String()
+	return c.getStatus().String()
+}
+
+func (c *Conn) getStatus() ConnStatus {
+	return ConnStatus(atomic.LoadUint32(&c.status))
+}
+
+func (c *Conn) setStatus(status ConnStatus) {
+	atomic.StoreUint32(&c.status, uint32(status))
+}
+
+// Closed 连接是否已断开
+func (c *Conn) Closed() bool {
+	return c.getStatus() == ConnStatus_Closed
 }
 
 // Name Conn名称
 func (c *Conn) Name() string {
-	return fmt.Sprintf("[%s]<->[%s]", c.conn.LocalID(), c.conn.RemoteID())
+	return fmt.Sprintf("[%s]<->[%s]", identity.Short(c.conn.LocalID()), identity.Short(c.conn.RemoteID()))
 }
 
 // String 描述
 func (c *Conn) String() string {
 	return fmt.Sprintf("Conn info: {Network: %s, From: %s(%s), To: %s(%s), Status: %s, Timeout: %s}",
-		c.conn.Network(), c.conn.LocalID(), c.conn.LocalAddr().String(),
-		c.conn.RemoteID(), c.conn.RemoteAddr().String(), c.status.String(), c.timeout.String())
+		c.conn.Network(), identity.Short(c.conn.LocalID()), c.conn.LocalAddr().String(),
+		identity.Short(c.conn.RemoteID()), c.conn.RemoteAddr().String(), c.getStatus().String(), c.Timeout().String())
 }
 
-// Close 关闭连接
+// Close 关闭连接，RecvLoop随之退出
 func (c *Conn) Close() error {
+	c.setStatus(ConnStatus_Closed)
+	c.closeOnce.Do(func() { close(c.done) })
 	return c.conn.Close()
 }
 
+// closeWith 以reason关闭连接，onClose收到的将是reason而不是读错误
+func (c *Conn) closeWith(reason error) error {
+	c.closeReason.Store(reason)
+	return c.Close()
+}
+
 // Send 发送消息
 func (c *Conn) Send(msg *defines.Message) error {
 	// 序列化为字节数组
@@ -102,14 +154,20 @@ func (c *Conn) Send(msg *defines.Message) error {
 	if err != nil {
 		return err
 	}
+	return c.write(b)
+}
 
-	// 发送
-	_, err = c.conn.Write(b)
-	if err != nil {
-		return err
+// write 发送已编码的消息
+func (c *Conn) write(b []byte) error {
+	// 发送。对端长时间不读时写操作会阻塞，以超时避免拖住发送循环
+	// 不支持deadline的自定义连接忽略超时
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if timeout := c.Timeout(); timeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
 	}
-
-	return nil
+	_, err := c.conn.Write(b)
+	return err
 }
 
 // RecvLoop 接收循环，在goroutine内循环读取并解码成Message
@@ -123,7 +181,7 @@ func (c *Conn) Send(msg *defines.Message) error {
 // 对端的conn则会收到“EOF”而退出
 //
 func (c *Conn) RecvLoop() {
-	c.status = ConnStatus_Running
+	atomic.CompareAndSwapUint32(&c.status, uint32(ConnStatus_Ready), uint32(ConnStatus_Running))
 	log.Printf("Conn(%s) running\n", c.Name())
 
 	var err error
@@ -133,9 +191,26 @@ func (c *Conn) RecvLoop() {
 		err = msg.Decode(c.conn)
 		if err != nil { // 遇到错误就断开连接
 			log.Printf("Conn(%s) met error: %s\n", c.Name(), err)
-			c.status = ConnStatus_Closed
+			c.Close() // 解码出错时连接本身可能还在，关闭以免对端继续写入
+			if reason, ok := c.closeReason.Load().(error); ok {
+				err = reason
+			}
+			if c.onClose != nil {
+				c.onClose(c, err)
+			}
 			return
 		}
+		// 保活消息在连接内处理
+		if msg.Type.IsKeepalive() {
+			c.handleKeepalive(msg)
+			continue
+		}
+		if msg.Type.IsGossip() {
+			if c.onGossip != nil {
+				c.onGossip(c, msg)
+			}
+			continue
+		}
 		// 塞到msgChan(来自Net.msgout)
 		c.msgChan <- msg
 	}
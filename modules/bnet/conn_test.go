@@ -7,14 +7,25 @@
 package bnet
 
 import (
-	_default "github.com/azd1997/blockchain-consensus/requires/default"
 	"reflect"
 	"testing"
 
 	"github.com/azd1997/blockchain-consensus/defines"
 	"github.com/azd1997/blockchain-consensus/requires"
+	_default "github.com/azd1997/blockchain-consensus/requires/default"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
+// 生成测试私钥及由其公钥派生的ID
+func genKey(t *testing.T) (crypto.PrivateKey, string) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, identity.FromPublicKey(key.Public())
+}
+
 type peer struct {
 	id   string
 	addr string
@@ -22,13 +33,14 @@ type peer struct {
 	d    requires.Dialer
 }
 
-func genPeer(id, addr string) (*peer, error) {
-	ln, err := _default.ListenTCP(id, addr)
+func genPeer(key crypto.PrivateKey, id, addr string) (*peer, error) {
+	ln, err := _default.ListenTCP(id, addr, key, nil)
 	if err != nil {
 		return nil, err
 	}
-	d, err := _default.NewDialer(id, addr, 0)
+	d, err := _default.NewDialer(id, addr, 0, key, nil)
 	if err != nil {
+		ln.Close()
 		return nil, err
 	}
 	return &peer{
@@ -41,22 +53,24 @@ func genPeer(id, addr string) (*peer, error) {
 
 // 测试连接的建立与消息的编解码
 func TestConn(t *testing.T) {
-	ida := "peerA"
+	keya, ida := genKey(t)
 	addra := "127.0.0.1:8083"
-	peera, err := genPeer(ida, addra)
+	peera, err := genPeer(keya, ida, addra)
 	if err != nil {
-		t.Errorf("%s: %s\n", ida, err)
+		t.Fatalf("%s: %s\n", ida, err)
 	}
 	defer peera.ln.Close()
 
-	idb := "peerB"
+	keyb, idb := genKey(t)
 	addrb := "127.0.0.1:8084"
-	peerb, err := genPeer(idb, addrb)
+	peerb, err := genPeer(keyb, idb, addrb)
 	if err != nil {
-		t.Errorf("%s: %s\n", idb, err)
+		t.Fatalf("%s: %s\n", idb, err)
 	}
 	defer peerb.ln.Close()
 
+	testMsg := genTestMsg(t, keyb, idb, ida)
+
 	// 先启动节点A，再启动节点B，节点B主动连接节点A
 	doneA, doneB := make(chan struct{}), make(chan struct{})
 
@@ -146,18 +160,24 @@ func TestConn(t *testing.T) {
 	<-doneA
 }
 
-var testMsg = &defines.Message{
-	Version: defines.CodeVersion,
-	Type:    defines.MessageType_Data,
-	From:    "id_peerb",
-	To:      "id_peera",
-	Sig:     []byte("Signature"),
-	Entries: []*defines.Entry{
-		&defines.Entry{
-			BaseIndex: 0,
-			Base:      []byte("Base"),
-			Type:      defines.EntryType_Block,
-			Data:      []byte("block"),
+// genTestMsg 生成from发往to的测试消息，并用from的私钥签名
+func genTestMsg(t *testing.T, key crypto.PrivateKey, from, to string) *defines.Message {
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Data,
+		From:    from,
+		To:      to,
+		Entries: []*defines.Entry{
+			&defines.Entry{
+				BaseIndex: 0,
+				Base:      []byte("Base"),
+				Type:      defines.EntryType_Block,
+				Data:      []byte("block"),
+			},
 		},
-	},
+	}
+	if err := msg.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	return msg
 }
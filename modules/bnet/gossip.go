@@ -0,0 +1,431 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/23/20 9:30 AM
+* @Description: gossip扩散层
+***********************************************************************/
+
+package bnet
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	直接广播要求发送方与所有节点建连，N个节点就是N*(N-1)条连接。
+	gossip下发起方只把消息发给Fanout个邻居，收到的节点交给共识模块后再转发给Fanout个邻居(不含来源和发起方)，
+	每转发一次跳数减1，减到0不再转发。已收到过的消息(按原始编码的sha256)直接丢弃，不重复交付也不重复转发。
+	挑选邻居时优先已建立的连接，不够Fanout个才向其他节点建连，所以每个节点的连接数稳定在Fanout左右。
+
+	转发的是发起方签名的原始编码，中间节点无法篡改；gossip消息本身不签名，连接建立时已认证过对端。
+	但对端只是转发者，所以转发和交付前要用节点表中发起方的公钥验签，发起方未知或验签失败则丢弃，
+	否则一个恶意邻居就能借所有诚实节点之手扩散伪造的消息。
+
+	gossip帧携带发起方的广播范围(Scope与Recipients)，中间节点只在这一范围内挑选转发目标。
+
+	随机转发可能漏掉某些节点，所以每隔PullInterval向一个随机邻居发送最近收到过的消息哈希，
+	对端回发它有而自己没有、且请求方在其广播范围内的消息(跳数为0，只交付不转发)。
+	对端的digest只覆盖其缓存窗口，窗口之前的消息对端可能已经淘汰，不再回发；
+	每次拉取至多回发MaxGossipRepair条，优先最新的。
+*/
+
+const (
+	DefaultGossipFanout       = 3
+	DefaultGossipTTL          = 6
+	DefaultGossipCacheSize    = 1024
+	DefaultGossipPullInterval = 2 * time.Second
+
+	// MaxGossipRepair 一次拉取请求至多回发的消息数
+	MaxGossipRepair = 64
+)
+
+// GossipOption gossip层配置，Net.Option.Gossip为nil则不启用
+// 各项为0时使用默认值；PullInterval<0则不做拉取修复
+type GossipOption struct {
+	Fanout       int
+	TTL          int
+	CacheSize    int
+	PullInterval time.Duration
+}
+
+type gossip struct {
+	fanout       int
+	ttl          int
+	pullInterval time.Duration
+	seen         *seenCache
+}
+
+func newGossip(opt *GossipOption) *gossip {
+	g := &gossip{
+		fanout:       opt.Fanout,
+		ttl:          opt.TTL,
+		pullInterval: opt.PullInterval,
+	}
+	if g.fanout <= 0 {
+		g.fanout = DefaultGossipFanout
+	}
+	if g.ttl <= 0 {
+		g.ttl = DefaultGossipTTL
+	}
+	if g.pullInterval == 0 {
+		g.pullInterval = DefaultGossipPullInterval
+	}
+	cacheSize := opt.CacheSize
+	if cacheSize <= 0 {
+		cacheSize = DefaultGossipCacheSize
+	}
+	g.seen = newSeenCache(cacheSize)
+	return g
+}
+
+type seenItem struct {
+	hash  [sha256.Size]byte
+	raw   []byte
+	scope *defines.Broadcast // 发起方的广播范围，只用到Scope与Recipients
+}
+
+// seenCache 最近收到过的消息，容量满时淘汰最早的
+// 同时保存原始编码，供拉取修复时回发
+type seenCache struct {
+	cap   int
+	order *list.List // 元素为*seenItem，最早的在前
+	items map[[sha256.Size]byte]*list.Element
+	lock  *sync.Mutex
+}
+
+func newSeenCache(cap int) *seenCache {
+	return &seenCache{
+		cap:   cap,
+		order: list.New(),
+		items: make(map[[sha256.Size]byte]*list.Element, cap),
+		lock:  new(sync.Mutex),
+	}
+}
+
+// has 消息是否已经记录过
+func (c *seenCache) has(hash [sha256.Size]byte) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, ok := c.items[hash]
+	return ok
+}
+
+// add 记录消息，已经存在时返回false
+func (c *seenCache) add(hash [sha256.Size]byte, raw []byte, scope *defines.Broadcast) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.items[hash]; ok {
+		return false
+	}
+	if c.order.Len() >= c.cap {
+		oldest := c.order.Front()
+		delete(c.items, oldest.Value.(*seenItem).hash)
+		c.order.Remove(oldest)
+	}
+	c.items[hash] = c.order.PushBack(&seenItem{hash: hash, raw: raw, scope: scope})
+	return true
+}
+
+func (c *seenCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+// digest 所有消息哈希的拼接
+func (c *seenCache) digest() []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	d := make([]byte, 0, c.order.Len()*sha256.Size)
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		h := e.Value.(*seenItem).hash
+		d = append(d, h[:]...)
+	}
+	return d
+}
+
+// missing 自己有而digest中没有的消息，按收到的先后排列，至多limit条
+// 只考虑比digest中最早的共有消息更新的部分：更早的消息不在对端的缓存窗口内，可能是对端已淘汰的，回发只会被再次当作新消息。
+// 没有共有消息时(如对端刚上线)取最新的limit条
+func (c *seenCache) missing(digest []byte, limit int) []*seenItem {
+	has := make(map[[sha256.Size]byte]struct{}, len(digest)/sha256.Size)
+	for i := 0; i+sha256.Size <= len(digest); i += sha256.Size {
+		var h [sha256.Size]byte
+		copy(h[:], digest[i:])
+		has[h] = struct{}{}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	start := c.order.Front()
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		if _, ok := has[e.Value.(*seenItem).hash]; ok {
+			start = e.Next()
+			break
+		}
+	}
+	var items []*seenItem
+	for e := start; e != nil; e = e.Next() {
+		item := e.Value.(*seenItem)
+		if _, ok := has[item.hash]; !ok {
+			items = append(items, item)
+		}
+	}
+	if len(items) > limit {
+		items = items[len(items)-limit:]
+	}
+	return items
+}
+
+// gossipFrame 构造gossip消息。To为BroadcastTo，同一份编码可以发给任意邻居
+// Entries[0]的BaseIndex为发起方的Scope，Data为原始消息；Recipients非空时放在Entries[1]
+func (n *Net) gossipFrame(ttl int, raw []byte, scope *defines.Broadcast) ([]byte, error) {
+	frame := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Gossip,
+		Epoch:   int64(ttl),
+		From:    n.id,
+		To:      defines.BroadcastTo,
+		Entries: []*defines.Entry{{BaseIndex: int64(scope.Scope), Data: raw}},
+	}
+	if len(scope.Recipients) > 0 {
+		buf := new(bytes.Buffer)
+		for _, id := range scope.Recipients {
+			if len(id) > 255 {
+				return nil, fmt.Errorf("gossip recipient id too long: %d bytes", len(id))
+			}
+			buf.WriteByte(byte(len(id)))
+			buf.WriteString(id)
+		}
+		frame.Entries = append(frame.Entries, &defines.Entry{Data: buf.Bytes()})
+	}
+	return frame.Encode()
+}
+
+// gossipScope 从gossip帧中取出发起方的广播范围
+func gossipScope(frame *defines.Message) (*defines.Broadcast, error) {
+	scope := &defines.Broadcast{Scope: defines.BroadcastScope(frame.Entries[0].BaseIndex)}
+	if len(frame.Entries) < 2 {
+		return scope, nil
+	}
+	data := frame.Entries[1].Data
+	for len(data) > 0 {
+		l := int(data[0])
+		if len(data) < 1+l {
+			return nil, errors.New("truncated gossip recipients")
+		}
+		scope.Recipients = append(scope.Recipients, string(data[1:1+l]))
+		data = data[1+l:]
+	}
+	return scope, nil
+}
+
+// inScope id是否在广播范围scope内
+func (n *Net) inScope(scope *defines.Broadcast, id string) bool {
+	for _, to := range n.recipients(scope) {
+		if to == id {
+			return true
+		}
+	}
+	return false
+}
+
+// gossipTargets 从candidates中挑选至多fanout个转发目标，不含exclude和自己
+// 优先已建立连接的节点
+func (n *Net) gossipTargets(candidates []string, exclude ...string) []string {
+	skip := map[string]struct{}{n.id: {}}
+	for _, id := range exclude {
+		skip[id] = struct{}{}
+	}
+	var connected, others []string
+	n.connsLock.RLock()
+	for _, id := range candidates {
+		if _, ok := skip[id]; ok {
+			continue
+		}
+		skip[id] = struct{}{}
+		if c := n.conns[id]; c != nil && !c.Closed() {
+			connected = append(connected, id)
+		} else {
+			others = append(others, id)
+		}
+	}
+	n.connsLock.RUnlock()
+
+	rand.Shuffle(len(connected), func(i, j int) { connected[i], connected[j] = connected[j], connected[i] })
+	rand.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	targets := append(connected, others...)
+	if len(targets) > n.gossip.fanout {
+		targets = targets[:n.gossip.fanout]
+	}
+	return targets
+}
+
+// gossipBroadcast 作为发起方，把广播交给gossip层扩散
+// Results只包含直接发送的邻居
+func (n *Net) gossipBroadcast(msg *defines.MessageWithError) {
+	raw, err := msg.Msg.Encode()
+	if err != nil {
+		n.deliver(msg, err)
+		return
+	}
+	scope := &defines.Broadcast{Scope: msg.Broadcast.Scope, Recipients: msg.Broadcast.Recipients}
+	n.gossip.seen.add(sha256.Sum256(raw), raw, scope)
+	frame, err := n.gossipFrame(n.gossip.ttl, raw, scope)
+	if err != nil {
+		n.deliver(msg, err)
+		return
+	}
+
+	n.fanout(msg, n.gossipTargets(n.recipients(msg.Broadcast)), frame)
+}
+
+// handleGossip 处理邻居发来的gossip消息，在连接的RecvLoop中调用
+func (n *Net) handleGossip(c *Conn, msg *defines.Message) {
+	if len(msg.Entries) == 0 {
+		return
+	}
+	from := c.conn.RemoteID()
+	switch msg.Type {
+	case defines.MessageType_Gossip:
+		scope, err := gossipScope(msg)
+		if err != nil {
+			n.Warnf("handleGossip: bad frame from %s: %s", identity.Short(from), err)
+			return
+		}
+		n.relayGossip(from, int(msg.Epoch), msg.Entries[0].Data, scope)
+	case defines.MessageType_GossipPull:
+		repaired := 0
+		for _, item := range n.gossip.seen.missing(msg.Entries[0].Data, MaxGossipRepair) {
+			if !n.inScope(item.scope, from) {
+				continue
+			}
+			frame, err := n.gossipFrame(0, item.raw, item.scope)
+			if err != nil {
+				n.Errorf("handleGossip: build repair frame fail: %s", err)
+				continue
+			}
+			n.enqueueTo(from, &outMsg{msg: msg, raw: frame, done: func(err error) {}})
+			repaired++
+		}
+		if repaired > 0 {
+			n.Debugf("handleGossip: repair %d msgs for %s", repaired, identity.Short(from))
+		}
+	}
+}
+
+// relayGossip 首次收到的消息交给上层，跳数未用完则在发起方的广播范围内继续转发
+func (n *Net) relayGossip(from string, ttl int, raw []byte, scope *defines.Broadcast) {
+	hash := sha256.Sum256(raw)
+	if n.gossip.seen.has(hash) {
+		return
+	}
+	inner := new(defines.Message)
+	if err := inner.Decode(bytes.NewReader(raw)); err != nil {
+		n.Warnf("relayGossip: decode msg from %s fail: %s", identity.Short(from), err)
+		return
+	}
+	if inner.Type.IsKeepalive() || inner.Type.IsGossip() {
+		return
+	}
+	// 验签通过才记录，伪造的消息不会进入缓存，也就不会在拉取修复时回发
+	if err := n.verifyOrigin(inner); err != nil {
+		n.Warnf("relayGossip: drop msg relayed by %s: %s", identity.Short(from), err)
+		return
+	}
+	if !n.gossip.seen.add(hash, raw, scope) {
+		return
+	}
+
+	if ttl > 0 {
+		frame, err := n.gossipFrame(ttl-1, raw, scope)
+		if err != nil {
+			n.Errorf("relayGossip: build frame fail: %s", err)
+		} else {
+			tos := n.gossipTargets(n.recipients(scope), from, inner.From)
+			for _, to := range tos {
+				to := to
+				n.enqueueTo(to, &outMsg{
+					msg: inner,
+					raw: frame,
+					done: func(err error) {
+						if err != nil {
+							n.Debugf("relayGossip: to %s fail: %s", identity.Short(to), err)
+						}
+					},
+				})
+			}
+		}
+	}
+
+	select {
+	case n.msgout <- inner:
+	case <-n.done:
+	}
+}
+
+// verifyOrigin 用节点表中登记的发起方公钥校验消息签名
+func (n *Net) verifyOrigin(msg *defines.Message) error {
+	info, err := n.pit.Get(msg.From)
+	if err != nil {
+		return err
+	}
+	if len(info.PubKey) == 0 {
+		return fmt.Errorf("no public key registered for %s", identity.Short(msg.From))
+	}
+	pub, err := crypto.UnmarshalPublicKey(info.PubKey)
+	if err != nil {
+		return err
+	}
+	return msg.Verify(pub)
+}
+
+// gossipPullLoop 定期向随机邻居拉取自己缺少的消息
+func (n *Net) gossipPullLoop() {
+	ticker := time.NewTicker(n.gossip.pullInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		var neighbors []string
+		n.connsLock.RLock()
+		for id, c := range n.conns {
+			if !c.Closed() {
+				neighbors = append(neighbors, id)
+			}
+		}
+		n.connsLock.RUnlock()
+		if len(neighbors) == 0 {
+			continue
+		}
+		to := neighbors[rand.Intn(len(neighbors))]
+
+		pull := &defines.Message{
+			Version: defines.CodeVersion,
+			Type:    defines.MessageType_GossipPull,
+			From:    n.id,
+			To:      to,
+			Entries: []*defines.Entry{{Data: n.gossip.seen.digest()}},
+		}
+		raw, err := pull.Encode()
+		if err != nil {
+			n.Errorf("gossipPullLoop: encode pull fail: %s", err)
+			continue
+		}
+		n.enqueueTo(to, &outMsg{msg: pull, raw: raw, done: func(err error) {}})
+	}
+}
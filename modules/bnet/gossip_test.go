@@ -0,0 +1,292 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/23/20 11:00 AM
+* @Description: gossip扩散测试
+***********************************************************************/
+
+package bnet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+func Test_seenCache(t *testing.T) {
+	c := newSeenCache(2)
+	raws := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	for _, raw := range raws {
+		if !c.add(sha256.Sum256(raw), raw, nil) {
+			t.Fatalf("add %s: already seen", raw)
+		}
+	}
+	if c.add(sha256.Sum256(raws[2]), raws[2], nil) {
+		t.Error("add c twice")
+	}
+	if c.len() != 2 {
+		t.Errorf("len = %d, want 2", c.len())
+	}
+	// a已被淘汰
+	if c.add(sha256.Sum256(raws[0]), raws[0], nil) != true {
+		t.Error("a should have been evicted")
+	}
+
+	// 现在有c、a，对端只有c
+	peer := newSeenCache(2)
+	peer.add(sha256.Sum256(raws[2]), raws[2], nil)
+	missing := c.missing(peer.digest(), MaxGossipRepair)
+	if len(missing) != 1 || string(missing[0].raw) != "a" {
+		t.Errorf("missing = %v, want [a]", missing)
+	}
+}
+
+// 对端digest窗口之前的消息不回发，没有共有消息时只回发最新的limit条
+func Test_seenCache_missingWindow(t *testing.T) {
+	c := newSeenCache(10)
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		c.add(sha256.Sum256([]byte(s)), []byte(s), nil)
+	}
+	raws := func(items []*seenItem) string {
+		s := ""
+		for _, item := range items {
+			s += string(item.raw)
+		}
+		return s
+	}
+
+	// 对端缓存只剩c、e，a、b已被其淘汰
+	peer := newSeenCache(2)
+	peer.add(sha256.Sum256([]byte("c")), []byte("c"), nil)
+	peer.add(sha256.Sum256([]byte("e")), []byte("e"), nil)
+	if got := raws(c.missing(peer.digest(), 10)); got != "d" {
+		t.Errorf("missing = %q, want \"d\"", got)
+	}
+
+	if got := raws(c.missing(nil, 2)); got != "de" {
+		t.Errorf("missing with empty digest = %q, want \"de\"", got)
+	}
+}
+
+func TestGossipFrameScope(t *testing.T) {
+	_, self := genKey(t)
+	_, r1 := genKey(t)
+	_, r2 := genKey(t)
+	n := &Net{id: self}
+	for _, scope := range []*defines.Broadcast{
+		{Scope: defines.BroadcastScope_Seeds},
+		{Scope: defines.BroadcastScope_None, Recipients: []string{r1, r2}},
+	} {
+		raw, err := n.gossipFrame(2, []byte("inner"), scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := new(defines.Message)
+		if err := frame.Decode(bytes.NewReader(raw)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := gossipScope(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Scope != scope.Scope || !reflect.DeepEqual(got.Recipients, scope.Recipients) {
+			t.Errorf("scope = %+v, want %+v", got, scope)
+		}
+		if string(frame.Entries[0].Data) != "inner" || frame.Epoch != 2 {
+			t.Errorf("frame = %+v", frame)
+		}
+	}
+}
+
+// 链状拓扑A-B-C-D下，A发起的gossip逐跳到达D；之后才上线的E通过拉取补齐
+func TestNet_Gossip(t *testing.T) {
+	type node struct {
+		key  crypto.PrivateKey
+		id   string
+		addr string
+	}
+	nodes := make([]*node, 5)
+	for i := range nodes {
+		key, id := genKey(t)
+		nodes[i] = &node{key: key, id: id, addr: []string{
+			"127.0.0.1:8116", "127.0.0.1:8117", "127.0.0.1:8118", "127.0.0.1:8119", "127.0.0.1:8120"}[i]}
+		log.InitGlobalLogger(id, false, false)
+	}
+	info := func(i int) *defines.PeerInfo {
+		return &defines.PeerInfo{
+			Id:     nodes[i].id,
+			Addr:   nodes[i].addr,
+			Duty:   defines.PeerDuty_Peer,
+			PubKey: crypto.MarshalPublicKey(nodes[i].key.Public()),
+		}
+	}
+	start := func(i int, msgin chan *defines.MessageWithError, pull time.Duration, neighbors ...int) *Net {
+		infos := make([]*defines.PeerInfo, 0, len(neighbors))
+		for _, j := range neighbors {
+			infos = append(infos, info(j))
+		}
+		n, err := NewNet(&Option{
+			Id:       nodes[i].id,
+			Addr:     nodes[i].addr,
+			Key:      nodes[i].key,
+			Protocol: Protocol_BTCP,
+			Pit:      newTestPit(t, nodes[i].id, infos...),
+			MsgIn:    msgin,
+			MsgOut:   make(chan *defines.Message, 10),
+			Gossip:   &GossipOption{Fanout: 1, PullInterval: pull},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Init(); err != nil {
+			t.Fatal(err)
+		}
+		// 转发前要验发起方A的签名，所以启动后再登记A，不改变连接拓扑
+		if i > 1 {
+			if err := n.pit.Set(info(0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return n
+	}
+
+	// 每个节点只连接链上相邻的节点
+	msginA := make(chan *defines.MessageWithError, 10)
+	a := start(0, msginA, -1, 1)
+	defer a.Close()
+	b := start(1, make(chan *defines.MessageWithError, 10), -1, 0, 2)
+	defer b.Close()
+	c := start(2, make(chan *defines.MessageWithError, 10), -1, 1, 3)
+	defer c.Close()
+	d := start(3, make(chan *defines.MessageWithError, 10), -1, 2, 4)
+	defer d.Close()
+
+	msg := &defines.Message{
+		Version: defines.CodeVersion,
+		Type:    defines.MessageType_Data,
+		From:    nodes[0].id,
+		Kind:    defines.MessageKind_NewBlock,
+	}
+	merr := defines.NewBroadcast(msg, defines.BroadcastScope_All)
+	merr.Broadcast.Gossip = true
+	if err := msg.Sign(nodes[0].key); err != nil {
+		t.Fatal(err)
+	}
+	msginA <- merr
+	select {
+	case err := <-merr.Err:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for gossip result")
+	}
+	if results := merr.Broadcast.Results; len(results) != 1 || results[nodes[1].id] != nil {
+		t.Errorf("results = %v, want only B", results)
+	}
+
+	expect := func(n *Net) {
+		t.Helper()
+		select {
+		case got := <-n.msgout:
+			if got.From != nodes[0].id || got.Kind != defines.MessageKind_NewBlock {
+				t.Errorf("unexpected msg at %x: %v", n.id, got)
+			}
+			if err := got.Verify(nodes[0].key.Public()); err != nil {
+				t.Error(err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for gossip at %x", n.id)
+		}
+	}
+	for _, n := range []*Net{b, c, d} {
+		expect(n)
+	}
+	// 不重复交付
+	for _, n := range []*Net{a, b, c, d} {
+		select {
+		case got := <-n.msgout:
+			t.Errorf("duplicate msg at %x: %v", n.id, got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// E错过了扩散，上线后从D拉取
+	e := start(4, make(chan *defines.MessageWithError, 10), 20*time.Millisecond, 3)
+	defer e.Close()
+	expect(e)
+}
+
+// 发起方未知或签名不符的消息既不交付也不转发，也不进入缓存
+func TestNet_RelayGossipVerify(t *testing.T) {
+	key, id := genKey(t)
+	keyA, idA := genKey(t)
+	keyX, idX := genKey(t)
+	_, idB := genKey(t)
+	log.InitGlobalLogger(id, false, false)
+	n, err := NewNet(&Option{
+		Id:       id,
+		Addr:     "127.0.0.1:8127",
+		Key:      key,
+		Protocol: Protocol_BTCP,
+		Pit: newTestPit(t, id, &defines.PeerInfo{
+			Id:     idA,
+			Addr:   "127.0.0.1:8128",
+			PubKey: crypto.MarshalPublicKey(keyA.Public()),
+		}),
+		MsgIn:  make(chan *defines.MessageWithError, 10),
+		MsgOut: make(chan *defines.Message, 10),
+		Gossip: &GossipOption{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	signed := func(from string, key crypto.PrivateKey) []byte {
+		msg := &defines.Message{
+			Version: defines.CodeVersion,
+			Type:    defines.MessageType_Data,
+			From:    from,
+			To:      defines.BroadcastTo,
+			Kind:    defines.MessageKind_NewBlock,
+		}
+		if err := msg.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := msg.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	scope := &defines.Broadcast{Scope: defines.BroadcastScope_All}
+
+	// X不在节点表中；冒充A但用X的私钥签名
+	for _, raw := range [][]byte{signed(idX, keyX), signed(idA, keyX)} {
+		n.relayGossip(idB, 0, raw, scope)
+	}
+	select {
+	case got := <-n.msgout:
+		t.Errorf("forged msg delivered: %v", got)
+	default:
+	}
+	if l := n.gossip.seen.len(); l != 0 {
+		t.Errorf("seen len = %d, want 0", l)
+	}
+
+	n.relayGossip(idB, 0, signed(idA, keyA), scope)
+	select {
+	case got := <-n.msgout:
+		if got.From != idA {
+			t.Errorf("unexpected msg: %v", got)
+		}
+	default:
+		t.Error("genuine msg not delivered")
+	}
+}
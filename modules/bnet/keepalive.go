@@ -0,0 +1,153 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/20/20 10:15 AM
+* @Description: 连接保活与往返时延
+***********************************************************************/
+
+package bnet
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	每个连接每隔KeepaliveInterval向对端发一个Ping，对端在RecvLoop中立即回复Pong。
+	发出下一个Ping时上一个的Pong还没有回来记为一次丢失，连续丢失KeepaliveMaxMiss次则认为对端已失联，
+	关闭连接，之后按reconnect.go的流程清理、通知并重连。
+	Ping携带发出时间，Pong原样带回，Ping方据此计算往返时延，并像TCP的SRTT一样做平滑: rtt = 7/8*rtt + 1/8*sample
+*/
+
+const (
+	DefaultKeepaliveInterval = 5 * time.Second
+	DefaultKeepaliveMaxMiss  = 3
+)
+
+var ErrKeepaliveTimeout = errors.New("keepalive timeout")
+
+// RTT 平滑后的往返时延，还没有样本时为0
+func (c *Conn) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.rtt))
+}
+
+// handleKeepalive 处理对端的Ping/Pong
+func (c *Conn) handleKeepalive(msg *defines.Message) {
+	switch msg.Type {
+	case defines.MessageType_Ping:
+		pong := &defines.Message{
+			Version: defines.CodeVersion,
+			Type:    defines.MessageType_Pong,
+			Epoch:   msg.Epoch,
+			From:    c.conn.LocalID(),
+			To:      c.conn.RemoteID(),
+		}
+		if err := c.Send(pong); err != nil {
+			c.closeWith(fmt.Errorf("reply pong: %w", err))
+		}
+	case defines.MessageType_Pong:
+		sent := msg.Epoch
+		// 只认最近一次Ping的Pong，更早的已经记为丢失
+		if sent != atomic.LoadInt64(&c.lastPing) {
+			return
+		}
+		atomic.StoreInt64(&c.lastPong, sent)
+		sample := time.Now().UnixNano() - sent
+		if sample < 0 {
+			return
+		}
+		old := atomic.LoadInt64(&c.rtt)
+		if old == 0 {
+			atomic.StoreInt64(&c.rtt, sample)
+		} else {
+			atomic.StoreInt64(&c.rtt, old-old/8+sample/8)
+		}
+	}
+}
+
+// keepaliveLoop 定期Ping对端，连续maxMiss次没有回应则关闭连接
+func (c *Conn) keepaliveLoop(interval time.Duration, maxMiss int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	misses := 0
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		if last := atomic.LoadInt64(&c.lastPing); last != 0 && atomic.LoadInt64(&c.lastPong) != last {
+			misses++
+			if misses >= maxMiss {
+				c.closeWith(fmt.Errorf("%w: %d pings unanswered", ErrKeepaliveTimeout, misses))
+				return
+			}
+		} else {
+			misses = 0
+		}
+
+		now := time.Now().UnixNano()
+		atomic.StoreInt64(&c.lastPing, now)
+		ping := &defines.Message{
+			Version: defines.CodeVersion,
+			Type:    defines.MessageType_Ping,
+			Epoch:   now,
+			From:    c.conn.LocalID(),
+			To:      c.conn.RemoteID(),
+		}
+		if err := c.Send(ping); err != nil {
+			c.closeWith(fmt.Errorf("send ping: %w", err))
+			return
+		}
+	}
+}
+
+// RTT 与id之间连接的往返时延，没有连接或还没有样本时ok为false
+func (n *Net) RTT(id string) (rtt time.Duration, ok bool) {
+	n.connsLock.RLock()
+	c := n.conns[id]
+	n.connsLock.RUnlock()
+	if c == nil || c.Closed() {
+		return 0, false
+	}
+	rtt = c.RTT()
+	return rtt, rtt > 0
+}
+
+// RTTs 所有连接的往返时延 <id, rtt>，不含还没有样本的
+func (n *Net) RTTs() map[string]time.Duration {
+	n.connsLock.RLock()
+	defer n.connsLock.RUnlock()
+	rtts := make(map[string]time.Duration, len(n.conns))
+	for id, c := range n.conns {
+		if rtt := c.RTT(); rtt > 0 && !c.Closed() {
+			rtts[id] = rtt
+		}
+	}
+	return rtts
+}
+
+// markLiveness 在节点信息表中记录id是否可达
+// 只在PeerAttr_Normal与PeerAttr_Disconnected之间切换，不覆盖PeerAttr_Malicious；不在表中的节点忽略
+func (n *Net) markLiveness(id string, alive bool) {
+	info, err := n.pit.Get(id)
+	if err != nil {
+		return
+	}
+	from, to := defines.PeerAttr_Normal, defines.PeerAttr_Disconnected
+	if alive {
+		from, to = to, from
+	}
+	if info.Attr != from {
+		return
+	}
+	if err := n.pit.SetAttr(id, to); err != nil {
+		n.Warnf("mark %s attr %d fail: %s", identity.Short(id), to, err)
+	}
+}
@@ -0,0 +1,171 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/20/20 11:00 AM
+* @Description: 连接保活测试
+***********************************************************************/
+
+package bnet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+// 正常的两端之间能测得往返时延，对端也保持可达
+func TestNet_KeepaliveRTT(t *testing.T) {
+	keyA, idA := genKey(t)
+	keyB, idB := genKey(t)
+	addrA, addrB := "127.0.0.1:8105", "127.0.0.1:8106"
+	log.InitGlobalLogger(idA, false, false)
+	log.InitGlobalLogger(idB, false, false)
+
+	peerA, err := NewNet(&Option{
+		Id:                idA,
+		Addr:              addrA,
+		Key:               keyA,
+		Protocol:          Protocol_BTCP,
+		Pit:               newTestPit(t, idA),
+		MsgIn:             make(chan *defines.MessageWithError, 10),
+		MsgOut:            make(chan *defines.Message, 10),
+		KeepaliveInterval: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peerA.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer peerA.Close()
+
+	pitB := newTestPit(t, idB, &defines.PeerInfo{
+		Id:     idA,
+		Addr:   addrA,
+		PubKey: crypto.MarshalPublicKey(keyA.Public()),
+	})
+	peerB, err := NewNet(&Option{
+		Id:                idB,
+		Addr:              addrB,
+		Key:               keyB,
+		Protocol:          Protocol_BTCP,
+		Pit:               pitB,
+		MsgIn:             make(chan *defines.MessageWithError, 10),
+		MsgOut:            make(chan *defines.Message, 10),
+		KeepaliveInterval: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peerB.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer peerB.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if rtt, ok := peerB.RTT(idA); ok {
+			t.Logf("rtt of A: %s", rtt)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for rtt")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, ok := peerB.RTTs()[idA]; !ok {
+		t.Error("RTTs misses A")
+	}
+	// 保活消息不应交给上层
+	select {
+	case msg := <-peerB.msgout:
+		t.Errorf("unexpected msg: %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if info, _ := pitB.Get(idA); info.Attr != defines.PeerAttr_Normal {
+		t.Errorf("attr of A = %d, want PeerAttr_Normal", info.Attr)
+	}
+}
+
+// 对端不回应Ping，连续丢失后断开并标记为PeerAttr_Disconnected
+func TestNet_KeepaliveTimeout(t *testing.T) {
+	keyA, idA := genKey(t)
+	keyB, idB := genKey(t)
+	addrA, addrB := "127.0.0.1:8107", "127.0.0.1:8108"
+	log.InitGlobalLogger(idB, false, false)
+
+	// A只接受连接，从不读写
+	peerA, err := genPeer(keyA, idA, addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerA.ln.Close()
+	accepted := make(chan requires.Conn, 1)
+	go func() {
+		c, err := peerA.ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	pitB := newTestPit(t, idB, &defines.PeerInfo{
+		Id:     idA,
+		Addr:   addrA,
+		PubKey: crypto.MarshalPublicKey(keyA.Public()),
+	})
+	peerB, err := NewNet(&Option{
+		Id:                idB,
+		Addr:              addrB,
+		Key:               keyB,
+		Protocol:          Protocol_BTCP,
+		Pit:               pitB,
+		MsgIn:             make(chan *defines.MessageWithError, 10),
+		MsgOut:            make(chan *defines.Message, 10),
+		KeepaliveInterval: 20 * time.Millisecond,
+		KeepaliveMaxMiss:  2,
+		ReconnectMin:      time.Minute, // 不让重连干扰断开后的状态
+		ReconnectMax:      time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan *defines.ConnEvent, 10)
+	peerB.SubscribeConnEvent(func(ev *defines.ConnEvent) {
+		events <- ev
+	})
+	if err := peerB.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer peerB.Close()
+	select {
+	case c := <-accepted:
+		defer c.Close()
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for B to connect")
+	}
+
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type != defines.ConnEvent_Disconnected {
+				continue
+			}
+			if ev.Id != idA || !errors.Is(ev.Err, ErrKeepaliveTimeout) {
+				t.Fatalf("event = %s, want keepalive timeout of A", ev)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for disconnect")
+		}
+		break
+	}
+	if info, _ := pitB.Get(idA); info.Attr != defines.PeerAttr_Disconnected {
+		t.Errorf("attr of A = %d, want PeerAttr_Disconnected", info.Attr)
+	}
+	if _, ok := peerB.RTT(idA); ok {
+		t.Error("RTT of disconnected A should be unavailable")
+	}
+}
@@ -11,11 +11,15 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/azd1997/blockchain-consensus/defines"
 	"github.com/azd1997/blockchain-consensus/modules/peerinfo"
 	"github.com/azd1997/blockchain-consensus/requires"
 	_default "github.com/azd1997/blockchain-consensus/requires/default"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 	"github.com/azd1997/blockchain-consensus/utils/log"
 )
 
@@ -35,6 +39,14 @@ const (
 	Module_Net = "NET"
 )
 
+// 默认Listener/Dialer支持的传输协议，对应 config.BnetConfig.Protocol
+const (
+	// Protocol_BTCP 基于tcp的默认协议，连接建立时进行身份认证握手，之后明文传输
+	Protocol_BTCP = "btcp"
+	// Protocol_BTLS 基于双向认证TLS的加密传输，证书由节点密钥背书
+	Protocol_BTLS = "btls"
+)
+
 // Option 选项
 type Option struct {
 	/*
@@ -43,6 +55,18 @@ type Option struct {
 	Id   string
 	Addr string
 
+	/*
+		Key 本地私钥，Id须由其公钥派生
+		使用默认的TCP连接时必须设置，用于连接建立时的身份认证握手
+	*/
+	Key crypto.PrivateKey
+
+	/*
+		Protocol 默认Listener/Dialer使用的传输协议，可选 Protocol_BTCP / Protocol_BTLS
+		为空则使用Protocol_BTCP。设置了Listener和Dialer时该项无效
+	*/
+	Protocol string
+
 	/*
 		当需要外部自定义传输协议时，外部调用者实现自己的Listener和Dialer并填入Option
 		如果Listener和Dialer设置，则不会采用默认的TCP连接
@@ -63,6 +87,35 @@ type Option struct {
 	MsgIn  chan *defines.MessageWithError
 	MsgOut chan *defines.Message
 
+	/*
+		ConnTimeout 连接的写超时，为0则使用DefaultConnTimeout。运行期间可通过SetConnTimeout修改
+	*/
+	ConnTimeout time.Duration
+
+	/*
+		ReconnectMin ReconnectMax 连接断开后重连的退避区间，每次失败后间隔翻倍，直到ReconnectMax
+		为0则分别使用DefaultReconnectMin、DefaultReconnectMax
+	*/
+	ReconnectMin time.Duration
+	ReconnectMax time.Duration
+
+	/*
+		KeepaliveInterval 连接保活的Ping间隔，为0则使用DefaultKeepaliveInterval，<0则不保活
+		KeepaliveMaxMiss 连续多少个Ping没有回应则断开，为0则使用DefaultKeepaliveMaxMiss
+	*/
+	KeepaliveInterval time.Duration
+	KeepaliveMaxMiss  int
+
+	/*
+		SendQueueCap 每个对端的发送队列容量，为0则使用DefaultSendQueueCap。队列满时的处理见send_queue.go
+	*/
+	SendQueueCap int
+
+	/*
+		Gossip 非nil则启用gossip层，Broadcast.Gossip为true的广播经gossip扩散，见gossip.go
+	*/
+	Gossip *GossipOption
+
 	/*
 		CustomInitFunc 用于设置Net模块启动时预设的行为
 		CustomMsgHandleFunc 消息处理函数，设置此项则MsgOut被CustomMsgHandleFunc接管，消息不再传出
@@ -102,6 +155,31 @@ type Net struct {
 	// 与对端结点连接异常时，或者后需考虑连接数量控制，会需要删除一些连接
 	conns     map[string]*Conn
 	connsLock *sync.RWMutex
+	// 新建连接的写超时(time.Duration)，必须使用atomic包的方法加载和变更
+	connTimeout int64
+
+	// 正在重连的节点，保证每个节点最多一个重连goroutine
+	reconnecting     map[string]struct{}
+	reconnectingLock *sync.Mutex
+	reconnectMin     time.Duration
+	reconnectMax     time.Duration
+
+	// 保活
+	keepaliveInterval time.Duration
+	keepaliveMaxMiss  int
+
+	// 发送队列 <to, queue>，每个队列有一个写goroutine
+	queues           map[string]*sendQueue
+	queuesLock       *sync.Mutex
+	sendQueueCap     int
+	queueIdleTimeout time.Duration
+
+	// gossip层，为nil表示未启用
+	gossip *gossip
+
+	// 连接事件订阅者
+	connSubs     []func(ev *defines.ConnEvent)
+	connSubsLock *sync.RWMutex
 
 	/*
 		消息的流动顺序：
@@ -163,11 +241,7 @@ func NewNet(opt *Option) (*Net, error) {
 
 	// 检查opt.Listener和opt.Dialer
 	if opt.Listener == nil && opt.Dialer == nil {
-		ln, err := _default.ListenTCP(opt.Id, opt.Addr)
-		if err != nil {
-			return nil, err
-		}
-		d, err := _default.NewDialer(opt.Id, opt.Addr, 0)
+		ln, d, err := defaultListenerDialer(opt, n.pit.VerifyPeer)
 		if err != nil {
 			return nil, err
 		}
@@ -197,11 +271,76 @@ func NewNet(opt *Option) (*Net, error) {
 
 	n.conns = make(map[string]*Conn)
 	n.connsLock = new(sync.RWMutex)
+	n.connTimeout = int64(opt.ConnTimeout)
+	if n.connTimeout == 0 {
+		n.connTimeout = int64(DefaultConnTimeout)
+	}
+	n.reconnecting = make(map[string]struct{})
+	n.reconnectingLock = new(sync.Mutex)
+	n.reconnectMin, n.reconnectMax = opt.ReconnectMin, opt.ReconnectMax
+	if n.reconnectMin <= 0 {
+		n.reconnectMin = DefaultReconnectMin
+	}
+	if n.reconnectMax <= 0 {
+		n.reconnectMax = DefaultReconnectMax
+	}
+	if n.reconnectMax < n.reconnectMin {
+		n.reconnectMax = n.reconnectMin
+	}
+	n.keepaliveInterval, n.keepaliveMaxMiss = opt.KeepaliveInterval, opt.KeepaliveMaxMiss
+	if n.keepaliveInterval == 0 {
+		n.keepaliveInterval = DefaultKeepaliveInterval
+	}
+	if n.keepaliveMaxMiss <= 0 {
+		n.keepaliveMaxMiss = DefaultKeepaliveMaxMiss
+	}
+	n.queues = make(map[string]*sendQueue)
+	n.queuesLock = new(sync.Mutex)
+	n.sendQueueCap = opt.SendQueueCap
+	if n.sendQueueCap <= 0 {
+		n.sendQueueCap = DefaultSendQueueCap
+	}
+	n.queueIdleTimeout = DefaultQueueIdleTimeout
+	if opt.Gossip != nil {
+		n.gossip = newGossip(opt.Gossip)
+	}
+	n.connSubsLock = new(sync.RWMutex)
 	n.done = make(chan struct{})
 
 	return n, nil
 }
 
+// defaultListenerDialer 按opt.Protocol构建默认的Listener和Dialer
+// 握手时以verifier(通常是节点信息表)校验对端公钥
+func defaultListenerDialer(opt *Option, verifier _default.PeerVerifier) (requires.Listener, requires.Dialer, error) {
+	switch opt.Protocol {
+	case "", Protocol_BTCP:
+		ln, err := _default.ListenTCP(opt.Id, opt.Addr, opt.Key, verifier)
+		if err != nil {
+			return nil, nil, err
+		}
+		d, err := _default.NewDialer(opt.Id, opt.Addr, 0, opt.Key, verifier)
+		if err != nil {
+			ln.Close()
+			return nil, nil, err
+		}
+		return ln, d, nil
+	case Protocol_BTLS:
+		ln, err := _default.ListenTLS(opt.Id, opt.Addr, opt.Key, verifier)
+		if err != nil {
+			return nil, nil, err
+		}
+		d, err := _default.NewTLSDialer(opt.Id, opt.Addr, 0, opt.Key, verifier)
+		if err != nil {
+			ln.Close()
+			return nil, nil, err
+		}
+		return ln, d, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown protocol: %s", opt.Protocol)
+	}
+}
+
 // Init 初始化
 // 整体的启动顺序：
 // 		1. 开启监听循环
@@ -210,7 +349,7 @@ func NewNet(opt *Option) (*Net, error) {
 //		4. 执行启动任务（如果有）
 func (n *Net) Init() error {
 
-	n.Infof("Init: id(%s), addr(%s): start", n.id, n.addr)
+	n.Infof("Init: id(%s), addr(%s): start", identity.Short(n.id), n.addr)
 
 	// 启动监听循环
 	go n.listenLoop()
@@ -220,12 +359,16 @@ func (n *Net) Init() error {
 	}
 	// 启动发送循环
 	go n.msgSendLoop()
+	// 启动gossip拉取修复
+	if n.gossip != nil && n.gossip.pullInterval > 0 {
+		go n.gossipPullLoop()
+	}
 
 	f := func(peer *defines.PeerInfo) error {
 		// 建立连接
 		_, err := n.connect(peer.Id)
 		if err != nil {
-			n.Errorf("Init: connect to peer (%s,%s) fail: %s", peer.Id, peer.Addr, err)
+			n.Errorf("Init: connect to peer (%s,%s) fail: %s", identity.Short(peer.Id), peer.Addr, err)
 			return err
 		}
 		return nil
@@ -248,7 +391,7 @@ func (n *Net) Init() error {
 	}
 
 	n.inited = true
-	n.Infof("Init: id(%s), addr(%s): finish", n.id, n.addr)
+	n.Infof("Init: id(%s), addr(%s): finish", identity.Short(n.id), n.addr)
 	return nil
 }
 
@@ -267,17 +410,15 @@ func (n *Net) Inited() bool {
 // 		目前直接使用done通知关闭
 func (n *Net) Close() error {
 
-	// 关闭监听器
-	err := n.ln.Close()
-	if err != nil {
-		return err
-	}
-
 	// 关闭发送循环，放弃msgin的读取
 	// 关闭监听循环
 	// 关闭消息处理循环（如果有）
+	// 先于关闭监听器，listenLoop据此区分Accept的错误是否因关闭引起
 	close(n.done)
 
+	// 关闭监听器
+	err := n.ln.Close()
+
 	// 关闭所有现有链接
 	n.connsLock.RLock()
 	for id := range n.conns {
@@ -286,7 +427,7 @@ func (n *Net) Close() error {
 	n.connsLock.RUnlock()
 
 	n.inited = false
-	return nil
+	return err
 }
 
 // Ok 判断Net是否准备好
@@ -328,8 +469,11 @@ func (n *Net) connect(to string) (*Conn, error) {
 	}
 
 	// 连接存在，直接返回
-	if n.conns[to] != nil {
-		return n.conns[to], nil
+	n.connsLock.RLock()
+	conn := n.conns[to]
+	n.connsLock.RUnlock()
+	if conn != nil && !conn.Closed() {
+		return conn, nil
 	}
 
 	// 连接不存在，创建连接
@@ -340,17 +484,41 @@ func (n *Net) connect(to string) (*Conn, error) {
 	// 和to建立连接
 	c, err := n.d.Dial(toPeerInfo.Addr, toPeerInfo.Id)
 	if err != nil {
+		n.markLiveness(to, false)
 		return nil, err
 	}
-	conn := ToConn(c, n.msgout) // c传输过来的消息会写到msgout传出去
-	// 记录连接
+	// 记录并启动连接
+	return n.addConn(to, n.newConn(c, true)), nil
+}
+
+// ConnTimeout 连接的写超时
+func (n *Net) ConnTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&n.connTimeout))
+}
+
+// SetConnTimeout 修改所有现有连接及之后新建连接的写超时，为0则恢复DefaultConnTimeout
+func (n *Net) SetConnTimeout(timeout time.Duration) {
+	if timeout == 0 {
+		timeout = DefaultConnTimeout
+	}
+	atomic.StoreInt64(&n.connTimeout, int64(timeout))
+	n.connsLock.RLock()
+	for _, c := range n.conns {
+		c.SetTimeout(timeout)
+	}
+	n.connsLock.RUnlock()
+}
+
+// dropConn 关闭并移除与id的连接及发送队列，之后向id发送消息时按节点信息表重新建连
+func (n *Net) dropConn(id string) {
 	n.connsLock.Lock()
-	n.conns[to] = conn
+	c := n.conns[id]
+	delete(n.conns, id)
 	n.connsLock.Unlock()
-	// 启动连接
-	n.startConn(conn)
-
-	return conn, nil
+	if c != nil {
+		c.Close()
+	}
+	n.dropQueue(id)
 }
 
 // startConn 启动连接
@@ -360,6 +528,9 @@ func (n *Net) startConn(c *Conn) {
 	}
 	// 启动其接收循环
 	go c.RecvLoop()
+	if n.keepaliveInterval > 0 {
+		go c.keepaliveLoop(n.keepaliveInterval, n.keepaliveMaxMiss)
+	}
 }
 
 // send 向对端节点发送消息
@@ -367,6 +538,15 @@ func (n *Net) startConn(c *Conn) {
 // 如果没有Conn，那么建立新Conn，并发送消息。
 // TODO: 关于连接数量的控制
 func (n *Net) send(to string, msg *defines.Message) error {
+	b, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	return n.sendRaw(to, b)
+}
+
+// sendRaw 向对端节点发送已编码的消息
+func (n *Net) sendRaw(to string, b []byte) error {
 	// 获取或创建连接
 	conn, err := n.connect(to)
 	if err != nil {
@@ -374,8 +554,10 @@ func (n *Net) send(to string, msg *defines.Message) error {
 	}
 
 	// 发送消息
-	err = conn.Send(msg)
+	// 发送失败说明连接已不可用，关闭后由RecvLoop退出触发清理和重连
+	err = conn.write(b)
 	if err != nil {
+		conn.Close()
 		return err
 	}
 
@@ -410,7 +592,7 @@ func (n *Net) msgHandleLoop() {
 }
 
 // msgSendLoop 发送循环
-// 不断读msgin的消息，然后发送出去
+// 不断读msgin的消息，分发到目标节点的发送队列，由各队列的写goroutine发送出去
 func (n *Net) msgSendLoop() {
 	for {
 		select {
@@ -420,18 +602,24 @@ func (n *Net) msgSendLoop() {
 		case msg := <-n.msgin:
 			// 检查
 			if err := msg.Check(); err != nil {
-				n.Errorf("msgSendLoop: recv invalid msg(%s): msg=%v, err=%s", msg.Msg.Desc, msg.Msg, err)
+				// msg或msg.Msg可能为nil，取Kind前须先判空
+				if msg == nil || msg.Msg == nil {
+					n.Errorf("msgSendLoop: recv invalid msg: err=%s", err)
+					continue
+				}
+				n.Errorf("msgSendLoop: recv invalid msg(%s): msg=%v, err=%s", msg.Msg.Kind, msg.Msg, err)
+				if msg.Err != nil {
+					n.deliver(msg, err)
+				}
 				continue
 			} else {
 				//n.Infof("msgSendLoop: recv msg(%v) from local\n", msg)
 			}
-			// 发送
-			if err := n.send(msg.Msg.To, msg.Msg); err != nil {
-				n.Errorf("msgSendLoop: send msg(%s) fail: msg=%v, err=%s", msg.Msg.Desc, msg.Msg, err)
-				msg.Err <- err // 回传发送时错误信息
+			// 入队，发送结果由写goroutine回传
+			if msg.Broadcast != nil {
+				n.broadcast(msg)
 			} else {
-				n.Debugf("msgSendLoop: send msg(%s) succ: msg=%v", msg.Msg.Desc, msg.Msg)
-				msg.Err <- nil
+				n.enqueue(msg)
 			}
 		}
 	}
@@ -449,15 +637,15 @@ func (n *Net) listenLoop() {
 			// 接受连接
 			conn, err := n.ln.Accept()
 			if err != nil {
+				if n.closed() { // 监听器已被Close关闭
+					n.Infof("listenLoop: returned...")
+					return
+				}
 				n.Errorf("listenLoop: accept fail: %s", err)
 				continue
 			}
-			// 启动连接，循环接收消息
-			c := ToConn(conn, n.msgout)
-			// 记录连接
-			n.conns[conn.RemoteID()] = c
-			// 启动连接
-			n.startConn(c)
+			// 记录并启动连接，循环接收消息
+			n.addConn(conn.RemoteID(), n.newConn(conn, false))
 		}
 	}
 }
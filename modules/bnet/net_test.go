@@ -7,38 +7,39 @@
 package bnet
 
 import (
-	"fmt"
-	"github.com/azd1997/blockchain-consensus/modules/peerinfo"
-	"github.com/azd1997/blockchain-consensus/utils/log"
-	"reflect"
 	"testing"
 	"time"
 
 	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/modules/peerinfo"
 	"github.com/azd1997/blockchain-consensus/requires"
 	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/log"
 )
 
 // 运行节点 已经存在的
 // 在内部根据配置启动节点(这里指Net)，而后返回其结构体
-func runPeer(t *testing.T, id string, addr string,
+func runPeer(t *testing.T, key crypto.PrivateKey, id string, addr string, protocol string, pit *peerinfo.PeerInfoTable,
 	initF func(n *Net) error, msgHandleF func(n *Net, msg *defines.Message) error) *Net {
 
 	opt := &Option{
 		Id:                  id,
 		Addr:                addr,
+		Key:                 key,
+		Protocol:            protocol,
 		Listener:            nil,
 		Dialer:              nil,
+		Pit:                 pit,
 		MsgIn:               make(chan *defines.MessageWithError, 10),
 		MsgOut:              make(chan *defines.Message, 10),
 		CustomInitFunc:      initF,
 		CustomMsgHandleFunc: msgHandleF,
-		LogDest:             log.LogDest_Stderr,
 	}
 
 	peer, err := NewNet(opt)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
 	err = peer.Init()
@@ -49,82 +50,110 @@ func runPeer(t *testing.T, id string, addr string,
 	return peer
 }
 
-// 测试Net的创建与输入输出
+// 新建并初始化节点信息表，预先存入infos
+// 测试节点以种子身份记录自己，以便接受表中没有的节点连入
+func newTestPit(t *testing.T, id string, infos ...*defines.PeerInfo) *peerinfo.PeerInfoTable {
+	pit, err := peerinfo.NewPeerInfoTable(id, getKvStoreWithPairs(infos...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pit.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err = pit.Set(&defines.PeerInfo{Id: id, Addr: "127.0.0.1:0", Duty: defines.PeerDuty_Seed}); err != nil {
+		t.Fatal(err)
+	}
+	return pit
+}
+
+// 测试Net的创建与输入输出，分别使用明文和加密的传输协议
 func TestNet(t *testing.T) {
+	t.Run(Protocol_BTCP, func(t *testing.T) {
+		testNet(t, Protocol_BTCP, "127.0.0.1:8091", "127.0.0.1:8092")
+	})
+	t.Run(Protocol_BTLS, func(t *testing.T) {
+		testNet(t, Protocol_BTLS, "127.0.0.1:8093", "127.0.0.1:8094")
+	})
+}
 
-	// peerA和peerB使用的PeerInfoTable
-	store := getKvStoreWithPairs(&defines.PeerInfo{
-		Id:   "peerA",
-		Addr: "127.0.0.1:8091",
-		Data: nil,
+func testNet(t *testing.T, protocol, addrA, addrB string) {
+	keyA, idA := genKey(t)
+	keyB, idB := genKey(t)
+
+	log.InitGlobalLogger(idA, false, false)
+	log.InitGlobalLogger(idB, false, false)
+
+	// peerA只知道自己；peerB预先知道peerA，包括其公钥
+	pitA := newTestPit(t, idA)
+	defer pitA.Close()
+	pitB := newTestPit(t, idB, &defines.PeerInfo{
+		Id:     idA,
+		Addr:   addrA,
+		PubKey: crypto.MarshalPublicKey(keyA.Public()),
 	})
-	err := peerinfo.Init(store)
-	if err != nil {
-		t.Error(err)
-	}
+	defer pitB.Close()
+
+	done := make(chan struct{})
 
 	// peerA作为接受请求端
-	peerA := runPeer(t, "peerA", "127.0.0.1:8091",
+	peerA := runPeer(t, keyA, idA, addrA, protocol, pitA,
 		nil,
 		func(n *Net, msg *defines.Message) error {
-			// peerA单纯地将msg回显
-			fmt.Printf("22222222222222222\n")
+			// peerA将msg以自己的身份回显
 			msg.From, msg.To = msg.To, msg.From
+			if err := msg.Sign(keyA); err != nil {
+				return err
+			}
 			merr := &defines.MessageWithError{
 				Msg: msg,
-				Err: make(chan error),
+				Err: make(chan error, 1),
 			}
 			n.msgin <- merr
-			fmt.Printf("merr: %v\n", <-merr.Err)
-
-			//n.Close()
-			return nil
+			return <-merr.Err
 		})
-
-	fmt.Printf("0000000000000000\n")
+	defer peerA.Close()
 
 	// peerB作为主动发信端
-	peerB := runPeer(t, "peerB", "127.0.0.1:8092",
+	peerB := runPeer(t, keyB, idB, addrB, protocol, pitB,
 		func(n *Net) error { // 节点B作为主动的一方，需要主动与peerA发送消息
-			fmt.Printf("0000111100001111\n")
+			msg := &defines.Message{
+				Version: defines.CodeVersion,
+				Type:    defines.MessageType_Data,
+				From:    idB,
+				To:      idA,
+				Kind:    defines.MessageKind_Transaction,
+			}
+			if err := msg.Sign(keyB); err != nil {
+				return err
+			}
 			merr := &defines.MessageWithError{
-				Msg: &defines.Message{
-					Version: defines.CodeVersion,
-					Type:    defines.MessageType_Data,
-					From:    "peerB",
-					To:      "peerA",
-					Sig:     []byte("signature"),
-					Desc:    "test message",
-				},
-				Err: make(chan error),
+				Msg: msg,
+				Err: make(chan error, 1),
 			}
 			n.msgin <- merr
-			fmt.Printf("merr: %v\n", <-merr.Err)
-			fmt.Printf("111111111111111\n")
-			return nil
+			return <-merr.Err
 		},
 		func(n *Net, msg *defines.Message) error {
-			// 收到的消息进行比较
-			if !reflect.DeepEqual(msg, &defines.Message{
-				Version: defines.CodeVersion,
-				Type:    defines.MessageType_Data,
-				From:    "peerA",
-				To:      "peerB",
-				Sig:     []byte("signature"),
-				Desc:    "test message",
-			}) {
-				t.Error("error!!!!")
+			// 收到的回显应是peerA签名的同一条消息
+			if msg.From != idA || msg.To != idB || msg.Kind != defines.MessageKind_Transaction {
+				t.Errorf("unexpected msg: %v", msg)
 			}
-			t.Log("success!!!!")
-
-			//n.Close()
+			if err := msg.Verify(keyA.Public()); err != nil {
+				t.Error(err)
+			}
+			close(done)
 			return nil
 		})
+	defer peerB.Close()
 
-	// 等待1s，避免直接退出
-	time.Sleep(1 * time.Second)
-	peerA.Close()
-	peerB.Close()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Error("timeout waiting for echo")
+	}
+	if peerA.Network() != peerB.Network() {
+		t.Errorf("network mismatch: %s != %s", peerA.Network(), peerB.Network())
+	}
 }
 
 ///////////////////////////////////
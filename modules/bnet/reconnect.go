@@ -0,0 +1,200 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/19/20 10:40 AM
+* @Description: 连接的生命周期管理：断线清理、退避重连与连接事件
+***********************************************************************/
+
+package bnet
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	连接表中的每个Conn都在RecvLoop中阻塞读，对端关闭、网络异常、发送失败(send中主动Close)
+	都会使RecvLoop退出并回调onConnClosed：
+		1. 若该Conn仍在连接表中，将其移除，通知ConnEvent_Disconnected，并开始重连
+		2. 若连接表中已经是另一个Conn或已被dropConn移除，什么也不做
+	新连接与连接表中仍然存活的连接冲突时(见addConn)：
+		- 对端再次连入，替换并关闭旧的连入连接。对端只在它那边没有可用连接时才会连入，旧的一定已经失效
+		- 双方同时互连，保留自己连出的连接用于发送，连入的连接只负责接收，不记入连接表
+	重连以指数退避进行，间隔在[ReconnectMin, ReconnectMax]之间并带±20%的随机抖动，避免集群重启后同时重连。
+	重连成功、对端主动连入、节点被移出节点表或Net关闭时停止重连。
+*/
+
+const (
+	DefaultReconnectMin = 200 * time.Millisecond
+	DefaultReconnectMax = 30 * time.Second
+
+	// reconnectJitter 重连间隔的随机抖动比例
+	reconnectJitter = 0.2
+)
+
+// SubscribeConnEvent 订阅连接事件
+// f在网络模块内部的goroutine中被同步调用，不应阻塞
+func (n *Net) SubscribeConnEvent(f func(ev *defines.ConnEvent)) {
+	if f == nil {
+		return
+	}
+	n.connSubsLock.Lock()
+	n.connSubs = append(n.connSubs, f)
+	n.connSubsLock.Unlock()
+}
+
+func (n *Net) publishConnEvent(ev *defines.ConnEvent) {
+	n.Debugf("%s", ev)
+	n.connSubsLock.RLock()
+	subs := n.connSubs
+	n.connSubsLock.RUnlock()
+	for _, f := range subs {
+		f(ev)
+	}
+}
+
+// newConn 封装c，c传输过来的消息会写到msgout传出去
+// outbound表示c是自己连出的
+func (n *Net) newConn(c requires.Conn, outbound bool) *Conn {
+	conn := ToConn(c, n.msgout)
+	conn.SetTimeout(n.ConnTimeout())
+	conn.outbound = outbound
+	conn.onClose = n.onConnClosed
+	if n.gossip != nil {
+		conn.onGossip = n.handleGossip
+	}
+	return conn
+}
+
+// addConn 记录并启动与id的连接，返回之后与id通信使用的连接
+func (n *Net) addConn(id string, c *Conn) *Conn {
+	n.connsLock.Lock()
+	if n.closed() { // 与Close并发时，不再记录新连接
+		n.connsLock.Unlock()
+		c.Close()
+		return c
+	}
+	old := n.conns[id]
+	alive := old != nil && !old.Closed()
+	switch {
+	case !alive || (!old.outbound && !c.outbound):
+		n.conns[id] = c
+	case c.outbound:
+		// 连出期间对端已经连入，使用已有的连接
+		n.connsLock.Unlock()
+		c.Close()
+		return old
+	default:
+		// 同时互连，连入的连接只接收
+		n.connsLock.Unlock()
+		n.startConn(c)
+		return old
+	}
+	n.connsLock.Unlock()
+
+	if alive {
+		old.Close()
+		n.Infof("stale conn from %s replaced", identity.Short(id))
+	}
+	n.startConn(c)
+	if !alive {
+		n.markLiveness(id, true)
+		n.publishConnEvent(&defines.ConnEvent{Id: id, Type: defines.ConnEvent_Connected})
+	}
+	return c
+}
+
+// onConnClosed Conn.RecvLoop退出时回调
+func (n *Net) onConnClosed(c *Conn, err error) {
+	id := c.conn.RemoteID()
+	n.connsLock.Lock()
+	evicted := n.conns[id] == c
+	if evicted {
+		delete(n.conns, id)
+	}
+	n.connsLock.Unlock()
+	if !evicted || n.closed() {
+		return
+	}
+
+	n.Warnf("conn to %s lost: %s", identity.Short(id), err)
+	n.markLiveness(id, false)
+	n.publishConnEvent(&defines.ConnEvent{Id: id, Type: defines.ConnEvent_Disconnected, Err: err})
+	n.scheduleReconnect(id)
+}
+
+// scheduleReconnect 启动对id的重连，已在重连中则忽略
+func (n *Net) scheduleReconnect(id string) {
+	n.reconnectingLock.Lock()
+	defer n.reconnectingLock.Unlock()
+	if _, ok := n.reconnecting[id]; ok {
+		return
+	}
+	n.reconnecting[id] = struct{}{}
+	go n.reconnectLoop(id)
+}
+
+// reconnectLoop 以指数退避重连id
+func (n *Net) reconnectLoop(id string) {
+	defer func() {
+		n.reconnectingLock.Lock()
+		delete(n.reconnecting, id)
+		n.reconnectingLock.Unlock()
+	}()
+
+	backoff := n.reconnectMin
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-n.done:
+			return
+		case <-time.After(jitter(backoff)):
+		}
+
+		// 对端已经主动连入
+		n.connsLock.RLock()
+		c := n.conns[id]
+		n.connsLock.RUnlock()
+		if c != nil && !c.Closed() {
+			return
+		}
+		// 已被移出节点表，不再需要
+		if _, err := n.pit.Get(id); err != nil {
+			n.Infof("stop reconnecting %s: %s", identity.Short(id), err)
+			return
+		}
+
+		if _, err := n.connect(id); err == nil {
+			n.Infof("reconnected to %s after %d attempts", identity.Short(id), attempt)
+			return
+		} else {
+			n.Debugf("reconnect to %s (attempt %d) fail: %s", identity.Short(id), attempt, err)
+		}
+
+		backoff *= 2
+		if backoff > n.reconnectMax {
+			backoff = n.reconnectMax
+		}
+	}
+}
+
+// closed Net是否已关闭
+func (n *Net) closed() bool {
+	select {
+	case <-n.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// jitter 在d的基础上随机浮动±reconnectJitter
+func jitter(d time.Duration) time.Duration {
+	delta := int64(float64(d) * reconnectJitter)
+	if delta <= 0 {
+		return d
+	}
+	return d - time.Duration(delta) + time.Duration(rand.Int63n(2*delta+1))
+}
@@ -0,0 +1,97 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/19/20 3:00 PM
+* @Description: 断线重连测试
+***********************************************************************/
+
+package bnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+// 对端重启后自动重连，并通知连接事件
+func TestNet_Reconnect(t *testing.T) {
+	keyA, idA := genKey(t)
+	keyB, idB := genKey(t)
+	addrA, addrB := "127.0.0.1:8103", "127.0.0.1:8104"
+	log.InitGlobalLogger(idA, false, false)
+	log.InitGlobalLogger(idB, false, false)
+
+	newNet := func(key crypto.PrivateKey, id, addr string, infos ...*defines.PeerInfo) *Net {
+		pit := newTestPit(t, id, infos...)
+		n, err := NewNet(&Option{
+			Id:           id,
+			Addr:         addr,
+			Key:          key,
+			Protocol:     Protocol_BTCP,
+			Pit:          pit,
+			MsgIn:        make(chan *defines.MessageWithError, 10),
+			MsgOut:       make(chan *defines.Message, 10),
+			ReconnectMin: 20 * time.Millisecond,
+			ReconnectMax: 100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	peerA := newNet(keyA, idA, addrA)
+	if err := peerA.Init(); err != nil {
+		t.Fatal(err)
+	}
+	peerB := newNet(keyB, idB, addrB, &defines.PeerInfo{
+		Id:     idA,
+		Addr:   addrA,
+		PubKey: crypto.MarshalPublicKey(keyA.Public()),
+	})
+	events := make(chan *defines.ConnEvent, 10)
+	peerB.SubscribeConnEvent(func(ev *defines.ConnEvent) {
+		events <- ev
+	})
+	if err := peerB.Init(); err != nil { // Init时连接A
+		t.Fatal(err)
+	}
+	defer peerB.Close()
+
+	expect := func(typ defines.ConnEventType) {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Id != idA || ev.Type != typ {
+				t.Fatalf("event = %s, want %s of A", ev, typ)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for %s", typ)
+		}
+	}
+	expect(defines.ConnEvent_Connected)
+
+	// A重启
+	peerA.Close()
+	expect(defines.ConnEvent_Disconnected)
+	peerA = newNet(keyA, idA, addrA)
+	if err := peerA.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer peerA.Close()
+	expect(defines.ConnEvent_Connected)
+
+	if err := peerB.send(idA, genTestMsg(t, keyB, idB, idA)); err != nil {
+		t.Errorf("send after reconnect: %s", err)
+	}
+	select {
+	case msg := <-peerA.msgout:
+		if msg.From != idB {
+			t.Errorf("unexpected msg: %v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("timeout waiting for msg after reconnect")
+	}
+}
@@ -0,0 +1,369 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/21/20 9:30 AM
+* @Description: 每个对端一个有界的发送队列
+***********************************************************************/
+
+package bnet
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	msgSendLoop只负责把msgin中的消息分发到目标节点的发送队列，不做任何网络IO。
+	每个队列有自己的写goroutine，一个对端变慢或卡住只会堆积它自己的队列，不影响发往其他节点的消息。
+
+	队列满时按消息的丢弃策略处理(见dropPolicyOf):
+		DropPolicy_Newest	拒绝新消息，返回ErrQueueFull。一般消息的默认策略
+		DropPolicy_Oldest	丢弃队列中最老的可丢弃消息，返回ErrDropped。证明只有最新的有意义
+		DropPolicy_Never	不丢弃。先挤掉最老的可丢弃消息，没有可挤的就超出容量入队。区块丢了只能靠补取，代价大
+
+	发送结果通过MessageWithError.Err异步回传，写goroutine不会因为没人读Err而阻塞，
+	所以Err至少要有1的缓冲，否则结果可能被丢弃
+
+	队列空闲超过queueIdleTimeout后从Net.queues移除，写goroutine随之退出，下次发往该节点时再创建。
+	节点被dropConn断开(例如被移出配置)时，队列立即移除，其中未发送的消息以ErrPeerDropped结束。
+*/
+
+const (
+	DefaultSendQueueCap = 256
+	// DefaultQueueIdleTimeout 发送队列空闲多久后移除
+	DefaultQueueIdleTimeout = time.Minute
+)
+
+var (
+	ErrQueueFull   = errors.New("send queue full")
+	ErrDropped     = errors.New("dropped from send queue")
+	ErrNetClosed   = errors.New("net closed")
+	ErrPeerDropped = errors.New("peer dropped")
+
+	// errQueueRetired 队列已被移除，enqueueTo据此换一个新队列
+	errQueueRetired = errors.New("send queue retired")
+)
+
+// DropPolicy 发送队列满时的丢弃策略
+type DropPolicy uint8
+
+const (
+	DropPolicy_Newest DropPolicy = 0
+	DropPolicy_Oldest DropPolicy = 1
+	DropPolicy_Never  DropPolicy = 2
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case DropPolicy_Newest:
+		return "drop-newest"
+	case DropPolicy_Oldest:
+		return "drop-oldest"
+	case DropPolicy_Never:
+		return "never-drop"
+	default:
+		return "unknown"
+	}
+}
+
+// dropPolicyOf 按消息携带的条目决定丢弃策略
+// 带区块的消息不丢弃；只带证明的消息丢弃最老的；其他拒绝新的
+func dropPolicyOf(msg *defines.Message) DropPolicy {
+	if len(msg.Entries) == 0 || msg.Type.IsGossip() {
+		return DropPolicy_Newest
+	}
+	allProofs := true
+	for _, ent := range msg.Entries {
+		switch ent.Type {
+		case defines.EntryType_Block, defines.EntryType_NewBlock:
+			return DropPolicy_Never
+		case defines.EntryType_Proof:
+		default:
+			allProofs = false
+		}
+	}
+	if allProofs {
+		return DropPolicy_Oldest
+	}
+	return DropPolicy_Newest
+}
+
+// outMsg 发送队列中的一条消息
+// raw是msg编码后的字节，广播时同一份raw被放进多个队列；done接收发送结果，不能阻塞
+type outMsg struct {
+	msg  *defines.Message
+	raw  []byte
+	done func(err error)
+}
+
+// sendQueue 发往某个节点的消息队列
+type sendQueue struct {
+	to    string
+	cap   int
+	items *list.List // 元素为*outMsg
+	lock  *sync.Mutex
+
+	// 有新消息时通知写goroutine，缓冲为1，多次通知合并为一次
+	notify chan struct{}
+
+	retired bool          // 已从Net.queues移除，不再接受新消息
+	stop    chan struct{} // retire时关闭，通知写goroutine退出
+}
+
+func newSendQueue(to string, cap int) *sendQueue {
+	return &sendQueue{
+		to:     to,
+		cap:    cap,
+		items:  list.New(),
+		lock:   new(sync.Mutex),
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+}
+
+// push 入队，返回因此被丢弃的消息(可能是msg本身)及原因
+// 队列已被移除时返回msg与errQueueRetired
+func (q *sendQueue) push(msg *outMsg) (dropped *outMsg, reason error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.retired {
+		return msg, errQueueRetired
+	}
+	if q.items.Len() >= q.cap {
+		switch dropPolicyOf(msg.msg) {
+		case DropPolicy_Newest:
+			return msg, ErrQueueFull
+		case DropPolicy_Oldest, DropPolicy_Never:
+			if e := q.oldestDroppable(); e != nil {
+				dropped, reason = q.items.Remove(e).(*outMsg), ErrDropped
+			} else if dropPolicyOf(msg.msg) == DropPolicy_Oldest {
+				// 队列里全是不可丢弃的消息
+				return msg, ErrQueueFull
+			}
+		}
+	}
+	q.items.PushBack(msg)
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return dropped, reason
+}
+
+// oldestDroppable 队列中最老的可丢弃消息
+func (q *sendQueue) oldestDroppable() *list.Element {
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		if dropPolicyOf(e.Value.(*outMsg).msg) != DropPolicy_Never {
+			return e
+		}
+	}
+	return nil
+}
+
+// pop 出队，队列为空时返回nil
+func (q *sendQueue) pop() *outMsg {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	e := q.items.Front()
+	if e == nil {
+		return nil
+	}
+	return q.items.Remove(e).(*outMsg)
+}
+
+// drain 清空队列，返回所有未发送的消息
+func (q *sendQueue) drain() []*outMsg {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	msgs := make([]*outMsg, 0, q.items.Len())
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		msgs = append(msgs, e.Value.(*outMsg))
+	}
+	q.items.Init()
+	return msgs
+}
+
+// retire 标记队列已移除并通知写goroutine退出，返回其中未发送的消息
+// idleOnly为true时只移除空队列。ok表示是否移除
+func (q *sendQueue) retire(idleOnly bool) (msgs []*outMsg, ok bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.retired || (idleOnly && q.items.Len() > 0) {
+		return nil, false
+	}
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		msgs = append(msgs, e.Value.(*outMsg))
+	}
+	q.items.Init()
+	q.retired = true
+	close(q.stop)
+	return msgs, true
+}
+
+func (q *sendQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.items.Len()
+}
+
+// enqueue 将单播消息编码后放入目标节点的发送队列，不阻塞
+func (n *Net) enqueue(msg *defines.MessageWithError) {
+	raw, err := msg.Msg.Encode()
+	if err != nil {
+		n.deliver(msg, err)
+		return
+	}
+	n.enqueueTo(msg.Msg.To, &outMsg{
+		msg:  msg.Msg,
+		raw:  raw,
+		done: func(err error) { n.deliver(msg, err) },
+	})
+}
+
+// enqueueTo 将msg放入发往to的队列，必要时创建队列及其写goroutine
+func (n *Net) enqueueTo(to string, msg *outMsg) {
+	var q *sendQueue
+	for {
+		n.queuesLock.Lock()
+		q = n.queues[to]
+		if q == nil {
+			if n.closed() {
+				n.queuesLock.Unlock()
+				msg.done(ErrNetClosed)
+				return
+			}
+			q = newSendQueue(to, n.sendQueueCap)
+			n.queues[to] = q
+			go n.writeLoop(q)
+		}
+		n.queuesLock.Unlock()
+
+		dropped, reason := q.push(msg)
+		if reason == errQueueRetired { // 取到队列后它刚好空闲超时被移除
+			continue
+		}
+		if dropped != nil {
+			n.Warnf("enqueue: drop msg(%s) to %s: %s", dropped.msg.Kind, identity.Short(to), reason)
+			dropped.done(reason)
+		}
+		break
+	}
+	// 写goroutine可能已经退出，不会再处理这个队列
+	if n.closed() {
+		for _, msg := range q.drain() {
+			msg.done(ErrNetClosed)
+		}
+	}
+}
+
+// writeLoop 按序发送q中的消息，直到Net关闭、队列空闲超时或被移除
+func (n *Net) writeLoop(q *sendQueue) {
+	idle := time.NewTimer(n.queueIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case <-n.done:
+			for _, msg := range q.drain() {
+				msg.done(ErrNetClosed)
+			}
+			return
+		case <-q.stop:
+			return
+		case <-idle.C:
+			if n.removeQueue(q, true) {
+				n.Debugf("writeLoop: queue to %s idle, removed", identity.Short(q.to))
+				return
+			}
+			idle.Reset(n.queueIdleTimeout)
+			continue
+		case <-q.notify:
+		}
+
+		for msg := q.pop(); msg != nil; msg = q.pop() {
+			if err := n.sendRaw(q.to, msg.raw); err != nil {
+				n.Errorf("writeLoop: send msg(%s) to %s fail: msg=%v, err=%s", msg.msg.Kind, identity.Short(q.to), msg.msg, err)
+				msg.done(err)
+			} else {
+				n.Debugf("writeLoop: send msg(%s) to %s succ: msg=%v", msg.msg.Kind, identity.Short(q.to), msg.msg)
+				msg.done(nil)
+			}
+			if n.closed() {
+				break
+			}
+		}
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(n.queueIdleTimeout)
+	}
+}
+
+// removeQueue 从Net.queues移除q，idleOnly为true时只移除空队列，返回是否移除
+// 未发送的消息以ErrPeerDropped结束
+func (n *Net) removeQueue(q *sendQueue, idleOnly bool) bool {
+	n.queuesLock.Lock()
+	if n.queues[q.to] != q {
+		n.queuesLock.Unlock()
+		return false
+	}
+	msgs, ok := q.retire(idleOnly)
+	if ok {
+		delete(n.queues, q.to)
+	}
+	n.queuesLock.Unlock()
+
+	for _, msg := range msgs {
+		msg.done(ErrPeerDropped)
+	}
+	return ok
+}
+
+// dropQueue 移除发往id的队列
+func (n *Net) dropQueue(id string) {
+	n.queuesLock.Lock()
+	q := n.queues[id]
+	n.queuesLock.Unlock()
+	if q != nil {
+		n.removeQueue(q, false)
+	}
+}
+
+// deliver 回传发送结果，没有缓冲空间时丢弃结果，不阻塞
+func (n *Net) deliver(msg *defines.MessageWithError, err error) {
+	select {
+	case msg.Err <- err:
+	default:
+		n.Debugf("deliver: result of msg(%s) to %s discarded", msg.Msg.Kind, identity.Short(msg.Msg.To))
+	}
+}
+
+// QueueDepth 发往id的消息中排队等待发送的数量
+func (n *Net) QueueDepth(id string) int {
+	n.queuesLock.Lock()
+	q := n.queues[id]
+	n.queuesLock.Unlock()
+	if q == nil {
+		return 0
+	}
+	return q.len()
+}
+
+// QueueDepths 所有发送队列的排队数量 <id, depth>
+func (n *Net) QueueDepths() map[string]int {
+	n.queuesLock.Lock()
+	defer n.queuesLock.Unlock()
+	depths := make(map[string]int, len(n.queues))
+	for id, q := range n.queues {
+		depths[id] = q.len()
+	}
+	return depths
+}
@@ -0,0 +1,324 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/21/20 11:00 AM
+* @Description: 发送队列测试
+***********************************************************************/
+
+package bnet
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+func queuedMsg(typ defines.EntryType, name string) *outMsg {
+	return &outMsg{
+		msg: &defines.Message{
+			From:    name,
+			To:      "peer",
+			Entries: []*defines.Entry{{Type: typ}},
+		},
+		done: func(err error) {},
+	}
+}
+
+func queuedNames(q *sendQueue) []string {
+	var names []string
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		names = append(names, e.Value.(*outMsg).msg.From)
+	}
+	return names
+}
+
+func Test_sendQueue_push(t *testing.T) {
+	q := newSendQueue("peer", 2)
+	q.push(queuedMsg(defines.EntryType_Proof, "p1"))
+	q.push(queuedMsg(defines.EntryType_Proof, "p2"))
+
+	// 证明挤掉最老的证明
+	dropped, reason := q.push(queuedMsg(defines.EntryType_Proof, "p3"))
+	if dropped == nil || dropped.msg.From != "p1" || !errors.Is(reason, ErrDropped) {
+		t.Fatalf("push p3: dropped=%v, reason=%v", dropped, reason)
+	}
+	// 区块挤掉最老的证明
+	if dropped, _ = q.push(queuedMsg(defines.EntryType_NewBlock, "b1")); dropped == nil || dropped.msg.From != "p2" {
+		t.Fatalf("push b1: dropped=%v", dropped)
+	}
+	// 一般消息被拒绝
+	tx := queuedMsg(defines.EntryType_Transaction, "tx")
+	if dropped, reason = q.push(tx); dropped != tx || !errors.Is(reason, ErrQueueFull) {
+		t.Fatalf("push tx: dropped=%v, reason=%v", dropped, reason)
+	}
+	if dropped, _ = q.push(queuedMsg(defines.EntryType_Block, "b2")); dropped == nil || dropped.msg.From != "p3" {
+		t.Fatalf("push b2: dropped=%v", dropped)
+	}
+	// 全是区块时，区块超出容量入队，证明被拒绝
+	if dropped, _ = q.push(queuedMsg(defines.EntryType_Block, "b3")); dropped != nil {
+		t.Fatalf("push b3: dropped=%v", dropped)
+	}
+	if dropped, reason = q.push(queuedMsg(defines.EntryType_Proof, "p4")); dropped == nil || !errors.Is(reason, ErrQueueFull) {
+		t.Fatalf("push p4: dropped=%v, reason=%v", dropped, reason)
+	}
+
+	want := []string{"b1", "b2", "b3"}
+	got := queuedNames(q)
+	if len(got) != len(want) {
+		t.Fatalf("queue = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("queue = %v, want %v", got, want)
+		}
+	}
+}
+
+// 卡住的对端不影响发往其他对端的消息
+func TestNet_SendQueueIsolation(t *testing.T) {
+	keyA, idA := genKey(t)
+	keyB, idB := genKey(t)
+	keyC, idC := genKey(t)
+	addrA, addrB, addrC := "127.0.0.1:8109", "127.0.0.1:8110", "127.0.0.1:8111"
+	log.InitGlobalLogger(idA, false, false)
+	log.InitGlobalLogger(idB, false, false)
+
+	// C接受TCP连接但从不握手，向C建连会一直卡到握手超时
+	lnC, err := net.Listen("tcp", addrC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lnC.Close()
+	go func() {
+		for {
+			c, err := lnC.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	peerB, err := NewNet(&Option{
+		Id:       idB,
+		Addr:     addrB,
+		Key:      keyB,
+		Protocol: Protocol_BTCP,
+		Pit:      newTestPit(t, idB),
+		MsgIn:    make(chan *defines.MessageWithError, 10),
+		MsgOut:   make(chan *defines.Message, 10),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peerB.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer peerB.Close()
+
+	msgin := make(chan *defines.MessageWithError, 10)
+	peerA, err := NewNet(&Option{
+		Id:       idA,
+		Addr:     addrA,
+		Key:      keyA,
+		Protocol: Protocol_BTCP,
+		Pit: newTestPit(t, idA, &defines.PeerInfo{
+			Id:     idB,
+			Addr:   addrB,
+			PubKey: crypto.MarshalPublicKey(keyB.Public()),
+		}, &defines.PeerInfo{
+			Id:     idC,
+			Addr:   addrC,
+			PubKey: crypto.MarshalPublicKey(keyC.Public()),
+		}),
+		MsgIn:             msgin,
+		MsgOut:            make(chan *defines.Message, 10),
+		KeepaliveInterval: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不调用Init，避免启动时就去连接C，只启动发送循环
+	go peerA.msgSendLoop()
+	defer peerA.Close()
+
+	toC := &defines.MessageWithError{Msg: genTestMsg(t, keyA, idA, idC), Err: make(chan error, 1)}
+	msgin <- toC
+	toC2 := &defines.MessageWithError{Msg: genTestMsg(t, keyA, idA, idC), Err: make(chan error, 1)}
+	msgin <- toC2
+	toB := &defines.MessageWithError{Msg: genTestMsg(t, keyA, idA, idB), Err: make(chan error, 1)}
+	msgin <- toB
+
+	select {
+	case err := <-toB.Err:
+		if err != nil {
+			t.Fatalf("send to B: %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("send to B blocked by C")
+	}
+	select {
+	case msg := <-peerB.msgout:
+		if msg.From != idA {
+			t.Errorf("unexpected msg: %v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("timeout waiting for msg at B")
+	}
+	// 第一条正在建连，第二条还在排队
+	if depth := peerA.QueueDepth(idC); depth != 1 {
+		t.Errorf("queue depth of C = %d, want 1", depth)
+	}
+	if depths := peerA.QueueDepths(); depths[idB] != 0 || depths[idC] != 1 {
+		t.Errorf("queue depths = %v", depths)
+	}
+}
+
+func TestNet_SendQueueRetire(t *testing.T) {
+	keyA, idA := genKey(t)
+	keyC, idC := genKey(t)
+	keyD, idD := genKey(t)
+	addrA, addrC, addrD := "127.0.0.1:8123", "127.0.0.1:8124", "127.0.0.1:8125"
+	log.InitGlobalLogger(idA, false, false)
+
+	// C接受TCP连接但从不握手；D没有监听
+	lnC, err := net.Listen("tcp", addrC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lnC.Close()
+	go func() {
+		for {
+			c, err := lnC.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	msgin := make(chan *defines.MessageWithError, 10)
+	peerA, err := NewNet(&Option{
+		Id:       idA,
+		Addr:     addrA,
+		Key:      keyA,
+		Protocol: Protocol_BTCP,
+		Pit: newTestPit(t, idA, &defines.PeerInfo{
+			Id:     idC,
+			Addr:   addrC,
+			PubKey: crypto.MarshalPublicKey(keyC.Public()),
+		}, &defines.PeerInfo{
+			Id:     idD,
+			Addr:   addrD,
+			PubKey: crypto.MarshalPublicKey(keyD.Public()),
+		}),
+		MsgIn:             msgin,
+		MsgOut:            make(chan *defines.Message, 10),
+		KeepaliveInterval: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerA.queueIdleTimeout = 100 * time.Millisecond
+	go peerA.msgSendLoop()
+	defer peerA.Close()
+
+	hasQueue := func(id string) bool {
+		_, ok := peerA.QueueDepths()[id]
+		return ok
+	}
+
+	// 发送失败后队列空闲，超时后被移除
+	toD := &defines.MessageWithError{Msg: genTestMsg(t, keyA, idA, idD), Err: make(chan error, 1)}
+	msgin <- toD
+	select {
+	case err := <-toD.Err:
+		if err == nil {
+			t.Fatal("send to D should fail")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for result of msg to D")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for hasQueue(idD) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if hasQueue(idD) {
+		t.Error("idle queue of D not removed")
+	}
+
+	// 之后再发往D，重新创建队列
+	toD2 := &defines.MessageWithError{Msg: genTestMsg(t, keyA, idA, idD), Err: make(chan error, 1)}
+	msgin <- toD2
+	select {
+	case <-toD2.Err:
+	case <-time.After(2 * time.Second):
+		t.Fatal("msg to D after queue removal not sent")
+	}
+
+	// 第一条卡在握手，第二条排队；断开C后排队的消息以ErrPeerDropped结束
+	toC := &defines.MessageWithError{Msg: genTestMsg(t, keyA, idA, idC), Err: make(chan error, 1)}
+	toC2 := &defines.MessageWithError{Msg: genTestMsg(t, keyA, idA, idC), Err: make(chan error, 1)}
+	msgin <- toC
+	msgin <- toC2
+	deadline = time.Now().Add(2 * time.Second)
+	for peerA.QueueDepth(idC) != 1 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	peerA.dropConn(idC)
+	select {
+	case err := <-toC2.Err:
+		if !errors.Is(err, ErrPeerDropped) {
+			t.Errorf("err = %v, want %v", err, ErrPeerDropped)
+		}
+	case <-time.After(time.Second):
+		t.Error("queued msg to dropped C not finished")
+	}
+	if hasQueue(idC) {
+		t.Error("queue of dropped C not removed")
+	}
+}
+
+// 收到nil消息不应使发送循环崩溃
+func TestNet_SendLoopNilMsg(t *testing.T) {
+	keyA, idA := genKey(t)
+	_, idB := genKey(t)
+	log.InitGlobalLogger(idA, false, false)
+
+	msgin := make(chan *defines.MessageWithError, 10)
+	peerA, err := NewNet(&Option{
+		Id:                idA,
+		Addr:              "127.0.0.1:8126",
+		Key:               keyA,
+		Protocol:          Protocol_BTCP,
+		Pit:               newTestPit(t, idA),
+		MsgIn:             msgin,
+		MsgOut:            make(chan *defines.Message, 10),
+		KeepaliveInterval: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go peerA.msgSendLoop()
+	defer peerA.Close()
+
+	msgin <- nil
+	msgin <- &defines.MessageWithError{Err: make(chan error, 1)}
+	// 发送循环仍在运行，则能回传这条不合法消息的错误
+	bad := genTestMsg(t, keyA, idA, idB)
+	bad.From = ""
+	merr := &defines.MessageWithError{Msg: bad, Err: make(chan error, 1)}
+	msgin <- merr
+	select {
+	case err := <-merr.Err:
+		if err == nil {
+			t.Error("invalid msg accepted")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("msgSendLoop stopped")
+	}
+}
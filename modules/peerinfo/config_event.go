@@ -0,0 +1,53 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/18/20 5:00 PM
+* @Description: 应用配置热更新中的seeds/peers变化
+***********************************************************************/
+
+package peerinfo
+
+import (
+	"github.com/azd1997/blockchain-consensus/config"
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+// HandleConfigEvent 订阅config.Reloader，应用[seeds]/[peers]的变化，其他事件忽略
+func (pit *PeerInfoTable) HandleConfigEvent(ev config.Event) {
+	switch e := ev.(type) {
+	case *config.SeedsChanged:
+		pit.applyNodesChange(defines.PeerDuty_Seed, e.Added, e.Removed)
+	case *config.PeersChanged:
+		pit.applyNodesChange(defines.PeerDuty_Peer, e.Added, e.Removed)
+	}
+}
+
+// applyNodesChange 新增或更新地址，删除被移出配置的节点
+// 事件中的id已由config.Reloader解码校验
+func (pit *PeerInfoTable) applyNodesChange(duty defines.PeerDuty, added, removed map[string]string) {
+	var err error
+	for id := range removed {
+		if duty == defines.PeerDuty_Seed {
+			err = pit.DelSeed(id)
+		} else {
+			err = pit.Del(id)
+		}
+		if err != nil {
+			pit.Warnf("remove %s fail: %s", identity.Short(id), err)
+		}
+	}
+
+	for id, addr := range added {
+		info := &defines.PeerInfo{Id: id, Addr: addr, Duty: duty}
+		// 已有记录只更新地址，保留握手时记下的公钥和属性
+		if old, _ := pit.Get(id); old != nil && old.Duty == duty {
+			ni := *old
+			ni.Addr = addr
+			info = &ni
+		}
+		if err := pit.Set(info); err != nil {
+			pit.Warnf("add %s fail: %s", identity.Short(id), err)
+		}
+	}
+	pit.Infof("config applied: %d %s added or updated, %d removed", len(added), duty, len(removed))
+}
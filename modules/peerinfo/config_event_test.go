@@ -0,0 +1,94 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/18/20 5:30 PM
+* @Description: 配置热更新事件测试
+***********************************************************************/
+
+package peerinfo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/azd1997/blockchain-consensus/config"
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+func TestPeerInfoTable_HandleConfigEvent(t *testing.T) {
+	ids := genTestIds(t, 3)
+	self, seed1, seed2 := ids[0], ids[1], ids[2]
+	peerKey, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer1, pk := identity.FromPublicKey(peerKey.Public()), crypto.MarshalPublicKey(peerKey.Public())
+
+	log.InitGlobalLogger(self, false, false)
+	tkv := &test.Store{
+		Cfs: map[requires.CF]bool{},
+		Kvs: map[string]string{},
+	}
+	pit, err := NewPeerInfoTable(self, tkv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer pit.Close()
+	if err := pit.AddSeeds(map[string]string{seed1: "addr1", seed2: "addr2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.Set(&defines.PeerInfo{Id: peer1, Addr: "addr3", Duty: defines.PeerDuty_Peer, PubKey: pk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 删除seed2，修改peer1的地址
+	pit.HandleConfigEvent(&config.SeedsChanged{
+		Added:   map[string]string{},
+		Removed: map[string]string{seed2: "addr2"},
+	})
+	pit.HandleConfigEvent(&config.PeersChanged{
+		Added:   map[string]string{peer1: "addr4"},
+		Removed: map[string]string{},
+	})
+
+	if pit.IsSeed(seed2) || pit.NSeed() != 1 {
+		t.Errorf("seed2 not removed: nSeed = %d", pit.NSeed())
+	}
+	info, err := pit.Get(peer1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Addr != "addr4" || !bytes.Equal(info.PubKey, pk) {
+		t.Errorf("peer1 = %s, want addr4 with pubkey kept", info)
+	}
+
+	// merge后被删除的种子也不会从kv中复活
+	if err := pit.merge(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.load(); err != nil {
+		t.Fatal(err)
+	}
+	if pit.IsSeed(seed2) {
+		t.Error("removed seed2 restored from kv")
+	}
+
+	// 删除peer1
+	pit.HandleConfigEvent(&config.PeersChanged{
+		Added:   map[string]string{},
+		Removed: map[string]string{peer1: "addr4"},
+	})
+	if _, err := pit.Get(peer1); err == nil {
+		t.Error("peer1 not removed")
+	}
+}
@@ -7,6 +7,7 @@
 package peerinfo
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"sync"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/azd1997/blockchain-consensus/defines"
 	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 	"github.com/azd1997/blockchain-consensus/utils/log"
 )
 
@@ -45,16 +47,19 @@ const (
 	Module_Pit           = "PIT"
 )
 
+// ErrUnknownPeer 非种子节点拒绝节点表中没有的节点连入
+var ErrUnknownPeer = errors.New("unknown peer")
+
 // PeerInfoTable 节点信息表
 // 节点信息表实际在使用时，一般是不会删除数据的，只会新增
 // 但是后序会考虑到节点的断连、恶意等，需要删除操作
 type PeerInfoTable struct {
 	id string // 自己的账号
 
-	// seeds目前设定为固定的配置，运行期间不修改其数据
-	// 因此使用期间不加锁
-	seeds map[string]*defines.PeerInfo
-	nSeed uint32 // 必须使用atomic包的方法加载和变更
+	// seeds来自配置，只在配置热更新时修改，直接读写而不经过dirty
+	seeds     map[string]*defines.PeerInfo
+	nSeed     uint32 // 必须使用atomic包的方法加载和变更
+	seedsLock *sync.RWMutex
 
 	nPeer     uint32 // 必须使用atomic包的方法加载和变更
 	peers     map[string]*defines.PeerInfo
@@ -89,6 +94,7 @@ func NewPeerInfoTable(id string, kv requires.Store) (*PeerInfoTable, error) {
 		seeds:         make(map[string]*defines.PeerInfo),
 		peers:         make(map[string]*defines.PeerInfo),
 		dirty:         make(map[string]*dirtyPeerInfo),
+		seedsLock:     new(sync.RWMutex),
 		peersLock:     new(sync.RWMutex),
 		dirtyLock:     new(sync.RWMutex),
 		kv:            kv,
@@ -182,76 +188,59 @@ func (pit *PeerInfoTable) load() error {
 
 // merge 将dirty刷合并到peers去，并且先更新到kv中
 // peers代表的是与kv存储引擎中一致的数据
+// 所有写操作放在同一个批次中提交，崩溃时kv要么是merge前的状态，要么是merge后的状态
 func (pit *PeerInfoTable) merge() error {
 
+	batch := pit.kv.NewBatch()
+
 	// 1. 将seeds合并
-	// 由于seeds数量少，直接全量覆盖
-	err := pit.kv.RangeCF(pit.seedsCF, func(key, value []byte) error {
-		pi := new(defines.PeerInfo)
-		err := pi.Decode(value)
-		if err != nil {
-			return err
-		}
-		pit.seeds[string(key)] = pi
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	for id := range pit.seeds {
-		b, err := pit.seeds[id].Encode()
-		if err != nil {
-			return err
-		}
-		err = pit.kv.Set(pit.seedsCF, []byte(id), b)
+	// 由于seeds数量少，直接全量覆盖。删除的seed在DelSeed时已从kv删除
+	for id, info := range pit.Seeds() {
+		b, err := info.Encode()
 		if err != nil {
 			return err
 		}
+		batch.Set(pit.seedsCF, []byte(id), b)
 	}
 
 	// 2. 将peers合并
 
-	// 将dirty合并到peers
-	pit.dirtyLock.RLock()
+	pit.dirtyLock.Lock()
+	defer pit.dirtyLock.Unlock()
 	pit.peersLock.Lock()
+	defer pit.peersLock.Unlock()
+
 	for id, dinfo := range pit.dirty {
-		_, ok := pit.peers[id]
 		switch dinfo.op {
 		case OpNone:
 			return errors.New("only OpDel or OpSet is permitted")
 		case OpDel:
-			if ok {
-				// 删除kv中该项
-				err := pit.kv.Del(pit.peersCF, []byte(id))
-				if err != nil {
-					return err
-				}
-				// 删除pit.peers中该项
-				delete(pit.peers, id)
-			} else {
-				return errors.New("OpDel occurred on a non-exist id")
-			}
+			// 新增后尚未merge就被删除的节点在kv中并不存在，删除也无妨
+			batch.Del(pit.peersCF, []byte(id))
 		case OpSet:
-			// 设置kv中该项
 			infobytes, err := dinfo.info.Encode()
 			if err != nil {
 				return err
 			}
-			err = pit.kv.Set(pit.peersCF, []byte(id), infobytes)
-			if err != nil {
-				return err
-			}
-			// 设置pit.peers中该项
-			pit.peers[id] = dinfo.info
+			batch.Set(pit.peersCF, []byte(id), infobytes)
 		}
 	}
-	pit.peersLock.Unlock()
-	pit.dirtyLock.RUnlock()
 
+	// 提交失败时dirty保持不变，下次merge重试
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+
+	// 将dirty合并到peers
+	for id, dinfo := range pit.dirty {
+		if dinfo.op == OpDel {
+			delete(pit.peers, id)
+		} else {
+			pit.peers[id] = dinfo.info
+		}
+	}
 	// 重置dirty
-	pit.dirtyLock.Lock()
 	pit.dirty = make(map[string]*dirtyPeerInfo)
-	pit.dirtyLock.Unlock()
 
 	return nil
 }
@@ -280,7 +269,10 @@ func (pit *PeerInfoTable) mergeLoop() {
 // Get 按id查询
 func (pit *PeerInfoTable) Get(id string) (*defines.PeerInfo, error) {
 	// 首先看是否是seed
-	if v, ok := pit.seeds[id]; ok {
+	pit.seedsLock.RLock()
+	v, ok := pit.seeds[id]
+	pit.seedsLock.RUnlock()
+	if ok {
 		return v, nil
 	}
 
@@ -292,14 +284,14 @@ func (pit *PeerInfoTable) Get(id string) (*defines.PeerInfo, error) {
 	dpi, ok2 := pit.dirty[id]
 	pit.dirtyLock.RUnlock()
 	if !ok1 && !ok2 || (ok2 && dpi.op == OpDel) {
-		return nil, fmt.Errorf("no such id: %s", id)
+		return nil, fmt.Errorf("no such id: %s", identity.Short(id))
 	}
 
 	if !ok2 {
 		return pi, nil
 	}
 	if ok2 && dpi.op == OpSet {
-		pit.Debugf("PeerInfoTable Get: {id:%s, PeerInfo:%s}", id, dpi.info.String())
+		pit.Debugf("PeerInfoTable Get: {id:%s, PeerInfo:%s}", identity.Short(id), dpi.info.String())
 		return dpi.info, nil
 	}
 	return nil, errors.New("unknown error")
@@ -312,13 +304,19 @@ func (pit *PeerInfoTable) Set(info *defines.PeerInfo) error {
 	if info == nil {
 		return nil
 	}
+	// 检查Id格式以及Id与公钥是否匹配
+	if err := info.Check(); err != nil {
+		return fmt.Errorf("invalid PeerInfo(%x): %w", info.Id, err)
+	}
 
 	// 如果是seed
 	if info.Duty == defines.PeerDuty_Seed {
+		pit.seedsLock.Lock()
 		if pit.seeds[info.Id] == nil {
 			pit.nSeedIncr() // 计数加1
 		}
 		pit.seeds[info.Id] = info
+		pit.seedsLock.Unlock()
 		return nil
 	}
 
@@ -339,7 +337,7 @@ func (pit *PeerInfoTable) Set(info *defines.PeerInfo) error {
 	}
 	pit.dirtyLock.Unlock()
 
-	pit.Debugf("PeerInfoTable Set: {id:%s, PeerInfo:%s}", id, info.String())
+	pit.Debugf("PeerInfoTable Set: {id:%s, PeerInfo:%s}", identity.Short(id), info.String())
 	return nil
 }
 
@@ -386,17 +384,78 @@ func (pit *PeerInfoTable) Del(id string) error {
 	dpi, ok2 := pit.dirty[id]
 	pit.dirtyLock.RUnlock()
 	if !ok1 && !ok2 || (ok2 && dpi.op == OpDel) {
-		return fmt.Errorf("no such id: %s", id)
+		return fmt.Errorf("no such id: %s", identity.Short(id))
 	}
 	pit.dirtyLock.Lock()
 	pit.dirty[id] = &dirtyPeerInfo{op: OpDel}
 	pit.dirtyLock.Unlock()
 	pit.nPeerDecr() // 计数减1
 
-	pit.Debugf("PeerInfoTable Del: {id:%s}", id)
+	pit.Debugf("PeerInfoTable Del: {id:%s}", identity.Short(id))
+	return nil
+}
+
+// DelSeed 删除种子节点，同时从kv中删除
+// 种子节点只随配置变化，因此不经过dirty，而是直接写kv，避免下次merge时又被写回
+func (pit *PeerInfoTable) DelSeed(id string) error {
+	pit.seedsLock.Lock()
+	defer pit.seedsLock.Unlock()
+	if _, ok := pit.seeds[id]; !ok {
+		return fmt.Errorf("no such seed: %s", identity.Short(id))
+	}
+	if err := pit.kv.Del(pit.seedsCF, []byte(id)); err != nil {
+		return err
+	}
+	delete(pit.seeds, id)
+	pit.nSeedDecr() // 计数减1
+
+	pit.Debugf("PeerInfoTable DelSeed: {id:%s}", identity.Short(id))
 	return nil
 }
 
+// VerifyPeer 连接握手时校验对端出示的公钥
+// 调用方已确认id由pubkey派生。
+// 若表中已记录该节点的公钥，则二者必须一致；
+// 若表中有该节点但未记录公钥，则补记该公钥；
+// 若表中无该节点，只有种子节点接受(新节点经由种子加入网络)，且不写入节点表，
+// 节点信息要等种子转发后才会入表，否则任何人都能往表里塞入节点并让本地不断回连
+func (pit *PeerInfoTable) VerifyPeer(id string, pubkey []byte) error {
+	info, _ := pit.Get(id)
+	if info == nil {
+		if pit.admitUnknown() {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrUnknownPeer, identity.Short(id))
+	}
+	if len(info.PubKey) > 0 {
+		if !bytes.Equal(info.PubKey, pubkey) {
+			return fmt.Errorf("pubkey of %x mismatches PeerInfoTable", id)
+		}
+		return nil
+	}
+	ni := *info
+	ni.PubKey = append([]byte(nil), pubkey...)
+	return pit.Set(&ni)
+}
+
+// admitUnknown 本地节点是否接受节点表中没有的节点连入
+// 依据本地节点自身的职责，只有种子节点接受
+func (pit *PeerInfoTable) admitUnknown() bool {
+	self, _ := pit.Get(pit.id)
+	return self != nil && self.Duty == defines.PeerDuty_Seed
+}
+
+// SetAttr 修改节点属性，如将被证实作恶的节点标记为PeerAttr_Malicious
+func (pit *PeerInfoTable) SetAttr(id string, attr defines.PeerAttr) error {
+	info, err := pit.Get(id)
+	if err != nil {
+		return err
+	}
+	ni := *info
+	ni.Attr = attr
+	return pit.Set(&ni)
+}
+
 // Close 关闭PeerInfoTable：关闭其内与kv的连接，通知mergeLoop退出
 func (pit *PeerInfoTable) Close() error {
 	close(pit.done)
@@ -449,9 +508,11 @@ func (pit *PeerInfoTable) Peers() map[string]*defines.PeerInfo {
 // Seeds 生成Seeds的快照
 func (pit *PeerInfoTable) Seeds() map[string]*defines.PeerInfo {
 	snapshot := map[string]*defines.PeerInfo{}
+	pit.seedsLock.RLock()
 	for id := range pit.seeds {
 		snapshot[id] = pit.seeds[id]
 	}
+	pit.seedsLock.RUnlock()
 	return snapshot
 }
 
@@ -484,18 +545,21 @@ func (pit *PeerInfoTable) RangeSeeds(f func(peer *defines.PeerInfo) error) (
 	}
 
 	errs = make(map[string]error)
-	for _, seed := range pit.seeds {
+	seeds := pit.Seeds()
+	for _, seed := range seeds {
 		seed := seed // 复制一份
 		if err := f(seed); err != nil {
 			errs[seed.Id] = err
 		}
 	}
-	return pit.NSeed(), errs
+	return len(seeds), errs
 }
 
 // IsSeed 判断id是否是seed节点
 func (pit *PeerInfoTable) IsSeed(id string) bool {
+	pit.seedsLock.RLock()
 	_, ok := pit.seeds[id]
+	pit.seedsLock.RUnlock()
 	return ok
 }
 
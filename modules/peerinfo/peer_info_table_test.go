@@ -10,7 +10,6 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"github.com/azd1997/blockchain-consensus/utils/log"
 	"reflect"
 	"testing"
 	"time"
@@ -18,21 +17,40 @@ import (
 	"github.com/azd1997/blockchain-consensus/defines"
 	"github.com/azd1997/blockchain-consensus/requires"
 	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+	"github.com/azd1997/blockchain-consensus/utils/log"
 )
 
+// 生成n个由公钥派生的测试ID
+func genTestIds(t *testing.T, n int) []string {
+	ids := make([]string, n)
+	for i := 0; i < n; i++ {
+		key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = identity.FromPublicKey(key.Public())
+	}
+	return ids
+}
+
 //////////////////////////////////////////////
 
 // 测试节点信息表的创建、初始化、增删改查、持久化
 func TestPeerInfoTable(t *testing.T) {
+	ids := genTestIds(t, 3)
+	id1, id2, id3 := ids[0], ids[1], ids[2]
+
 	// 初始化日志
-	log.InitGlobalLogger("id1")
+	log.InitGlobalLogger(id1, false, false)
 
 	// 创建
 	tkv := &test.Store{
 		Cfs: map[requires.CF]bool{},
 		Kvs: map[string]string{},
 	}
-	pit, err := NewPeerInfoTable("id1", tkv)
+	pit, err := NewPeerInfoTable(id1, tkv)
 	if err != nil {
 		t.Error(err)
 	}
@@ -49,19 +67,19 @@ func TestPeerInfoTable(t *testing.T) {
 
 	// 插入三条数据
 	err = pit.Set(&defines.PeerInfo{
-		Id:   "id1",
+		Id:   id1,
 		Addr: "addr1",
 		Duty: defines.PeerDuty_Seed,
 	})
 	handleError(t, err, pit, tkv)
 	err = pit.Set(&defines.PeerInfo{
-		Id:   "id2",
+		Id:   id2,
 		Addr: "addr2",
 		Duty: defines.PeerDuty_Peer,
 	})
 	handleError(t, err, pit, tkv)
 	err = pit.Set(&defines.PeerInfo{
-		Id:   "id3",
+		Id:   id3,
 		Addr: "addr3",
 		Duty: defines.PeerDuty_Peer,
 	})
@@ -72,9 +90,10 @@ func TestPeerInfoTable(t *testing.T) {
 
 	// 睡眠触发merge
 	time.Sleep(150 * time.Millisecond)
+	mergeAndCheck(t, pit, tkv)
 
 	// 删除数据
-	err = pit.Del("id3")
+	err = pit.Del(id3)
 	handleError(t, err, pit, tkv)
 	if pit.NSeed() != 1 || pit.NPeer() != 1 {
 		t.Error(pit.NSeed(), pit.NPeer())
@@ -82,7 +101,7 @@ func TestPeerInfoTable(t *testing.T) {
 
 	// 修改数据
 	err = pit.Set(&defines.PeerInfo{
-		Id:   "id2",
+		Id:   id2,
 		Addr: "addr22222",
 	})
 	handleError(t, err, pit, tkv)
@@ -91,24 +110,42 @@ func TestPeerInfoTable(t *testing.T) {
 	}
 
 	// 查数据
-	info, err := pit.Get("id2")
+	info, err := pit.Get(id2)
 	handleError(t, err, pit, tkv)
 	if !reflect.DeepEqual(info, &defines.PeerInfo{
-		Id:   "id2",
+		Id:   id2,
 		Addr: "addr22222",
 	}) {
 		t.Error("errorrrrr")
 	}
 
+	// 非法Id不允许写入
+	err = pit.Set(&defines.PeerInfo{
+		Id:   "id4",
+		Addr: "addr4",
+		Duty: defines.PeerDuty_Peer,
+	})
+	if err == nil {
+		t.Error("PeerInfo with invalid Id should be rejected")
+	}
+
 	// 睡眠触发merge
 	time.Sleep(150 * time.Millisecond)
+	mergeAndCheck(t, pit, tkv)
 }
 
 func handleError(t *testing.T, err error, pit *PeerInfoTable, tkv *test.Store) {
 	if err != nil {
 		t.Error(err)
 	}
-	if err = checkPitAndKv(pit, tkv); err != nil {
+}
+
+// 写入先进入dirty，只有merge之后pit与kv才保持一致
+func mergeAndCheck(t *testing.T, pit *PeerInfoTable, tkv *test.Store) {
+	if err := pit.merge(); err != nil {
+		t.Error(err)
+	}
+	if err := checkPitAndKv(pit, tkv); err != nil {
 		t.Error(err)
 	}
 }
@@ -143,3 +180,121 @@ func checkPitAndKv(pit *PeerInfoTable, kv *test.Store) error {
 	}
 	return nil
 }
+
+// 测试握手时对端公钥的校验
+func TestPeerInfoTable_VerifyPeer(t *testing.T) {
+	keys := make([]crypto.PrivateKey, 3)
+	ids := make([]string, 3)
+	for i := range keys {
+		key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i], ids[i] = key, identity.FromPublicKey(key.Public())
+	}
+	pk := func(i int) []byte { return crypto.MarshalPublicKey(keys[i].Public()) }
+
+	log.InitGlobalLogger(ids[0], false, false)
+	tkv := &test.Store{
+		Cfs: map[requires.CF]bool{},
+		Kvs: map[string]string{},
+	}
+	pit, err := NewPeerInfoTable(ids[0], tkv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer pit.Close()
+
+	// 已记录公钥
+	if err := pit.Set(&defines.PeerInfo{Id: ids[1], Addr: "addr1", PubKey: pk(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.VerifyPeer(ids[1], pk(1)); err != nil {
+		t.Error(err)
+	}
+	if err := pit.VerifyPeer(ids[1], pk(2)); err == nil {
+		t.Error("VerifyPeer should refuse mismatched pubkey")
+	}
+
+	// 未记录公钥，校验后补记
+	if err := pit.Set(&defines.PeerInfo{Id: ids[2], Addr: "addr2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.VerifyPeer(ids[2], pk(2)); err != nil {
+		t.Error(err)
+	}
+	info, err := pit.Get(ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(info.PubKey, pk(2)) {
+		t.Error("VerifyPeer should record pubkey")
+	}
+
+	// 未知节点：自身不是种子时拒绝
+	stranger, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, spk := identity.FromPublicKey(stranger.Public()), crypto.MarshalPublicKey(stranger.Public())
+	if err := pit.Set(&defines.PeerInfo{Id: ids[0], Addr: "addr0", Duty: defines.PeerDuty_Peer}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.VerifyPeer(sid, spk); !errors.Is(err, ErrUnknownPeer) {
+		t.Errorf("err = %v, want ErrUnknownPeer", err)
+	}
+
+	// 自身是种子时接受，但不写入节点表
+	if err := pit.Set(&defines.PeerInfo{Id: ids[0], Addr: "addr0", Duty: defines.PeerDuty_Seed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.VerifyPeer(sid, spk); err != nil {
+		t.Error(err)
+	}
+	if _, err := pit.Get(sid); err == nil {
+		t.Error("VerifyPeer should not record unknown peer")
+	}
+}
+
+func TestPeerInfoTable_SetAttr(t *testing.T) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := identity.FromPublicKey(key.Public())
+
+	log.InitGlobalLogger(id, false, false)
+	tkv := &test.Store{
+		Cfs: map[requires.CF]bool{},
+		Kvs: map[string]string{},
+	}
+	pit, err := NewPeerInfoTable(id, tkv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer pit.Close()
+
+	if err := pit.SetAttr(id, defines.PeerAttr_Malicious); err == nil {
+		t.Error("SetAttr on unknown peer should fail")
+	}
+
+	if err := pit.Set(&defines.PeerInfo{Id: id, Addr: "addr0", Duty: defines.PeerDuty_Peer}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pit.SetAttr(id, defines.PeerAttr_Malicious); err != nil {
+		t.Fatal(err)
+	}
+	info, err := pit.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Attr != defines.PeerAttr_Malicious || info.Addr != "addr0" {
+		t.Errorf("info = %s, want Attr=PeerAttr_Malicious and other fields kept", info)
+	}
+}
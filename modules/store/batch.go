@@ -0,0 +1,183 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 11/4/20 10:10 AM
+* @Description: FileStore的写批次与快照
+***********************************************************************/
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/azd1997/blockchain-consensus/requires"
+)
+
+var ErrBatchCommitted = errors.New("batch already committed")
+
+// batchOp 一个写操作
+type batchOp struct {
+	op    byte // opSet/opDel
+	key   string
+	value []byte
+}
+
+// NewBatch 创建写批次
+func (s *FileStore) NewBatch() requires.Batch {
+	return &fileBatch{s: s}
+}
+
+// fileBatch 批次内的写操作编码为一条opBatch记录，一次写入
+type fileBatch struct {
+	s         *FileStore
+	ops       []batchOp
+	cfs       map[requires.CF]bool
+	committed bool
+}
+
+func (b *fileBatch) add(op byte, cf requires.CF, key, value []byte) {
+	if b.cfs == nil {
+		b.cfs = map[requires.CF]bool{}
+	}
+	b.cfs[cf] = true
+	b.ops = append(b.ops, batchOp{op: op, key: string(cf[:]) + string(key), value: append([]byte{}, value...)})
+}
+
+func (b *fileBatch) Set(cf requires.CF, key, value []byte) {
+	b.add(opSet, cf, key, value)
+}
+
+func (b *fileBatch) Del(cf requires.CF, key []byte) {
+	b.add(opDel, cf, key, nil)
+}
+
+func (b *fileBatch) Len() int {
+	return len(b.ops)
+}
+
+// Commit 原子地提交批次
+func (b *fileBatch) Commit() error {
+	s := b.s
+	s.Lock()
+	defer s.Unlock()
+
+	if b.committed {
+		return ErrBatchCommitted
+	}
+	for cf := range b.cfs {
+		if err := s.check(cf); err != nil {
+			return err
+		}
+	}
+	if len(b.ops) == 0 {
+		b.committed = true
+		return nil
+	}
+
+	var rec []byte
+	if len(b.ops) == 1 {
+		rec = encodeRecord(b.ops[0].op, b.ops[0].key, b.ops[0].value)
+	} else {
+		var value []byte
+		for _, o := range b.ops {
+			value = append(value, encodeRecord(o.op, o.key, o.value)...)
+		}
+		rec = encodeRecord(opBatch, "", value)
+	}
+	if err := s.append(rec, b.ops); err != nil {
+		return err
+	}
+	b.committed = true
+	return nil
+}
+
+// Snapshot 获取只读快照
+// 日志只追加不改写，快照复制一份索引并持有当前的日志文件即可，之后的compact不影响已有的快照
+func (s *FileStore) Snapshot() (requires.Snapshot, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.refs == 0 {
+		return nil, ErrClosed
+	}
+	snap := &fileSnapshot{
+		log:   s.log,
+		index: make(map[string]valueLoc, len(s.index)),
+		cfs:   make(map[requires.CF]bool, len(s.cfs)),
+	}
+	for k, loc := range s.index {
+		snap.index[k] = loc
+	}
+	for cf := range s.cfs {
+		snap.cfs[cf] = true
+	}
+	s.log.acquire()
+	return snap, nil
+}
+
+type fileSnapshot struct {
+	log   *logFile
+	index map[string]valueLoc
+	cfs   map[requires.CF]bool
+}
+
+func (sn *fileSnapshot) check(cf requires.CF) error {
+	if sn.index == nil {
+		return errors.New("snapshot released")
+	}
+	if !sn.cfs[cf] {
+		return fmt.Errorf("%w: %q", ErrUnknownCF, string(cf[:]))
+	}
+	return nil
+}
+
+// Get 查询，key不存在时返回nil, nil
+func (sn *fileSnapshot) Get(cf requires.CF, key []byte) ([]byte, error) {
+	if err := sn.check(cf); err != nil {
+		return nil, err
+	}
+	k := string(cf[:]) + string(key)
+	loc, ok := sn.index[k]
+	if !ok {
+		return nil, nil
+	}
+	return sn.log.readValue(k, loc)
+}
+
+// RangeCF 按key升序遍历某个CF，f返回错误时继续遍历，最终返回第一个错误
+// 读取记录失败时立即返回
+func (sn *fileSnapshot) RangeCF(cf requires.CF, f func(key, value []byte) error) error {
+	if err := sn.check(cf); err != nil {
+		return err
+	}
+	prefix := string(cf[:])
+	keys := make([]string, 0)
+	for k := range sn.index {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var firstErr error
+	for _, k := range keys {
+		v, err := sn.log.readValue(k, sn.index[k])
+		if err != nil {
+			return err
+		}
+		if err := f([]byte(k[requires.CFLen:]), v); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (sn *fileSnapshot) Release() {
+	if sn.index == nil {
+		return
+	}
+	sn.log.release()
+	sn.log, sn.index, sn.cfs = nil, nil, nil
+}
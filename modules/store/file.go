@@ -0,0 +1,532 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 11/3/20 9:40 AM
+* @Description: 基于追加写日志文件的KV存储
+***********************************************************************/
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/azd1997/blockchain-consensus/requires"
+)
+
+/*
+	FileStore 把所有写操作按顺序追加到数据目录下的日志文件，内存中只维护key到记录偏移的索引，
+	value按需从日志中读取并校验crc。适合区块链元数据、节点表这类key数量有限、读多写少的场景。
+
+	记录格式: crc32(4B) | op(1B) | keyLen(4B) | valueLen(4B) | key | value
+	crc覆盖crc之后的全部内容，key为 cf(6B) + 用户key。
+	批次(opBatch)的key为空，value为批次内各写操作的记录依次拼接，整个批次只是一条记录，因此是原子的。
+
+	正常关闭时把索引写入索引文件(见hint.go)，下次打开只需重放索引之后的日志；崩溃后没有索引文件，重放整个日志。
+	写到一半就崩溃留下的残缺记录(长度不足或crc不符)只可能出现在文件末尾，重放时遇到即截断文件，之前的记录都是完整的。
+	syncOnCommit为true时每次写操作都会fsync，断电也不会丢失已返回的写入；
+	否则只保证进程崩溃时不丢失(数据已在操作系统缓存中)。
+
+	日志中被覆盖/删除的记录不会立即回收，失效记录过多时(打开时或写入后)重写一次日志文件(compact)。
+*/
+
+const (
+	dataFileName = "data.log"
+	hintFileName = "data.hint"
+
+	recordHeaderLen = 13
+	// 单条记录的上限，超过则认为是损坏的记录
+	maxRecordLen = 64 << 20
+
+	// DefaultCompactMinSize 日志文件小于该大小时不做compact
+	DefaultCompactMinSize = 1 << 20
+)
+
+// 记录类型
+const (
+	opSet   byte = 1
+	opDel   byte = 2
+	opBatch byte = 3
+)
+
+// ErrCorrupted 索引指向的记录读不出或crc不符
+var ErrCorrupted = errors.New("corrupted record")
+
+// ErrBroken 写入失败后日志文件无法恢复到写入前的状态，不再接受写操作，重新打开后可恢复
+var ErrBroken = errors.New("store broken by a failed write")
+
+// NewFileStore 新建FileStore
+// dir 为数据目录，为空时不落盘(纯内存)
+// syncOnCommit 每次写操作后是否fsync
+func NewFileStore(dir string, syncOnCommit bool) *FileStore {
+	return &FileStore{
+		dir:            dir,
+		syncOnCommit:   syncOnCommit,
+		compactMinSize: DefaultCompactMinSize,
+		index:          map[string]valueLoc{},
+		cfs:            map[requires.CF]bool{},
+	}
+}
+
+// FileStore 文件存储
+// 多个模块(PeerInfoTable、BlockChain等)可以共用一个FileStore，
+// Open/Close 按引用计数处理，最后一次Close才真正关闭文件
+type FileStore struct {
+	dir            string
+	syncOnCommit   bool
+	compactMinSize int64
+
+	log     *logFile
+	refs    int
+	size    int64 // 日志文件大小
+	lastOff int64 // 最后一条记录的起始偏移
+	nrecs   int   // 日志中的记录数(含失效的)
+
+	index map[string]valueLoc
+	cfs   map[requires.CF]bool
+
+	broken error // 非nil时拒绝写操作，见append
+
+	sync.RWMutex
+}
+
+// Open 打开存储，首次打开时加载索引并重放其后的日志
+func (s *FileStore) Open() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.refs > 0 {
+		s.refs++
+		return nil
+	}
+	if s.dir != "" {
+		if err := s.openFile(); err != nil {
+			return err
+		}
+	} else {
+		s.log = newMemLog()
+	}
+	s.refs = 1
+	return nil
+}
+
+// Close 关闭存储，落盘时写入索引文件
+func (s *FileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.refs == 0 {
+		return nil
+	}
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+
+	var err error
+	if s.log.f != nil {
+		err = s.log.f.Sync()
+		if err == nil && s.broken == nil { // 日志末尾可能有残缺记录，不写索引，下次打开时重放并截断
+			h := &hint{logSize: s.size, lastOff: s.lastOff, nrecs: s.nrecs, index: s.index}
+			if herr := writeHint(s.hintPath(), h); herr != nil {
+				log.Printf("FileStore: write hint file: %v\n", herr)
+			}
+		}
+	}
+	if rerr := s.log.release(); err == nil {
+		err = rerr
+	}
+	s.log = nil
+	s.index = map[string]valueLoc{}
+	s.size, s.lastOff, s.nrecs = 0, 0, 0
+	s.broken = nil
+	return err
+}
+
+// Get 查询，key不存在时返回nil, nil
+func (s *FileStore) Get(cf requires.CF, key []byte) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if err := s.check(cf); err != nil {
+		return nil, err
+	}
+	k := string(cf[:]) + string(key)
+	loc, ok := s.index[k]
+	if !ok {
+		return nil, nil
+	}
+	return s.log.readValue(k, loc)
+}
+
+// Set 写入
+func (s *FileStore) Set(cf requires.CF, key, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.check(cf); err != nil {
+		return err
+	}
+	k := string(cf[:]) + string(key)
+	return s.append(encodeRecord(opSet, k, value), []batchOp{{op: opSet, key: k, value: value}})
+}
+
+// Del 删除
+func (s *FileStore) Del(cf requires.CF, key []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.check(cf); err != nil {
+		return err
+	}
+	k := string(cf[:]) + string(key)
+	if _, ok := s.index[k]; !ok {
+		return nil
+	}
+	return s.append(encodeRecord(opDel, k, nil), []batchOp{{op: opDel, key: k}})
+}
+
+// RegisterCF 注册列族，已注册时do nothing
+// 列族本身不落盘，使用者每次启动时都需要注册
+func (s *FileStore) RegisterCF(cf requires.CF) error {
+	s.Lock()
+	defer s.Unlock()
+	s.cfs[cf] = true
+	return nil
+}
+
+// RangeCF 按key升序遍历某个CF，f返回错误时继续遍历，最终返回第一个错误
+// 遍历的是调用时的快照，f中可以读写该存储
+func (s *FileStore) RangeCF(cf requires.CF, f func(key, value []byte) error) error {
+	snap, err := s.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+	return snap.RangeCF(cf, f)
+}
+
+func (s *FileStore) check(cf requires.CF) error {
+	if s.refs == 0 {
+		return ErrClosed
+	}
+	if !s.cfs[cf] {
+		return fmt.Errorf("%w: %q", ErrUnknownCF, string(cf[:]))
+	}
+	return nil
+}
+
+// append 追加一条记录并更新索引，ops为记录包含的写操作，多于一个时rec为批次记录
+// 写入失败时日志已截断回原长度，索引与日志保持一致；截断也失败的话此后的偏移都不可信，只能拒绝写入
+func (s *FileStore) append(rec []byte, ops []batchOp) error {
+	if s.broken != nil {
+		return fmt.Errorf("%w: %v", ErrBroken, s.broken)
+	}
+	if err := s.log.write(rec, s.size, s.syncOnCommit); err != nil {
+		if errors.Is(err, errLogBroken) {
+			s.broken = err
+			return fmt.Errorf("%w: %v", ErrBroken, err)
+		}
+		return err
+	}
+	applyOps(s.index, s.size, len(ops) > 1, ops)
+	s.lastOff = s.size
+	s.size += int64(len(rec))
+	s.nrecs += len(ops)
+
+	// 写入已经成功，compact失败只影响空间回收
+	if s.needCompact() {
+		if err := s.compact(); err != nil {
+			log.Printf("FileStore: compact %s: %v\n", s.dir, err)
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) needCompact() bool {
+	return s.size > s.compactMinSize && s.nrecs > 2*len(s.index)
+}
+
+func (s *FileStore) path() string {
+	return filepath.Join(s.dir, dataFileName)
+}
+
+func (s *FileStore) hintPath() string {
+	return filepath.Join(s.dir, hintFileName)
+}
+
+// openFile 打开日志文件，加载索引文件并重放其后的日志，必要时compact
+func (s *FileStore) openFile() error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	h, err := s.loadHint(f, fi.Size())
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := f.Seek(h.logSize, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	size, lastOff, nrecs, err := replay(f, h.logSize, h.index)
+	if err != nil {
+		return fail(err)
+	}
+	if size == h.logSize {
+		lastOff = h.lastOff
+	}
+	if fi.Size() > size { // 末尾的残缺记录
+		log.Printf("FileStore: truncate %d bytes of torn record at the end of %s\n", fi.Size()-size, s.path())
+		if err := f.Truncate(size); err != nil {
+			return fail(err)
+		}
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	s.log, s.index = newFileLog(f), h.index
+	s.size, s.lastOff, s.nrecs = size, lastOff, h.nrecs+nrecs
+
+	if s.needCompact() {
+		return s.compact()
+	}
+	return nil
+}
+
+// loadHint 读取并删除索引文件，索引与日志对不上时返回空索引(从头重放)
+func (s *FileStore) loadHint(f *os.File, fileSize int64) (*hint, error) {
+	empty := &hint{index: map[string]valueLoc{}}
+	h, err := readHint(s.hintPath())
+	if os.IsNotExist(err) {
+		return empty, nil
+	}
+	// 打开后日志会被改写，旧的索引文件必须先删除，崩溃后才不会误用
+	if rerr := os.Remove(s.hintPath()); rerr != nil {
+		return nil, rerr
+	}
+	if rerr := syncDir(s.dir); rerr != nil {
+		return nil, rerr
+	}
+	if err != nil {
+		log.Printf("FileStore: ignore %s: %v\n", s.hintPath(), err)
+		return empty, nil
+	}
+	if h.logSize > fileSize || (h.logSize > 0 && !checkRecordAt(f, h.lastOff, h.logSize)) {
+		log.Printf("FileStore: %s does not match %s, replay the whole log\n", s.hintPath(), s.path())
+		return empty, nil
+	}
+	return h, nil
+}
+
+// checkRecordAt 检查[off, end)是否恰好是一条完整的记录
+func checkRecordAt(f *os.File, off, end int64) bool {
+	_, _, _, n, err := readRecord(io.NewSectionReader(f, off, end-off))
+	return err == nil && n == end-off
+}
+
+// compact 只保留有效记录，重写日志文件
+func (s *FileStore) compact() error {
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var nl *logFile
+	tmpPath := s.path() + ".compact"
+	if s.log.f == nil {
+		nl = newMemLog()
+	} else {
+		tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		nl = newFileLog(tmp)
+	}
+	fail := func(err error) error {
+		nl.release()
+		if nl.f != nil {
+			os.Remove(tmpPath)
+		}
+		return err
+	}
+
+	index := make(map[string]valueLoc, len(keys))
+	size, lastOff := int64(0), int64(0)
+	for _, k := range keys {
+		v, err := s.log.readValue(k, s.index[k])
+		if err != nil {
+			return fail(err)
+		}
+		rec := encodeRecord(opSet, k, v)
+		if err := nl.write(rec, size, false); err != nil {
+			return fail(err)
+		}
+		index[k] = valueLoc{off: size, n: uint32(len(rec))}
+		lastOff = size
+		size += int64(len(rec))
+	}
+	if nl.f != nil {
+		if err := nl.f.Sync(); err != nil {
+			return fail(err)
+		}
+		// 先rename再关闭旧文件，任何时刻磁盘上都有一份完整的日志
+		if err := os.Rename(tmpPath, s.path()); err != nil {
+			return fail(err)
+		}
+		if err := syncDir(s.dir); err != nil {
+			nl.release()
+			return err
+		}
+	}
+	s.log.release()
+	s.log, s.index = nl, index
+	s.size, s.lastOff, s.nrecs = size, lastOff, len(keys)
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+////////////////////////////// 记录编解码 //////////////////////////////
+
+var errTornRecord = errors.New("torn record")
+
+func encodeRecord(op byte, key string, value []byte) []byte {
+	rec := make([]byte, recordHeaderLen+len(key)+len(value))
+	rec[4] = op
+	binary.BigEndian.PutUint32(rec[5:9], uint32(len(key)))
+	binary.BigEndian.PutUint32(rec[9:13], uint32(len(value)))
+	copy(rec[recordHeaderLen:], key)
+	copy(rec[recordHeaderLen+len(key):], value)
+	binary.BigEndian.PutUint32(rec[0:4], crc32.ChecksumIEEE(rec[4:]))
+	return rec
+}
+
+// readRecord 读取一条记录，遇到文件末尾返回io.EOF，残缺记录返回errTornRecord
+func readRecord(r io.Reader) (op byte, key string, value []byte, n int64, err error) {
+	hdr := make([]byte, recordHeaderLen)
+	if _, err = io.ReadFull(r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errTornRecord
+		}
+		return
+	}
+	klen, vlen := binary.BigEndian.Uint32(hdr[5:9]), binary.BigEndian.Uint32(hdr[9:13])
+	if klen+vlen > maxRecordLen {
+		err = errTornRecord
+		return
+	}
+	body := make([]byte, klen+vlen)
+	if _, err = io.ReadFull(r, body); err != nil {
+		err = errTornRecord
+		return
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(hdr[0:4]) {
+		err = errTornRecord
+		return
+	}
+	op, key, value = hdr[4], string(body[:klen]), body[klen:]
+	n = int64(recordHeaderLen) + int64(klen) + int64(vlen)
+	return
+}
+
+// replay 从off处开始重放日志并更新index，
+// 返回有效内容的长度、最后一条记录的起始偏移及新增的记录数(批次按其写操作数计)
+func replay(f *os.File, off int64, index map[string]valueLoc) (size, lastOff int64, nrecs int, err error) {
+	size, lastOff = off, off
+	r := bufio.NewReader(f)
+	for {
+		op, key, value, n, rerr := readRecord(r)
+		if rerr == io.EOF || rerr == errTornRecord {
+			return size, lastOff, nrecs, nil
+		}
+		if rerr != nil {
+			return 0, 0, 0, rerr
+		}
+		ops, ok := decodeOps(op, key, value)
+		if !ok { // 无法识别的记录视为损坏，之后的内容都不可信
+			return size, lastOff, nrecs, nil
+		}
+		applyOps(index, size, op == opBatch, ops)
+		lastOff = size
+		size += n
+		nrecs += len(ops)
+	}
+}
+
+// decodeOps 将一条记录解析为写操作，批次记录需要完整解析后才能应用
+func decodeOps(op byte, key string, value []byte) ([]batchOp, bool) {
+	switch op {
+	case opSet, opDel:
+		if len(key) < requires.CFLen {
+			return nil, false
+		}
+		return []batchOp{{op: op, key: key, value: value}}, true
+	case opBatch:
+		var ops []batchOp
+		r := bytes.NewReader(value)
+		for r.Len() > 0 {
+			subOp, subKey, subValue, _, err := readRecord(r)
+			if err != nil || subOp == opBatch {
+				return nil, false
+			}
+			sub, ok := decodeOps(subOp, subKey, subValue)
+			if !ok {
+				return nil, false
+			}
+			ops = append(ops, sub...)
+		}
+		return ops, true
+	default:
+		return nil, false
+	}
+}
+
+// applyOps 按一条记录中的写操作更新索引，off为该记录的起始偏移
+// 批次记录中各写操作的子记录依次排在批次记录的value中
+func applyOps(index map[string]valueLoc, off int64, batched bool, ops []batchOp) {
+	pos := off
+	if batched {
+		pos += recordHeaderLen
+	}
+	for _, o := range ops {
+		n := uint32(recordHeaderLen + len(o.key) + len(o.value))
+		if o.op == opSet {
+			index[o.key] = valueLoc{off: pos, n: n}
+		} else {
+			delete(index, o.key)
+		}
+		pos += int64(n)
+	}
+}
@@ -0,0 +1,121 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/27/20 10:00 AM
+* @Description: 索引文件，正常关闭时保存key到日志偏移的索引，下次打开时免去重放整个日志
+***********************************************************************/
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+/*
+	索引文件格式: magic(4B) | logSize(8B) | lastOff(8B) | nrecs(8B) | count(4B) | 表项... | crc32(4B)
+	表项: keyLen(4B) | key | off(8B) | n(4B)
+	crc覆盖之前的全部内容。
+
+	logSize为写索引时日志的长度，打开时只需重放其后的部分；lastOff为最后一条记录的起始偏移，
+	打开时校验这条记录，与日志对不上(日志被截断或改写)则放弃索引、重放整个日志。
+	索引文件在打开时即删除，只有正常关闭后才存在，崩溃后总是完整重放。
+*/
+
+const hintMagic = "BCCH"
+
+var errBadHint = errors.New("bad hint file")
+
+// hint 索引文件的内容
+type hint struct {
+	logSize int64
+	lastOff int64
+	nrecs   int
+	index   map[string]valueLoc
+}
+
+func writeHint(path string, h *hint) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString(hintMagic)
+	var b [8]byte
+	for _, v := range []int64{h.logSize, h.lastOff, int64(h.nrecs)} {
+		binary.BigEndian.PutUint64(b[:], uint64(v))
+		buf.Write(b[:])
+	}
+	binary.BigEndian.PutUint32(b[:4], uint32(len(h.index)))
+	buf.Write(b[:4])
+	for k, loc := range h.index {
+		binary.BigEndian.PutUint32(b[:4], uint32(len(k)))
+		buf.Write(b[:4])
+		buf.WriteString(k)
+		binary.BigEndian.PutUint64(b[:], uint64(loc.off))
+		buf.Write(b[:])
+		binary.BigEndian.PutUint32(b[:4], loc.n)
+		buf.Write(b[:4])
+	}
+	binary.BigEndian.PutUint32(b[:4], crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(b[:4])
+	return ioutil.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// readHint 读取索引文件，文件不存在时返回os.ErrNotExist
+func readHint(path string) (*hint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	crc := crc32.NewIEEE()
+	r := io.TeeReader(bufio.NewReader(f), crc)
+	var hdr [4 + 8*3 + 4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || string(hdr[:4]) != hintMagic {
+		return nil, errBadHint
+	}
+	h := &hint{
+		logSize: int64(binary.BigEndian.Uint64(hdr[4:12])),
+		lastOff: int64(binary.BigEndian.Uint64(hdr[12:20])),
+		nrecs:   int(binary.BigEndian.Uint64(hdr[20:28])),
+	}
+	count := binary.BigEndian.Uint32(hdr[28:32])
+	if h.lastOff < 0 || h.lastOff > h.logSize {
+		return nil, errBadHint
+	}
+	h.index = make(map[string]valueLoc)
+	var b [8]byte
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return nil, errBadHint
+		}
+		klen := binary.BigEndian.Uint32(b[:4])
+		if klen > maxRecordLen {
+			return nil, errBadHint
+		}
+		key := make([]byte, klen)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, errBadHint
+		}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, errBadHint
+		}
+		loc := valueLoc{off: int64(binary.BigEndian.Uint64(b[:]))}
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return nil, errBadHint
+		}
+		loc.n = binary.BigEndian.Uint32(b[:4])
+		if loc.off < 0 || loc.off+int64(loc.n) > h.logSize {
+			return nil, errBadHint
+		}
+		h.index[string(key)] = loc
+	}
+	sum := crc.Sum32()
+	if _, err := io.ReadFull(r, b[:4]); err != nil || binary.BigEndian.Uint32(b[:4]) != sum {
+		return nil, errBadHint
+	}
+	return h, nil
+}
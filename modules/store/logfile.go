@@ -0,0 +1,114 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/27/20 9:30 AM
+* @Description: FileStore的日志文件，按偏移读取记录
+***********************************************************************/
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// valueLoc 一条set记录在日志中的位置
+// 批次内的写操作指向批次记录内部的子记录，子记录同样带有crc
+type valueLoc struct {
+	off int64  // 记录的起始偏移
+	n   uint32 // 记录的总长度
+}
+
+// errLogBroken 写入失败后未能截断回写入前的长度，文件末尾可能留有残缺记录
+var errLogBroken = errors.New("log file left with a torn record")
+
+// logFile 日志文件，按引用计数关闭
+// compact换上新文件后，未释放的快照仍持有旧文件，最后一个快照释放时才真正关闭
+type logFile struct {
+	f *os.File  // 为nil时内容保存在buf中(纯内存)
+	w io.Writer // 追加写入的目标，即f
+
+	lock sync.RWMutex // 保护buf
+	buf  []byte
+
+	refs int32
+}
+
+func newMemLog() *logFile {
+	return &logFile{refs: 1}
+}
+
+func newFileLog(f *os.File) *logFile {
+	return &logFile{f: f, w: f, refs: 1}
+}
+
+// write 在末尾(off处)追加
+// 写入可能只完成了一部分，失败时截断回off，之后的记录仍从off开始写；截断也失败时返回errLogBroken
+func (l *logFile) write(rec []byte, off int64, sync bool) error {
+	if l.f == nil {
+		l.lock.Lock()
+		l.buf = append(l.buf, rec...)
+		l.lock.Unlock()
+		return nil
+	}
+	_, err := l.w.Write(rec)
+	if err == nil && sync {
+		err = l.f.Sync()
+	}
+	if err == nil {
+		return nil
+	}
+	if terr := l.f.Truncate(off); terr != nil {
+		return fmt.Errorf("%w: write: %v, truncate: %v", errLogBroken, err, terr)
+	}
+	if _, serr := l.f.Seek(off, io.SeekStart); serr != nil {
+		return fmt.Errorf("%w: write: %v, seek: %v", errLogBroken, err, serr)
+	}
+	return err
+}
+
+// readAt 读取[off, off+n)
+func (l *logFile) readAt(off int64, n uint32) ([]byte, error) {
+	data := make([]byte, n)
+	if l.f == nil {
+		l.lock.RLock()
+		defer l.lock.RUnlock()
+		if off < 0 || off+int64(n) > int64(len(l.buf)) {
+			return nil, fmt.Errorf("%w: [%d, %d) out of log", ErrCorrupted, off, off+int64(n))
+		}
+		copy(data, l.buf[off:])
+		return data, nil
+	}
+	if _, err := l.f.ReadAt(data, off); err != nil {
+		return nil, fmt.Errorf("%w: read [%d, %d): %v", ErrCorrupted, off, off+int64(n), err)
+	}
+	return data, nil
+}
+
+// readValue 读取loc处的set记录，校验crc后返回其value
+func (l *logFile) readValue(key string, loc valueLoc) ([]byte, error) {
+	data, err := l.readAt(loc.off, loc.n)
+	if err != nil {
+		return nil, err
+	}
+	op, k, v, _, err := readRecord(bytes.NewReader(data))
+	if err != nil || op != opSet || k != key {
+		return nil, fmt.Errorf("%w: at offset %d", ErrCorrupted, loc.off)
+	}
+	return v, nil
+}
+
+func (l *logFile) acquire() {
+	atomic.AddInt32(&l.refs, 1)
+}
+
+func (l *logFile) release() error {
+	if atomic.AddInt32(&l.refs, -1) > 0 || l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
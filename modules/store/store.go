@@ -0,0 +1,58 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 11/3/20 9:15 AM
+* @Description: 内置的requires.Store实现，按StoreConfig选择存储引擎
+***********************************************************************/
+
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/azd1997/blockchain-consensus/config"
+	"github.com/azd1997/blockchain-consensus/requires"
+)
+
+// 内置的存储引擎，对应 config.StoreConfig.Engine
+const (
+	// Engine_File 基于追加写日志文件的持久化存储，Database为数据目录
+	Engine_File = "file"
+	// Engine_Memory 纯内存存储，进程退出后数据丢失，仅用于测试
+	Engine_Memory = "memory"
+
+	DefaultEngine = Engine_File
+
+	// Engine_Badger 早期配置中的占位引擎，从未内置实现，见 ErrEngineRemoved
+	Engine_Badger = "badger"
+)
+
+var (
+	ErrUnknownEngine = errors.New("unknown store engine")
+	// ErrEngineRemoved 配置了不再支持的引擎，需改为 engine = "file"
+	ErrEngineRemoved = errors.New(`store engine "badger" is not built in, set engine = "file" in [store]`)
+	ErrUnknownCF     = errors.New("unregistered cf")
+	ErrClosed        = errors.New("store closed")
+)
+
+// New 根据配置创建存储引擎
+func New(cfg *config.StoreConfig) (requires.Store, error) {
+	engine := cfg.Engine
+	if engine == "" {
+		engine = DefaultEngine
+	}
+
+	switch engine {
+	case Engine_File:
+		if cfg.Database == "" {
+			return nil, errors.New("empty database dir for file store")
+		}
+		return NewFileStore(cfg.Database, cfg.Sync), nil
+	case Engine_Memory:
+		return NewFileStore("", false), nil
+	case Engine_Badger:
+		return nil, ErrEngineRemoved
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEngine, cfg.Engine)
+	}
+}
@@ -0,0 +1,546 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 11/3/20 2:30 PM
+* @Description: 存储引擎测试
+***********************************************************************/
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azd1997/blockchain-consensus/config"
+	"github.com/azd1997/blockchain-consensus/requires"
+)
+
+var (
+	cfA = requires.String2CF("cfa")
+	cfB = requires.String2CF("cfb")
+)
+
+func openTestStore(t *testing.T, dir string) *FileStore {
+	s := NewFileStore(dir, true)
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, cf := range []requires.CF{cfA, cfB} {
+		if err := s.RegisterCF(cf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func mustGet(t *testing.T, s requires.Store, cf requires.CF, key string) []byte {
+	v, err := s.Get(cf, []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+
+	if err := s.Set(cfA, []byte("k1"), []byte("a1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(cfA, []byte("k2"), []byte("a2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(cfB, []byte("k1"), []byte("b1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(cfA, []byte("k3"), []byte("a3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Del(cfA, []byte("k3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(cfA, []byte("k2"), []byte("a2'")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未注册的CF
+	if _, err := s.Get(requires.String2CF("cfc"), []byte("k1")); !errors.Is(err, ErrUnknownCF) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownCF)
+	}
+
+	check := func(s *FileStore) {
+		t.Helper()
+		if v := mustGet(t, s, cfA, "k1"); string(v) != "a1" {
+			t.Errorf("cfA.k1 = %q", v)
+		}
+		if v := mustGet(t, s, cfA, "k2"); string(v) != "a2'" {
+			t.Errorf("cfA.k2 = %q", v)
+		}
+		if v := mustGet(t, s, cfB, "k1"); string(v) != "b1" {
+			t.Errorf("cfB.k1 = %q", v)
+		}
+		if v := mustGet(t, s, cfA, "k3"); v != nil {
+			t.Errorf("deleted cfA.k3 = %q", v)
+		}
+
+		var keys []string
+		err := s.RangeCF(cfA, func(key, value []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(keys) != "[k1 k2]" {
+			t.Errorf("keys of cfA = %v", keys)
+		}
+	}
+	check(s)
+
+	// 多个使用者共用，最后一次Close才关闭
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	check(s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(cfA, []byte("k1")); !errors.Is(err, ErrClosed) {
+		t.Errorf("err = %v, want %v", err, ErrClosed)
+	}
+
+	// 重启后重放日志
+	check(openTestStore(t, dir))
+}
+
+func TestFileStore_TornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	if err := s.Set(cfA, []byte("k1"), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(cfA, []byte("k2"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟写第三条记录时崩溃：只写入了一部分
+	path := filepath.Join(dir, dataFileName)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := fi.Size()
+	rec := encodeRecord(opSet, string(cfA[:])+"k3", []byte("v3"))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(rec[:len(rec)-1]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s = openTestStore(t, dir)
+	if v := mustGet(t, s, cfA, "k2"); string(v) != "v2" {
+		t.Errorf("k2 = %q", v)
+	}
+	if v := mustGet(t, s, cfA, "k3"); v != nil {
+		t.Errorf("torn k3 = %q", v)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != good {
+		t.Errorf("torn record should be truncated, size = %d, want %d", fi.Size(), good)
+	}
+
+	// 截断后继续写入
+	if err := s.Set(cfA, []byte("k3"), []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s = openTestStore(t, dir)
+	if v := mustGet(t, s, cfA, "k3"); string(v) != "v3" {
+		t.Errorf("k3 = %q", v)
+	}
+
+	// crc不符同样视为残缺记录
+	s.Close()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, dir)
+	if v := mustGet(t, s, cfA, "k3"); v != nil {
+		t.Errorf("corrupted k3 = %q", v)
+	}
+	if v := mustGet(t, s, cfA, "k1"); string(v) != "v1" {
+		t.Errorf("k1 = %q", v)
+	}
+}
+
+func TestFileStore_Compact(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	for i := 0; i < 100; i++ {
+		if err := s.Set(cfA, []byte("k"), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Set(cfB, []byte("k"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = NewFileStore(dir, true)
+	s.compactMinSize = 0
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCF(cfA)
+	s.RegisterCF(cfB)
+	if s.nrecs != 2 {
+		t.Errorf("nrecs = %d after compact, want 2", s.nrecs)
+	}
+	if err := s.Set(cfA, []byte("k2"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openTestStore(t, dir)
+	if v := mustGet(t, s, cfA, "k"); string(v) != "v99" {
+		t.Errorf("k = %q, want v99", v)
+	}
+	if v := mustGet(t, s, cfA, "k2"); string(v) != "v" {
+		t.Errorf("k2 = %q", v)
+	}
+	if v := mustGet(t, s, cfB, "k"); string(v) != "b" {
+		t.Errorf("cfB.k = %q", v)
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		cfg     config.StoreConfig
+		wantErr error
+	}{
+		{config.StoreConfig{Engine: Engine_File, Database: dir, Sync: true}, nil},
+		{config.StoreConfig{Database: dir}, nil},
+		{config.StoreConfig{Engine: Engine_Memory}, nil},
+		{config.StoreConfig{Engine: "badger", Database: dir}, ErrEngineRemoved},
+		{config.StoreConfig{Engine: "leveldb", Database: dir}, ErrUnknownEngine},
+	}
+	for _, tt := range tests {
+		s, err := New(&tt.cfg)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("New(%+v) err = %v, want %v", tt.cfg, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if err := s.Open(); err != nil {
+			t.Fatal(err)
+		}
+		s.RegisterCF(cfA)
+		if err := s.Set(cfA, []byte("k"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if v := mustGet(t, s, cfA, "k"); !bytes.Equal(v, []byte("v")) {
+			t.Errorf("%s: k = %q", tt.cfg.Engine, v)
+		}
+		s.Close()
+	}
+}
+
+func TestFileStore_Batch(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	if err := s.Set(cfA, []byte("k0"), []byte("v0")); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := s.NewBatch()
+	batch.Set(cfA, []byte("k1"), []byte("v1"))
+	batch.Set(cfB, []byte("k1"), []byte("b1"))
+	batch.Del(cfA, []byte("k0"))
+	if batch.Len() != 3 {
+		t.Errorf("batch len = %d, want 3", batch.Len())
+	}
+	if v := mustGet(t, s, cfA, "k1"); v != nil {
+		t.Error("batch should not take effect before commit")
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(); !errors.Is(err, ErrBatchCommitted) {
+		t.Errorf("err = %v, want %v", err, ErrBatchCommitted)
+	}
+	if v := mustGet(t, s, cfA, "k1"); string(v) != "v1" {
+		t.Errorf("k1 = %q", v)
+	}
+	if v := mustGet(t, s, cfA, "k0"); v != nil {
+		t.Errorf("deleted k0 = %q", v)
+	}
+
+	// 快照不受之后写入的影响
+	if v, _ := snap.Get(cfA, []byte("k0")); string(v) != "v0" {
+		t.Errorf("snapshot k0 = %q", v)
+	}
+	n := 0
+	snap.RangeCF(cfA, func(key, value []byte) error {
+		n++
+		return nil
+	})
+	if n != 1 {
+		t.Errorf("snapshot has %d keys in cfA, want 1", n)
+	}
+	snap.Release()
+
+	// 未注册的CF使整个批次失败
+	bad := s.NewBatch()
+	bad.Set(cfA, []byte("k2"), []byte("v2"))
+	bad.Set(requires.String2CF("cfc"), []byte("k2"), []byte("v2"))
+	if err := bad.Commit(); !errors.Is(err, ErrUnknownCF) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownCF)
+	}
+	if v := mustGet(t, s, cfA, "k2"); v != nil {
+		t.Error("failed batch should not take effect")
+	}
+	s.Close()
+
+	// 批次记录写到一半崩溃，重启后整个批次都不生效
+	path := filepath.Join(dir, dataFileName)
+	torn := s.NewBatch().(*fileBatch)
+	torn.Set(cfA, []byte("k3"), []byte("v3"))
+	torn.Set(cfB, []byte("k3"), []byte("b3"))
+	var value []byte
+	for _, o := range torn.ops {
+		value = append(value, encodeRecord(o.op, o.key, o.value)...)
+	}
+	rec := encodeRecord(opBatch, "", value)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(rec[:len(rec)-3]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s = openTestStore(t, dir)
+	if v := mustGet(t, s, cfA, "k3"); v != nil {
+		t.Errorf("torn batch k3 = %q", v)
+	}
+	if v := mustGet(t, s, cfB, "k1"); string(v) != "b1" {
+		t.Errorf("committed batch cfB.k1 = %q", v)
+	}
+	if v := mustGet(t, s, cfA, "k0"); v != nil {
+		t.Errorf("committed batch k0 = %q", v)
+	}
+}
+
+func TestFileStore_Hint(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	b := s.NewBatch()
+	b.Set(cfA, []byte("k1"), []byte("v1"))
+	b.Set(cfB, []byte("k1"), []byte("b1"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(cfA, []byte("k2"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	hintPath := filepath.Join(dir, hintFileName)
+	if _, err := os.Stat(hintPath); err != nil {
+		t.Fatalf("no hint file after close: %v", err)
+	}
+
+	// 索引文件之后追加的记录照常重放
+	path := filepath.Join(dir, dataFileName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(encodeRecord(opSet, string(cfA[:])+"k3", []byte("v3"))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s = openTestStore(t, dir)
+	if _, err := os.Stat(hintPath); !os.IsNotExist(err) {
+		t.Errorf("hint file should be removed on open, err = %v", err)
+	}
+	for _, kv := range []struct {
+		cf   requires.CF
+		k, v string
+	}{{cfA, "k1", "v1"}, {cfB, "k1", "b1"}, {cfA, "k2", "v2"}, {cfA, "k3", "v3"}} {
+		if v := mustGet(t, s, kv.cf, kv.k); string(v) != kv.v {
+			t.Errorf("%s = %q, want %q", kv.k, v, kv.v)
+		}
+	}
+	if s.nrecs != 4 {
+		t.Errorf("nrecs = %d, want 4", s.nrecs)
+	}
+	s.Close()
+
+	// 损坏的索引文件被忽略，从头重放
+	data, err := ioutil.ReadFile(hintPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := ioutil.WriteFile(hintPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, dir)
+	if v := mustGet(t, s, cfA, "k3"); string(v) != "v3" {
+		t.Errorf("k3 = %q after bad hint", v)
+	}
+	s.Close()
+
+	// 日志中value被改写时读取报错，而不是返回错误的数据
+	h, err := readHint(hintPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := h.index[string(cfA[:])+"k1"]
+	data, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[loc.off+int64(loc.n)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, dir)
+	if _, err := s.Get(cfA, []byte("k1")); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("err = %v, want %v", err, ErrCorrupted)
+	}
+	s.Close()
+}
+
+func TestFileStore_CompactOnWrite(t *testing.T) {
+	for _, dir := range []string{t.TempDir(), ""} {
+		s := NewFileStore(dir, false)
+		s.compactMinSize = 0
+		if err := s.Open(); err != nil {
+			t.Fatal(err)
+		}
+		s.RegisterCF(cfA)
+		if err := s.Set(cfA, []byte("a"), []byte("a")); err != nil {
+			t.Fatal(err)
+		}
+		snap, err := s.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			if err := s.Set(cfA, []byte("k"), []byte(fmt.Sprintf("v%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if s.nrecs > 2*len(s.index) {
+			t.Errorf("dir %q: nrecs = %d with %d keys, log not compacted", dir, s.nrecs, len(s.index))
+		}
+		if v := mustGet(t, s, cfA, "k"); string(v) != "v9" {
+			t.Errorf("dir %q: k = %q", dir, v)
+		}
+		// compact之前的快照仍读旧日志
+		if v, err := snap.Get(cfA, []byte("a")); err != nil || string(v) != "a" {
+			t.Errorf("dir %q: snapshot a = %q, %v", dir, v, err)
+		}
+		snap.Release()
+		s.Close()
+	}
+}
+
+// tornWriter 只写入前n字节就返回错误，模拟写到一半失败
+type tornWriter struct {
+	f     *os.File
+	n     int
+	after func() // 写入失败后执行，nil则什么也不做
+}
+
+var errTornWrite = errors.New("torn write")
+
+func (w *tornWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p[:w.n])
+	if err == nil {
+		err = errTornWrite
+	}
+	if w.after != nil {
+		w.after()
+	}
+	return n, err
+}
+
+func TestFileStore_FailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	if err := s.Set(cfA, []byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 写到一半失败，日志截断回原长度，之后的写入照常
+	s.log.w = &tornWriter{f: s.log.f, n: 5}
+	if err := s.Set(cfA, []byte("b"), []byte("2")); !errors.Is(err, errTornWrite) {
+		t.Fatalf("err = %v, want errTornWrite", err)
+	}
+	s.log.w = s.log.f
+	if err := s.Set(cfA, []byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, s, cfA, "c"); string(v) != "3" {
+		t.Errorf("c = %q", v)
+	}
+	if v := mustGet(t, s, cfA, "b"); v != nil {
+		t.Errorf("failed write visible: b = %q", v)
+	}
+
+	// 截断也失败时拒绝之后的写入
+	s.log.w = &tornWriter{f: s.log.f, n: 5, after: func() { s.log.f.Close() }}
+	if err := s.Set(cfA, []byte("d"), []byte("4")); !errors.Is(err, ErrBroken) {
+		t.Fatalf("err = %v, want ErrBroken", err)
+	}
+	if err := s.Set(cfA, []byte("e"), []byte("5")); !errors.Is(err, ErrBroken) {
+		t.Errorf("err = %v, want ErrBroken", err)
+	}
+	s.Close()
+
+	// 重新打开后，失败之前的写入都在，残缺记录被截掉
+	r := openTestStore(t, dir)
+	defer r.Close()
+	for k, want := range map[string]string{"a": "1", "c": "3"} {
+		if v := mustGet(t, r, cfA, k); string(v) != want {
+			t.Errorf("%s = %q, want %q", k, v, want)
+		}
+	}
+	if err := r.Set(cfA, []byte("f"), []byte("6")); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, r, cfA, "f"); string(v) != "6" {
+		t.Errorf("f = %q", v)
+	}
+}
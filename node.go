@@ -7,11 +7,15 @@
 package bcc
 
 import (
+	"errors"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/config"
 	"github.com/azd1997/blockchain-consensus/defines"
 	"github.com/azd1997/blockchain-consensus/modules/bnet"
 	"github.com/azd1997/blockchain-consensus/modules/peerinfo"
 	"github.com/azd1997/blockchain-consensus/requires"
-	"github.com/azd1997/blockchain-consensus/utils/log"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
 )
 
 /*
@@ -38,19 +42,43 @@ import (
 
 // Option Node所需的选项
 type Option struct {
+	// 账户
+	Id   string
+	Duty defines.PeerDuty
+	Key  crypto.PrivateKey
+
+	// 共识，按Consensus.Type从注册表中选取，见RegisterConsensus
+	Consensus config.ConsensusConfig
+	// Config 完整配置，原样传给共识模块，可以为nil
+	Config *config.TomlConfig
+	// Validators 应用层的交易语义校验器，原样传给共识模块
+	Validators []requires.Validator
+
+	// 网络
+	// Listener和Dialer都为nil时，按Protocol在Addr上构建默认的Listener和Dialer
+	Addr     string
+	Protocol string
+	Listener requires.Listener
+	Dialer   requires.Dialer
+	// ConnTimeout 连接写超时，为0则使用bnet.DefaultConnTimeout
+	ConnTimeout time.Duration
+	// KeepaliveInterval KeepaliveMaxMiss 连接保活，含义同bnet.Option
+	KeepaliveInterval time.Duration
+	KeepaliveMaxMiss  int
+	// SendQueueCap 每个对端的发送队列容量，为0则使用bnet.DefaultSendQueueCap
+	SendQueueCap int
+	// Gossip 非nil则启用gossip扩散
+	Gossip *bnet.GossipOption
+
+	// 外部依赖
+	Kv requires.Store
+	BC requires.BlockChain
+
+	// 预配置的种子节点和共识节点 <id, addr>
+	Seeds map[string]string
+	Peers map[string]string
 }
 
-// PrepareOption 准备Option
-//func PrepareOption(configReader io.Reader) (*Option, error) {
-//	// 解析配置
-//	tc, err := config.ParseConfig(configReader)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	、、
-//}
-
 // Node 节点服务器
 type Node struct {
 
@@ -58,11 +86,14 @@ type Node struct {
 	id   string
 	duty defines.PeerDuty
 	addr string
+	key  crypto.PrivateKey
 
 	// kv 存储
 	kv requires.Store
 	// bc 区块链（相当于日志持久器）
 	bc requires.BlockChain
+	// clog 共识状态日志，与bc共用kv
+	clog *ConsensusLog
 
 	// 节点信息表
 	pit *peerinfo.PeerInfoTable
@@ -73,55 +104,121 @@ type Node struct {
 	// 网络模块
 	net *bnet.Net
 
-	// 日志输出目的地
-	LogDest log.LogDest
+	// 停止监听配置热更新，见WatchConfig
+	stopWatch func()
 }
 
 // NewNode 构建Node
+// 日志使用全局Logger，调用前需先log.InitGlobalLogger
 func NewNode(
-	id string, duty defines.PeerDuty, // 账户配置
-	consensusType string, // 共识配置
+	id string, duty defines.PeerDuty, key crypto.PrivateKey, // 账户配置
+	css config.ConsensusConfig, // 共识配置
 	ln requires.Listener, dialer requires.Dialer, // 网络配置
 	kv requires.Store, bc requires.BlockChain, // 外部依赖
-	logdest log.LogDest, // 日志配置
+	validators ...requires.Validator, // 应用层的交易校验
 ) (*Node, error) {
+	return NewNodeWithOption(&Option{
+		Id:         id,
+		Duty:       duty,
+		Key:        key,
+		Consensus:  css,
+		Listener:   ln,
+		Dialer:     dialer,
+		Kv:         kv,
+		BC:         bc,
+		Validators: validators,
+	})
+}
 
+// NewNodeWithOption 按Option构建Node
+func NewNodeWithOption(opt *Option) (*Node, error) {
+	if opt.Key == nil {
+		return nil, errors.New("nil private key")
+	}
+
+	addr := opt.Addr
+	if opt.Listener != nil {
+		addr = opt.Listener.LocalListenAddr().String()
+	}
 	node := &Node{
-		id: id,
-		kv: kv,
-		bc: bc,
+		id:   opt.Id,
+		duty: opt.Duty,
+		addr: addr,
+		key:  opt.Key,
+		kv:   opt.Kv,
+		bc:   opt.BC,
 	}
 
 	// 构建节点表
-	pit := peerinfo.NewPeerInfoTable(kv)
-	err := pit.Init()
+	pit, err := peerinfo.NewPeerInfoTable(opt.Id, opt.Kv)
+	if err != nil {
+		return nil, err
+	}
+	err = pit.Init()
 	if err != nil {
 		return nil, err
 	}
 	node.pit = pit
+	// 预配置节点表：自己以及配置中的seeds/peers
+	if err := pit.Set(&defines.PeerInfo{
+		Id:     node.id,
+		Addr:   node.addr,
+		Duty:   node.duty,
+		PubKey: crypto.MarshalPublicKey(opt.Key.Public()),
+	}); err != nil {
+		return nil, err
+	}
+	pit.AddSeeds(opt.Seeds)
+	pit.AddPeers(opt.Peers)
+
+	// 共识状态日志
+	clog, err := NewConsensusLog(opt.Kv)
+	if err != nil {
+		return nil, err
+	}
+	node.clog = clog
 
 	// 构建共识状态机
-	css, err := NewConsensus(consensusType)
+	node.css, err = NewConsensus(opt.Consensus.Type, &ConsensusOption{
+		Id:         opt.Id,
+		Duty:       opt.Duty,
+		Key:        opt.Key,
+		Pit:        pit,
+		BC:         opt.BC,
+		Log:        clog,
+		Config:     opt.Config,
+		Validators: opt.Validators,
+	})
 	if err != nil {
 		return nil, err
 	}
-	node.css = css
-	cssin, cssout := css.InMsgChan(), css.OutMsgChan()
+	cssin, cssout := node.css.MsgInChan(), node.css.MsgOutChan()
 
 	// 构建网络模块
-	opt := &bnet.Option{
-		Listener: ln,
-		Dialer:   dialer,
-		MsgIn:    cssout,
-		MsgOut:   cssin,
-		LogDest:  logdest,
-		Pit:      pit,
-	}
-	netmod, err := bnet.NewNet(opt)
+	netmod, err := bnet.NewNet(&bnet.Option{
+		Id:          opt.Id,
+		Addr:        node.addr,
+		Key:         opt.Key,
+		Protocol:    opt.Protocol,
+		Listener:    opt.Listener,
+		Dialer:      opt.Dialer,
+		MsgIn:       cssout,
+		MsgOut:      cssin,
+		Pit:         pit,
+		ConnTimeout: opt.ConnTimeout,
+
+		KeepaliveInterval: opt.KeepaliveInterval,
+		KeepaliveMaxMiss:  opt.KeepaliveMaxMiss,
+		SendQueueCap:      opt.SendQueueCap,
+		Gossip:            opt.Gossip,
+	})
 	if err != nil {
 		return nil, err
 	}
 	node.net = netmod
+	if h, ok := node.css.(ConnEventHandler); ok {
+		netmod.SubscribeConnEvent(h.HandleConnEvent)
+	}
 
 	return node, nil
 }
@@ -129,19 +226,31 @@ func NewNode(
 // Init 初始化
 func (s *Node) Init() error {
 	// 准备好PeerInfoTable
-	err := s.pit.Init()
-	if err != nil {
-		return err
+	if !s.pit.Inited() {
+		err := s.pit.Init()
+		if err != nil {
+			return err
+		}
 	}
 
 	// 网络模块初始化
-	err = s.net.Init()
-	if err != nil {
-		return err
+	if !s.net.Inited() {
+		err := s.net.Init()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 区块链初始化
+	if !s.bc.Inited() {
+		err := s.bc.Init()
+		if err != nil {
+			return err
+		}
 	}
 
 	// 共识模块初始化
-	err = s.css.Init()
+	err := s.css.Init()
 	if err != nil {
 		return err
 	}
@@ -149,6 +258,20 @@ func (s *Node) Init() error {
 	return nil
 }
 
+// Close 关闭Node。先关闭共识模块，再关闭网络模块和节点表
+func (s *Node) Close() error {
+	if s.stopWatch != nil {
+		s.stopWatch()
+	}
+	if err := s.css.Close(); err != nil {
+		return err
+	}
+	if err := s.net.Close(); err != nil {
+		return err
+	}
+	return s.pit.Close()
+}
+
 // Ok 检查Node是否非空，以及内部一些成员是否准备好
 func (s *Node) Ok() bool {
 	return s != nil && s.css != nil && s.net != nil && s.bc != nil
@@ -0,0 +1,187 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/16/20 11:05 AM
+* @Description: 根据配置文件构建Node
+***********************************************************************/
+
+package bcc
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/config"
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/modules/blockchain"
+	"github.com/azd1997/blockchain-consensus/modules/bnet"
+	"github.com/azd1997/blockchain-consensus/modules/store"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+// NewNodeFromConfigFile 读取配置文件构建Node，见NewNodeFromConfig
+// 构建的Node在收到SIGUSR1时重新加载该文件，见WatchConfig
+func NewNodeFromConfigFile(path string, validators ...requires.Validator) (*Node, error) {
+	r, err := config.NewReloader(path)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := PrepareOption(r.Config())
+	if err != nil {
+		return nil, err
+	}
+	opt.Validators = validators
+	node, err := NewNodeWithOption(opt)
+	if err != nil {
+		return nil, err
+	}
+	node.WatchConfig(r)
+	return node, nil
+}
+
+// NewNodeFromConfig 解析并校验配置，构建一个可以直接Init的Node
+// 存储、区块链、网络与共识模块都按配置使用内置实现。需要替换其中某个的，使用NewNodeWithOption
+// 全局Logger以配置中的账户为id初始化(已初始化则沿用)
+// validators为应用层的交易语义校验器，配置文件无法表达，由调用方传入
+func NewNodeFromConfig(r io.Reader, validators ...requires.Validator) (*Node, error) {
+	cfg, err := config.ParseConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := PrepareOption(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opt.Validators = validators
+	return NewNodeWithOption(opt)
+}
+
+// PrepareOption 根据配置准备Option：加载私钥，构建存储与区块链，解析seeds/peers
+func PrepareOption(cfg *config.TomlConfig) (*Option, error) {
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+
+	// 账户
+	duty := map[string]defines.PeerDuty{
+		config.Duty_None: defines.PeerDuty_None,
+		config.Duty_Peer: defines.PeerDuty_Peer,
+		config.Duty_Seed: defines.PeerDuty_Seed,
+	}[cfg.Account.Duty]
+	key, err := loadOrCreateKey(cfg.Account.Key)
+	if err != nil {
+		return nil, err
+	}
+	id := identity.FromPublicKey(key.Public())
+	if cfg.Account.Id != "" {
+		cid, err := identity.Decode(cfg.Account.Id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: account.id: %s", config.ErrInvalidConfig, err)
+		}
+		if cid != id {
+			return nil, fmt.Errorf("%w: account.id(%s) is not derived from account.key(%s)",
+				config.ErrInvalidConfig, cfg.Account.Id, identity.EncodeHex(id))
+		}
+	}
+	log.InitGlobalLogger(id, false, false)
+	if cfg.Log.Level != "" {
+		if err := log.SetLevel(id, cfg.Log.Level); err != nil {
+			return nil, fmt.Errorf("%w: log.level: %s", config.ErrInvalidConfig, err)
+		}
+	}
+
+	seeds, err := identity.DecodeMap(cfg.Seeds)
+	if err != nil {
+		return nil, fmt.Errorf("%w: seeds: %s", config.ErrInvalidConfig, err)
+	}
+	peers, err := identity.DecodeMap(cfg.Peers)
+	if err != nil {
+		return nil, fmt.Errorf("%w: peers: %s", config.ErrInvalidConfig, err)
+	}
+
+	// 存储与区块链
+	kv, err := store.New(&cfg.Store)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := blockchain.NewBlockChain(id, key, kv)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := &Option{
+		Id:          id,
+		Duty:        duty,
+		Key:         key,
+		Consensus:   cfg.Consensus,
+		Config:      cfg,
+		Addr:        cfg.Bnet.Addr,
+		Protocol:    cfg.Bnet.Protocol,
+		ConnTimeout: time.Duration(cfg.Bnet.TimeoutMs) * time.Millisecond,
+
+		KeepaliveInterval: time.Duration(cfg.Bnet.KeepaliveMs) * time.Millisecond,
+		KeepaliveMaxMiss:  cfg.Bnet.KeepaliveMaxMiss,
+		SendQueueCap:      cfg.Bnet.SendQueueCap,
+		Kv:                kv,
+		BC:                bc,
+		Seeds:             seeds,
+		Peers:             peers,
+	}
+	if g := cfg.Bnet.Gossip; g.Enable {
+		opt.Gossip = &bnet.GossipOption{
+			Fanout:       g.Fanout,
+			TTL:          g.TTL,
+			CacheSize:    g.CacheSize,
+			PullInterval: time.Duration(g.PullMs) * time.Millisecond,
+		}
+	}
+	return opt, nil
+}
+
+// loadOrCreateKey 读取私钥文件，文件不存在时生成新的私钥并写入
+func loadOrCreateKey(path string) (crypto.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		return crypto.UnmarshalPrivateKey(b)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, crypto.MarshalPrivateKey(key), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WatchConfig 收到SIGUSR1时通过r重新加载配置，并将变化应用到节点表、网络模块和日志级别
+// 需要重启才能生效的修改会被拒绝，节点继续使用当前配置运行。Close时停止监听
+func (s *Node) WatchConfig(r *config.Reloader) {
+	r.Subscribe(s.HandleConfigEvent)
+	s.stopWatch = r.WatchSignal()
+}
+
+// HandleConfigEvent 应用配置变化
+// 节点表先于网络模块处理，网络模块重新建连时使用的是新地址
+func (s *Node) HandleConfigEvent(ev config.Event) {
+	s.pit.HandleConfigEvent(ev)
+	s.net.HandleConfigEvent(ev)
+	if e, ok := ev.(*config.LogLevelChanged); ok {
+		if err := log.SetLevel(s.id, e.New); err != nil {
+			s.pit.Errorf("set log level fail: %s", err)
+		}
+	}
+}
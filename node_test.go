@@ -1,7 +1,215 @@
 /**********************************************************************
 * @Author: Eiger (201820114847@mail.scut.edu.cn)
 * @Date: 12/14/20 10:22 AM
-* @Description: The file is for
+* @Description: Node测试
 ***********************************************************************/
 
 package bcc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/config"
+	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/implements/pot"
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/test"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+	"github.com/azd1997/blockchain-consensus/utils/log"
+)
+
+func TestNewNode(t *testing.T) {
+	key, id := genKey(t)
+	log.InitGlobalLogger(id, false, false)
+	p, err := genPeer(key, id, "127.0.0.1:8087")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.ln.Close()
+
+	newNode := func(typ string) (*Node, error) {
+		return NewNode(id, defines.PeerDuty_Peer, key,
+			config.ConsensusConfig{Type: typ},
+			p.ln, p.d,
+			test.NewStore(), test.NewBlockChain(id, key))
+	}
+
+	node, err := newNode("Pot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node.css.(*pot.Pot); !ok || !node.Ok() {
+		t.Errorf("node.css = %T, want *pot.Pot", node.css)
+	}
+
+	if _, err := newNode("raft"); !errors.Is(err, ErrUnknownConsensus) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownConsensus)
+	}
+
+	// 应用层的校验器随Node一起传给共识模块
+	var got []requires.Validator
+	consensusFactoriesLock.Lock()
+	consensusFactories[ConsensusType_Pot] = func(opt *ConsensusOption) (Consensus, error) {
+		got = opt.Validators
+		return newPot(opt)
+	}
+	consensusFactoriesLock.Unlock()
+	defer func() {
+		consensusFactoriesLock.Lock()
+		consensusFactories[ConsensusType_Pot] = newPot
+		consensusFactoriesLock.Unlock()
+	}()
+	v := requires.ValidatorFunc(func(b *defines.Block, tx *defines.Transaction) error { return nil })
+	if _, err := NewNode(id, defines.PeerDuty_Peer, key, config.ConsensusConfig{Type: "pot"},
+		p.ln, p.d, test.NewStore(), test.NewBlockChain(id, key), v); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Errorf("consensus got %d validators, want 1", len(got))
+	}
+}
+
+func TestNewNodeFromConfig(t *testing.T) {
+	_, seed := genKey(t)
+	keyPath := filepath.Join(t.TempDir(), "node.key")
+	tomlConfig := func(id, duty string) string {
+		return fmt.Sprintf(`
+[account]
+id = "%s"
+duty = "%s"
+key = "%s"
+
+[consensus]
+type = "pot"
+
+[store]
+engine = "memory"
+
+[bnet]
+protocol = "btls"
+addr = "127.0.0.1:8088"
+
+[seeds]
+"%s" = "127.0.0.1:8089"
+`, id, duty, keyPath, identity.EncodeHex(seed))
+	}
+
+	// 首次启动生成私钥
+	node, err := NewNodeFromConfig(strings.NewReader(tomlConfig("", "peer")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !node.Ok() || !node.IsConsensusNode() || node.addr != "127.0.0.1:8088" {
+		t.Errorf("node = %+v", node)
+	}
+	if info, err := node.pit.Get(seed); err != nil || info.Duty != defines.PeerDuty_Seed {
+		t.Errorf("seed not in PeerInfoTable: %v, %v", info, err)
+	}
+	if info, err := node.pit.Get(node.id); err != nil || len(info.PubKey) == 0 {
+		t.Errorf("self not in PeerInfoTable: %v, %v", info, err)
+	}
+	id := node.id
+	if err := node.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 之后沿用同一私钥
+	node, err = NewNodeFromConfig(strings.NewReader(tomlConfig(identity.EncodeHex(id), "seed")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.id != id || node.duty != defines.PeerDuty_Seed {
+		t.Errorf("node id = %s, duty = %v", identity.EncodeHex(node.id), node.duty)
+	}
+	node.Close()
+
+	tests := []string{
+		tomlConfig(identity.EncodeHex(seed), "peer"), // id与私钥不符
+		tomlConfig("", "worker"),
+		strings.Replace(tomlConfig("", "peer"), `addr = "127.0.0.1:8088"`, "", 1),
+	}
+	for _, tt := range tests {
+		if _, err := NewNodeFromConfig(strings.NewReader(tt)); !errors.Is(err, config.ErrInvalidConfig) {
+			t.Errorf("err = %v, want %v", err, config.ErrInvalidConfig)
+		}
+	}
+}
+
+func TestNode_WatchConfig(t *testing.T) {
+	_, seed := genKey(t)
+	_, peer := genKey(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	tomlConfig := func(nodes string, timeoutMs int) string {
+		return fmt.Sprintf(`
+[account]
+duty = "peer"
+key = "%s"
+
+[consensus]
+type = "pot"
+
+[store]
+engine = "memory"
+
+[bnet]
+protocol = "btcp"
+addr = "127.0.0.1:8097"
+timeout_ms = %d
+
+%s
+`, filepath.Join(dir, "node.key"), timeoutMs, nodes)
+	}
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(tomlConfig(fmt.Sprintf("[seeds]\n\"%s\" = \"127.0.0.1:8089\"", identity.EncodeHex(seed)), 5000))
+	node, err := NewNodeFromConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	if node.net.ConnTimeout() != 5*time.Second || !node.pit.IsSeed(seed) {
+		t.Fatalf("initial config not applied: timeout = %s", node.net.ConnTimeout())
+	}
+
+	// 种子换成普通节点，缩短超时，发送SIGUSR1
+	write(tomlConfig(fmt.Sprintf("[peers]\n\"%s\" = \"127.0.0.1:8090\"", identity.EncodeHex(peer)), 1000))
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for node.net.ConnTimeout() != time.Second && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if node.net.ConnTimeout() != time.Second {
+		t.Fatalf("timeout = %s after reload, want 1s", node.net.ConnTimeout())
+	}
+	if node.pit.IsSeed(seed) {
+		t.Error("removed seed still in PeerInfoTable")
+	}
+	if info, err := node.pit.Get(peer); err != nil || info.Addr != "127.0.0.1:8090" {
+		t.Errorf("added peer not in PeerInfoTable: %v, %v", info, err)
+	}
+
+	// 修改监听地址需要重启，被拒绝
+	write(strings.Replace(tomlConfig("", 2000), "127.0.0.1:8097", "127.0.0.1:8098", 1))
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if node.net.ConnTimeout() != time.Second {
+		t.Errorf("rejected config applied: timeout = %s", node.net.ConnTimeout())
+	}
+}
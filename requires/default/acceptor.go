@@ -0,0 +1,94 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 12/26/20 10:00 AM
+* @Description: 并发握手的接受循环，TCPListener/TLSListener共用
+***********************************************************************/
+
+package _default
+
+import (
+	"net"
+	"sync"
+
+	"github.com/azd1997/blockchain-consensus/requires"
+)
+
+// MaxPendingHandshakes 同时进行中的入站握手数上限，超出后暂停接受新连接
+const MaxPendingHandshakes = 64
+
+// acceptResult 一次入站握手的结果
+type acceptResult struct {
+	conn requires.Conn
+	err  error
+}
+
+// acceptor 在后台接受TCP连接，每个连接在自己的goroutine中握手
+// 握手最长可能耗时DefaultHandshakeTimeout，放在接受循环里做的话，一个迟迟不发数据的客户端就会卡住所有后来者
+type acceptor struct {
+	ln        *net.TCPListener
+	handshake func(c net.Conn) (requires.Conn, error) // 失败时由acceptor关闭c
+
+	once    sync.Once
+	results chan acceptResult
+	slots   chan struct{} // 握手并发数的信号量
+	done    chan struct{} // 接受循环退出后关闭
+	err     error         // 接受循环退出的原因，done关闭后可读
+}
+
+func newAcceptor(ln *net.TCPListener, handshake func(c net.Conn) (requires.Conn, error)) *acceptor {
+	return &acceptor{
+		ln:        ln,
+		handshake: handshake,
+		results:   make(chan acceptResult),
+		slots:     make(chan struct{}, MaxPendingHandshakes),
+		done:      make(chan struct{}),
+	}
+}
+
+// accept 返回下一个握手完成(成功或失败)的连接
+// 握手失败的连接也会返回其错误，调用方可以记录后继续accept
+func (a *acceptor) accept() (requires.Conn, error) {
+	a.once.Do(func() { go a.loop() })
+	select {
+	case r := <-a.results:
+		return r.conn, r.err
+	case <-a.done:
+		return nil, a.err
+	}
+}
+
+// loop 接受循环，监听套接字关闭后退出
+func (a *acceptor) loop() {
+	for {
+		a.slots <- struct{}{}
+		c, err := a.ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				<-a.slots
+				continue
+			}
+			a.err = err
+			close(a.done)
+			return
+		}
+		go a.serve(c)
+	}
+}
+
+// serve 对c握手并交出结果
+func (a *acceptor) serve(c net.Conn) {
+	defer func() { <-a.slots }()
+
+	rc, err := a.handshake(c)
+	if err != nil {
+		c.Close()
+	}
+	select {
+	case a.results <- acceptResult{conn: rc, err: err}:
+	case <-a.done:
+		// 已关闭，无人接收
+		if rc != nil {
+			rc.Close()
+		}
+	}
+}
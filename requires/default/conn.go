@@ -18,6 +18,7 @@ type TCPConn struct {
 	conn                              *net.TCPConn
 	localId, remoteId                 string
 	localListenAddr, remoteListenAddr *net.TCPAddr
+	remotePubKey                      []byte // 握手时对端出示并已验证的公钥
 }
 
 func (T *TCPConn) ok() bool {
@@ -99,3 +100,8 @@ func (T *TCPConn) LocalListenAddr() net.Addr {
 func (T *TCPConn) RemoteListenAddr() net.Addr {
 	return T.remoteListenAddr
 }
+
+// RemotePubKey 握手时对端出示并已验证的公钥(crypto.MarshalPublicKey格式)
+func (T *TCPConn) RemotePubKey() []byte {
+	return T.remotePubKey
+}
@@ -14,10 +14,20 @@ import (
 
 	"github.com/azd1997/blockchain-consensus/requires"
 	"github.com/azd1997/blockchain-consensus/utils/address"
-	"github.com/azd1997/blockchain-consensus/utils/binary"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
-func NewDialer(id, addr string, timeout time.Duration) (*TCPDialer, error) {
+// NewDialer 新建TCPDialer
+// key 为本地私钥，id必须由其公钥派生
+// verifier 用于握手时校验对端身份，可为nil
+func NewDialer(id, addr string, timeout time.Duration, key crypto.PrivateKey, verifier PeerVerifier) (*TCPDialer, error) {
+	if key == nil {
+		return nil, errors.New("nil private key")
+	}
+	if err := identity.MatchPublicKey(id, key.Public()); err != nil {
+		return nil, err
+	}
 	localAddr, err := address.ParseTCP4(addr)
 	if err != nil {
 		return nil, err
@@ -31,6 +41,8 @@ func NewDialer(id, addr string, timeout time.Duration) (*TCPDialer, error) {
 		d:               d,
 		localListenAddr: localAddr,
 		localId:         id,
+		key:             key,
+		verifier:        verifier,
 	}, nil
 }
 
@@ -38,6 +50,8 @@ type TCPDialer struct {
 	d               *net.Dialer
 	localListenAddr *net.TCPAddr
 	localId         string
+	key             crypto.PrivateKey
+	verifier        PeerVerifier
 }
 
 func (T *TCPDialer) ok() bool {
@@ -48,6 +62,9 @@ func (T *TCPDialer) Dial(addr, remoteId string) (requires.Conn, error) {
 	if !T.ok() {
 		return nil, errors.New("TCPDialer not ok")
 	}
+	if err := identity.Validate(remoteId); err != nil {
+		return nil, err
+	}
 
 	conn, err := T.d.Dial("tcp", addr)
 	if err != nil {
@@ -70,10 +87,12 @@ func (T *TCPDialer) Dial(addr, remoteId string) (requires.Conn, error) {
 	// 握手
 	err = T.handshake(rc)
 	if err != nil {
+		rc.conn.Close()
 		return nil, err
 	}
 
-	log.Printf("dialer dial {from: %s(%s), to: %s(%s)} succ\n", T.localId, T.localListenAddr, remoteId, remoteAddr)
+	log.Printf("dialer dial {from: %s(%s), to: %s(%s)} succ\n",
+		identity.Short(T.localId), T.localListenAddr, identity.Short(remoteId), remoteAddr)
 	return rc, nil
 }
 
@@ -90,14 +109,13 @@ func (T *TCPDialer) LocalListenAddr() net.Addr {
 }
 
 // 握手
+// 向对端证明自身身份，并要求对端证明其持有remoteId对应的私钥，见handshake.go
 func (T *TCPDialer) handshake(c *TCPConn) error {
-	// 建立连接后紧跟着发送 “idlen | id | addrlen | addr” 消息
-	idlen := uint8(len(T.localId))
-	localAddr := T.localListenAddr.String()
-	addrlen := uint8(len(localAddr))
-	err := binary.Write(c.conn, binary.BigEndian, idlen, []byte(T.localId), addrlen, []byte(localAddr))
+	pk, err := dialerHandshake(c.conn, T.key, T.localId, T.localListenAddr.String(), c.remoteId,
+		c.remoteListenAddr.String(), T.verifier, DefaultHandshakeTimeout)
 	if err != nil {
 		return err
 	}
+	c.remotePubKey = pk
 	return nil
 }
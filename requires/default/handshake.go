@@ -0,0 +1,287 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/20/20 9:30 AM
+* @Description: 建立连接时的身份认证握手(挑战-应答)
+***********************************************************************/
+
+package _default
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/utils/binary"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	握手流程(D为发起连接方Dialer，L为接受连接方Listener)：
+
+	1. D -> L: 版本(1B) | idlen(1B) | idD | addrlen(1B) | addrD | addrlen(1B) | addrL | pklen(2B) | pkD | nonceD(32B)
+	2. L -> D: idlen(1B) | idL | pklen(2B) | pkL | nonceL(32B) | siglen(2B) | sigL
+	3. D -> L: siglen(2B) | sigD
+
+	其中 pk 为 crypto.MarshalPublicKey 序列化的公钥，双方都需检查 id 是否由 pk 派生
+	sigL = SignL( transcript(L) ), sigD = SignD( transcript(D) )
+	transcript(role) = 域标识 | role | nonceD | nonceL | idD | idL | pkD | pkL | addrD | addrL
+
+	双方各自对对方提供的新鲜nonce签名，证明自己持有声称的ID对应的私钥，且签名无法被重放
+	addrD为D的监听地址(L之后会按它回连)，addrL为D实际拨号的地址，二者都被签名覆盖，中间人无法改写。
+	由于NAT、端口转发等，addrL不一定等于L的监听地址，L不做相等检查
+*/
+
+const (
+	// HandshakeVersion 握手协议版本
+	HandshakeVersion uint8 = 2
+	// HandshakeNonceLen 握手随机数长度
+	HandshakeNonceLen = 32
+	// DefaultHandshakeTimeout 默认握手超时
+	DefaultHandshakeTimeout = 5 * time.Second
+
+	handshakeDomain = "bcc-handshake"
+	roleDialer      = byte('D')
+	roleListener    = byte('L')
+)
+
+var (
+	ErrHandshakeVersion  = errors.New("handshake: unsupported version")
+	ErrHandshakeRemoteId = errors.New("handshake: unexpected remote id")
+	ErrHandshakeSig      = errors.New("handshake: verify sig fail")
+)
+
+// PeerVerifier 握手时校验对端身份
+// 调用时已确认id由pubkey派生，PeerVerifier再结合本地节点信息表等决定是否接受该连接
+// 返回错误则拒绝连接
+type PeerVerifier func(id string, pubkey []byte) error
+
+// handshakeState 握手过程中双方的信息
+type handshakeState struct {
+	idD, idL       string
+	pkD, pkL       []byte
+	nonceD, nonceL []byte
+	addrD, addrL   string
+}
+
+// transcript 签名覆盖的内容
+func (hs *handshakeState) transcript(role byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(handshakeDomain)
+	buf.WriteByte(role)
+	buf.Write(hs.nonceD)
+	buf.Write(hs.nonceL)
+	buf.WriteString(hs.idD)
+	buf.WriteString(hs.idL)
+	buf.Write(hs.pkD)
+	buf.Write(hs.pkL)
+	writeString8(buf, hs.addrD)
+	writeString8(buf, hs.addrL)
+	return buf.Bytes()
+}
+
+// writeString8 写入 len(1B) | s，地址长度不定，加上长度避免拼接歧义
+func writeString8(buf *bytes.Buffer, s string) {
+	buf.WriteByte(uint8(len(s)))
+	buf.WriteString(s)
+}
+
+// checkPeer 检查对端id与公钥是否匹配，并交给verifier进一步校验
+func checkPeer(id string, pk []byte, verifier PeerVerifier) error {
+	if err := identity.MatchPubKey(id, pk); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+	if verifier != nil {
+		if err := verifier(id, pk); err != nil {
+			return fmt.Errorf("handshake: peer refused: %w", err)
+		}
+	}
+	return nil
+}
+
+// dialerHandshake 发起方握手
+// localAddr为本地监听地址，remoteAddr为拨号的地址
+// 返回对端的公钥
+func dialerHandshake(c net.Conn, key crypto.PrivateKey, localId, localAddr, remoteId, remoteAddr string,
+	verifier PeerVerifier, timeout time.Duration) ([]byte, error) {
+
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	defer c.SetDeadline(time.Time{})
+
+	hs := &handshakeState{
+		idD:    localId,
+		idL:    remoteId,
+		pkD:    crypto.MarshalPublicKey(key.Public()),
+		addrD:  localAddr,
+		addrL:  remoteAddr,
+		nonceD: make([]byte, HandshakeNonceLen),
+	}
+	if _, err := io.ReadFull(rand.Reader, hs.nonceD); err != nil {
+		return nil, err
+	}
+
+	// 1. 发送自身身份与挑战
+	err := binary.Write(c, binary.BigEndian,
+		HandshakeVersion,
+		uint8(len(hs.idD)), []byte(hs.idD),
+		uint8(len(hs.addrD)), []byte(hs.addrD),
+		uint8(len(hs.addrL)), []byte(hs.addrL),
+		uint16(len(hs.pkD)), hs.pkD,
+		hs.nonceD)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 读取对端身份、挑战与签名
+	idL, err := readBytes8(c)
+	if err != nil {
+		return nil, err
+	}
+	if string(idL) != remoteId {
+		return nil, ErrHandshakeRemoteId
+	}
+	if hs.pkL, err = readBytes16(c); err != nil {
+		return nil, err
+	}
+	if err := checkPeer(remoteId, hs.pkL, verifier); err != nil {
+		return nil, err
+	}
+	hs.nonceL = make([]byte, HandshakeNonceLen)
+	if _, err := io.ReadFull(c, hs.nonceL); err != nil {
+		return nil, err
+	}
+	sigL, err := readBytes16(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := crypto.Verify(hs.pkL, hs.transcript(roleListener), sigL); err != nil {
+		return nil, ErrHandshakeSig
+	}
+
+	// 3. 应答对端挑战
+	sigD, err := key.Sign(hs.transcript(roleDialer))
+	if err != nil {
+		return nil, err
+	}
+	if err := binary.Write(c, binary.BigEndian, uint16(len(sigD)), sigD); err != nil {
+		return nil, err
+	}
+
+	return hs.pkL, nil
+}
+
+// listenerHandshake 接受方握手
+// 返回对端的id、监听地址与公钥
+func listenerHandshake(c net.Conn, key crypto.PrivateKey, localId string,
+	verifier PeerVerifier, timeout time.Duration) (remoteId string, remoteAddr string, pk []byte, err error) {
+
+	if err = c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+	defer c.SetDeadline(time.Time{})
+
+	hs := &handshakeState{
+		idL:    localId,
+		pkL:    crypto.MarshalPublicKey(key.Public()),
+		nonceL: make([]byte, HandshakeNonceLen),
+	}
+
+	// 1. 读取对端身份与挑战
+	version := uint8(0)
+	if err = binary.Read(c, binary.BigEndian, &version); err != nil {
+		return
+	}
+	if version != HandshakeVersion {
+		err = ErrHandshakeVersion
+		return
+	}
+	idD, err := readBytes8(c)
+	if err != nil {
+		return
+	}
+	hs.idD = string(idD)
+	if err = identity.Validate(hs.idD); err != nil {
+		return
+	}
+	addrD, err := readBytes8(c)
+	if err != nil {
+		return
+	}
+	hs.addrD = string(addrD)
+	addrL, err := readBytes8(c)
+	if err != nil {
+		return
+	}
+	hs.addrL = string(addrL)
+	if hs.pkD, err = readBytes16(c); err != nil {
+		return
+	}
+	if err = checkPeer(hs.idD, hs.pkD, verifier); err != nil {
+		return
+	}
+	hs.nonceD = make([]byte, HandshakeNonceLen)
+	if _, err = io.ReadFull(c, hs.nonceD); err != nil {
+		return
+	}
+
+	// 2. 发送自身身份、挑战与对其挑战的应答
+	if _, err = io.ReadFull(rand.Reader, hs.nonceL); err != nil {
+		return
+	}
+	sigL, err := key.Sign(hs.transcript(roleListener))
+	if err != nil {
+		return
+	}
+	err = binary.Write(c, binary.BigEndian,
+		uint8(len(hs.idL)), []byte(hs.idL),
+		uint16(len(hs.pkL)), hs.pkL,
+		hs.nonceL,
+		uint16(len(sigL)), sigL)
+	if err != nil {
+		return
+	}
+
+	// 3. 读取对端应答
+	sigD, err := readBytes16(c)
+	if err != nil {
+		return
+	}
+	if err = crypto.Verify(hs.pkD, hs.transcript(roleDialer), sigD); err != nil {
+		err = ErrHandshakeSig
+		return
+	}
+
+	return hs.idD, hs.addrD, hs.pkD, nil
+}
+
+// readBytes8 读取 len(1B) | data
+func readBytes8(r io.Reader) ([]byte, error) {
+	n := uint8(0)
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readBytes16 读取 len(2B) | data
+func readBytes16(r io.Reader) ([]byte, error) {
+	n := uint16(0)
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
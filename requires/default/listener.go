@@ -7,23 +7,36 @@
 package _default
 
 import (
-	"encoding/binary"
 	"errors"
 	"log"
 	"net"
 
 	"github.com/azd1997/blockchain-consensus/requires"
 	"github.com/azd1997/blockchain-consensus/utils/address"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
 type TCPListener struct {
 	ln              *net.TCPListener
 	localId         string
 	localListenAddr *net.TCPAddr
+	key             crypto.PrivateKey
+	verifier        PeerVerifier
+	acc             *acceptor
 }
 
 // address ipv4地址 形如"127.0.0.1:80"
-func ListenTCP(localid string, addr string) (*TCPListener, error) {
+// key 为本地私钥，localid必须由其公钥派生
+// verifier 用于握手时校验对端身份，可为nil
+func ListenTCP(localid string, addr string, key crypto.PrivateKey, verifier PeerVerifier) (*TCPListener, error) {
+	if key == nil {
+		return nil, errors.New("nil private key")
+	}
+	if err := identity.MatchPublicKey(localid, key.Public()); err != nil {
+		return nil, err
+	}
+
 	// 解析地址
 	tcpaddr, err := address.ParseTCP4(addr)
 	if err != nil {
@@ -36,31 +49,36 @@ func ListenTCP(localid string, addr string) (*TCPListener, error) {
 		return nil, err
 	}
 
-	return &TCPListener{
+	T := &TCPListener{
 		ln:              ln,
 		localId:         localid,
 		localListenAddr: tcpaddr,
-	}, nil
+		key:             key,
+		verifier:        verifier,
+	}
+	T.acc = newAcceptor(ln, T.accept)
+	return T, nil
 }
 
 func (T *TCPListener) ok() bool {
-	return T != nil && T.ln != nil && T.localListenAddr != nil
+	return T != nil && T.ln != nil && T.localListenAddr != nil && T.acc != nil
 }
 
 func (T *TCPListener) Network() string {
 	return "tcp"
 }
 
+// Accept 返回下一个完成握手的连接，握手失败时返回其错误
+// 各连接的握手并发进行，见acceptor.go
 func (T *TCPListener) Accept() (requires.Conn, error) {
 	if !T.ok() {
 		return nil, errors.New("TCPListener not ok")
 	}
+	return T.acc.accept()
+}
 
-	c, err := T.ln.Accept()
-	if err != nil {
-		return nil, err
-	}
-
+// accept 对新接受的连接握手
+func (T *TCPListener) accept(c net.Conn) (requires.Conn, error) {
 	rc := &TCPConn{
 		conn:            c.(*net.TCPConn),
 		localId:         T.localId,
@@ -68,13 +86,12 @@ func (T *TCPListener) Accept() (requires.Conn, error) {
 	}
 
 	// 握手
-	err = T.handshake(rc)
-	if err != nil {
+	if err := T.handshake(rc); err != nil {
 		return nil, err
 	}
 
 	log.Printf("listener accept {from: %s(%s), to: %s(%s)} succ\n",
-		rc.RemoteID(), rc.RemoteListenAddr(), T.localId, T.localListenAddr)
+		identity.Short(rc.RemoteID()), rc.RemoteListenAddr(), identity.Short(T.localId), T.localListenAddr)
 	return rc, nil
 }
 
@@ -94,36 +111,19 @@ func (T *TCPListener) LocalListenAddr() net.Addr {
 }
 
 // 握手
+// 读取对端身份并完成挑战-应答，见handshake.go
 func (T *TCPListener) handshake(c *TCPConn) error {
-	// 要读取第一个包出来，解析出对方身份，这一步相当于握手
-	// TODO: 这里先简单的约定，建立连接之后需要发送如下报文：
-	// idlen | id | addrlen | addr
-	idlen := uint8(0)
-	err := binary.Read(c.conn, binary.BigEndian, &idlen)
-	if err != nil {
-		return err
-	}
-	from := make([]byte, idlen)
-	err = binary.Read(c.conn, binary.BigEndian, from)
-	if err != nil {
-		return err
-	}
-	addrlen := uint8(0)
-	err = binary.Read(c.conn, binary.BigEndian, &addrlen)
-	if err != nil {
-		return err
-	}
-	fromaddrbytes := make([]byte, addrlen)
-	err = binary.Read(c.conn, binary.BigEndian, fromaddrbytes)
+	from, fromaddrstr, pk, err := listenerHandshake(c.conn, T.key, T.localId, T.verifier, DefaultHandshakeTimeout)
 	if err != nil {
 		return err
 	}
-	fromaddr, err := address.ParseTCP4(string(fromaddrbytes))
+	fromaddr, err := address.ParseTCP4(fromaddrstr)
 	if err != nil {
 		return err
 	}
 
-	c.remoteId = string(from)
+	c.remoteId = from
 	c.remoteListenAddr = fromaddr
+	c.remotePubKey = pk
 	return nil
 }
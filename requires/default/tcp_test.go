@@ -7,12 +7,34 @@
 package _default
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/binary"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 )
 
+// 生成测试私钥及由其公钥派生的ID
+func genKey(t *testing.T) (crypto.PrivateKey, string) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, identity.FromPublicKey(key.Public())
+}
+
+// 生成一个由公钥派生的测试ID
+func genId(t *testing.T) string {
+	_, id := genKey(t)
+	return id
+}
+
 type peer struct {
 	id   string
 	addr string
@@ -20,13 +42,14 @@ type peer struct {
 	d    requires.Dialer
 }
 
-func genPeer(id, addr string) (*peer, error) {
-	ln, err := ListenTCP(id, addr)
+func genPeer(key crypto.PrivateKey, id, addr string, verifier PeerVerifier) (*peer, error) {
+	ln, err := ListenTCP(id, addr, key, verifier)
 	if err != nil {
 		return nil, err
 	}
-	d, err := NewDialer(id, addr, 0)
+	d, err := NewDialer(id, addr, 0, key, verifier)
 	if err != nil {
+		ln.Close()
 		return nil, err
 	}
 	return &peer{
@@ -39,19 +62,19 @@ func genPeer(id, addr string) (*peer, error) {
 
 // 测试两个TCP peer间连接的建立和基本的消息发送（echo回显）
 func TestTCP(t *testing.T) {
-	ida := "peerA"
+	keya, ida := genKey(t)
 	addra := "127.0.0.1:8081"
-	peera, err := genPeer(ida, addra)
+	peera, err := genPeer(keya, ida, addra, nil)
 	if err != nil {
-		t.Errorf("%s: %s\n", ida, err)
+		t.Fatalf("%s: %s\n", ida, err)
 	}
 	defer peera.ln.Close()
 
-	idb := "peerB"
+	keyb, idb := genKey(t)
 	addrb := "127.0.0.1:8082"
-	peerb, err := genPeer(idb, addrb)
+	peerb, err := genPeer(keyb, idb, addrb, nil)
 	if err != nil {
-		t.Errorf("%s: %s\n", idb, err)
+		t.Fatalf("%s: %s\n", idb, err)
 	}
 	defer peerb.ln.Close()
 
@@ -122,11 +145,11 @@ func TestTCP(t *testing.T) {
 
 // 测试Listener创建是否正常
 func TestTCPListener(t *testing.T) {
-	id := "peerA"
+	key, id := genKey(t)
 	addr := "127.0.0.1:8081"
-	ln, err := ListenTCP(id, addr)
+	ln, err := ListenTCP(id, addr, key, nil)
 	if err != nil {
-		t.Errorf("%s: %s\n", id, err)
+		t.Fatalf("%s: %s\n", id, err)
 	}
 	t.Log(ln.localId, ln.localListenAddr.String())
 	ln.Close()
@@ -134,11 +157,217 @@ func TestTCPListener(t *testing.T) {
 
 // 测试Dialer创建是否正常
 func TestTCPDialer(t *testing.T) {
-	id := "peerA"
+	key, id := genKey(t)
 	addr := "127.0.0.1:8081"
-	d, err := NewDialer(id, addr, 0)
+	d, err := NewDialer(id, addr, 0, key, nil)
 	if err != nil {
-		t.Errorf("%s: %s\n", id, err)
+		t.Fatalf("%s: %s\n", id, err)
 	}
 	t.Log(d.localId, d.localListenAddr.String())
 }
+
+// 测试非法ID被拒绝
+func TestTCPInvalidId(t *testing.T) {
+	key, id := genKey(t)
+	addr := "127.0.0.1:8083"
+	if _, err := ListenTCP("peerA", addr, key, nil); err == nil {
+		t.Error("ListenTCP with invalid id should fail")
+	}
+	if _, err := NewDialer("peerA", addr, 0, key, nil); err == nil {
+		t.Error("NewDialer with invalid id should fail")
+	}
+	// id与私钥不匹配
+	if _, err := NewDialer(genId(t), addr, 0, key, nil); err == nil {
+		t.Error("NewDialer with mismatched key should fail")
+	}
+	d, err := NewDialer(id, addr, 0, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Dial(addr, "peerB"); err == nil {
+		t.Error("Dial to invalid remote id should fail")
+	}
+}
+
+// 测试握手拒绝冒充者
+func TestTCPHandshakeImpostor(t *testing.T) {
+	keya, ida := genKey(t)
+	addra := "127.0.0.1:8084"
+	lna, err := ListenTCP(ida, addra, keya, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每次Accept的结果
+	accepted := make(chan error)
+	closed := make(chan struct{})
+	defer func() {
+		close(closed)
+		lna.Close()
+	}()
+	go func() {
+		for {
+			c, err := lna.Accept()
+			if err == nil {
+				c.Close()
+			}
+			select {
+			case accepted <- err:
+			case <-closed:
+				return
+			}
+		}
+	}()
+
+	keyb, idb := genKey(t)
+	addrb := "127.0.0.1:8085"
+
+	// 1. 监听方并非拨号方期望的节点
+	d, err := NewDialer(idb, addrb, 0, keyb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Dial(addra, genId(t)); !errors.Is(err, ErrHandshakeRemoteId) {
+		t.Errorf("Dial to wrong remote id: err = %v, want %v", err, ErrHandshakeRemoteId)
+	}
+	if err := <-accepted; err == nil {
+		t.Error("listener should fail when dialer aborts")
+	}
+
+	// 2. 监听方公钥与本地节点信息表中的记录不一致
+	errRefused := errors.New("pubkey mismatches peer info table")
+	d, err = NewDialer(idb, addrb, 0, keyb, func(id string, pubkey []byte) error {
+		return errRefused
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Dial(addra, ida); !errors.Is(err, errRefused) {
+		t.Errorf("Dial with refusing verifier: err = %v, want %v", err, errRefused)
+	}
+	if err := <-accepted; err == nil {
+		t.Error("listener should fail when dialer aborts")
+	}
+
+	// 3. 冒充者出示idb及其公钥，却不持有idb的私钥
+	forger, _ := genKey(t)
+	c, err := net.Dial("tcp", addra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkb := crypto.MarshalPublicKey(keyb.Public())
+	sig, err := forger.Sign([]byte("forged"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = binary.Write(c, binary.BigEndian,
+		HandshakeVersion,
+		uint8(len(idb)), []byte(idb),
+		uint8(len(addrb)), []byte(addrb),
+		uint8(len(addra)), []byte(addra),
+		uint16(len(pkb)), pkb,
+		make([]byte, HandshakeNonceLen),
+		uint16(len(sig)), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-accepted; !errors.Is(err, ErrHandshakeSig) {
+		t.Errorf("Accept impostor: err = %v, want %v", err, ErrHandshakeSig)
+	}
+	c.Close()
+
+	// 4. 合法拨号成功，双方取得对端公钥
+	d, err = NewDialer(idb, addrb, 0, keyb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, err := d.Dial(addra, ida)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cd.Close()
+	if err := <-accepted; err != nil {
+		t.Error(err)
+	}
+	if err := identity.MatchPubKey(ida, cd.(*TCPConn).RemotePubKey()); err != nil {
+		t.Error(err)
+	}
+}
+
+// 一个迟迟不发握手数据的客户端不应阻塞其他连接的接受
+func TestTCPSlowHandshake(t *testing.T) {
+	keya, ida := genKey(t)
+	addra := "127.0.0.1:8121"
+	lna, err := ListenTCP(ida, addra, keya, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lna.Close()
+
+	accepted := make(chan requires.Conn, 1)
+	go func() {
+		for {
+			c, err := lna.Accept()
+			if err != nil {
+				if _, ok := err.(net.Error); ok {
+					return // 监听已关闭
+				}
+				continue
+			}
+			accepted <- c
+		}
+	}()
+
+	// 只建立TCP连接，不握手
+	slow, err := net.Dial("tcp", addra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	keyb, idb := genKey(t)
+	d, err := NewDialer(idb, "127.0.0.1:8122", 0, keyb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	cd, err := d.Dial(addra, ida)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cd.Close()
+	select {
+	case c := <-accepted:
+		defer c.Close()
+		if c.RemoteID() != idb {
+			t.Errorf("accepted %s, want %s", identity.Short(c.RemoteID()), identity.Short(idb))
+		}
+	case <-time.After(DefaultHandshakeTimeout / 2):
+		t.Fatal("accept blocked by slow client")
+	}
+	if cost := time.Since(start); cost > DefaultHandshakeTimeout/2 {
+		t.Errorf("dial cost %s", cost)
+	}
+}
+
+// 双方的地址被签名覆盖，且不同的地址拆分得到不同的transcript
+func TestHandshakeTranscript(t *testing.T) {
+	hs := handshakeState{addrD: "127.0.0.1:1", addrL: "127.0.0.1:2"}
+	base := hs.transcript(roleListener)
+
+	moved := hs
+	moved.addrD = "10.0.0.1:1"
+	if bytes.Equal(base, moved.transcript(roleListener)) {
+		t.Error("addrD not covered by transcript")
+	}
+	moved = hs
+	moved.addrL = "10.0.0.1:2"
+	if bytes.Equal(base, moved.transcript(roleListener)) {
+		t.Error("addrL not covered by transcript")
+	}
+	if bytes.Equal(
+		(&handshakeState{addrD: "a", addrL: "bc"}).transcript(roleListener),
+		(&handshakeState{addrD: "ab", addrL: "c"}).transcript(roleListener)) {
+		t.Error("ambiguous transcript")
+	}
+}
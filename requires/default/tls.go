@@ -0,0 +1,315 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/21/20 3:05 PM
+* @Description: 基于双向认证TLS的加密连接，接口同TCPConn/TCPListener/TCPDialer
+***********************************************************************/
+
+package _default
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/requires"
+	"github.com/azd1997/blockchain-consensus/utils/address"
+	"github.com/azd1997/blockchain-consensus/utils/binary"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	TLS连接建立流程：
+	1. TCP连接建立后，双方进行TLS1.3握手，并互相校验对方证书(见tls_cert.go)
+	2. 加密通道建立后，D -> L: idlen(1B) | idD | addrlen(1B) | addrD
+	   L检查idD与证书派生的ID一致，并记录D的监听地址
+*/
+
+// TLSConn 加密连接
+type TLSConn struct {
+	*tls.Conn
+	localId, remoteId                 string
+	localListenAddr, remoteListenAddr *net.TCPAddr
+	remotePubKey                      []byte // 对端证书中经过校验的节点公钥
+}
+
+func (T *TLSConn) Network() string {
+	return "tls"
+}
+
+func (T *TLSConn) LocalID() string {
+	return T.localId
+}
+
+func (T *TLSConn) RemoteID() string {
+	return T.remoteId
+}
+
+func (T *TLSConn) LocalListenAddr() net.Addr {
+	return T.localListenAddr
+}
+
+func (T *TLSConn) RemoteListenAddr() net.Addr {
+	return T.remoteListenAddr
+}
+
+// RemotePubKey 对端的节点公钥(crypto.MarshalPublicKey格式)
+func (T *TLSConn) RemotePubKey() []byte {
+	return T.remotePubKey
+}
+
+// tlsConfig 生成一次握手使用的TLS配置
+// 校验通过的对端ID与公钥通过id, pk传出
+func tlsConfig(cert tls.Certificate, expectId string, verifier PeerVerifier, id *string, pk *[]byte) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		ClientAuth:   tls.RequireAnyClientCert,
+		// 证书不依赖CA，而是由节点密钥背书，校验完全由VerifyPeerCertificate完成
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			rid, rpk, err := verifyNodeCertificate(rawCerts)
+			if err != nil {
+				return err
+			}
+			if expectId != "" && rid != expectId {
+				return ErrHandshakeRemoteId
+			}
+			if err := checkPeer(rid, rpk, verifier); err != nil {
+				return err
+			}
+			*id, *pk = rid, rpk
+			return nil
+		},
+	}
+}
+
+////////////////////////////////////////////////
+
+type TLSListener struct {
+	ln              *net.TCPListener
+	localId         string
+	localListenAddr *net.TCPAddr
+	cert            tls.Certificate
+	verifier        PeerVerifier
+	acc             *acceptor
+}
+
+// ListenTLS 监听TLS连接
+// 参数同ListenTCP
+func ListenTLS(localid string, addr string, key crypto.PrivateKey, verifier PeerVerifier) (*TLSListener, error) {
+	if key == nil {
+		return nil, errors.New("nil private key")
+	}
+	if err := identity.MatchPublicKey(localid, key.Public()); err != nil {
+		return nil, err
+	}
+	cert, err := newNodeCertificate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpaddr, err := address.ParseTCP4(addr)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.ListenTCP("tcp", tcpaddr)
+	if err != nil {
+		return nil, err
+	}
+
+	T := &TLSListener{
+		ln:              ln,
+		localId:         localid,
+		localListenAddr: tcpaddr,
+		cert:            cert,
+		verifier:        verifier,
+	}
+	T.acc = newAcceptor(ln, T.accept)
+	return T, nil
+}
+
+func (T *TLSListener) ok() bool {
+	return T != nil && T.ln != nil && T.localListenAddr != nil && T.acc != nil
+}
+
+func (T *TLSListener) Network() string {
+	return "tls"
+}
+
+// Accept 同TCPListener.Accept
+func (T *TLSListener) Accept() (requires.Conn, error) {
+	if !T.ok() {
+		return nil, errors.New("TLSListener not ok")
+	}
+	return T.acc.accept()
+}
+
+// accept 对新接受的连接握手
+func (T *TLSListener) accept(c net.Conn) (requires.Conn, error) {
+	rc := &TLSConn{
+		localId:         T.localId,
+		localListenAddr: T.localListenAddr,
+	}
+	rc.Conn = tls.Server(c, tlsConfig(T.cert, "", T.verifier, &rc.remoteId, &rc.remotePubKey))
+
+	// 握手
+	if err := T.handshake(rc); err != nil {
+		return nil, err
+	}
+
+	log.Printf("tls listener accept {from: %s(%s), to: %s(%s)} succ\n",
+		identity.Short(rc.RemoteID()), rc.RemoteListenAddr(), identity.Short(T.localId), T.localListenAddr)
+	return rc, nil
+}
+
+func (T *TLSListener) Close() error {
+	if !T.ok() {
+		return errors.New("TLSListener not ok")
+	}
+	return T.ln.Close()
+}
+
+func (T *TLSListener) LocalID() string {
+	return T.localId
+}
+
+func (T *TLSListener) LocalListenAddr() net.Addr {
+	return T.localListenAddr
+}
+
+// 握手
+// TLS握手完成后读取对端的 id 与监听地址
+func (T *TLSListener) handshake(c *TLSConn) error {
+	if err := c.SetDeadline(time.Now().Add(DefaultHandshakeTimeout)); err != nil {
+		return err
+	}
+	defer c.SetDeadline(time.Time{})
+
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	from, err := readBytes8(c)
+	if err != nil {
+		return err
+	}
+	if string(from) != c.remoteId {
+		return ErrHandshakeRemoteId
+	}
+	fromaddr, err := readBytes8(c)
+	if err != nil {
+		return err
+	}
+	c.remoteListenAddr, err = address.ParseTCP4(string(fromaddr))
+	return err
+}
+
+////////////////////////////////////////////////
+
+// NewTLSDialer 新建TLSDialer
+// 参数同NewDialer
+func NewTLSDialer(id, addr string, timeout time.Duration, key crypto.PrivateKey, verifier PeerVerifier) (*TLSDialer, error) {
+	if key == nil {
+		return nil, errors.New("nil private key")
+	}
+	if err := identity.MatchPublicKey(id, key.Public()); err != nil {
+		return nil, err
+	}
+	cert, err := newNodeCertificate(key)
+	if err != nil {
+		return nil, err
+	}
+	localAddr, err := address.ParseTCP4(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TLSDialer{
+		d:               &net.Dialer{Timeout: timeout},
+		localListenAddr: localAddr,
+		localId:         id,
+		cert:            cert,
+		verifier:        verifier,
+	}, nil
+}
+
+type TLSDialer struct {
+	d               *net.Dialer
+	localListenAddr *net.TCPAddr
+	localId         string
+	cert            tls.Certificate
+	verifier        PeerVerifier
+}
+
+func (T *TLSDialer) ok() bool {
+	return T != nil && T.d != nil && T.localListenAddr != nil
+}
+
+func (T *TLSDialer) Dial(addr, remoteId string) (requires.Conn, error) {
+	if !T.ok() {
+		return nil, errors.New("TLSDialer not ok")
+	}
+	if err := identity.Validate(remoteId); err != nil {
+		return nil, err
+	}
+
+	remoteAddr, err := address.ParseTCP4(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := T.d.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &TLSConn{
+		localId:          T.localId,
+		localListenAddr:  T.localListenAddr,
+		remoteListenAddr: remoteAddr,
+	}
+	rc.Conn = tls.Client(conn, tlsConfig(T.cert, remoteId, T.verifier, &rc.remoteId, &rc.remotePubKey))
+
+	// 握手
+	err = T.handshake(rc)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	log.Printf("tls dialer dial {from: %s(%s), to: %s(%s)} succ\n",
+		identity.Short(T.localId), T.localListenAddr, identity.Short(remoteId), remoteAddr)
+	return rc, nil
+}
+
+func (T *TLSDialer) Network() string {
+	return "tls"
+}
+
+func (T *TLSDialer) LocalID() string {
+	return T.localId
+}
+
+func (T *TLSDialer) LocalListenAddr() net.Addr {
+	return T.localListenAddr
+}
+
+// 握手
+// TLS握手完成后在加密通道内告知对端自己的 id 与监听地址
+func (T *TLSDialer) handshake(c *TLSConn) error {
+	if err := c.SetDeadline(time.Now().Add(DefaultHandshakeTimeout)); err != nil {
+		return err
+	}
+	defer c.SetDeadline(time.Time{})
+
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	localAddr := T.localListenAddr.String()
+	return binary.Write(c, binary.BigEndian,
+		uint8(len(T.localId)), []byte(T.localId),
+		uint8(len(localAddr)), []byte(localAddr))
+}
@@ -0,0 +1,134 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/21/20 2:10 PM
+* @Description: 由节点密钥背书的TLS证书
+***********************************************************************/
+
+package _default
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+/*
+	TLS本身只支持有限的几种证书密钥，而节点密钥可能是secp256k1等TLS不支持的算法，
+	因此每个TLSListener/TLSDialer生成一对临时的P-256证书密钥，
+	再用节点私钥对证书公钥(SubjectPublicKeyInfo)签名，将 节点公钥 | 签名 写入证书的扩展字段。
+
+	校验对端证书时：
+	1. 取出扩展字段中的节点公钥与签名，验证签名覆盖了证书公钥
+	2. 由节点公钥派生出对端ID
+	TLS握手本身保证对端持有证书私钥，从而间接证明对端持有节点私钥
+*/
+
+var (
+	// nodeCertExtOID 证书中存放节点公钥及其背书签名的扩展字段
+	nodeCertExtOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53594, 1, 1}
+
+	ErrNoNodeCert      = errors.New("tls: no peer certificate")
+	ErrNoNodeCertExt   = errors.New("tls: peer certificate lacks node key extension")
+	ErrNodeCertSig     = errors.New("tls: verify node key endorsement fail")
+	ErrNodeCertExpired = errors.New("tls: peer certificate expired")
+)
+
+const nodeCertSigPrefix = "bcc-tls-cert:"
+
+// nodeCertExt 证书扩展字段内容
+type nodeCertExt struct {
+	PubKey []byte // crypto.MarshalPublicKey序列化的节点公钥
+	Sig    []byte // 节点私钥对 nodeCertSigPrefix | SubjectPublicKeyInfo 的签名
+}
+
+// newNodeCertificate 生成由节点私钥背书的自签名证书
+func newNodeCertificate(key crypto.PrivateKey) (tls.Certificate, error) {
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&certKey.PublicKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	sig, err := key.Sign(append([]byte(nodeCertSigPrefix), spki...))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	ext, err := asn1.Marshal(nodeCertExt{
+		PubKey: crypto.MarshalPublicKey(key.Public()),
+		Sig:    sig,
+	})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	sn, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:    sn,
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.Add(100 * 365 * 24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: nodeCertExtOID, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &certKey.PublicKey, certKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  certKey,
+	}, nil
+}
+
+// verifyNodeCertificate 校验对端证书，返回对端ID及节点公钥
+func verifyNodeCertificate(rawCerts [][]byte) (id string, pk []byte, err error) {
+	if len(rawCerts) == 0 {
+		return "", nil, ErrNoNodeCert
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return "", nil, ErrNodeCertExpired
+	}
+
+	var ext *nodeCertExt
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(nodeCertExtOID) {
+			ext = new(nodeCertExt)
+			if _, err := asn1.Unmarshal(e.Value, ext); err != nil {
+				return "", nil, err
+			}
+			break
+		}
+	}
+	if ext == nil {
+		return "", nil, ErrNoNodeCertExt
+	}
+
+	msg := append([]byte(nodeCertSigPrefix), cert.RawSubjectPublicKeyInfo...)
+	if err := crypto.Verify(ext.PubKey, msg, ext.Sig); err != nil {
+		return "", nil, ErrNodeCertSig
+	}
+	id, err = identity.FromPubKeyBytes(ext.PubKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, ext.PubKey, nil
+}
@@ -0,0 +1,216 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/21/20 4:20 PM
+* @Description: 测试TLSConn/TLSListener/TLSDialer
+***********************************************************************/
+
+package _default
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
+)
+
+// 测试TLS连接的建立与回显，节点密钥分别为ed25519和secp256k1
+func TestTLS(t *testing.T) {
+	keya, ida := genKey(t)
+	addra := "127.0.0.1:8086"
+	lna, err := ListenTLS(ida, addra, keya, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lna.Close()
+
+	go func() {
+		c, err := lna.Accept()
+		if err != nil {
+			t.Errorf("%s: %s\n", identity.Short(ida), err)
+			return
+		}
+		io.Copy(c, c) // 回显，直到c被对端关闭
+		c.Close()
+	}()
+
+	keyb, err := crypto.GenerateKey(crypto.SigScheme_Secp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idb := identity.FromPublicKey(keyb.Public())
+	db, err := NewTLSDialer(idb, "127.0.0.1:8087", 0, keyb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.Dial(addra, ida)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Network() != "tls" || c.RemoteID() != ida {
+		t.Errorf("unexpected conn: network=%s, remote=%s", c.Network(), identity.Short(c.RemoteID()))
+	}
+	if !bytes.Equal(c.(*TLSConn).RemotePubKey(), crypto.MarshalPublicKey(keya.Public())) {
+		t.Error("RemotePubKey mismatches")
+	}
+
+	msg := []byte("Hello world!")
+	if _, err := c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Errorf("echo(%s) != %s", buf, msg)
+	}
+}
+
+// 测试TLS连接拒绝身份不符的对端
+func TestTLSRefuse(t *testing.T) {
+	keya, ida := genKey(t)
+	addra := "127.0.0.1:8088"
+	lna, err := ListenTLS(ida, addra, keya, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan error)
+	closed := make(chan struct{})
+	defer func() {
+		close(closed)
+		lna.Close()
+	}()
+	go func() {
+		for {
+			c, err := lna.Accept()
+			if err == nil {
+				c.Close()
+			}
+			select {
+			case accepted <- err:
+			case <-closed:
+				return
+			}
+		}
+	}()
+
+	keyb, idb := genKey(t)
+	addrb := "127.0.0.1:8089"
+
+	// 1. 监听方并非期望的节点
+	d, err := NewTLSDialer(idb, addrb, 0, keyb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Dial(addra, genId(t)); !errors.Is(err, ErrHandshakeRemoteId) {
+		t.Errorf("Dial to wrong remote id: err = %v, want %v", err, ErrHandshakeRemoteId)
+	}
+	if err := <-accepted; err == nil {
+		t.Error("listener should fail when dialer aborts")
+	}
+
+	// 2. verifier拒绝对端
+	errRefused := errors.New("refused")
+	d, err = NewTLSDialer(idb, addrb, 0, keyb, func(id string, pubkey []byte) error {
+		return errRefused
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Dial(addra, ida); !errors.Is(err, errRefused) {
+		t.Errorf("Dial with refusing verifier: err = %v, want %v", err, errRefused)
+	}
+	if err := <-accepted; err == nil {
+		t.Error("listener should fail when dialer aborts")
+	}
+
+	// 3. 不使用TLS的明文连接被拒绝
+	c, err := net.Dial("tcp", addra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("plaintext hello"))
+	if err := <-accepted; err == nil {
+		t.Error("plaintext conn should be refused")
+	}
+	c.Close()
+}
+
+// 测试线路上传输的内容不含明文
+func TestTLSCiphertext(t *testing.T) {
+	keya, ida := genKey(t)
+	addra := "127.0.0.1:8090"
+	lna, err := ListenTLS(ida, addra, keya, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lna.Close()
+
+	secret := []byte("patient record: blood type AB")
+	recv := make(chan []byte, 1)
+	go func() {
+		c, err := lna.Accept()
+		if err != nil {
+			t.Error(err)
+			close(recv)
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, len(secret))
+		io.ReadFull(c, buf)
+		recv <- buf
+	}()
+
+	// 中间人转发并记录dialer发出的原始字节
+	relayAddr := "127.0.0.1:8091"
+	rln, err := net.Listen("tcp", relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rln.Close()
+	wire := new(bytes.Buffer)
+	relayed := make(chan struct{})
+	go func() {
+		defer close(relayed)
+		src, err := rln.Accept()
+		if err != nil {
+			return
+		}
+		defer src.Close()
+		dst, err := net.Dial("tcp", addra)
+		if err != nil {
+			return
+		}
+		defer dst.Close()
+		go io.Copy(src, dst)
+		io.Copy(io.MultiWriter(dst, wire), src)
+	}()
+
+	keyb, idb := genKey(t)
+	d, err := NewTLSDialer(idb, "127.0.0.1:8092", 0, keyb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Dial(relayAddr, ida)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(secret); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-recv; !bytes.Equal(got, secret) {
+		t.Errorf("recv(%s) != %s", got, secret)
+	}
+	c.Close()
+	<-relayed
+
+	if bytes.Contains(wire.Bytes(), secret) {
+		t.Error("secret found in cleartext on the wire")
+	}
+}
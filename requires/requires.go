@@ -64,15 +64,31 @@ type BlockChain interface {
 //	AddTransaction(txbytes []byte) error
 //}
 
-// Validator 本地验证器，负责验证账户/区块/交易/证明的有效性
+// Validator 交易语义校验器，由应用层实现并注册到共识模块
+// 区块的链接关系、哈希、签名等通用检查由共识模块完成，
+// 交易内容本身是否合法(余额、重放、业务字段等)只有应用层清楚，交给Validator判断
 type Validator interface {
-	validate(data []byte) *ValidateResult
+	// ValidateTx 校验区块b中的交易tx，返回非nil则整个区块被拒绝
+	// 调用时tx的哈希与签名已经验证通过
+	ValidateTx(b *defines.Block, tx *defines.Transaction) error
 }
 
-// ValidateResult 验证结果
-type ValidateResult struct {
-	validate bool
-	reason   []byte // 无效的原因
+// ValidatorFunc 将普通函数适配为Validator
+type ValidatorFunc func(b *defines.Block, tx *defines.Transaction) error
+
+// ValidateTx 实现Validator接口
+func (f ValidatorFunc) ValidateTx(b *defines.Block, tx *defines.Transaction) error {
+	return f(b, tx)
+}
+
+// ConsensusLog 共识状态日志
+// 保存区块链之外的共识状态(纪元、投票、作恶名单等)，使共识模块重启后能够恢复
+// 状态的编码由共识模块决定，ConsensusLog只负责原样存取
+type ConsensusLog interface {
+	// ReadConsensusState 读取最近一次保存的状态，从未保存过时返回空
+	ReadConsensusState() []byte
+	// SaveConsensusState 保存状态，覆盖之前的状态
+	SaveConsensusState(state []byte) error
 }
 
 // Dialer 连接器
@@ -138,6 +154,32 @@ type Store interface {
 
 	// 遍历某个CF
 	RangeCF(cf CF, f func(key, value []byte) error) error
+
+	// NewBatch 创建写批次，批次内的写操作在Commit时原子地生效
+	NewBatch() Batch
+	// Snapshot 获取当前数据的只读快照，之后的写操作对快照不可见
+	Snapshot() (Snapshot, error)
+}
+
+// Batch 原子写批次
+// 需要同时修改多个键(例如区块与其索引、节点表的多个表项)时使用，
+// 进程在Commit中途崩溃的话，重启后要么全部生效，要么全部未生效
+type Batch interface {
+	Set(cf CF, key, value []byte)
+	Del(cf CF, key []byte)
+	// Len 批次内的写操作数
+	Len() int
+	// Commit 提交。一个批次只能提交一次
+	Commit() error
+}
+
+// Snapshot 只读快照
+type Snapshot interface {
+	Get(cf CF, key []byte) ([]byte, error)
+	// RangeCF 遍历快照中的某个CF
+	RangeCF(cf CF, f func(key, value []byte) error) error
+	// Release 释放快照
+	Release()
 }
 
 const CFLen = 6
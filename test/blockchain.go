@@ -12,6 +12,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+	"github.com/azd1997/blockchain-consensus/utils/identity"
 	"github.com/azd1997/blockchain-consensus/utils/log"
 	"github.com/azd1997/blockchain-consensus/defines"
 )
@@ -32,7 +34,8 @@ type BlockSegment struct {
 	blocks *[]*defines.Block
 }
 
-func NewBlockChain(id string) *BlockChain {
+// key 为本节点私钥，用于对生成的区块签名
+func NewBlockChain(id string, key crypto.PrivateKey) *BlockChain {
 
 	logger := log.NewLogger(Module_Bcl, id)
 	if logger == nil {
@@ -43,6 +46,7 @@ func NewBlockChain(id string) *BlockChain {
 
 	return &BlockChain{
 		id:id,
+		key:key,
 		chain: []*BlockSegment{
 			&BlockSegment{	// 创建第1个分段
 				start:  0,
@@ -62,6 +66,7 @@ func NewBlockChain(id string) *BlockChain {
 // BlockChain 渐进式地增加区块，不允许制造空洞
 type BlockChain struct {
 	id string
+	key crypto.PrivateKey
 
 	// 该标志标记是否添加过最新区块
 	addnew bool
@@ -106,9 +111,9 @@ func (bc *BlockChain) Display() string {
 		segStr := fmt.Sprintf("Segment%d<%d-%d>:\t", i+1, seg.start, seg.end)
 		for j:=0; j<len(*seg.blocks); j++ {
 			if j < len(*seg.blocks) - 1 {
-				segStr += fmt.Sprintf("(%d,%s)%s ——> ", (*seg.blocks)[j].Index, (*seg.blocks)[j].Maker, (*seg.blocks)[j].ShortName())
+				segStr += fmt.Sprintf("(%d,%s)%s ——> ", (*seg.blocks)[j].Index, identity.Short((*seg.blocks)[j].Maker), (*seg.blocks)[j].ShortName())
 			} else {
-				segStr += fmt.Sprintf("(%d,%s)%s\n", (*seg.blocks)[j].Index, (*seg.blocks)[j].Maker, (*seg.blocks)[j].ShortName())
+				segStr += fmt.Sprintf("(%d,%s)%s\n", (*seg.blocks)[j].Index, identity.Short((*seg.blocks)[j].Maker), (*seg.blocks)[j].ShortName())
 			}
 		}
 		display += segStr
@@ -343,7 +348,7 @@ func (bc *BlockChain) AddBlock(block *defines.Block) error {
 // 约定 每找到一个可以插到一个"可信任的区块"时，将该区块插到"可信任的区块"所在的分段前面
 func (bc *BlockChain) checkDiscontinuous() error {
 
-	for i:=len(bc.chain)-1; i>0; i++ {	// 0号分段不需要检查
+	for i:=len(bc.chain)-1; i>0; i-- {	// 0号分段不需要检查
 
 		selfSeg, prevSeg := bc.chain[i], bc.chain[i-1]
 
@@ -415,7 +420,7 @@ func (bc *BlockChain) CreateTheWorld() (genesis *defines.Block, err error) {
 		return nil, errors.New("non-empty blockchain")
 	}
 
-	genesis, err = defines.NewBlockAndSign(1, bc.id, nil, nil, fmt.Sprintf("block from %s at %s", bc.id, time.Now().String()))
+	genesis, err = defines.NewBlockAndSign(1, bc.id, nil, nil, fmt.Sprintf("block from %s at %s", bc.id, time.Now().String()), bc.key)
 	if err != nil {
 		return nil, err
 	}
@@ -452,7 +457,7 @@ func (bc *BlockChain) GenNextBlock() (*defines.Block, error) {
 
 	maxIndex := bc.GetMaxIndex()
 	latestBlock := bc.GetLatestBlock()
-	nextb, err := defines.NewBlockAndSign(maxIndex+1, bc.id, latestBlock.SelfHash, txs, fmt.Sprintf("block from %s at %s", bc.id, time.Now().String()))
+	nextb, err := defines.NewBlockAndSign(maxIndex+1, bc.id, latestBlock.SelfHash, txs, fmt.Sprintf("block from %s at %s", bc.id, time.Now().String()), bc.key)
 	if err != nil {
 		return nil, err
 	}
@@ -81,3 +81,64 @@ func (s *Store) RangeCF(cf requires.CF, f func(key, value []byte) error) error {
 
 	return firstErr
 }
+
+func (s *Store) NewBatch() requires.Batch {
+	return &batch{s: s}
+}
+
+func (s *Store) Snapshot() (requires.Snapshot, error) {
+	snap := NewStore()
+	for cf := range s.Cfs {
+		snap.Cfs[cf] = true
+	}
+	for k, v := range s.Kvs {
+		snap.Kvs[k] = v
+	}
+	return &snapshot{s: snap}, nil
+}
+
+// 测试用的requires.Batch实现，Commit时逐个写入
+type batch struct {
+	s   *Store
+	ops []func()
+}
+
+func (b *batch) Set(cf requires.CF, key, value []byte) {
+	k, v := string(cf[:])+string(key), string(value)
+	b.ops = append(b.ops, func() { b.s.Kvs[k] = v })
+}
+
+func (b *batch) Del(cf requires.CF, key []byte) {
+	k := string(cf[:]) + string(key)
+	b.ops = append(b.ops, func() { delete(b.s.Kvs, k) })
+}
+
+func (b *batch) Len() int {
+	return len(b.ops)
+}
+
+func (b *batch) Commit() error {
+	for _, op := range b.ops {
+		op()
+	}
+	log.Printf("testStore Commit: %d ops\n", len(b.ops))
+	b.ops = nil
+	return nil
+}
+
+// 测试用的requires.Snapshot实现，复制了一份Store
+type snapshot struct {
+	s *Store
+}
+
+func (sn *snapshot) Get(cf requires.CF, key []byte) ([]byte, error) {
+	return []byte(sn.s.Kvs[string(cf[:])+string(key)]), nil
+}
+
+func (sn *snapshot) RangeCF(cf requires.CF, f func(key, value []byte) error) error {
+	return sn.s.RangeCF(cf, f)
+}
+
+func (sn *snapshot) Release() {
+	sn.s = nil
+}
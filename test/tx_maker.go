@@ -12,17 +12,20 @@ import (
 	"time"
 
 	"github.com/azd1997/blockchain-consensus/defines"
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
 )
 
 type TxMaker struct {
 	id    string                    // 自己
+	key   crypto.PrivateKey         // 自己的私钥，用于交易签名
 	tos   []string                  // 可能的交易接收方
 	txout chan *defines.Transaction // 传给pot状态机
 }
 
-func NewTxMaker(id string, tos []string, txout chan *defines.Transaction) *TxMaker {
+func NewTxMaker(id string, key crypto.PrivateKey, tos []string, txout chan *defines.Transaction) *TxMaker {
 	return &TxMaker{
 		id:    id,
+		key:   key,
 		tos:   tos,
 		txout: txout,
 	}
@@ -41,7 +44,7 @@ func (tm *TxMaker) Start() {
 			// 随机交易金额
 			amount := rand.Intn(100)
 			description := fmt.Sprintf("this is a tx from %s to %s", tm.id, to)
-			tx, err := defines.NewTransactionAndSign(tm.id, to, int64(amount), nil, description)
+			tx, err := defines.NewTransactionAndSign(tm.id, to, int64(amount), nil, description, tm.key)
 			if err != nil {
 				fmt.Printf("TxMaker(%s) make tx fail: %s\n", tm.id, err)
 			}
@@ -0,0 +1,174 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/18/20 3:12 PM
+* @Description: 可插拔的签名算法抽象
+***********************************************************************/
+
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SigScheme 签名算法
+type SigScheme uint8
+
+const (
+	SigScheme_Unknown   SigScheme = 0
+	SigScheme_Ed25519   SigScheme = 1
+	SigScheme_Secp256k1 SigScheme = 2
+)
+
+// DefaultSigScheme 默认签名算法
+const DefaultSigScheme = SigScheme_Ed25519
+
+func (s SigScheme) String() string {
+	switch s {
+	case SigScheme_Ed25519:
+		return "ed25519"
+	case SigScheme_Secp256k1:
+		return "secp256k1"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSigScheme 根据名称解析签名算法，空字符串返回默认算法
+func ParseSigScheme(name string) (SigScheme, error) {
+	switch name {
+	case "":
+		return DefaultSigScheme, nil
+	case "ed25519":
+		return SigScheme_Ed25519, nil
+	case "secp256k1":
+		return SigScheme_Secp256k1, nil
+	default:
+		return SigScheme_Unknown, fmt.Errorf("unknown sig scheme(%s)", name)
+	}
+}
+
+var (
+	ErrUnknownSigScheme = errors.New("unknown sig scheme")
+	ErrInvalidKey       = errors.New("invalid key")
+	ErrVerifySigFail    = errors.New("verify sig fail")
+)
+
+// PrivateKey 私钥
+type PrivateKey interface {
+	Scheme() SigScheme
+	Public() PublicKey
+	Sign(data []byte) ([]byte, error)
+	Bytes() []byte // 原始私钥字节，不含算法前缀
+}
+
+// PublicKey 公钥
+type PublicKey interface {
+	Scheme() SigScheme
+	Verify(data, sig []byte) bool
+	Bytes() []byte // 原始公钥字节，不含算法前缀
+}
+
+// Signer 签名算法的实现者，外部可通过RegisterSigner注册新的算法
+type Signer interface {
+	GenerateKey() (PrivateKey, error)
+	PrivateKeyFromBytes(b []byte) (PrivateKey, error)
+	PublicKeyFromBytes(b []byte) (PublicKey, error)
+}
+
+var (
+	signersLock sync.RWMutex
+	signers     = map[SigScheme]Signer{}
+)
+
+// RegisterSigner 注册签名算法
+func RegisterSigner(scheme SigScheme, signer Signer) {
+	signersLock.Lock()
+	defer signersLock.Unlock()
+	signers[scheme] = signer
+}
+
+// GetSigner 获取签名算法
+func GetSigner(scheme SigScheme) (Signer, error) {
+	signersLock.RLock()
+	defer signersLock.RUnlock()
+	signer, ok := signers[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownSigScheme, scheme)
+	}
+	return signer, nil
+}
+
+// GenerateKey 使用指定算法生成密钥对
+func GenerateKey(scheme SigScheme) (PrivateKey, error) {
+	signer, err := GetSigner(scheme)
+	if err != nil {
+		return nil, err
+	}
+	return signer.GenerateKey()
+}
+
+/*
+	密钥序列化格式：
+
+	+------------------------------+
+	| 算法(1B) | 原始密钥字节(变长) |
+	+------------------------------+
+
+	带上算法前缀，使得PeerInfo等处存储的公钥可以自描述
+*/
+
+// MarshalPublicKey 序列化公钥
+func MarshalPublicKey(pub PublicKey) []byte {
+	raw := pub.Bytes()
+	b := make([]byte, 1+len(raw))
+	b[0] = byte(pub.Scheme())
+	copy(b[1:], raw)
+	return b
+}
+
+// UnmarshalPublicKey 反序列化公钥
+func UnmarshalPublicKey(b []byte) (PublicKey, error) {
+	if len(b) < 2 {
+		return nil, ErrInvalidKey
+	}
+	signer, err := GetSigner(SigScheme(b[0]))
+	if err != nil {
+		return nil, err
+	}
+	return signer.PublicKeyFromBytes(b[1:])
+}
+
+// MarshalPrivateKey 序列化私钥
+func MarshalPrivateKey(priv PrivateKey) []byte {
+	raw := priv.Bytes()
+	b := make([]byte, 1+len(raw))
+	b[0] = byte(priv.Scheme())
+	copy(b[1:], raw)
+	return b
+}
+
+// UnmarshalPrivateKey 反序列化私钥
+func UnmarshalPrivateKey(b []byte) (PrivateKey, error) {
+	if len(b) < 2 {
+		return nil, ErrInvalidKey
+	}
+	signer, err := GetSigner(SigScheme(b[0]))
+	if err != nil {
+		return nil, err
+	}
+	return signer.PrivateKeyFromBytes(b[1:])
+}
+
+// Verify 使用序列化后的公钥验证签名
+func Verify(pubBytes []byte, data, sig []byte) error {
+	pub, err := UnmarshalPublicKey(pubBytes)
+	if err != nil {
+		return err
+	}
+	if !pub.Verify(data, sig) {
+		return ErrVerifySigFail
+	}
+	return nil
+}
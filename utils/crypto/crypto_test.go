@@ -0,0 +1,52 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/18/20 4:30 PM
+* @Description: The file is for
+***********************************************************************/
+
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSigners(t *testing.T) {
+	data := []byte("data to sign")
+
+	for _, scheme := range []SigScheme{SigScheme_Ed25519, SigScheme_Secp256k1} {
+		key, err := GenerateKey(scheme)
+		if err != nil {
+			t.Fatalf("[%s] GenerateKey: %s", scheme, err)
+		}
+		sig, err := key.Sign(data)
+		if err != nil {
+			t.Fatalf("[%s] Sign: %s", scheme, err)
+		}
+		if !key.Public().Verify(data, sig) {
+			t.Errorf("[%s] Verify fail", scheme)
+		}
+		if key.Public().Verify([]byte("other data"), sig) {
+			t.Errorf("[%s] Verify other data should fail", scheme)
+		}
+
+		// 序列化往返
+		pubBytes := MarshalPublicKey(key.Public())
+		if err := Verify(pubBytes, data, sig); err != nil {
+			t.Errorf("[%s] Verify with marshaled pubkey: %s", scheme, err)
+		}
+		key2, err := UnmarshalPrivateKey(MarshalPrivateKey(key))
+		if err != nil {
+			t.Fatalf("[%s] UnmarshalPrivateKey: %s", scheme, err)
+		}
+		if !bytes.Equal(key2.Public().Bytes(), key.Public().Bytes()) {
+			t.Errorf("[%s] mismatched public key after unmarshal", scheme)
+		}
+	}
+}
+
+func TestUnmarshalPublicKey_UnknownScheme(t *testing.T) {
+	if _, err := UnmarshalPublicKey([]byte{99, 1, 2, 3}); err == nil {
+		t.Error("unknown scheme should fail")
+	}
+}
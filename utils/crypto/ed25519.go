@@ -0,0 +1,84 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/18/20 3:40 PM
+* @Description: ed25519签名算法
+***********************************************************************/
+
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+)
+
+func init() {
+	RegisterSigner(SigScheme_Ed25519, ed25519Signer{})
+}
+
+type ed25519Signer struct{}
+
+func (ed25519Signer) GenerateKey() (PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519PrivateKey(priv), nil
+}
+
+// PrivateKeyFromBytes 接受32B种子或64B完整私钥
+func (ed25519Signer) PrivateKeyFromBytes(b []byte) (PrivateKey, error) {
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519PrivateKey(ed25519.NewKeyFromSeed(b)), nil
+	case ed25519.PrivateKeySize:
+		priv := make([]byte, ed25519.PrivateKeySize)
+		copy(priv, b)
+		return ed25519PrivateKey(priv), nil
+	default:
+		return nil, ErrInvalidKey
+	}
+}
+
+func (ed25519Signer) PublicKeyFromBytes(b []byte) (PublicKey, error) {
+	if len(b) != ed25519.PublicKeySize {
+		return nil, ErrInvalidKey
+	}
+	pub := make([]byte, ed25519.PublicKeySize)
+	copy(pub, b)
+	return ed25519PublicKey(pub), nil
+}
+
+type ed25519PrivateKey ed25519.PrivateKey
+
+func (k ed25519PrivateKey) Scheme() SigScheme {
+	return SigScheme_Ed25519
+}
+
+func (k ed25519PrivateKey) Public() PublicKey {
+	return ed25519PublicKey(ed25519.PrivateKey(k).Public().(ed25519.PublicKey))
+}
+
+func (k ed25519PrivateKey) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(k), data), nil
+}
+
+func (k ed25519PrivateKey) Bytes() []byte {
+	return []byte(k)
+}
+
+type ed25519PublicKey ed25519.PublicKey
+
+func (k ed25519PublicKey) Scheme() SigScheme {
+	return SigScheme_Ed25519
+}
+
+func (k ed25519PublicKey) Verify(data, sig []byte) bool {
+	if len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(k), data, sig)
+}
+
+func (k ed25519PublicKey) Bytes() []byte {
+	return []byte(k)
+}
@@ -0,0 +1,87 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/18/20 4:05 PM
+* @Description: secp256k1签名算法，签名前先对数据做sha256
+***********************************************************************/
+
+package crypto
+
+import (
+	"crypto/sha256"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+func init() {
+	RegisterSigner(SigScheme_Secp256k1, secp256k1Signer{})
+}
+
+type secp256k1Signer struct{}
+
+func (secp256k1Signer) GenerateKey() (PrivateKey, error) {
+	priv, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &secp256k1PrivateKey{priv}, nil
+}
+
+func (secp256k1Signer) PrivateKeyFromBytes(b []byte) (PrivateKey, error) {
+	if len(b) != secp256k1.PrivKeyBytesLen {
+		return nil, ErrInvalidKey
+	}
+	return &secp256k1PrivateKey{secp256k1.PrivKeyFromBytes(b)}, nil
+}
+
+// PublicKeyFromBytes 接受压缩(33B)或非压缩(65B)格式
+func (secp256k1Signer) PublicKeyFromBytes(b []byte) (PublicKey, error) {
+	pub, err := secp256k1.ParsePubKey(b)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return &secp256k1PublicKey{pub}, nil
+}
+
+type secp256k1PrivateKey struct {
+	key *secp256k1.PrivateKey
+}
+
+func (k *secp256k1PrivateKey) Scheme() SigScheme {
+	return SigScheme_Secp256k1
+}
+
+func (k *secp256k1PrivateKey) Public() PublicKey {
+	return &secp256k1PublicKey{k.key.PubKey()}
+}
+
+func (k *secp256k1PrivateKey) Sign(data []byte) ([]byte, error) {
+	h := sha256.Sum256(data)
+	return ecdsa.Sign(k.key, h[:]).Serialize(), nil
+}
+
+func (k *secp256k1PrivateKey) Bytes() []byte {
+	return k.key.Serialize()
+}
+
+type secp256k1PublicKey struct {
+	key *secp256k1.PublicKey
+}
+
+func (k *secp256k1PublicKey) Scheme() SigScheme {
+	return SigScheme_Secp256k1
+}
+
+func (k *secp256k1PublicKey) Verify(data, sig []byte) bool {
+	s, err := ecdsa.ParseDERSignature(sig)
+	if err != nil {
+		return false
+	}
+	h := sha256.Sum256(data)
+	return s.Verify(h[:], k.key)
+}
+
+// Bytes 压缩格式公钥 33B
+func (k *secp256k1PublicKey) Bytes() []byte {
+	return k.key.SerializeCompressed()
+}
@@ -0,0 +1,88 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/19/20 10:40 AM
+* @Description: base58编码，采用比特币字母表
+***********************************************************************/
+
+package identity
+
+import (
+	"fmt"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base58Indexes [256]int
+
+func init() {
+	for i := range base58Indexes {
+		base58Indexes[i] = -1
+	}
+	for i := 0; i < len(base58Alphabet); i++ {
+		base58Indexes[base58Alphabet[i]] = i
+	}
+}
+
+// base58Encode 编码。前导的0字节编码为'1'
+func base58Encode(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+
+	// 大端的256进制数转换为58进制数，逆序存放
+	buf := make([]byte, 0, len(b)*138/100+1)
+	for _, c := range b[zeros:] {
+		carry := int(c)
+		for i := 0; i < len(buf); i++ {
+			carry += int(buf[i]) << 8
+			buf[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			buf = append(buf, byte(carry%58))
+			carry /= 58
+		}
+	}
+
+	res := make([]byte, zeros+len(buf))
+	for i := 0; i < zeros; i++ {
+		res[i] = base58Alphabet[0]
+	}
+	for i := 0; i < len(buf); i++ {
+		res[zeros+i] = base58Alphabet[buf[len(buf)-1-i]]
+	}
+	return string(res)
+}
+
+// base58Decode 解码
+func base58Decode(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	// 58进制数转换为256进制数，逆序存放
+	buf := make([]byte, 0, len(s)*733/1000+1)
+	for i := zeros; i < len(s); i++ {
+		carry := base58Indexes[s[i]]
+		if carry < 0 {
+			return nil, fmt.Errorf("invalid base58 character '%c'", s[i])
+		}
+		for j := 0; j < len(buf); j++ {
+			carry += int(buf[j]) * 58
+			buf[j] = byte(carry & 0xff)
+			carry >>= 8
+		}
+		for carry > 0 {
+			buf = append(buf, byte(carry&0xff))
+			carry >>= 8
+		}
+	}
+
+	res := make([]byte, zeros+len(buf))
+	for i := 0; i < len(buf); i++ {
+		res[zeros+i] = buf[len(buf)-1-i]
+	}
+	return res, nil
+}
@@ -0,0 +1,158 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/19/20 10:02 AM
+* @Description: 节点身份。节点ID由公钥哈希派生，定长20B，类似于账户地址
+***********************************************************************/
+
+package identity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+)
+
+// IdLen ID的字节长度
+const IdLen = 20
+
+var (
+	ErrInvalidIdLen   = errors.New("invalid id length")
+	ErrMismatchPubKey = errors.New("id does not match public key")
+)
+
+/*
+	ID派生方式：
+
+	ID = sha256( 算法(1B) | 原始公钥 )[:20]
+
+	哈希的输入是crypto.MarshalPublicKey的结果，带上算法前缀，
+	避免不同算法下相同的公钥字节得到相同的ID
+*/
+
+// FromPublicKey 由公钥派生ID
+func FromPublicKey(pub crypto.PublicKey) string {
+	return fromPubKeyBytes(crypto.MarshalPublicKey(pub))
+}
+
+// FromPubKeyBytes 由序列化后的公钥(crypto.MarshalPublicKey)派生ID
+func FromPubKeyBytes(pubBytes []byte) (string, error) {
+	if _, err := crypto.UnmarshalPublicKey(pubBytes); err != nil {
+		return "", err
+	}
+	return fromPubKeyBytes(pubBytes), nil
+}
+
+func fromPubKeyBytes(pubBytes []byte) string {
+	h := sha256.Sum256(pubBytes)
+	return string(h[:IdLen])
+}
+
+// Validate 检查ID格式
+func Validate(id string) error {
+	if len(id) != IdLen {
+		return fmt.Errorf("%w: %d", ErrInvalidIdLen, len(id))
+	}
+	return nil
+}
+
+// MatchPubKey 检查ID是否由该公钥(序列化后)派生
+func MatchPubKey(id string, pubBytes []byte) error {
+	if err := Validate(id); err != nil {
+		return err
+	}
+	derived, err := FromPubKeyBytes(pubBytes)
+	if err != nil {
+		return err
+	}
+	if derived != id {
+		return ErrMismatchPubKey
+	}
+	return nil
+}
+
+// MatchPublicKey 检查ID是否由该公钥派生
+func MatchPublicKey(id string, pub crypto.PublicKey) error {
+	if err := Validate(id); err != nil {
+		return err
+	}
+	if FromPublicKey(pub) != id {
+		return ErrMismatchPubKey
+	}
+	return nil
+}
+
+////////////////////////// 文本编码 //////////////////////////
+
+// 配置文件等文本场景下ID需要编码为可读形式，支持hex和base58两种
+
+// EncodeHex 编码为十六进制字符串
+func EncodeHex(id string) string {
+	return hex.EncodeToString([]byte(id))
+}
+
+// DecodeHex 从十六进制字符串解码
+func DecodeHex(s string) (string, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	id := string(b)
+	if err := Validate(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// EncodeBase58 编码为base58字符串
+func EncodeBase58(id string) string {
+	return base58Encode([]byte(id))
+}
+
+// DecodeBase58 从base58字符串解码
+func DecodeBase58(s string) (string, error) {
+	b, err := base58Decode(s)
+	if err != nil {
+		return "", err
+	}
+	id := string(b)
+	if err := Validate(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Decode 自动识别hex或base58编码
+// 长度为2*IdLen且能按hex解码的视为hex，否则按base58解码
+func Decode(s string) (string, error) {
+	if len(s) == 2*IdLen {
+		if id, err := DecodeHex(s); err == nil {
+			return id, nil
+		}
+	}
+	return DecodeBase58(s)
+}
+
+// DecodeMap 解码配置中 <编码后的id, addr> 形式的节点表
+func DecodeMap(nodes map[string]string) (map[string]string, error) {
+	res := make(map[string]string, len(nodes))
+	for s, addr := range nodes {
+		id, err := Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("id(%s): %w", s, err)
+		}
+		res[id] = addr
+	}
+	return res, nil
+}
+
+// Short 取ID十六进制的前8个字符，用于日志展示
+func Short(id string) string {
+	h := EncodeHex(id)
+	if len(h) > 8 {
+		return h[:8]
+	}
+	return h
+}
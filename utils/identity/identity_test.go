@@ -0,0 +1,85 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/19/20 11:05 AM
+* @Description: The file is for
+***********************************************************************/
+
+package identity
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/azd1997/blockchain-consensus/utils/crypto"
+)
+
+func TestFromPublicKey(t *testing.T) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := FromPublicKey(key.Public())
+	if err := Validate(id); err != nil {
+		t.Error(err)
+	}
+	if err := MatchPubKey(id, crypto.MarshalPublicKey(key.Public())); err != nil {
+		t.Error(err)
+	}
+
+	other, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MatchPublicKey(id, other.Public()); err == nil {
+		t.Error("id should not match other's public key")
+	}
+	if err := Validate("seed01"); err == nil {
+		t.Error("short id should be invalid")
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	key, err := crypto.GenerateKey(crypto.DefaultSigScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := FromPublicKey(key.Public())
+
+	for _, s := range []string{EncodeHex(id), EncodeBase58(id)} {
+		did, err := Decode(s)
+		if err != nil {
+			t.Errorf("decode %s: %s", s, err)
+		}
+		if did != id {
+			t.Errorf("decode %s: mismatched id", s)
+		}
+	}
+
+	if _, err := DecodeBase58("0OIl"); err == nil {
+		t.Error("invalid base58 characters should fail")
+	}
+}
+
+func TestBase58(t *testing.T) {
+	var tests = []struct {
+		raw []byte
+		enc string
+	}{
+		{[]byte{}, ""},
+		{[]byte{0}, "1"},
+		{[]byte{0, 0, 1}, "112"},
+		{[]byte("hello world"), "StV1DL6CwTryKyV"},
+	}
+	for _, test := range tests {
+		if enc := base58Encode(test.raw); enc != test.enc {
+			t.Errorf("encode %v: got %s, want %s", test.raw, enc, test.enc)
+		}
+		dec, err := base58Decode(test.enc)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(dec, test.raw) {
+			t.Errorf("decode %s: got %v, want %v", test.enc, dec, test.raw)
+		}
+	}
+}
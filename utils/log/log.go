@@ -7,7 +7,10 @@
 package log
 
 import (
+	"encoding/hex"
 	"fmt"
+
+	"go.uber.org/zap"
 )
 
 // Logger 基于sugarLogger包装
@@ -15,111 +18,128 @@ type Logger struct {
 	module string
 	id     string
 	prefix string
+	sugar  *zap.SugaredLogger
 }
 
 // NewLogger 新建
 func NewLogger(module string, id string) *Logger {
-	if loggers[id] == nil {
+	sugar := getLogger(id)
+	if sugar == nil {
 		panic("init global zap logger first")
 	}
 
-	prefix := fmt.Sprintf("\t[%s](%s)\t", module, id)
+	prefix := fmt.Sprintf("\t[%s](%s)\t", module, displayId(id))
 
 	return &Logger{
 		module: module,
 		id:     id,
 		prefix: prefix,
+		sugar:  sugar,
+	}
+}
+
+// displayId 由公钥派生的ID是二进制串，日志中以其十六进制的前8个字符展示
+func displayId(id string) string {
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x20 || id[i] > 0x7e {
+			h := hex.EncodeToString([]byte(id))
+			if len(h) > 8 {
+				h = h[:8]
+			}
+			return h
+		}
 	}
+	return id
 }
 
 func (logger *Logger) Debug(args ...interface{}) {
 	args = append([]interface{}{logger.prefix}, args...)
-	loggers[logger.id].Debug(args...)
+	logger.sugar.Debug(args...)
 }
 
 func (logger *Logger) Debugf(template string, args ...interface{}) {
 	template = fmt.Sprintf("%s%s", logger.prefix, template)
-	loggers[logger.id].Debugf(template, args...)
+	logger.sugar.Debugf(template, args...)
 }
 
 func (logger *Logger) Debugw(msg string, keysAndValues ...interface{}) {
 	msg = fmt.Sprintf("%s%s", logger.prefix, msg)
-	loggers[logger.id].Debugw(msg, keysAndValues...)
+	logger.sugar.Debugw(msg, keysAndValues...)
 }
 
 func (logger *Logger) Info(args ...interface{}) {
 	args = append([]interface{}{logger.prefix}, args...)
-	loggers[logger.id].Info(args...)
+	logger.sugar.Info(args...)
 }
 
 func (logger *Logger) Infof(template string, args ...interface{}) {
 	template = fmt.Sprintf("%s%s", logger.prefix, template)
-	loggers[logger.id].Infof(template, args...)
+	logger.sugar.Infof(template, args...)
 }
 
 func (logger *Logger) Infow(msg string, keysAndValues ...interface{}) {
 	msg = fmt.Sprintf("%s%s", logger.prefix, msg)
-	loggers[logger.id].Infow(msg, keysAndValues...)
+	logger.sugar.Infow(msg, keysAndValues...)
 }
 
 func (logger *Logger) Warn(args ...interface{}) {
 	args = append([]interface{}{logger.prefix}, args...)
-	loggers[logger.id].Warn(args...)
+	logger.sugar.Warn(args...)
 }
 
 func (logger *Logger) Warnf(template string, args ...interface{}) {
 	template = fmt.Sprintf("%s%s", logger.prefix, template)
-	loggers[logger.id].Warnf(template, args...)
+	logger.sugar.Warnf(template, args...)
 }
 
 func (logger *Logger) Warnw(msg string, keysAndValues ...interface{}) {
 	msg = fmt.Sprintf("%s%s", logger.prefix, msg)
-	loggers[logger.id].Warnw(msg, keysAndValues...)
+	logger.sugar.Warnw(msg, keysAndValues...)
 }
 
 func (logger *Logger) Error(args ...interface{}) {
 	args = append([]interface{}{logger.prefix}, args...)
-	loggers[logger.id].Error(args...)
+	logger.sugar.Error(args...)
 }
 
 func (logger *Logger) Errorf(template string, args ...interface{}) {
 	template = fmt.Sprintf("%s%s", logger.prefix, template)
-	loggers[logger.id].Errorf(template, args...)
+	logger.sugar.Errorf(template, args...)
 }
 
 func (logger *Logger) Errorw(msg string, keysAndValues ...interface{}) {
 	msg = fmt.Sprintf("%s%s", logger.prefix, msg)
-	loggers[logger.id].Errorw(msg, keysAndValues...)
+	logger.sugar.Errorw(msg, keysAndValues...)
 }
 
 func (logger *Logger) Panic(args ...interface{}) {
 	args = append([]interface{}{logger.prefix}, args...)
-	loggers[logger.id].Panic(args...)
+	logger.sugar.Panic(args...)
 }
 
 func (logger *Logger) Panicf(template string, args ...interface{}) {
 	template = fmt.Sprintf("%s%s", logger.prefix, template)
-	loggers[logger.id].Panicf(template, args...)
+	logger.sugar.Panicf(template, args...)
 }
 
 func (logger *Logger) Panicw(msg string, keysAndValues ...interface{}) {
 	msg = fmt.Sprintf("%s%s", logger.prefix, msg)
-	loggers[logger.id].Panicw(msg, keysAndValues...)
+	logger.sugar.Panicw(msg, keysAndValues...)
 }
 
 func (logger *Logger) Fatal(args ...interface{}) {
 	args = append([]interface{}{logger.prefix}, args...)
-	loggers[logger.id].Fatal(args...)
+	logger.sugar.Fatal(args...)
 }
 
 func (logger *Logger) Fatalf(template string, args ...interface{}) {
 	template = fmt.Sprintf("%s%s", logger.prefix, template)
-	loggers[logger.id].Fatalf(template, args...)
+	logger.sugar.Fatalf(template, args...)
 }
 
 func (logger *Logger) Fatalw(msg string, keysAndValues ...interface{}) {
 	msg = fmt.Sprintf("%s%s", logger.prefix, msg)
-	loggers[logger.id].Fatalw(msg, keysAndValues...)
+	logger.sugar.Fatalw(msg, keysAndValues...)
 }
 
 //
@@ -8,11 +8,12 @@ package log
 
 import (
 	"fmt"
+	"path/filepath"
 	"testing"
 )
 
 func TestLogger(t *testing.T) {
-	InitGlobalLogger("testid", "./test.log")
+	InitGlobalLogger("testid", true, false, filepath.Join(t.TempDir(), "test.log"))
 	defer Sync()
 
 	l := NewLogger("TES", "testid")
@@ -31,3 +32,12 @@ func TestLogger(t *testing.T) {
 	//log.Printf(af, 3, "aaa")
 	//fmt.Printf(af, 3, "aaa")
 }
+
+func TestDisplayId(t *testing.T) {
+	if s := displayId("seed01"); s != "seed01" {
+		t.Errorf("printable id should be displayed as is, got %s", s)
+	}
+	if s := displayId(string([]byte{0xab, 0xcd, 0x01, 0x02, 0x03, 0x04, 0x05})); s != "abcd0102" {
+		t.Errorf("binary id should be displayed as short hex, got %s", s)
+	}
+}
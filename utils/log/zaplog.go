@@ -7,15 +7,26 @@
 package log
 
 import (
+	"fmt"
 	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
+	"sync"
 )
 
 // 全局单例
 // 一般情况下都是使用全局单例，但是对于想要在内存中进行集群部署来说，需要有logger生成函数
-var loggers = map[string]*zap.SugaredLogger{} // <id, logger>
+var (
+	loggers     = map[string]*zap.SugaredLogger{} // <id, logger>
+	loggersLock sync.RWMutex
+)
+
+// 各logger的级别，可以在运行期间通过SetLevel修改
+var (
+	levels     = map[string]zap.AtomicLevel{} // <id, level>
+	levelsLock sync.RWMutex
+)
 
 //var (
 //	highPriority = zap.LevelEnablerFunc(func(level zapcore.Level) bool {
@@ -29,6 +40,8 @@ var loggers = map[string]*zap.SugaredLogger{} // <id, logger>
 
 // InitGlobalLogger 初始化全局日志单例
 func InitGlobalLogger(id string, debug bool, addCaller bool, logFileName ...string) {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
 	if loggers[id] != nil {
 		return
 	}
@@ -38,12 +51,13 @@ func InitGlobalLogger(id string, debug bool, addCaller bool, logFileName ...stri
 
 	var allCore []zapcore.Core
 
-	var logLevel zapcore.LevelEnabler
+	logLevel := zap.NewAtomicLevelAt(zapcore.InfoLevel)
 	if debug {
-		logLevel = zapcore.DebugLevel
-	} else {
-		logLevel = zapcore.InfoLevel
+		logLevel.SetLevel(zapcore.DebugLevel)
 	}
+	levelsLock.Lock()
+	levels[id] = logLevel
+	levelsLock.Unlock()
 
 	encoder := getEncoder()
 	consoleWriter := zapcore.Lock(os.Stdout)
@@ -96,6 +110,32 @@ func InitGlobalLogger(id string, debug bool, addCaller bool, logFileName ...stri
 //	sugarLogger = logger.Sugar()
 //}
 
+// getLogger 获取id的全局logger，未初始化时为nil
+func getLogger(id string) *zap.SugaredLogger {
+	loggersLock.RLock()
+	defer loggersLock.RUnlock()
+	return loggers[id]
+}
+
+// SetLevel 修改id的logger的级别，对控制台和文件输出同时生效
+// level为debug/info/warn/error，空串视为info
+func SetLevel(id string, level string) error {
+	var l zapcore.Level
+	if level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return err
+		}
+	}
+	levelsLock.RLock()
+	al, ok := levels[id]
+	levelsLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("logger of %s not inited", displayId(id))
+	}
+	al.SetLevel(l)
+	return nil
+}
+
 // Sync 将日志写到文件中去
 // 需要注意，由于这里使用的zaplogger有两个输出，一个是控制台，一个是文件。 Sync对Stdout是无效的，会报错，所以只能忽略这个错误了
 func Sync() {
@@ -104,6 +144,8 @@ func Sync() {
 	//}
 	//sugarLogger.Sync()
 
+	loggersLock.RLock()
+	defer loggersLock.RUnlock()
 	for id := range loggers {
 		loggers[id].Sync()
 	}
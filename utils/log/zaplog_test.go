@@ -6,10 +6,15 @@
 
 package log
 
-import "testing"
+import (
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
 
 func TestZapLogger(t *testing.T) {
-	InitGlobalLogger("id1", "./test.log")
+	InitGlobalLogger("id1", true, false, filepath.Join(t.TempDir(), "test.log"))
 	defer Sync()
 
 	loggers["id1"].Debug("test sugar log")
@@ -18,3 +23,22 @@ func TestZapLogger(t *testing.T) {
 	loggers["id1"].Debugw("some msg", "module", "TESTLOG")
 	loggers["id1"].Debugw("[TEST]\tsome msg", "module", "TESTLOG")
 }
+
+func TestSetLevel(t *testing.T) {
+	InitGlobalLogger("id2", false, false)
+	if loggers["id2"].Desugar().Core().Enabled(zapcore.DebugLevel) {
+		t.Fatal("debug should be disabled by default")
+	}
+	if err := SetLevel("id2", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	if !loggers["id2"].Desugar().Core().Enabled(zapcore.DebugLevel) {
+		t.Error("debug should be enabled after SetLevel")
+	}
+	if err := SetLevel("id2", "verbose"); err == nil {
+		t.Error("unknown level should be rejected")
+	}
+	if err := SetLevel("no-such-id", "info"); err == nil {
+		t.Error("SetLevel on uninited logger should fail")
+	}
+}
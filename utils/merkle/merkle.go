@@ -0,0 +1,168 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/27/20 10:15 AM
+* @Description: 默克尔树及包含性证明
+***********************************************************************/
+
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+/*
+	叶子哈希 = sha256(0x00 | data)
+	内部节点 = sha256(0x01 | left | right)
+	叶子与内部节点使用不同前缀，避免将内部节点伪装成叶子(第二原像攻击)
+
+	某层节点数为奇数时，最后一个节点直接提升到上一层，而不是与自身配对，
+	从而避免 [a,b,c] 与 [a,b,c,c] 得到相同的根(CVE-2012-2459)
+*/
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+var (
+	ErrEmptyLeaves     = errors.New("merkle: empty leaves")
+	ErrIndexOutOfRange = errors.New("merkle: index out of range")
+	ErrLeafNotFound    = errors.New("merkle: leaf not found")
+)
+
+// EmptyRoot 空树的根
+var EmptyRoot = func() []byte {
+	h := sha256.Sum256(nil)
+	return h[:]
+}()
+
+// leafHash 叶子哈希
+func leafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// nodeHash 内部节点哈希
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Root 计算leaves的默克尔根
+// leaves为空时返回EmptyRoot
+func Root(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return EmptyRoot
+	}
+	level := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = leafHash(l)
+	}
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// nextLevel 由下一层节点计算上一层
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i+1 < len(level); i += 2 {
+		next = append(next, nodeHash(level[i], level[i+1]))
+	}
+	if len(level)%2 == 1 {
+		next = append(next, level[len(level)-1])
+	}
+	return next
+}
+
+// ProofNode 证明路径上的兄弟节点
+type ProofNode struct {
+	Hash []byte
+	Left bool // 兄弟节点是否位于左侧
+}
+
+// Proof 包含性证明，自叶子向根排列
+// 字段均导出，便于gob等编码后在网络中传输
+type Proof struct {
+	Index int // 叶子在列表中的下标
+	Total int // 叶子总数
+	Path  []ProofNode
+}
+
+// BuildProof 为leaves[index]生成包含性证明
+func BuildProof(leaves [][]byte, index int) (*Proof, error) {
+	if len(leaves) == 0 {
+		return nil, ErrEmptyLeaves
+	}
+	if index < 0 || index >= len(leaves) {
+		return nil, ErrIndexOutOfRange
+	}
+
+	level := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = leafHash(l)
+	}
+
+	proof := &Proof{Index: index, Total: len(leaves)}
+	idx := index
+	for len(level) > 1 {
+		if idx%2 == 1 {
+			proof.Path = append(proof.Path, ProofNode{Hash: level[idx-1], Left: true})
+		} else if idx+1 < len(level) {
+			proof.Path = append(proof.Path, ProofNode{Hash: level[idx+1], Left: false})
+		}
+		// 奇数个节点时最后一个节点直接提升，不需要兄弟节点
+		level = nextLevel(level)
+		idx /= 2
+	}
+	return proof, nil
+}
+
+// BuildProofFor 在leaves中查找leaf并为其生成包含性证明
+func BuildProofFor(leaves [][]byte, leaf []byte) (*Proof, error) {
+	for i, l := range leaves {
+		if bytes.Equal(l, leaf) {
+			return BuildProof(leaves, i)
+		}
+	}
+	return nil, ErrLeafNotFound
+}
+
+// Verify 验证leaf包含在根为root的默克尔树中
+func Verify(root, leaf []byte, proof *Proof) bool {
+	if proof == nil || proof.Total <= 0 || proof.Index < 0 || proof.Index >= proof.Total {
+		return false
+	}
+
+	// 按Index和Total重放路径的形状，防止伪造兄弟节点的左右位置
+	h := leafHash(leaf)
+	idx, n, p := proof.Index, proof.Total, 0
+	for n > 1 {
+		if idx%2 == 1 || idx+1 < n {
+			if p >= len(proof.Path) {
+				return false
+			}
+			sib := proof.Path[p]
+			p++
+			if sib.Left != (idx%2 == 1) {
+				return false
+			}
+			if sib.Left {
+				h = nodeHash(sib.Hash, h)
+			} else {
+				h = nodeHash(h, sib.Hash)
+			}
+		}
+		idx /= 2
+		n = (n + 1) / 2
+	}
+	return p == len(proof.Path) && bytes.Equal(h, root)
+}
@@ -0,0 +1,115 @@
+/**********************************************************************
+* @Author: Eiger (201820114847@mail.scut.edu.cn)
+* @Date: 10/27/20 11:02 AM
+* @Description: 默克尔树测试
+***********************************************************************/
+
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func genLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		leaves[i] = []byte(fmt.Sprintf("tx-%d", i))
+	}
+	return leaves
+}
+
+func TestRoot(t *testing.T) {
+	if !bytes.Equal(Root(nil), EmptyRoot) {
+		t.Error("root of empty leaves should be EmptyRoot")
+	}
+
+	// 单个叶子
+	a := []byte("a")
+	if !bytes.Equal(Root([][]byte{a}), leafHash(a)) {
+		t.Error("root of single leaf should be its leaf hash")
+	}
+
+	// 两个叶子
+	b := []byte("b")
+	if !bytes.Equal(Root([][]byte{a, b}), nodeHash(leafHash(a), leafHash(b))) {
+		t.Error("root of two leaves mismatch")
+	}
+
+	// 顺序敏感
+	if bytes.Equal(Root([][]byte{a, b}), Root([][]byte{b, a})) {
+		t.Error("root should depend on leaves order")
+	}
+
+	// 不复制奇数层末尾节点: [a,b,c] 与 [a,b,c,c] 的根不同
+	c := []byte("c")
+	if bytes.Equal(Root([][]byte{a, b, c}), Root([][]byte{a, b, c, c})) {
+		t.Error("[a,b,c] and [a,b,c,c] should have different roots")
+	}
+}
+
+func TestProof(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := genLeaves(n)
+		root := Root(leaves)
+		for i := 0; i < n; i++ {
+			proof, err := BuildProof(leaves, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Verify(root, leaves[i], proof) {
+				t.Errorf("n=%d, i=%d: verify fail", n, i)
+			}
+			// 其他叶子不能通过该证明
+			if n > 1 && Verify(root, leaves[(i+1)%n], proof) {
+				t.Errorf("n=%d, i=%d: verify wrong leaf should fail", n, i)
+			}
+		}
+	}
+}
+
+func TestProofTampered(t *testing.T) {
+	leaves := genLeaves(7)
+	root := Root(leaves)
+	proof, err := BuildProofFor(leaves, leaves[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Index != 4 {
+		t.Errorf("proof.Index = %d, want 4", proof.Index)
+	}
+
+	// 篡改兄弟节点
+	tampered := *proof
+	tampered.Path = append([]ProofNode(nil), proof.Path...)
+	tampered.Path[0].Hash = leafHash([]byte("evil"))
+	if Verify(root, leaves[4], &tampered) {
+		t.Error("verify with tampered sibling should fail")
+	}
+
+	// 篡改左右位置
+	tampered.Path = append([]ProofNode(nil), proof.Path...)
+	tampered.Path[0].Left = !tampered.Path[0].Left
+	if Verify(root, leaves[4], &tampered) {
+		t.Error("verify with flipped sibling side should fail")
+	}
+
+	// 截断路径
+	tampered.Path = proof.Path[:len(proof.Path)-1]
+	if Verify(root, leaves[4], &tampered) {
+		t.Error("verify with truncated path should fail")
+	}
+
+	// 错误的根
+	if Verify(Root(leaves[:6]), leaves[4], proof) {
+		t.Error("verify against wrong root should fail")
+	}
+
+	if _, err := BuildProofFor(leaves, []byte("missing")); err != ErrLeafNotFound {
+		t.Errorf("err = %v, want %v", err, ErrLeafNotFound)
+	}
+	if _, err := BuildProof(leaves, 7); err != ErrIndexOutOfRange {
+		t.Errorf("err = %v, want %v", err, ErrIndexOutOfRange)
+	}
+}